@@ -1,14 +1,23 @@
 package api
 
 import (
-	"crypto/subtle"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/metrics"
 	"github.com/n0rdy/forq/services"
+	"github.com/n0rdy/forq/signing"
 	"github.com/n0rdy/forq/utils"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
 )
 
@@ -29,31 +38,235 @@ func init() {
 	}
 }
 
+// principalCtxKey is the request context key of the *services.Principal set by apiKeyTokenAuth.
+type principalCtxKey struct{}
+
 // apiKeyTokenAuth validates the API key and throttles repeated failures per IP.
+// The key is either one of the authSecrets, which are superusers, or one of the named
+// API keys, whose scopes are enforced per route by Router.requirePermission.
+// apiKeysService is nil for the endpoints that accept their own secret only.
 // The key is checked FIRST (constant-time), so a valid key always passes even
 // while its IP is locked out: behind a proxy without FORQ_TRUST_PROXY_HEADERS
 // all clients share the proxy's IP, and an attacker's bogus keys must not be
 // able to lock out legitimate producers/consumers.
-func apiKeyTokenAuth(authSecret string, throttlingService *services.ThrottlingService, trustProxyHeaders bool) func(http.Handler) http.Handler {
+func apiKeyTokenAuth(authSecrets *services.AuthSecretsService, apiKeysService *services.ApiKeysService, throttlingService *services.ThrottlingService, trustProxyHeaders bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			authHeader := req.Header.Get("X-API-Key")
-			if subtle.ConstantTimeCompare([]byte(authHeader), []byte(authSecret)) != 1 {
+
+			var principal *services.Principal
+			if authSecrets.IsValid(authHeader) {
+				principal = authSecretPrincipal(signing.KeyId(authHeader))
+			} else if apiKeysService != nil {
+				principal = apiKeysService.Authenticate(authHeader)
+			}
+
+			if principal == nil {
+				// the keys are random, so only the IP is throttled: there is no
+				// credential to guess the rest of
 				ip := utils.ClientIP(req, trustProxyHeaders)
-				if throttlingService.IsLocked(ip) {
+				if throttlingService.IsLocked(ip, "", req.Context()) {
 					sendTooManyRequestsResponse(w)
 					return
 				}
-				throttlingService.RecordFailure(ip)
+				throttlingService.RecordFailure(ip, "", req.Context())
 				log.Error().Msg("Invalid API key")
 				sendUnauthorizedErrorResponse(w)
 				return
 			}
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalCtxKey{}, principal)))
+		})
+	}
+}
+
+// signedRequestAuth verifies the HMAC-SHA256 request signatures made with one
+// of the authSecrets (see the signing package), so the secret itself never
+// travels over the wire, and rejects the requests signed outside the
+// signing.MaxClockSkew window, so a captured request can't be replayed later.
+// The named API keys can't sign, as only their hashes are stored. The failures
+// are throttled per IP the same way as in apiKeyTokenAuth, and per key ID. The requests
+// without a signature are passed to unsignedAuth, or rejected if it's nil.
+func signedRequestAuth(authSecrets *services.AuthSecretsService, throttlingService *services.ThrottlingService, trustProxyHeaders bool, unsignedAuth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var unsignedHandler http.Handler
+		if unsignedAuth != nil {
+			unsignedHandler = unsignedAuth(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			signature := req.Header.Get(signing.SignatureHeader)
+			if signature == "" && unsignedHandler != nil {
+				unsignedHandler.ServeHTTP(w, req)
+				return
+			}
+
+			// the signature covers the body, so it's read upfront and restored
+			// for the handlers; the cap is the largest body any route accepts
+			body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxProduceBodyBytes))
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					log.Error().Err(err).Msg("Signed request body exceeds size limit")
+					sendMiddlewareErrorResponse(w, http.StatusRequestEntityTooLarge, common.ErrCodeBadRequestContentExceedsLimit)
+					return
+				}
+				log.Error().Err(err).Msg("Failed to read signed request body")
+				sendMiddlewareErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidBody)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			if !isValidSignature(authSecrets, req, signature, body) {
+				ip := utils.ClientIP(req, trustProxyHeaders)
+				// only the known key IDs are throttled per credential, so that
+				// made-up ones can't flood the throttling entries
+				var credential string
+				if keyId := req.Header.Get(signing.KeyIdHeader); authSecrets.SigningSecret(keyId) != nil {
+					credential = "auth_secret:" + keyId
+				}
+				if throttlingService.IsLocked(ip, credential, req.Context()) {
+					sendTooManyRequestsResponse(w)
+					return
+				}
+				throttlingService.RecordFailure(ip, credential, req.Context())
+				log.Error().Msg("Invalid request signature")
+				sendUnauthorizedErrorResponse(w)
+				return
+			}
+
+			principal := authSecretPrincipal(req.Header.Get(signing.KeyIdHeader))
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalCtxKey{}, principal)))
+		})
+	}
+}
+
+// clientCertAuth authenticates the requests with a verified TLS client
+// certificate as the API key named after the certificate's common name, see
+// services.ApiKeysService.PrincipalByName. The certificates are verified
+// during the TLS handshake already, so there is nothing to throttle here. The
+// requests without a certificate are passed to otherAuth.
+func clientCertAuth(apiKeysService *services.ApiKeysService, otherAuth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		otherHandler := otherAuth(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+				otherHandler.ServeHTTP(w, req)
+				return
+			}
+
+			commonName := req.TLS.VerifiedChains[0][0].Subject.CommonName
+			principal := apiKeysService.PrincipalByName(commonName)
+			if principal == nil {
+				log.Error().Str("common_name", commonName).Msg("No API key matches the client certificate")
+				sendUnauthorizedErrorResponse(w)
+				return
+			}
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalCtxKey{}, principal)))
+		})
+	}
+}
+
+func isValidSignature(authSecrets *services.AuthSecretsService, req *http.Request, signature string, body []byte) bool {
+	timestamp, err := strconv.ParseInt(req.Header.Get(signing.TimestampHeader), 10, 64)
+	if err != nil || !signing.IsFresh(timestamp, time.Now()) {
+		return false
+	}
+	secret := authSecrets.SigningSecret(req.Header.Get(signing.KeyIdHeader))
+	if secret == nil {
+		return false
+	}
+	return signing.Verify(signature, req.Method, req.URL.RequestURI(), timestamp, body, string(secret))
+}
+
+// authSecretPrincipal is the superuser named after the key ID of the auth
+// secret, as in the admin UI sessions, so that the audit log and the rate
+// limits tell the secrets apart while they are rotated.
+func authSecretPrincipal(keyId string) *services.Principal {
+	return &services.Principal{Name: "auth_secret:" + keyId, Superuser: true}
+}
+
+// principalFromContext returns the principal authenticated by apiKeyTokenAuth.
+func principalFromContext(ctx context.Context) *services.Principal {
+	principal, _ := ctx.Value(principalCtxKey{}).(*services.Principal)
+	return principal
+}
+
+// rateLimit enforces the request rate limits of the route - the permission it
+// requires - per API key and per client IP. It runs after the auth, which
+// throttles the failed attempts on its own. The most restrictive limit is
+// reported in the RateLimit-* headers.
+func rateLimit(rateLimitingService *services.RateLimitingService, route string, trustProxyHeaders bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			var key string
+			if principal := principalFromContext(req.Context()); principal != nil {
+				key = principal.Name
+			}
+
+			decision := rateLimitingService.Allow(route, key, utils.ClientIP(req, trustProxyHeaders))
+			if decision.Limit > 0 {
+				h := w.Header()
+				h.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+				h.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+				h.Set("RateLimit-Reset", strconv.Itoa(decision.ResetSec))
+			}
+			if !decision.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(decision.RetryAfterSec))
+				sendMiddlewareErrorResponse(w, http.StatusTooManyRequests, common.ErrCodeTooManyRequestsRateLimit)
+				return
+			}
 			next.ServeHTTP(w, req)
 		})
 	}
 }
 
+// knownHttpMethods are the methods of RFC 9110 and PATCH, the only ones used
+// as the metric label as is.
+var knownHttpMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// requestDuration records the duration of each request per route pattern,
+// including the ones rejected by the auth or the rate limits. The requests
+// that match no route share the "unmatched" route, and the non-standard
+// methods the "_OTHER" method, as in OpenTelemetry's HTTP conventions, so that
+// scanners probing random paths or methods can't blow up the metric's
+// cardinality.
+func requestDuration(metricsService metrics.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+			next.ServeHTTP(ww, req)
+
+			// the route is only known once chi has routed the request
+			route := "unmatched"
+			if routeCtx := chi.RouteContext(req.Context()); routeCtx != nil && routeCtx.RoutePattern() != "" {
+				route = routeCtx.RoutePattern()
+			}
+			code := ww.Status()
+			if code == 0 {
+				// nothing written, e.g. the client is gone, which net/http reports as 200
+				code = http.StatusOK
+			}
+			method := req.Method
+			if !knownHttpMethods[method] {
+				method = "_OTHER"
+			}
+			metricsService.ObserveHttpRequestDuration(method, route, code, time.Since(start))
+		})
+	}
+}
+
 // securityHeaders middleware sets HTTP security headers on every API response.
 // API responses aren't browser-rendered, so CSP is omitted; the rest are
 // cheap defense-in-depth in case a response ever ends up loaded by a browser
@@ -84,3 +297,9 @@ func sendTooManyRequestsResponse(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusTooManyRequests)
 	w.Write(tooManyRequestsRespBody)
 }
+
+func sendMiddlewareErrorResponse(w http.ResponseWriter, httpCode int, errCode string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	json.NewEncoder(w).Encode(common.ErrorResponse{Code: errCode})
+}
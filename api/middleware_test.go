@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/metrics"
+)
+
+type recordingMetricsService struct {
+	*metrics.NoopMetricsService
+	methods []string
+}
+
+func (rms *recordingMetricsService) ObserveHttpRequestDuration(method string, route string, code int, duration time.Duration) {
+	rms.methods = append(rms.methods, method)
+}
+
+func TestRequestDuration_MethodLabel(t *testing.T) {
+	rms := &recordingMetricsService{}
+	handler := requestDuration(rms)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	tests := map[string]string{
+		http.MethodGet:    http.MethodGet,
+		http.MethodPatch:  http.MethodPatch,
+		http.MethodDelete: http.MethodDelete,
+		"PROPFIND":        "_OTHER",
+		"get":             "_OTHER",
+		"X-RANDOM-12345":  "_OTHER",
+	}
+	for method, want := range tests {
+		rms.methods = nil
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/api/v1/queues/orders/messages", nil))
+		if len(rms.methods) != 1 || rms.methods[0] != want {
+			t.Errorf("method %q labeled as %v, want %q", method, rms.methods, want)
+		}
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/metrics"
 	"github.com/n0rdy/forq/services"
+	"github.com/n0rdy/forq/tracing"
+	"github.com/n0rdy/forq/utils"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -20,77 +25,174 @@ import (
 // content-size check still happens after decoding.
 const maxProduceBodyBytes = 2 * 1024 * 1024
 
+// maxAdminBodyBytes bounds the admin request bodies, which are small JSON
+// documents with a handful of fields.
+const maxAdminBodyBytes = 64 * 1024
+
+// backupDownloadTimeout bounds the backup download instead of the server's
+// write timeout, as a large DB takes longer.
+const backupDownloadTimeout = time.Hour
+
+// quotaErrCodePrefix marks the errors of the producers over quota, which get
+// a Retry-After header on top of the 429.
+const quotaErrCodePrefix = "too_many_requests.quota."
+
 type Router struct {
-	monitoringService *services.MonitoringService
-	messagesService   *services.MessagesService
-	throttlingService *services.ThrottlingService
-	authSecret        string
-	metricsEnabled    bool
-	metricsAuthSecret string
-	env               string
-	trustProxyHeaders bool
+	metricsService      metrics.Service
+	monitoringService   *services.MonitoringService
+	drainService        *services.DrainService
+	backupService       *services.BackupService
+	messagesService     *services.MessagesService
+	queuesService       *services.QueuesService
+	throttlingService   *services.ThrottlingService
+	rateLimitingService *services.RateLimitingService
+	apiKeysService      *services.ApiKeysService
+	sessionsService     *services.SessionsService
+	auditService        *services.AuditService
+	authSecrets         *services.AuthSecretsService
+	authMode            string
+	metricsEnabled      bool
+	metricsAuthSecrets  *services.AuthSecretsService
+	env                 string
+	trustProxyHeaders   bool
 }
 
 func NewRouter(
+	metricsService metrics.Service,
 	monitoringService *services.MonitoringService,
+	drainService *services.DrainService,
+	backupService *services.BackupService,
 	messagesService *services.MessagesService,
+	queuesService *services.QueuesService,
 	throttlingService *services.ThrottlingService,
-	authSecret string,
+	rateLimitingService *services.RateLimitingService,
+	apiKeysService *services.ApiKeysService,
+	sessionsService *services.SessionsService,
+	auditService *services.AuditService,
+	authSecrets *services.AuthSecretsService,
+	authMode string,
 	metricsEnabled bool,
-	metricsAuthSecret string,
+	metricsAuthSecrets *services.AuthSecretsService,
 	env string,
 	trustProxyHeaders bool,
 ) *Router {
 	return &Router{
-		monitoringService: monitoringService,
-		messagesService:   messagesService,
-		throttlingService: throttlingService,
-		authSecret:        authSecret,
-		metricsEnabled:    metricsEnabled,
-		metricsAuthSecret: metricsAuthSecret,
-		env:               env,
-		trustProxyHeaders: trustProxyHeaders,
+		metricsService:      metricsService,
+		monitoringService:   monitoringService,
+		drainService:        drainService,
+		backupService:       backupService,
+		messagesService:     messagesService,
+		queuesService:       queuesService,
+		throttlingService:   throttlingService,
+		rateLimitingService: rateLimitingService,
+		apiKeysService:      apiKeysService,
+		sessionsService:     sessionsService,
+		auditService:        auditService,
+		authSecrets:         authSecrets,
+		authMode:            authMode,
+		metricsEnabled:      metricsEnabled,
+		metricsAuthSecrets:  metricsAuthSecrets,
+		env:                 env,
+		trustProxyHeaders:   trustProxyHeaders,
+	}
+}
+
+// apiAuth returns the auth middleware of the FORQ_AUTH_MODE. A verified TLS
+// client certificate is accepted in any mode. The metrics endpoint isn't
+// affected: it's scraped with its own secret in X-API-Key.
+func (ar *Router) apiAuth() func(http.Handler) http.Handler {
+	apiKeyAuth := apiKeyTokenAuth(ar.authSecrets, ar.apiKeysService, ar.throttlingService, ar.trustProxyHeaders)
+	switch ar.authMode {
+	case common.SignedAuthMode:
+		return clientCertAuth(ar.apiKeysService, signedRequestAuth(ar.authSecrets, ar.throttlingService, ar.trustProxyHeaders, nil))
+	case common.AnyAuthMode:
+		return clientCertAuth(ar.apiKeysService, signedRequestAuth(ar.authSecrets, ar.throttlingService, ar.trustProxyHeaders, apiKeyAuth))
+	default:
+		return clientCertAuth(ar.apiKeysService, apiKeyAuth)
 	}
 }
 
 func (ar *Router) NewRouter() *chi.Mux {
 	router := chi.NewRouter()
 
+	router.Use(tracing.Middleware)
+	router.Use(requestDuration(ar.metricsService))
 	router.Use(securityHeaders(ar.env))
 
 	router.Get("/healthcheck", ar.healthcheck)
+	router.Get("/livez", ar.livez)
+	router.Get("/readyz", ar.readyz)
 
 	if ar.metricsEnabled {
 		router.Route("/metrics", func(r chi.Router) {
-			r.Use(apiKeyTokenAuth(ar.metricsAuthSecret, ar.throttlingService, ar.trustProxyHeaders))
+			r.Use(apiKeyTokenAuth(ar.metricsAuthSecrets, nil, ar.throttlingService, ar.trustProxyHeaders))
 
 			r.Get("/", promhttp.Handler().ServeHTTP)
 		})
 	}
 
 	router.Route("/api/v1", func(r chi.Router) {
-		r.Use(apiKeyTokenAuth(ar.authSecret, ar.throttlingService, ar.trustProxyHeaders))
+		r.Use(ar.apiAuth())
 
 		r.Route("/queues", func(r chi.Router) {
 			r.Route("/{queue}/messages", func(r chi.Router) {
 				r.Use(ar.validateQueueName)
 
-				r.Post("/", ar.produceMessage)
-				r.Get("/", ar.consumeMessage)
+				r.With(ar.requirePermission(common.ProducePermission), ar.rateLimit(common.ProducePermission)).Post("/", ar.produceMessage)
+				r.With(ar.requirePermission(common.ConsumePermission), ar.rateLimit(common.ConsumePermission)).Get("/", ar.consumeMessage)
 
 				r.Route("/{messageId}", func(r chi.Router) {
 					r.Use(ar.validateMessageId)
+					r.Use(ar.requirePermission(common.ConsumePermission))
+					r.Use(ar.rateLimit(common.ConsumePermission))
 
 					r.Post("/ack", ar.ackMessage)
 					r.Post("/nack", ar.nackMessage)
 				})
 			})
 		})
+
+		r.Route("/admin", func(r chi.Router) {
+			// the rate limit goes after the permission checks, so that the
+			// rejected requests don't use up the budget
+			r.Route("/queues/{queue}", func(r chi.Router) {
+				r.Use(ar.validateQueueName)
+				r.Use(ar.requirePermission(common.AdminPermission))
+				r.Use(ar.rateLimit(common.AdminPermission))
+
+				r.Post("/redrive", ar.redriveDlqMessages)
+				r.Get("/settings", ar.getQueueSettings)
+				r.Put("/dlq-policy", ar.updateDlqPolicy)
+				r.Put("/delivery-limits", ar.updateDeliveryLimits)
+				r.Put("/quotas", ar.updateQuotas)
+				r.Post("/pause", ar.pauseQueue)
+				r.Post("/resume", ar.resumeQueue)
+			})
+
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Use(ar.requireGlobalAdmin)
+				r.Use(ar.rateLimit(common.AdminPermission))
+
+				r.Get("/", ar.getApiKeys)
+				r.Post("/", ar.createApiKey)
+				r.Delete("/{keyId}", ar.deleteApiKey)
+			})
+
+			r.With(ar.requireGlobalAdmin, ar.rateLimit(common.AdminPermission)).Get("/audit-log", ar.exportAuditLog)
+			r.With(ar.requireGlobalAdmin, ar.rateLimit(common.AdminPermission)).Post("/drain", ar.drain)
+			r.With(ar.requireGlobalAdmin, ar.rateLimit(common.AdminPermission)).Get("/backup", ar.downloadBackup)
+		})
 	})
 
 	return router
 }
 
+// rateLimit shares the budgets of the route class - produce, consume or admin -
+// among all the queues.
+func (ar *Router) rateLimit(route string) func(http.Handler) http.Handler {
+	return rateLimit(ar.rateLimitingService, route, ar.trustProxyHeaders)
+}
+
 func (ar *Router) validateQueueName(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if !common.IsValidQueueName(chi.URLParam(req, "queue")) {
@@ -111,6 +213,43 @@ func (ar *Router) validateMessageId(next http.Handler) http.Handler {
 	})
 }
 
+// requirePermission enforces the scopes of the API key on the queue of the route.
+func (ar *Router) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			queueName := chi.URLParam(req, "queue")
+			if !ar.isAllowed(req, permission, queueName) {
+				ar.sendErrorResponse(w, http.StatusForbidden, common.ErrCodeForbidden)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// requireGlobalAdmin guards the API keys management: an admin of some queues
+// only must not be able to issue itself keys for the rest.
+func (ar *Router) requireGlobalAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal := principalFromContext(req.Context())
+		if principal == nil || !principal.IsGlobalAdmin() {
+			log.Warn().Msg("API key is not allowed to manage API keys")
+			ar.sendErrorResponse(w, http.StatusForbidden, common.ErrCodeForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (ar *Router) isAllowed(req *http.Request, permission string, queueName string) bool {
+	principal := principalFromContext(req.Context())
+	if principal == nil || !principal.Allows(permission, queueName) {
+		log.Warn().Str("permission", permission).Str("queue", queueName).Msg("API key is not allowed to access the queue")
+		return false
+	}
+	return true
+}
+
 func (ar *Router) produceMessage(w http.ResponseWriter, req *http.Request) {
 	req.Body = http.MaxBytesReader(w, req.Body, maxProduceBodyBytes)
 
@@ -179,6 +318,320 @@ func (ar *Router) nackMessage(w http.ResponseWriter, req *http.Request) {
 	ar.sendNoContentEmptyResponse(w)
 }
 
+func (ar *Router) redriveDlqMessages(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxAdminBodyBytes)
+
+	var redriveReq common.RedriveRequest
+	err := json.NewDecoder(req.Body).Decode(&redriveReq)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode redrive request body")
+		ar.sendErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidBody)
+		return
+	}
+
+	// redriving is producing into the target queue, so the target is admin-scoped too
+	if redriveReq.TargetQueue != "" && !ar.isAllowed(req, common.AdminPermission, redriveReq.TargetQueue) {
+		ar.sendErrorResponse(w, http.StatusForbidden, common.ErrCodeForbidden)
+		return
+	}
+
+	queueName := chi.URLParam(req, "queue")
+
+	redrivenCount, err := ar.messagesService.RedriveDlqMessages(queueName, redriveReq, req.Context())
+	ar.audit(req, services.AuditEvent{
+		Action:  common.RedriveDlqAuditAction,
+		Queue:   queueName,
+		Count:   &redrivenCount,
+		Details: services.RedriveAuditDetails(redriveReq),
+		Err:     err,
+	})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendJsonResponse(w, http.StatusOK, common.RedriveResponse{RedrivenCount: redrivenCount})
+}
+
+func (ar *Router) getQueueSettings(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	settings, err := ar.queuesService.GetQueueSettings(queueName, req.Context())
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendJsonResponse(w, http.StatusOK, common.QueueSettingsResponse{
+		Queue:                 settings.QueueName,
+		DlqPolicy:             settings.DlqPolicy,
+		DlqName:               settings.DlqName,
+		DeliveryRatePerSecond: settings.DeliveryRatePerSec,
+		DeliveryBurst:         settings.DeliveryBurst,
+		MaxInFlight:           settings.MaxInFlight,
+		MaxMessages:           settings.MaxMessages,
+		MaxBytes:              settings.MaxBytes,
+		Paused:                settings.Paused,
+		PausedAt:              settings.PausedAt,
+	})
+}
+
+func (ar *Router) updateDlqPolicy(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxAdminBodyBytes)
+
+	var policyReq common.DlqPolicyRequest
+	err := json.NewDecoder(req.Body).Decode(&policyReq)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode DLQ policy request body")
+		ar.sendErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidBody)
+		return
+	}
+
+	// the failed messages are moved into the custom DLQ, so it is admin-scoped too
+	if policyReq.DlqName != "" && !ar.isAllowed(req, common.AdminPermission, policyReq.DlqName) {
+		ar.sendErrorResponse(w, http.StatusForbidden, common.ErrCodeForbidden)
+		return
+	}
+
+	queueName := chi.URLParam(req, "queue")
+
+	err = ar.queuesService.UpdateDlqPolicy(queueName, policyReq, req.Context())
+	ar.audit(req, services.AuditEvent{
+		Action:  common.UpdateDlqPolicyAuditAction,
+		Queue:   queueName,
+		Details: services.DlqPolicyAuditDetails(policyReq),
+		Err:     err,
+	})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendNoContentEmptyResponse(w)
+}
+
+func (ar *Router) updateDeliveryLimits(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxAdminBodyBytes)
+
+	var limitsReq common.DeliveryLimitsRequest
+	err := json.NewDecoder(req.Body).Decode(&limitsReq)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode delivery limits request body")
+		ar.sendErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidBody)
+		return
+	}
+
+	queueName := chi.URLParam(req, "queue")
+
+	err = ar.queuesService.UpdateDeliveryLimits(queueName, limitsReq, req.Context())
+	ar.audit(req, services.AuditEvent{
+		Action:  common.UpdateDeliveryLimitsAuditAction,
+		Queue:   queueName,
+		Details: services.DeliveryLimitsAuditDetails(limitsReq),
+		Err:     err,
+	})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendNoContentEmptyResponse(w)
+}
+
+func (ar *Router) updateQuotas(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxAdminBodyBytes)
+
+	var quotasReq common.QuotasRequest
+	err := json.NewDecoder(req.Body).Decode(&quotasReq)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode quotas request body")
+		ar.sendErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidBody)
+		return
+	}
+
+	queueName := chi.URLParam(req, "queue")
+
+	err = ar.queuesService.UpdateQuotas(queueName, quotasReq, req.Context())
+	ar.audit(req, services.AuditEvent{
+		Action:  common.UpdateQuotasAuditAction,
+		Queue:   queueName,
+		Details: services.QuotasAuditDetails(quotasReq),
+		Err:     err,
+	})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendNoContentEmptyResponse(w)
+}
+
+func (ar *Router) pauseQueue(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := ar.queuesService.PauseQueue(queueName, req.Context())
+	ar.audit(req, services.AuditEvent{Action: common.PauseQueueAuditAction, Queue: queueName, Err: err})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendNoContentEmptyResponse(w)
+}
+
+func (ar *Router) resumeQueue(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := ar.queuesService.ResumeQueue(queueName, req.Context())
+	ar.audit(req, services.AuditEvent{Action: common.ResumeQueueAuditAction, Queue: queueName, Err: err})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendNoContentEmptyResponse(w)
+}
+
+func (ar *Router) getApiKeys(w http.ResponseWriter, req *http.Request) {
+	apiKeys, err := ar.apiKeysService.GetApiKeys(req.Context())
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendJsonResponse(w, http.StatusOK, apiKeys)
+}
+
+func (ar *Router) createApiKey(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxAdminBodyBytes)
+
+	var newKeyReq common.NewApiKeyRequest
+	err := json.NewDecoder(req.Body).Decode(&newKeyReq)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode API key request body")
+		ar.sendErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidBody)
+		return
+	}
+
+	newKey, err := ar.apiKeysService.CreateApiKey(newKeyReq, req.Context())
+	ar.audit(req, services.AuditEvent{
+		Action:  common.CreateApiKeyAuditAction,
+		Details: "name: " + newKeyReq.Name,
+		Err:     err,
+	})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendJsonResponse(w, http.StatusCreated, newKey)
+}
+
+func (ar *Router) deleteApiKey(w http.ResponseWriter, req *http.Request) {
+	keyId := chi.URLParam(req, "keyId")
+	name, err := ar.apiKeysService.DeleteApiKey(keyId, req.Context())
+	ar.audit(req, services.AuditEvent{
+		Action:  common.DeleteApiKeyAuditAction,
+		Details: "id: " + keyId,
+		Err:     err,
+	})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	// the admin UI sessions logged in with the key
+	if err := ar.sessionsService.InvalidateUserSessions("api_key:"+name, req.Context()); err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	ar.sendNoContentEmptyResponse(w)
+}
+
+// exportAuditLog writes the entries matching the query filters as NDJSON,
+// one common.AuditEntryResponse per line, the newest first.
+func (ar *Router) exportAuditLog(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	filter := common.AuditFilter{
+		Actor:   query.Get("actor"),
+		Action:  query.Get("action"),
+		Queue:   query.Get("queue"),
+		Outcome: query.Get("outcome"),
+	}
+	for param, target := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		if v := query.Get(param); v != "" {
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || parsed <= 0 {
+				ar.sendErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidTimeRange)
+				return
+			}
+			*target = parsed
+		}
+	}
+	if filter.Since > 0 && filter.Until > 0 && filter.Until <= filter.Since {
+		ar.sendErrorResponse(w, http.StatusBadRequest, common.ErrCodeBadRequestInvalidTimeRange)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	err := ar.auditService.Export(filter, func(entry common.AuditEntryResponse) error {
+		return encoder.Encode(entry)
+	}, req.Context())
+	if err != nil {
+		// the status is sent with the first line already, the client sees a truncated export
+		log.Error().Err(err).Msg("failed to export the audit log")
+	}
+}
+
+// drain takes this instance out of the rotation, e.g. before a maintenance.
+// It's not the shutdown: the operator stops Forq once the drain is over.
+func (ar *Router) drain(w http.ResponseWriter, req *http.Request) {
+	details := "started"
+	if !ar.drainService.Drain() {
+		details = "already draining"
+	}
+	ar.audit(req, services.AuditEvent{Action: common.DrainInstanceAuditAction, Details: details})
+	ar.sendNoContentEmptyResponse(w)
+}
+
+// downloadBackup streams a snapshot of the DB, gzip-compressed with ?gzip.
+// It's exempt from the handle timeout, see TimeoutHandler.
+func (ar *Router) downloadBackup(w http.ResponseWriter, req *http.Request) {
+	compress := req.URL.Query().Has("gzip")
+
+	snapshot, err := ar.backupService.Snapshot(req.Context())
+	ar.audit(req, services.AuditEvent{Action: common.BackupDatabaseAuditAction, Err: err})
+	if err != nil {
+		ar.sendResponseFromError(w, err)
+		return
+	}
+	defer snapshot.Close()
+
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(backupDownloadTimeout))
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to extend the write deadline of the backup download")
+	}
+	name := services.BackupFileName(time.Now(), compress)
+	if compress {
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	err = snapshot.WriteTo(w, compress)
+	if err != nil {
+		// the status is sent with the first bytes already, the client sees a truncated download
+		log.Error().Err(err).Msg("failed to send the backup")
+	}
+}
+
+// audit records the action of the authenticated API key.
+func (ar *Router) audit(req *http.Request, event services.AuditEvent) {
+	event.Actor = auditActor(principalFromContext(req.Context()))
+	event.SourceIp = utils.ClientIP(req, ar.trustProxyHeaders)
+	ar.auditService.Record(event, req.Context())
+}
+
+// auditActor tells the auth secrets apart from the API keys, which can't have
+// a ":" in their names.
+func auditActor(principal *services.Principal) string {
+	if principal.Superuser {
+		return principal.Name // "auth_secret:<key ID>"
+	}
+	return "api_key:" + principal.Name
+}
+
 func (ar *Router) healthcheck(w http.ResponseWriter, req *http.Request) {
 	if ar.monitoringService.IsHealthy(req.Context()) {
 		ar.sendNoContentEmptyResponse(w)
@@ -187,6 +640,32 @@ func (ar *Router) healthcheck(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// livez is the liveness probe: it only tells that Forq serves the requests,
+// as restarting it wouldn't fix e.g. the full disk.
+func (ar *Router) livez(w http.ResponseWriter, req *http.Request) {
+	ar.sendNoContentEmptyResponse(w)
+}
+
+// readyz is the readiness probe, with the checks listed in the body if the
+// verbose query param is set, e.g. /readyz?verbose
+func (ar *Router) readyz(w http.ResponseWriter, req *http.Request) {
+	readiness := ar.monitoringService.Readiness(req.Context())
+	if req.URL.Query().Has("verbose") {
+		httpCode := http.StatusOK
+		if !readiness.Ready {
+			httpCode = http.StatusServiceUnavailable
+		}
+		ar.sendJsonResponse(w, httpCode, readiness)
+		return
+	}
+
+	if readiness.Ready {
+		ar.sendNoContentEmptyResponse(w)
+	} else {
+		ar.sendErrorResponse(w, http.StatusServiceUnavailable, common.ErrCodeServiceNotReady)
+	}
+}
+
 func (ar *Router) sendNoContentEmptyResponse(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -211,6 +690,10 @@ func (ar *Router) sendErrorResponse(w http.ResponseWriter, httpCode int, errCode
 func (ar *Router) sendResponseFromError(w http.ResponseWriter, err error) {
 	var fe common.ForqError
 	if errors.As(err, &fe) {
+		if strings.HasPrefix(fe.Code, quotaErrCodePrefix) {
+			// the quotas usage is recounted every QuotasUsageRefreshSec, that's the earliest the producer can get through
+			w.Header().Set("Retry-After", strconv.Itoa(common.QuotasUsageRefreshSec))
+		}
 		ar.sendErrorResponse(w, httpStatusForErrorCode(fe.Code), fe.Code)
 	} else {
 		ar.sendErrorResponse(w, http.StatusInternalServerError, common.ErrCodeInternal)
@@ -223,14 +706,20 @@ func httpStatusForErrorCode(errCode string) int {
 		return http.StatusBadRequest
 	case strings.HasPrefix(errCode, "not_found."):
 		return http.StatusNotFound
+	case strings.HasPrefix(errCode, "too_many_requests."):
+		return http.StatusTooManyRequests
+	case errCode == common.ErrCodeServiceDraining:
+		return http.StatusServiceUnavailable
 	// the codes below are currently written directly by middleware/handlers
 	// with their status and never travel through here as ForqError values -
 	// mapped anyway so a future refactor can't silently turn them into 500s:
 	case errCode == common.ErrCodeUnauthorized:
 		return http.StatusUnauthorized
+	case errCode == common.ErrCodeForbidden:
+		return http.StatusForbidden
 	case errCode == common.ErrCodeTooManyRequests:
 		return http.StatusTooManyRequests
-	case errCode == common.ErrCodeServiceUnhealthy:
+	case errCode == common.ErrCodeServiceUnhealthy, errCode == common.ErrCodeServiceNotReady:
 		return http.StatusServiceUnavailable
 	default:
 		return http.StatusInternalServerError
@@ -1,18 +1,27 @@
 package api_test
 
 import (
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/n0rdy/forq/api"
 	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
 	"github.com/n0rdy/forq/internal/testutil"
 	"github.com/n0rdy/forq/metrics"
 	"github.com/n0rdy/forq/services"
+	"github.com/n0rdy/forq/signing"
 )
 
 const testAuthSecret = "test-secret-that-is-32-chars-long"
@@ -22,20 +31,67 @@ const testAuthSecret = "test-secret-that-is-32-chars-long"
 // service, so lockout state can't leak between tests.
 func newTestServer(t *testing.T) *httptest.Server {
 	t.Helper()
+	return newTestServerWithAuthMode(t, common.ApiKeyAuthMode)
+}
 
-	repo, appConfigs, _ := testutil.NewTestRepo(t)
-	metricsService := metrics.NewMetricsService(false)
-	messagesService := services.NewMessagesService(metricsService, repo, appConfigs)
-	monitoringService := services.NewMonitoringService(repo)
-	throttlingService := services.NewThrottlingService()
-	t.Cleanup(func() { throttlingService.Close() })
+func newTestServerWithAuthMode(t *testing.T, authMode string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(newTestHandler(t, authMode, configs.RateLimitsSettings{}))
+	t.Cleanup(srv.Close)
+	return srv
+}
 
-	router := api.NewRouter(monitoringService, messagesService, throttlingService, testAuthSecret, false, "", common.LocalEnv, false)
-	srv := httptest.NewServer(router.NewRouter())
+// newTestServerWithRateLimits has the given rate limits instead of none.
+func newTestServerWithRateLimits(t *testing.T, rateLimits configs.RateLimitsSettings) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(newTestHandler(t, common.ApiKeyAuthMode, rateLimits))
 	t.Cleanup(srv.Close)
 	return srv
 }
 
+func newTestHandler(t *testing.T, authMode string, rateLimits configs.RateLimitsSettings) http.Handler {
+	t.Helper()
+
+	repo, appConfigs, _ := testutil.NewTestRepo(t)
+	metricsService := metrics.NewMetricsService(configs.MetricsSettings{})
+	limitingService, err := services.NewLimitingService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotasService, err := services.NewQuotasService(metricsService, repo, appConfigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { quotasService.Close() })
+	messagesService := services.NewMessagesService(metricsService, repo, limitingService, quotasService, appConfigs)
+	monitoringService := services.NewMonitoringService(filepath.Join(t.TempDir(), "forq.db"), configs.DefaultSettings().Health)
+	monitoringService.Started(repo, nil)
+	queuesService := services.NewQueuesService(repo, limitingService, quotasService)
+	throttlingService := services.NewThrottlingService(repo, configs.DefaultSettings().Throttling)
+	t.Cleanup(func() { throttlingService.Close() })
+	rateLimitingService := services.NewRateLimitingService(metricsService, rateLimits)
+	t.Cleanup(func() { rateLimitingService.Close() })
+	apiKeysService, err := services.NewApiKeysService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecretsService, err := services.NewAuthSecretsService(metricsService, testAuthSecret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionsService := services.NewSessionsService(repo, 24*time.Hour, 7*24*time.Hour)
+	t.Cleanup(func() { sessionsService.Close() })
+	auditService := services.NewAuditService(repo)
+
+	drainService := services.NewDrainService(monitoringService, messagesService, configs.DefaultSettings().Health)
+
+	backupService := services.NewBackupService(repo, filepath.Join(t.TempDir(), "forq.db"), configs.DefaultSettings().Backup)
+
+	router := api.NewRouter(metricsService, monitoringService, drainService, backupService, messagesService, queuesService, throttlingService, rateLimitingService, apiKeysService, sessionsService, auditService, authSecretsService, authMode, false, nil, common.LocalEnv, false)
+	return router.NewRouter()
+}
+
 func doRequest(t *testing.T, method, url, body string, headers map[string]string) (*http.Response, string) {
 	t.Helper()
 	var bodyReader *strings.Reader
@@ -200,6 +256,309 @@ func TestMessageIdValidation(t *testing.T) {
 	}
 }
 
+func TestRedriveEndpoint(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1/admin/queues"
+
+	resp, body := doRequest(t, "POST", base+"/orders/redrive", `{"targetQueue":"quarantine"}`, nil)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestDlqOnlyOp {
+		t.Fatalf("redrive from non-DLQ: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "POST", base+"/orders-dlq/redrive", `{"targetQueue":"emails-dlq"}`, nil)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestInvalidTargetQueue {
+		t.Fatalf("redrive into a DLQ: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "POST", base+"/orders-dlq/redrive", `not-json`, nil)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestInvalidBody {
+		t.Fatalf("redrive with malformed body: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "POST", base+"/orders-dlq/redrive", `{"targetQueue":"quarantine","ratePerSecond":100}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("redrive: %d %s", resp.StatusCode, body)
+	}
+	var redriveResp common.RedriveResponse
+	if err := json.Unmarshal([]byte(body), &redriveResp); err != nil {
+		t.Fatal(err)
+	}
+	if redriveResp.RedrivenCount != 0 {
+		t.Fatalf("redriven %d messages from an empty DLQ", redriveResp.RedrivenCount)
+	}
+}
+
+func TestDlqPolicyEndpoints(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1/admin/queues"
+
+	resp, body := doRequest(t, "PUT", base+"/orders/dlq-policy", `{"dlqPolicy":"custom","dlqName":"graveyard"}`, nil)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestInvalidDlqName {
+		t.Fatalf("custom DLQ without suffix: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "PUT", base+"/orders/dlq-policy", `{"dlqPolicy":"custom","dlqName":"shared-dlq"}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("set DLQ policy: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "GET", base+"/orders/settings", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get settings: %d %s", resp.StatusCode, body)
+	}
+	var settings common.QueueSettingsResponse
+	if err := json.Unmarshal([]byte(body), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.DlqPolicy != common.CustomDlqPolicy || settings.DlqName != "shared-dlq" {
+		t.Fatalf("settings: %+v", settings)
+	}
+}
+
+func TestDeliveryLimitsEndpoint(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1/admin/queues"
+
+	resp, body := doRequest(t, "PUT", base+"/orders/delivery-limits", `{"burst":5}`, nil)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestInvalidBurst {
+		t.Fatalf("burst without rate: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "PUT", base+"/orders/delivery-limits", `{"ratePerSecond":10,"maxInFlight":3}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("set delivery limits: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "GET", base+"/orders/settings", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get settings: %d %s", resp.StatusCode, body)
+	}
+	var settings common.QueueSettingsResponse
+	if err := json.Unmarshal([]byte(body), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.DeliveryRatePerSecond != 10 || settings.MaxInFlight != 3 || settings.DlqPolicy != common.DefaultDlqPolicy {
+		t.Fatalf("settings: %+v", settings)
+	}
+}
+
+func TestPauseResumeEndpoints(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1"
+
+	resp, body := doRequest(t, "POST", base+"/admin/queues/orders/pause", "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("pause: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "POST", base+"/queues/orders/messages", `{"content":"hello"}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("produce into a paused queue: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "GET", base+"/admin/queues/orders/settings", "", nil)
+	var settings common.QueueSettingsResponse
+	if err := json.Unmarshal([]byte(body), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !settings.Paused || settings.PausedAt == 0 {
+		t.Fatalf("settings of a paused queue: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "POST", base+"/admin/queues/orders/resume", "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("resume: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "GET", base+"/queues/orders/messages", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("consume after resume: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestProduceOverQuota(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, body := doRequest(t, "PUT", srv.URL+"/api/v1/admin/queues/orders/quotas", `{"maxMessages":1}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("set quotas: %d %s", resp.StatusCode, body)
+	}
+
+	base := srv.URL + "/api/v1/queues/orders/messages"
+	resp, body = doRequest(t, "POST", base, `{"content":"first"}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("produce within quota: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "POST", base, `{"content":"second"}`, nil)
+	if resp.StatusCode != http.StatusTooManyRequests || errorCode(t, body) != common.ErrCodeTooManyRequestsQuotaMessages {
+		t.Fatalf("produce over quota: %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+}
+
+func TestProduceOverRateLimit(t *testing.T) {
+	srv := newTestServerWithRateLimits(t, configs.RateLimitsSettings{
+		Produce: configs.RouteRateLimits{PerKey: configs.RateLimit{RatePerSec: 0.01, Burst: 2}},
+	})
+
+	base := srv.URL + "/api/v1/queues/orders/messages"
+	for i := range 2 {
+		resp, body := doRequest(t, "POST", base, `{"content":"x"}`, nil)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("produce %d within the burst: %d %s", i+1, resp.StatusCode, body)
+		}
+		if got := resp.Header.Get("RateLimit-Remaining"); got != strconv.Itoa(1-i) {
+			t.Fatalf("RateLimit-Remaining after produce %d = %q", i+1, got)
+		}
+	}
+
+	resp, body := doRequest(t, "POST", base, `{"content":"x"}`, nil)
+	if resp.StatusCode != http.StatusTooManyRequests || errorCode(t, body) != common.ErrCodeTooManyRequestsRateLimit {
+		t.Fatalf("produce over the rate limit: %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Retry-After") == "" || resp.Header.Get("RateLimit-Limit") != "2" || resp.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("headers of the rate limited produce: %v", resp.Header)
+	}
+
+	// consume has its own budget, unlimited here
+	resp, body = doRequest(t, "GET", base, "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("consume while produce is rate limited: %d %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("RateLimit-Limit") != "" {
+		t.Fatalf("RateLimit-Limit of an unlimited route = %q", resp.Header.Get("RateLimit-Limit"))
+	}
+
+	// and so does every API key
+	key := createApiKey(t, srv.URL, "producer", `[{"permission":"produce","queues":"*"}]`)
+	resp, body = doRequest(t, "POST", base, `{"content":"x"}`, map[string]string{"X-API-Key": key})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("produce with another API key: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestForbiddenRequestsDontUseRateLimit(t *testing.T) {
+	srv := newTestServerWithRateLimits(t, configs.RateLimitsSettings{
+		Produce: configs.RouteRateLimits{PerIp: configs.RateLimit{RatePerSec: 0.01, Burst: 1}},
+	})
+	key := createApiKey(t, srv.URL, "orders-producer", `[{"permission":"produce","queues":"orders"}]`)
+
+	base := srv.URL + "/api/v1/queues/payments/messages"
+	for range 3 {
+		resp, body := doRequest(t, "POST", base, `{"content":"x"}`, map[string]string{"X-API-Key": key})
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("produce to a queue out of the key's scopes: %d %s", resp.StatusCode, body)
+		}
+	}
+
+	// the IP's budget is intact
+	resp, body := doRequest(t, "POST", base, `{"content":"x"}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("produce after the forbidden ones: %d %s", resp.StatusCode, body)
+	}
+}
+
+// createApiKey creates an API key with the superuser secret and returns the key.
+func createApiKey(t *testing.T, srvURL, name, scopes string) string {
+	t.Helper()
+	resp, body := doRequest(t, "POST", srvURL+"/api/v1/admin/api-keys", `{"name":"`+name+`","scopes":`+scopes+`}`, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create API key: %d %s", resp.StatusCode, body)
+	}
+	var newKey common.NewApiKeyResponse
+	if err := json.Unmarshal([]byte(body), &newKey); err != nil {
+		t.Fatal(err)
+	}
+	return newKey.Key
+}
+
+func TestApiKeyScopes(t *testing.T) {
+	srv := newTestServer(t)
+	key := createApiKey(t, srv.URL, "orders-producer", `[{"permission":"produce","queues":"orders*"}]`)
+	withKey := map[string]string{"X-API-Key": key}
+
+	resp, body := doRequest(t, "POST", srv.URL+"/api/v1/queues/orders-eu/messages", `{"content":"x"}`, withKey)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("produce within scope: %d %s", resp.StatusCode, body)
+	}
+
+	forbidden := []struct{ method, url string }{
+		{"POST", "/api/v1/queues/emails/messages"},
+		{"GET", "/api/v1/queues/orders-eu/messages"},
+		{"GET", "/api/v1/admin/queues/orders-eu/settings"},
+		{"GET", "/api/v1/admin/api-keys"},
+	}
+	for _, tc := range forbidden {
+		resp, body := doRequest(t, tc.method, srv.URL+tc.url, `{"content":"x"}`, withKey)
+		if resp.StatusCode != http.StatusForbidden || errorCode(t, body) != common.ErrCodeForbidden {
+			t.Fatalf("%s %s out of scope: %d %s", tc.method, tc.url, resp.StatusCode, body)
+		}
+	}
+
+	// a queue admin can't redrive into a queue out of its scope
+	adminKey := createApiKey(t, srv.URL, "orders-admin", `[{"permission":"admin","queues":"orders"}]`)
+	resp, body = doRequest(t, "POST", srv.URL+"/api/v1/admin/queues/orders-dlq/redrive", `{"targetQueue":"emails"}`, map[string]string{"X-API-Key": adminKey})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("redrive out of scope: %d %s", resp.StatusCode, body)
+	}
+	resp, body = doRequest(t, "POST", srv.URL+"/api/v1/admin/queues/orders-dlq/redrive", `{"targetQueue":"orders"}`, map[string]string{"X-API-Key": adminKey})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("redrive within scope: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestApiKeysManagement(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1/admin/api-keys"
+
+	resp, body := doRequest(t, "POST", base, `{"name":"orders","scopes":[{"permission":"read","queues":"orders"}]}`, nil)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestInvalidScopes {
+		t.Fatalf("unknown permission: %d %s", resp.StatusCode, body)
+	}
+
+	key := createApiKey(t, srv.URL, "global-admin", `[{"permission":"admin","queues":"*"}]`)
+	withKey := map[string]string{"X-API-Key": key}
+
+	// global admins manage the API keys too
+	resp, body = doRequest(t, "POST", base, `{"name":"global-admin","scopes":[{"permission":"consume","queues":"*"}]}`, withKey)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestKeyNameTaken {
+		t.Fatalf("duplicate name: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = doRequest(t, "GET", base, "", withKey)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("list API keys: %d %s", resp.StatusCode, body)
+	}
+	if strings.Contains(body, key) {
+		t.Fatal("the key itself must not be listed")
+	}
+	var apiKeys []common.ApiKeyResponse
+	if err := json.Unmarshal([]byte(body), &apiKeys); err != nil {
+		t.Fatal(err)
+	}
+	if len(apiKeys) != 1 || apiKeys[0].Name != "global-admin" {
+		t.Fatalf("API keys: %+v", apiKeys)
+	}
+
+	resp, body = doRequest(t, "DELETE", base+"/"+apiKeys[0].Id, "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete API key: %d %s", resp.StatusCode, body)
+	}
+	resp, body = doRequest(t, "DELETE", base+"/"+apiKeys[0].Id, "", nil)
+	if resp.StatusCode != http.StatusNotFound || errorCode(t, body) != common.ErrCodeNotFoundApiKey {
+		t.Fatalf("delete deleted API key: %d %s", resp.StatusCode, body)
+	}
+
+	// deleted keys are rejected right away
+	resp, _ = doRequest(t, "GET", base, "", withKey)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("deleted key: %d", resp.StatusCode)
+	}
+}
+
 func TestAuth(t *testing.T) {
 	srv := newTestServer(t)
 	url := srv.URL + "/api/v1/queues/orders/messages"
@@ -254,6 +613,177 @@ func TestThrottleLockoutDoesNotBlockValidKey(t *testing.T) {
 	}
 }
 
+// doSignedRequest sends the request signed with the secret, after letting
+// tamper modify it, e.g. to replay a stale signature.
+func doSignedRequest(t *testing.T, method, url, body, secret string, tamper func(req *http.Request)) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signing.SignRequest(req, secret); err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(req)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSignedAuthMode(t *testing.T) {
+	srv := newTestServerWithAuthMode(t, common.SignedAuthMode)
+	url := srv.URL + "/api/v1/queues/orders/messages"
+
+	if status := doSignedRequest(t, "POST", url, `{"content":"x"}`, testAuthSecret, nil); status != http.StatusNoContent {
+		t.Fatalf("signed produce: %d, want 204", status)
+	}
+	// the body is restored after the verification, so the handler consumes the message produced above
+	if status := doSignedRequest(t, "GET", url, "", testAuthSecret, nil); status != http.StatusOK {
+		t.Fatalf("signed consume: %d, want 200", status)
+	}
+
+	rejected := map[string]func(req *http.Request){
+		"tampered body": func(req *http.Request) {
+			req.Body = io.NopCloser(strings.NewReader(`{"content":"y"}`))
+		},
+		"tampered path": func(req *http.Request) {
+			req.URL.Path = "/api/v1/queues/emails/messages"
+		},
+		"stale timestamp": func(req *http.Request) {
+			stale := time.Now().Add(-signing.MaxClockSkew - time.Minute).Unix()
+			req.Header.Set(signing.TimestampHeader, strconv.FormatInt(stale, 10))
+			req.Header.Set(signing.SignatureHeader, signing.Sign("POST", req.URL.RequestURI(), stale, []byte(`{"content":"x"}`), testAuthSecret))
+		},
+		"unknown key ID": func(req *http.Request) {
+			req.Header.Set(signing.KeyIdHeader, "00000000")
+		},
+		"wrong secret": func(req *http.Request) {
+			timestamp, _ := strconv.ParseInt(req.Header.Get(signing.TimestampHeader), 10, 64)
+			req.Header.Set(signing.SignatureHeader, signing.Sign("POST", req.URL.RequestURI(), timestamp, []byte(`{"content":"x"}`), "another-secret-that-is-32-chars-long"))
+		},
+	}
+	for name, tamper := range rejected {
+		if status := doSignedRequest(t, "POST", url, `{"content":"x"}`, testAuthSecret, tamper); status != http.StatusUnauthorized {
+			t.Errorf("%s: %d, want 401", name, status)
+		}
+	}
+
+	// the bearer secret isn't accepted in the signed mode; a new server, as
+	// the failures above have locked out the IP
+	srv = newTestServerWithAuthMode(t, common.SignedAuthMode)
+	resp, _ := doRequest(t, "POST", srv.URL+"/api/v1/queues/orders/messages", `{"content":"x"}`, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("X-API-Key in signed mode: %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestAnyAuthMode(t *testing.T) {
+	srv := newTestServerWithAuthMode(t, common.AnyAuthMode)
+	url := srv.URL + "/api/v1/queues/orders/messages"
+
+	if status := doSignedRequest(t, "POST", url, `{"content":"x"}`, testAuthSecret, nil); status != http.StatusNoContent {
+		t.Fatalf("signed produce: %d, want 204", status)
+	}
+	resp, body := doRequest(t, "POST", url, `{"content":"x"}`, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("X-API-Key produce: %d %s", resp.StatusCode, body)
+	}
+
+	// a bad signature isn't retried as an X-API-Key request
+	tampered := func(req *http.Request) {
+		req.Header.Set("X-API-Key", testAuthSecret)
+		req.Header.Set(signing.SignatureHeader, "bogus")
+	}
+	if status := doSignedRequest(t, "POST", url, `{"content":"x"}`, testAuthSecret, tampered); status != http.StatusUnauthorized {
+		t.Fatalf("bad signature with a valid X-API-Key: %d, want 401", status)
+	}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := testutil.NewTestCA(t)
+	certFile, keyFile := ca.WriteCert(t, dir, "forq")
+	tlsService, err := services.NewTlsService(certFile, keyFile, ca.WriteCaFile(t, dir), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tlsService.Close)
+
+	srv := httptest.NewUnstartedServer(newTestHandler(t, common.SignedAuthMode, configs.RateLimitsSettings{}))
+	srv.TLS = tlsService.ServerConfig(true)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	newClient := func(commonName string) *http.Client {
+		var certs []tls.Certificate
+		if commonName != "" {
+			clientCertFile, clientKeyFile := ca.WriteCert(t, dir, commonName)
+			cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			certs = append(certs, cert)
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+	do := func(client *http.Client, method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(respBody)
+	}
+
+	// the certificate acts as the API key named after its common name
+	admin := newClient("admin")
+	if status, _ := do(admin, "GET", "/api/v1/admin/api-keys", ""); status != http.StatusUnauthorized {
+		t.Fatalf("cert without a matching API key: %d, want 401", status)
+	}
+	// a client without a cert authenticates per the auth mode
+	noCert := newClient("")
+	req, _ := http.NewRequest("POST", srv.URL+"/api/v1/admin/api-keys", strings.NewReader(`{"name":"admin","scopes":[{"permission":"admin","queues":"*"}]}`))
+	if err := signing.SignRequest(req, testAuthSecret); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := noCert.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create API key: %d", resp.StatusCode)
+	}
+	status, body := do(admin, "POST", "/api/v1/admin/api-keys", `{"name":"orders-producer","scopes":[{"permission":"produce","queues":"orders"}]}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create API key with the admin cert: %d %s", status, body)
+	}
+
+	producer := newClient("orders-producer")
+	if status, body := do(producer, "POST", "/api/v1/queues/orders/messages", `{"content":"x"}`); status != http.StatusNoContent {
+		t.Fatalf("produce within scope: %d %s", status, body)
+	}
+	if status, _ := do(producer, "GET", "/api/v1/queues/orders/messages", ""); status != http.StatusForbidden {
+		t.Fatalf("consume out of scope: %d, want 403", status)
+	}
+
+	if status, _ := do(noCert, "POST", "/api/v1/queues/orders/messages", `{"content":"x"}`); status != http.StatusUnauthorized {
+		t.Fatalf("no cert, no signature: %d, want 401", status)
+	}
+}
+
 func TestHealthcheck(t *testing.T) {
 	srv := newTestServer(t)
 
@@ -267,3 +797,204 @@ func TestHealthcheck(t *testing.T) {
 		t.Fatalf("healthcheck: %d", resp.StatusCode)
 	}
 }
+
+func TestProbes(t *testing.T) {
+	srv := newTestServer(t)
+
+	// the probes need no auth
+	for _, path := range []string{"/livez", "/readyz"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%s: %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/readyz?verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var readiness common.ReadinessResponse
+	if err := json.NewDecoder(resp.Body).Decode(&readiness); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !readiness.Ready || len(readiness.Checks) != 4 {
+		t.Fatalf("verbose readyz: %d %+v", resp.StatusCode, readiness)
+	}
+	for _, check := range readiness.Checks {
+		if !check.Ok {
+			t.Errorf("check %+v failed", check)
+		}
+	}
+}
+
+func TestDrainEndpoint(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1/queues/orders/messages"
+
+	doRequest(t, "POST", base, `{"content":"hello"}`, nil)
+	resp, body := doRequest(t, "GET", base, "", nil)
+	var msg common.MessageResponse
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		t.Fatalf("consume: %d %s", resp.StatusCode, body)
+	}
+
+	if resp, body := doRequest(t, "POST", srv.URL+"/api/v1/admin/drain", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("drain: %d %s", resp.StatusCode, body)
+	}
+	// draining twice is fine
+	if resp, body := doRequest(t, "POST", srv.URL+"/api/v1/admin/drain", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("second drain: %d %s", resp.StatusCode, body)
+	}
+
+	if resp, _ := doRequest(t, "GET", srv.URL+"/readyz", "", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("readyz while draining: %d", resp.StatusCode)
+	}
+	resp, body = doRequest(t, "GET", base, "", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || errorCode(t, body) != common.ErrCodeServiceDraining {
+		t.Fatalf("consume while draining: %d %s", resp.StatusCode, body)
+	}
+	resp, _ = doRequest(t, "POST", base+"/"+msg.Id+"/ack", "", map[string]string{common.ReceiptHeader: msg.Receipt})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("ack while draining: %d", resp.StatusCode)
+	}
+
+	resp, body = doRequest(t, "GET", srv.URL+"/api/v1/admin/audit-log?action="+common.DrainInstanceAuditAction, "", nil)
+	if resp.StatusCode != http.StatusOK || strings.Count(body, "\n") != 2 || !strings.Contains(body, "already draining") {
+		t.Fatalf("audit log: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestBackupDownload(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, body := doRequest(t, "GET", srv.URL+"/api/v1/admin/backup?gzip", "", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("backup: %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if disposition := resp.Header.Get("Content-Disposition"); !strings.HasSuffix(disposition, `.db.gz"`) {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+	gr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "SQLite format 3\x00") {
+		t.Error("not an SQLite database")
+	}
+
+	resp, body = doRequest(t, "GET", srv.URL+"/api/v1/admin/audit-log?action="+common.BackupDatabaseAuditAction, "", nil)
+	if resp.StatusCode != http.StatusOK || strings.Count(body, "\n") != 1 {
+		t.Fatalf("audit log: %d %s", resp.StatusCode, body)
+	}
+}
+
+// TestTimeoutHandler lets the streaming paths run past the handle timeout.
+func TestTimeoutHandler(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(api.TimeoutHandler(slow, 10*time.Millisecond))
+	t.Cleanup(srv.Close)
+
+	for path, want := range map[string]int{
+		"/api/v1/admin/backup":              http.StatusOK,
+		"/api/v1/admin/queues/orders/pause": http.StatusServiceUnavailable,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+// TestStartupHandler serves the probes only, until the API router is in.
+func TestStartupHandler(t *testing.T) {
+	monitoringService := services.NewMonitoringService(filepath.Join(t.TempDir(), "forq.db"), configs.DefaultSettings().Health)
+	handler := api.NewStartupHandler(monitoringService, common.LocalEnv)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, _ := get("/livez"); status != http.StatusNoContent {
+		t.Fatalf("livez while starting: %d", status)
+	}
+	if status, body := get("/readyz"); status != http.StatusServiceUnavailable || !strings.Contains(body, common.ErrCodeServiceNotReady) {
+		t.Fatalf("readyz while starting: %d %s", status, body)
+	}
+	if status, body := get("/readyz?verbose"); status != http.StatusServiceUnavailable || !strings.Contains(body, `"message":"starting"`) {
+		t.Fatalf("verbose readyz while starting: %d %s", status, body)
+	}
+	if status, _ := get("/api/v1/queues/orders/messages"); status != http.StatusServiceUnavailable {
+		t.Fatalf("API while starting: %d", status)
+	}
+
+	handler.Started(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if status, _ := get("/api/v1/queues/orders/messages"); status != http.StatusTeapot {
+		t.Fatalf("API once started: %d", status)
+	}
+}
+
+func TestExportAuditLog(t *testing.T) {
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1"
+
+	if resp, body := doRequest(t, "POST", base+"/admin/queues/orders/pause", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("pause: %d %s", resp.StatusCode, body)
+	}
+	if resp, body := doRequest(t, "PUT", base+"/admin/queues/orders/quotas", `{"maxMessages":-1}`, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid quotas: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body := doRequest(t, "GET", base+"/admin/audit-log?queue=orders", "", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export: %d %q %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("export = %q, want 2 lines", body)
+	}
+	var quotas, pause common.AuditEntryResponse
+	if err := json.Unmarshal([]byte(lines[0]), &quotas); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &pause); err != nil {
+		t.Fatal(err)
+	}
+	if pause.Actor != "auth_secret:"+signing.KeyId(testAuthSecret) || pause.Action != common.PauseQueueAuditAction || pause.Outcome != common.SuccessAuditOutcome || pause.SourceIp == "" {
+		t.Errorf("pause entry = %+v", pause)
+	}
+	if quotas.Action != common.UpdateQuotasAuditAction || quotas.Outcome != common.FailureAuditOutcome || !strings.HasSuffix(quotas.Details, common.ErrCodeBadRequestInvalidMaxMessages) {
+		t.Errorf("rejected quotas entry = %+v", quotas)
+	}
+
+	resp, body = doRequest(t, "GET", base+"/admin/audit-log?since=yesterday", "", nil)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != common.ErrCodeBadRequestInvalidTimeRange {
+		t.Fatalf("invalid since: %d %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// StartupHandler is the API handler from the very start: the API listens
+// before the migrations run, so that a long migration doesn't fail the
+// liveness probe, and the readiness probe tells Forq is still starting. Until
+// Started swaps the API router in, the other requests get a 503.
+type StartupHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func NewStartupHandler(monitoringService *services.MonitoringService, env string) *StartupHandler {
+	ar := &Router{monitoringService: monitoringService, env: env}
+
+	router := chi.NewRouter()
+	router.Use(securityHeaders(env))
+
+	router.Get("/livez", ar.livez)
+	router.Get("/readyz", ar.readyz)
+	router.NotFound(func(w http.ResponseWriter, req *http.Request) {
+		ar.sendErrorResponse(w, http.StatusServiceUnavailable, common.ErrCodeServiceNotReady)
+	})
+
+	sh := &StartupHandler{}
+	sh.Started(router)
+	return sh
+}
+
+// Started makes the handler serve the API router from now on.
+func (sh *StartupHandler) Started(handler http.Handler) {
+	sh.handler.Store(&handler)
+}
+
+func (sh *StartupHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	(*sh.handler.Load()).ServeHTTP(w, req)
+}
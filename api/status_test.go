@@ -21,10 +21,19 @@ func TestHttpStatusForErrorCode(t *testing.T) {
 		common.ErrCodeBadRequestDlqOnlyOp:           http.StatusBadRequest,
 		common.ErrCodeBadRequestReceiptMissing:      http.StatusBadRequest,
 		common.ErrCodeBadRequestReceiptInvalid:      http.StatusBadRequest,
+		common.ErrCodeBadRequestInvalidKeyName:      http.StatusBadRequest,
+		common.ErrCodeBadRequestKeyNameTaken:        http.StatusBadRequest,
+		common.ErrCodeBadRequestInvalidScopes:       http.StatusBadRequest,
 		common.ErrCodeUnauthorized:                  http.StatusUnauthorized,
+		common.ErrCodeForbidden:                     http.StatusForbidden,
 		common.ErrCodeTooManyRequests:               http.StatusTooManyRequests,
+		common.ErrCodeTooManyRequestsQuotaMessages:  http.StatusTooManyRequests,
+		common.ErrCodeTooManyRequestsQuotaBytes:     http.StatusTooManyRequests,
+		common.ErrCodeTooManyRequestsRateLimit:      http.StatusTooManyRequests,
 		common.ErrCodeNotFoundMessage:               http.StatusNotFound,
+		common.ErrCodeNotFoundApiKey:                http.StatusNotFound,
 		common.ErrCodeServiceUnhealthy:              http.StatusServiceUnavailable,
+		common.ErrCodeServiceDraining:               http.StatusServiceUnavailable,
 		common.ErrCodeInternal:                      http.StatusInternalServerError,
 		"some.unknown.code":                         http.StatusInternalServerError,
 	}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// streamingPaths are exempt from the handle timeout: http.TimeoutHandler
+// buffers the whole response in memory and cuts it at the timeout, while the
+// DB backup download can be gigabytes and take minutes. Their handlers set
+// their own write deadline instead.
+var streamingPaths = map[string]bool{
+	"/api/v1/admin/backup": true,
+}
+
+// TimeoutHandler is http.TimeoutHandler, but for the streaming paths.
+func TimeoutHandler(handler http.Handler, timeout time.Duration) http.Handler {
+	timeoutHandler := http.TimeoutHandler(handler, timeout, "timeout")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if streamingPaths[req.URL.Path] {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		timeoutHandler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,145 @@
+package api_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	producerTraceId     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	producerTraceparent = "00-" + producerTraceId + "-00f067aa0ba902b7-01"
+)
+
+// otlpCollectorStub receives the spans exported over OTLP/HTTP.
+type otlpCollectorStub struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func newOtlpCollectorStub(t *testing.T) (*otlpCollectorStub, *httptest.Server) {
+	t.Helper()
+
+	stub := &otlpCollectorStub{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		var exportReq coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &exportReq); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		stub.mu.Lock()
+		for _, resourceSpans := range exportReq.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				stub.spans = append(stub.spans, scopeSpans.Spans...)
+			}
+		}
+		stub.mu.Unlock()
+
+		resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return stub, srv
+}
+
+func (s *otlpCollectorStub) span(t *testing.T, name string) *tracepb.Span {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.spans, func(span *tracepb.Span) bool { return span.Name == name })
+	if i < 0 {
+		var names []string
+		for _, span := range s.spans {
+			names = append(names, span.Name)
+		}
+		t.Fatalf("no %q span exported, got %v", name, names)
+	}
+	return s.spans[i]
+}
+
+func TestTracing(t *testing.T) {
+	stub, collector := newOtlpCollectorStub(t)
+	provider, err := tracing.NewProvider(configs.TracingSettings{Endpoint: collector.URL, SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1/queues/orders/messages"
+
+	resp, body := doRequest(t, "POST", base, `{"content":"hello"}`, map[string]string{"traceparent": producerTraceparent})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("produce: %d %s", resp.StatusCode, body)
+	}
+	resp, body = doRequest(t, "GET", base, "", nil)
+	var msg common.MessageResponse
+	if err := json.Unmarshal([]byte(body), &msg); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("consume: %d %s", resp.StatusCode, body)
+	}
+
+	// Forq's own produce span, in the producer's trace
+	if !strings.HasPrefix(msg.Traceparent, "00-"+producerTraceId+"-") || msg.Traceparent == producerTraceparent {
+		t.Fatalf("traceparent on consume = %q, want a span of trace %s", msg.Traceparent, producerTraceId)
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"POST /api/v1/queues/{queue}/messages", "MessagesService.ProcessNewMessage", "ForqRepo.InsertMessage"} {
+		if got := hex.EncodeToString(stub.span(t, name).TraceId); got != producerTraceId {
+			t.Errorf("%q span in trace %s, want the producer's %s", name, got, producerTraceId)
+		}
+	}
+	consumeSpan := stub.span(t, "MessagesService.GetMessageForConsuming")
+	if len(consumeSpan.Links) != 1 || hex.EncodeToString(consumeSpan.Links[0].TraceId) != producerTraceId {
+		t.Fatalf("consume span links = %v, want the produce span", consumeSpan.Links)
+	}
+	stub.span(t, "ForqRepo.SelectMessageForConsuming")
+}
+
+func TestTraceparentWithoutTracing(t *testing.T) {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+
+	srv := newTestServer(t)
+	base := srv.URL + "/api/v1/queues/orders/messages"
+
+	doRequest(t, "POST", base, `{"content":"traced"}`, map[string]string{"traceparent": producerTraceparent})
+	doRequest(t, "POST", base, `{"content":"untraced"}`, nil)
+
+	// the producer's traceparent is passed through as is
+	for _, want := range []string{producerTraceparent, ""} {
+		_, body := doRequest(t, "GET", base, "", nil)
+		var msg common.MessageResponse
+		if err := json.Unmarshal([]byte(body), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Traceparent != want {
+			t.Fatalf("traceparent of %q = %q, want %q", msg.Content, msg.Traceparent, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/services"
+)
+
+const usage = `usage:
+  forq                          run the server
+  forq config validate [path]   validate the config file (FORQ_CONFIG_FILE by default) with the FORQ_* env vars applied
+  forq backup <path>            write a snapshot of the database (FORQ_DB_PATH) into path, gzip-compressed if it ends with .gz`
+
+// runCommand runs the CLI subcommand and returns the exit code.
+func runCommand(args []string) int {
+	if len(args) >= 2 && args[0] == "config" && args[1] == "validate" && len(args) <= 3 {
+		path := os.Getenv("FORQ_CONFIG_FILE")
+		if len(args) == 3 {
+			path = args[2]
+		}
+		return validateConfig(path)
+	}
+	if len(args) == 2 && args[0] == "backup" {
+		return backup(args[1])
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+func validateConfig(path string) int {
+	_, err := configs.LoadSettings(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration is invalid:\n%s\n", err)
+		return 1
+	}
+
+	if path == "" {
+		fmt.Println("configuration is valid (no config file, env vars only)")
+	} else {
+		fmt.Printf("configuration is valid: %s\n", path)
+	}
+	return 0
+}
+
+// backup works while Forq runs: the snapshot is consistent, and the writes go
+// on meanwhile.
+func backup(path string) int {
+	settings, err := configs.LoadSettings(os.Getenv("FORQ_CONFIG_FILE"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration is invalid:\n%s\n", err)
+		return 1
+	}
+	// unlike on start, a missing DB is not created
+	dbPath, err := filepath.Abs(settings.DbPath)
+	if err == nil {
+		_, err = os.Stat(dbPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no database at %s: %v\n", settings.DbPath, err)
+		return 1
+	}
+
+	repo, err := db.NewSQLiteRepo(dbPath, settings.AppConfigs())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open the database at %s: %v\n", dbPath, err)
+		return 1
+	}
+	defer repo.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = services.NewBackupService(repo, dbPath, settings.Backup).BackupToFile(path, ctx)
+	if err != nil {
+		// the details are logged already
+		fmt.Fprintf(os.Stderr, "backup failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("database %s backed up into %s\n", dbPath, path)
+	return 0
+}
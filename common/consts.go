@@ -3,6 +3,13 @@ package common
 const (
 	DlqSuffix = "-dlq"
 
+	// MinAuthSecretLength applies to FORQ_AUTH_SECRET, FORQ_METRICS_AUTH_SECRET and the secrets file.
+	MinAuthSecretLength = 32
+
+	// QuotasUsageRefreshSec is how often the quotas usage is recounted from the DB. It is also the Retry-After
+	// for the producers over quota, as that's when the space freed by the consumers is noticed.
+	QuotasUsageRefreshSec = 5
+
 	// ReceiptHeader carries the delivery receipt on ack/nack requests.
 	ReceiptHeader = "X-Forq-Receipt"
 
@@ -20,6 +27,61 @@ const (
 	LinuxOS   = "linux"
 	MacOS     = "darwin"
 
+	// DLQ policies:
+	DefaultDlqPolicy = "default" // failed messages are moved to "<queue>-dlq"
+	NoDlqPolicy      = "none"    // failed messages are dropped
+	CustomDlqPolicy  = "custom"  // failed messages are moved to the configured DLQ, which can be shared by several queues
+
+	// API key permissions:
+	ProducePermission = "produce"
+	ConsumePermission = "consume"
+	AdminPermission   = "admin" // queue settings, redrive, pause/resume; implies produce and consume
+
+	// AllQueuesPattern is the API key queue pattern that matches every queue
+	AllQueuesPattern = "*"
+
+	// admin UI roles, each one includes the ones before:
+	ViewerRole   = "viewer"   // browses the queues and messages
+	OperatorRole = "operator" // requeues, deletes and redrives the messages, pauses and resumes the queues
+	AdminRole    = "admin"    // changes the queue settings, manages the API keys and the users
+
+	// API auth modes:
+	ApiKeyAuthMode = "api_key" // the X-API-Key header
+	SignedAuthMode = "signed"  // HMAC-SHA256 signed requests, see the signing package
+	AnyAuthMode    = "any"     // either of the above
+
+	// metrics backends:
+	PrometheusMetricsBackend = "prometheus" // scraped from the API's /metrics
+	StatsdMetricsBackend     = "statsd"     // pushed to a DogStatsD server, e.g. the Datadog agent
+
+	// audit log actions:
+	LoginAuditAction                 = "login"
+	LogoutAuditAction                = "logout"
+	DeleteAllDlqMessagesAuditAction  = "dlq.delete_all"
+	RequeueAllDlqMessagesAuditAction = "dlq.requeue_all"
+	DeleteDlqMessageAuditAction      = "dlq.delete_message"
+	RequeueDlqMessageAuditAction     = "dlq.requeue_message"
+	RedriveDlqAuditAction            = "dlq.redrive"
+	PauseQueueAuditAction            = "queue.pause"
+	ResumeQueueAuditAction           = "queue.resume"
+	UpdateDlqPolicyAuditAction       = "queue.update_dlq_policy"
+	UpdateDeliveryLimitsAuditAction  = "queue.update_delivery_limits"
+	UpdateQuotasAuditAction          = "queue.update_quotas"
+	CreateApiKeyAuditAction          = "api_key.create"
+	DeleteApiKeyAuditAction          = "api_key.delete"
+	CreateUserAuditAction            = "user.create"
+	UpdateUserRoleAuditAction        = "user.update_role"
+	ResetUserPasswordAuditAction     = "user.reset_password"
+	DeleteUserAuditAction            = "user.delete"
+	RevokeSessionAuditAction         = "session.revoke"
+	UnlockAuditAction                = "lockout.unlock"
+	DrainInstanceAuditAction         = "instance.drain"
+	BackupDatabaseAuditAction        = "database.backup"
+
+	// audit log outcomes:
+	SuccessAuditOutcome = "success"
+	FailureAuditOutcome = "failure"
+
 	// reasons to move message to DLQ:
 	MaxAttemptsReachedFailureReason = "max_attempts_reached"
 	MessageExpiredFailureReason     = "message_expired"
@@ -30,4 +92,65 @@ var (
 		LocalEnv: true,
 		ProEnv:   true,
 	}
+
+	SupportedDlqPolicies = map[string]bool{
+		DefaultDlqPolicy: true,
+		NoDlqPolicy:      true,
+		CustomDlqPolicy:  true,
+	}
+
+	SupportedAuthModes = map[string]bool{
+		ApiKeyAuthMode: true,
+		SignedAuthMode: true,
+		AnyAuthMode:    true,
+	}
+
+	SupportedMetricsBackends = map[string]bool{
+		PrometheusMetricsBackend: true,
+		StatsdMetricsBackend:     true,
+	}
+
+	SupportedPermissions = map[string]bool{
+		ProducePermission: true,
+		ConsumePermission: true,
+		AdminPermission:   true,
+	}
+
+	// SupportedRoles maps the roles to their rank
+	SupportedRoles = map[string]int{
+		ViewerRole:   1,
+		OperatorRole: 2,
+		AdminRole:    3,
+	}
+
+	// AuditActions are listed in the audit page filter
+	AuditActions = []string{
+		LoginAuditAction,
+		LogoutAuditAction,
+		DeleteAllDlqMessagesAuditAction,
+		RequeueAllDlqMessagesAuditAction,
+		DeleteDlqMessageAuditAction,
+		RequeueDlqMessageAuditAction,
+		RedriveDlqAuditAction,
+		PauseQueueAuditAction,
+		ResumeQueueAuditAction,
+		UpdateDlqPolicyAuditAction,
+		UpdateDeliveryLimitsAuditAction,
+		UpdateQuotasAuditAction,
+		CreateApiKeyAuditAction,
+		DeleteApiKeyAuditAction,
+		CreateUserAuditAction,
+		UpdateUserRoleAuditAction,
+		ResetUserPasswordAuditAction,
+		DeleteUserAuditAction,
+		RevokeSessionAuditAction,
+		UnlockAuditAction,
+		DrainInstanceAuditAction,
+		BackupDatabaseAuditAction,
+	}
+
+	SupportedFailureReasons = map[string]bool{
+		MaxAttemptsReachedFailureReason: true,
+		MessageExpiredFailureReason:     true,
+	}
 )
@@ -11,10 +11,37 @@ const (
 	ErrCodeBadRequestDlqOnlyOp           = "bad_request.dlq_only_operation"
 	ErrCodeBadRequestReceiptMissing      = "bad_request.receipt.missing"
 	ErrCodeBadRequestReceiptInvalid      = "bad_request.receipt.invalid"
+	ErrCodeBadRequestInvalidTargetQueue  = "bad_request.body.targetQueue.invalid"
+	ErrCodeBadRequestInvalidFailReason   = "bad_request.body.failureReason.invalid"
+	ErrCodeBadRequestInvalidRate         = "bad_request.body.ratePerSecond.invalid"
+	ErrCodeBadRequestInvalidDlqPolicy    = "bad_request.body.dlqPolicy.invalid"
+	ErrCodeBadRequestInvalidDlqName      = "bad_request.body.dlqName.invalid"
+	ErrCodeBadRequestInvalidBurst        = "bad_request.body.burst.invalid"
+	ErrCodeBadRequestInvalidMaxInFlight  = "bad_request.body.maxInFlight.invalid"
+	ErrCodeBadRequestInvalidMaxMessages  = "bad_request.body.maxMessages.invalid"
+	ErrCodeBadRequestInvalidMaxBytes     = "bad_request.body.maxBytes.invalid"
+	ErrCodeBadRequestInvalidKeyName      = "bad_request.body.name.invalid"
+	ErrCodeBadRequestKeyNameTaken        = "bad_request.body.name.taken"
+	ErrCodeBadRequestInvalidScopes       = "bad_request.body.scopes.invalid"
+	ErrCodeBadRequestInvalidUsername     = "bad_request.body.username.invalid"
+	ErrCodeBadRequestUsernameTaken       = "bad_request.body.username.taken"
+	ErrCodeBadRequestInvalidPassword     = "bad_request.body.password.invalid"
+	ErrCodeBadRequestInvalidRole         = "bad_request.body.role.invalid"
+	ErrCodeBadRequestInvalidTimeRange    = "bad_request.query.time_range.invalid"
 	ErrCodeUnauthorized                  = "unauthorized"
+	ErrCodeForbidden                     = "forbidden"
 	ErrCodeTooManyRequests               = "too_many_requests"
+	ErrCodeTooManyRequestsQuotaMessages  = "too_many_requests.quota.messages"
+	ErrCodeTooManyRequestsQuotaBytes     = "too_many_requests.quota.bytes"
+	ErrCodeTooManyRequestsRateLimit      = "too_many_requests.rate_limit"
 	ErrCodeNotFoundMessage               = "not_found.message"
+	ErrCodeNotFoundApiKey                = "not_found.api_key"
+	ErrCodeNotFoundUser                  = "not_found.user"
+	ErrCodeNotFoundSession               = "not_found.session"
+	ErrCodeNotFoundLockout               = "not_found.lockout"
 	ErrCodeServiceUnhealthy              = "forq.unhealthy"
+	ErrCodeServiceNotReady               = "forq.not_ready"
+	ErrCodeServiceDraining               = "forq.draining"
 	ErrCodeInternal                      = "internal"
 )
 
@@ -28,7 +55,32 @@ var (
 	ErrBadRequestDlqOnlyOp           = ForqError{Code: ErrCodeBadRequestDlqOnlyOp}
 	ErrBadRequestReceiptMissing      = ForqError{Code: ErrCodeBadRequestReceiptMissing}
 	ErrBadRequestReceiptInvalid      = ForqError{Code: ErrCodeBadRequestReceiptInvalid}
+	ErrBadRequestInvalidTargetQueue  = ForqError{Code: ErrCodeBadRequestInvalidTargetQueue}
+	ErrBadRequestInvalidFailReason   = ForqError{Code: ErrCodeBadRequestInvalidFailReason}
+	ErrBadRequestInvalidRate         = ForqError{Code: ErrCodeBadRequestInvalidRate}
+	ErrBadRequestInvalidDlqPolicy    = ForqError{Code: ErrCodeBadRequestInvalidDlqPolicy}
+	ErrBadRequestInvalidDlqName      = ForqError{Code: ErrCodeBadRequestInvalidDlqName}
+	ErrBadRequestInvalidBurst        = ForqError{Code: ErrCodeBadRequestInvalidBurst}
+	ErrBadRequestInvalidMaxInFlight  = ForqError{Code: ErrCodeBadRequestInvalidMaxInFlight}
+	ErrBadRequestInvalidMaxMessages  = ForqError{Code: ErrCodeBadRequestInvalidMaxMessages}
+	ErrBadRequestInvalidMaxBytes     = ForqError{Code: ErrCodeBadRequestInvalidMaxBytes}
+	ErrBadRequestInvalidKeyName      = ForqError{Code: ErrCodeBadRequestInvalidKeyName}
+	ErrBadRequestKeyNameTaken        = ForqError{Code: ErrCodeBadRequestKeyNameTaken}
+	ErrBadRequestInvalidScopes       = ForqError{Code: ErrCodeBadRequestInvalidScopes}
+	ErrBadRequestInvalidUsername     = ForqError{Code: ErrCodeBadRequestInvalidUsername}
+	ErrBadRequestUsernameTaken       = ForqError{Code: ErrCodeBadRequestUsernameTaken}
+	ErrBadRequestInvalidPassword     = ForqError{Code: ErrCodeBadRequestInvalidPassword}
+	ErrBadRequestInvalidRole         = ForqError{Code: ErrCodeBadRequestInvalidRole}
+	ErrBadRequestInvalidTimeRange    = ForqError{Code: ErrCodeBadRequestInvalidTimeRange}
+	ErrTooManyRequestsQuotaMessages  = ForqError{Code: ErrCodeTooManyRequestsQuotaMessages}
+	ErrTooManyRequestsQuotaBytes     = ForqError{Code: ErrCodeTooManyRequestsQuotaBytes}
+	ErrUnauthorized                  = ForqError{Code: ErrCodeUnauthorized}
 	ErrNotFoundMessage               = ForqError{Code: ErrCodeNotFoundMessage}
+	ErrNotFoundApiKey                = ForqError{Code: ErrCodeNotFoundApiKey}
+	ErrNotFoundUser                  = ForqError{Code: ErrCodeNotFoundUser}
+	ErrNotFoundSession               = ForqError{Code: ErrCodeNotFoundSession}
+	ErrNotFoundLockout               = ForqError{Code: ErrCodeNotFoundLockout}
+	ErrServiceDraining               = ForqError{Code: ErrCodeServiceDraining}
 	ErrInternal                      = ForqError{Code: ErrCodeInternal}
 )
 
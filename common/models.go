@@ -2,8 +2,9 @@ package common
 
 // LoginPageData contains data for the login page
 type LoginPageData struct {
-	Title string
-	Error string
+	Title      string
+	Error      string
+	SsoEnabled bool
 }
 
 // DashboardPageData contains data for the dashboard page
@@ -21,6 +22,26 @@ type QueuePageData struct {
 	Queue *QueueStats
 }
 
+// QueueSettings represents the per-queue settings, with defaults for the queues that have never been configured
+type QueueSettings struct {
+	QueueName string
+	DlqPolicy string // "default", "none" or "custom"
+	DlqName   string // effective DLQ name, empty for the "none" policy
+	IsDLQ     bool
+	Paused    bool
+	PausedAt  int64  // Unix milliseconds, 0 if not paused
+	Error     string // validation error to show next to the settings form
+
+	// delivery limits, zero values mean unlimited
+	DeliveryRatePerSec float64
+	DeliveryBurst      int
+	MaxInFlight        int
+
+	// quotas, zero values mean unlimited
+	MaxMessages int64
+	MaxBytes    int64
+}
+
 // MessagesComponentData contains data for the messages component with cursor-based pagination
 type MessagesComponentData struct {
 	Messages   []MessageMetadata
@@ -30,11 +51,124 @@ type MessagesComponentData struct {
 	IsDLQ      bool   // Whether this is a DLQ queue (for action buttons)
 }
 
+// ApiKeysPageData contains data for the API keys management page
+type ApiKeysPageData struct {
+	Title   string
+	ApiKeys []ApiKey
+}
+
+// ApiKey represents an API key for UI display, the key itself is never shown after its creation
+type ApiKey struct {
+	ID        string
+	Name      string
+	Scopes    []ApiKeyScope
+	CreatedAt string
+}
+
+// ApiKeyCreatedData contains the outcome of an API key creation, the only time the key is shown
+type ApiKeyCreatedData struct {
+	Name  string
+	Key   string
+	Error string
+}
+
+// UsersPageData contains data for the users management page
+type UsersPageData struct {
+	Title string
+	Users []User
+}
+
+// User represents an admin UI account for UI display
+type User struct {
+	ID        string
+	Username  string
+	Role      string
+	CreatedAt string
+}
+
+// UserResultData contains the outcome of a user management action
+type UserResultData struct {
+	Message string
+	Error   string
+}
+
+// SessionsPageData contains data for the active sessions page
+type SessionsPageData struct {
+	Title           string
+	Sessions        []Session
+	IdleTimeout     string
+	AbsoluteTimeout string
+}
+
+// Session represents an admin UI session for UI display
+type Session struct {
+	ID           string // the hash of the session ID, not the ID itself
+	Username     string
+	Role         string
+	ClientIp     string
+	UserAgent    string
+	CreatedAt    string
+	LastActiveAt string
+	Current      bool
+}
+
+// AuditPageData contains data for the audit log page
+type AuditPageData struct {
+	Title   string
+	Entries []AuditEntry
+	Filter  AuditFilter
+	Actions []string
+	// NextPageUrl is empty on the last page
+	NextPageUrl string
+}
+
+// AuditEntry represents an audit log entry for UI display
+type AuditEntry struct {
+	CreatedAt string
+	Actor     string
+	SourceIp  string
+	Action    string
+	Queue     string
+	MessageId string
+	Count     string
+	Outcome   string
+	Details   string
+}
+
+// LockoutsPageData contains data for the auth lockouts page
+type LockoutsPageData struct {
+	Title      string
+	Lockouts   []Lockout
+	MaxFails   int
+	Window     string
+	Lockout    string
+	MaxLockout string
+	Persisted  bool
+}
+
+// Lockout represents an IP or a credential locked out after the failed auth
+// attempts, for UI display
+type Lockout struct {
+	Subject     string // e.g. "ip:203.0.113.7" or "user:jane"
+	Kind        string
+	Name        string
+	Strikes     int
+	LockedUntil string
+}
+
+// RedriveResultData contains the outcome of a DLQ redrive triggered from the queue page
+type RedriveResultData struct {
+	RedrivenCount int64
+	TargetQueue   string
+	Error         string
+}
+
 // QueueStats represents queue statistics for dashboard display
 type QueueStats struct {
 	Name          string
 	TotalMessages int
 	Type          string // "Regular" or "DLQ"
+	Paused        bool
 }
 
 // MessageMetadata represents basic metadata about a message with the idea of saving memory and network by not including full content
@@ -57,5 +191,6 @@ type MessageDetails struct {
 	ProcessAfter        string
 	ProcessingStartedAt string
 	FailureReason       string
+	OriginQueue         string
 	UpdatedAt           string
 }
@@ -4,3 +4,55 @@ type NewMessageRequest struct {
 	Content      string `json:"content"`
 	ProcessAfter int64  `json:"processAfter,omitempty"` // optional Unix timestamp in milliseconds
 }
+
+// RedriveRequest moves DLQ messages into an arbitrary target queue. All the
+// filters are optional: an empty request body (besides the target) redrives
+// the whole DLQ at once.
+type RedriveRequest struct {
+	TargetQueue    string `json:"targetQueue"`
+	FailureReason  string `json:"failureReason,omitempty"`  // optional, one of the DLQ failure reasons
+	ReceivedAfter  int64  `json:"receivedAfter,omitempty"`  // optional Unix timestamp in milliseconds, inclusive
+	ReceivedBefore int64  `json:"receivedBefore,omitempty"` // optional Unix timestamp in milliseconds, exclusive
+	RatePerSecond  int    `json:"ratePerSecond,omitempty"`  // optional, 0 means all messages become visible at once
+}
+
+type DlqPolicyRequest struct {
+	DlqPolicy string `json:"dlqPolicy"`         // default, none or custom
+	DlqName   string `json:"dlqName,omitempty"` // required for the custom policy only
+}
+
+// DeliveryLimitsRequest replaces all the delivery limits of a queue. Zero
+// values mean unlimited.
+type DeliveryLimitsRequest struct {
+	RatePerSecond float64 `json:"ratePerSecond,omitempty"` // deliveries per second, fractions allowed, e.g. 0.5 is one every 2 seconds
+	Burst         int     `json:"burst,omitempty"`         // deliveries allowed at once after an idle period, defaults to the rate
+	MaxInFlight   int     `json:"maxInFlight,omitempty"`   // messages being processed at once
+}
+
+// QuotasRequest replaces all the quotas of a queue. Zero values mean unlimited.
+type QuotasRequest struct {
+	MaxMessages int64 `json:"maxMessages,omitempty"`
+	MaxBytes    int64 `json:"maxBytes,omitempty"` // sum of the messages content sizes
+}
+
+// ApiKeyScope grants a permission on the queues matching the pattern, e.g.
+// "orders-*". The "*" pattern matches every queue.
+type ApiKeyScope struct {
+	Permission string `json:"permission"` // produce, consume or admin
+	Queues     string `json:"queues"`
+}
+
+type NewApiKeyRequest struct {
+	Name   string        `json:"name"`
+	Scopes []ApiKeyScope `json:"scopes"`
+}
+
+// AuditFilter selects the audit log entries. Zero values mean "no filter".
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Queue   string
+	Outcome string // success or failure
+	Since   int64  // Unix milliseconds, inclusive
+	Until   int64  // Unix milliseconds, exclusive
+}
@@ -8,8 +8,70 @@ type MessageResponse struct {
 	// from a consumer that exceeded the visibility timeout can't affect a
 	// redelivery claimed by another consumer. Opaque to clients.
 	Receipt string `json:"receipt"`
+	// Traceparent is the W3C trace context of the producer, if it was traced,
+	// for the consumer to link its spans to.
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+type RedriveResponse struct {
+	RedrivenCount int64 `json:"redrivenCount"`
+}
+
+type QueueSettingsResponse struct {
+	Queue                 string  `json:"queue"`
+	DlqPolicy             string  `json:"dlqPolicy"`
+	DlqName               string  `json:"dlqName,omitempty"`
+	DeliveryRatePerSecond float64 `json:"deliveryRatePerSecond,omitempty"`
+	DeliveryBurst         int     `json:"deliveryBurst,omitempty"`
+	MaxInFlight           int     `json:"maxInFlight,omitempty"`
+	MaxMessages           int64   `json:"maxMessages,omitempty"`
+	MaxBytes              int64   `json:"maxBytes,omitempty"`
+	Paused                bool    `json:"paused"`
+	PausedAt              int64   `json:"pausedAt,omitempty"` // Unix timestamp in milliseconds
+}
+
+type ApiKeyResponse struct {
+	Id        string        `json:"id"`
+	Name      string        `json:"name"`
+	Scopes    []ApiKeyScope `json:"scopes"`
+	CreatedAt int64         `json:"createdAt"` // Unix timestamp in milliseconds
+}
+
+// NewApiKeyResponse is the only time the key itself is returned: Forq stores
+// its hash only.
+type NewApiKeyResponse struct {
+	ApiKeyResponse
+	Key string `json:"key"`
+}
+
+// AuditEntryResponse is one line of the NDJSON audit log export.
+type AuditEntryResponse struct {
+	Id        int64  `json:"id"`
+	CreatedAt int64  `json:"createdAt"` // Unix timestamp in milliseconds
+	Actor     string `json:"actor"`
+	SourceIp  string `json:"sourceIp"`
+	Action    string `json:"action"`
+	Queue     string `json:"queue,omitempty"`
+	MessageId string `json:"messageId,omitempty"`
+	Count     *int64 `json:"count,omitempty"` // the number of messages affected by the bulk actions
+	Outcome   string `json:"outcome"`         // success or failure
+	Details   string `json:"details,omitempty"`
 }
 
 type ErrorResponse struct {
 	Code string `json:"code,omitempty"`
 }
+
+// ReadinessResponse is the verbose /readyz body, with a check per reason for
+// Forq not to be ready.
+type ReadinessResponse struct {
+	Ready  bool                     `json:"ready"`
+	Checks []ReadinessCheckResponse `json:"checks"`
+}
+
+type ReadinessCheckResponse struct {
+	Name      string  `json:"name"`
+	Ok        bool    `json:"ok"`
+	Message   string  `json:"message,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+}
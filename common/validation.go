@@ -15,6 +15,14 @@ func IsValidQueueName(name string) bool {
 	return queueNameRegex.MatchString(name)
 }
 
+// queuePatternRegex is the queue name charset plus the "*" and "?" wildcards
+// of the API key scopes.
+var queuePatternRegex = regexp.MustCompile(`^[a-zA-Z0-9._*?-]{1,64}$`)
+
+func IsValidQueuePattern(pattern string) bool {
+	return queuePatternRegex.MatchString(pattern)
+}
+
 func IsValidMessageId(messageId string) bool {
 	_, err := uuid.Parse(messageId)
 	return err == nil
@@ -60,3 +60,16 @@ func TestIsValidMessageId(t *testing.T) {
 		}
 	}
 }
+
+func TestIsValidQueuePattern(t *testing.T) {
+	for _, pattern := range []string{"*", "orders", "orders-*", "orders-??", "*.v2"} {
+		if !IsValidQueuePattern(pattern) {
+			t.Errorf("expected %q to be valid", pattern)
+		}
+	}
+	for _, pattern := range []string{"", "orders/*", "orders-[a-z]", "orders\\*"} {
+		if IsValidQueuePattern(pattern) {
+			t.Errorf("expected %q to be invalid", pattern)
+		}
+	}
+}
@@ -1,19 +1,38 @@
 package configs
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type AppConfigs struct {
+	PollingDurationMs int64 // Duration for which the queue is polled for new messages via HTTP2 long-polling
+	MetricsEnabled    bool  // Whether to enable metrics collection
+	GlobalQuotas      Quotas
+	JobsIntervals     JobsIntervals
+	ServerConfig      ServerConfig // Configuration for the server, including timeouts
+
+	limits Limits // reloaded on SIGHUP, hence behind the mutex
+	mu     sync.RWMutex
+}
+
+// Limits are the message limits, which can be changed on the fly: they only
+// apply to the messages produced or delivered from then on.
+type Limits struct {
 	MessageContentMaxSizeBytes int
 	MaxProcessAfterDelayMs     int64 // Maximum delay after which a message can be processed, in milliseconds. Applies to delays provided by the users via API.
 	MaxDeliveryAttempts        int
 	BackoffDelaysMs            []int64
 	QueueTtlMs                 int64
 	DlqTtlMs                   int64
-	PollingDurationMs          int64 // Duration for which the queue is polled for new messages via HTTP2 long-polling
 	MaxProcessingTimeMs        int64 // Maximum time allowed for processing a message before it is considered stale
-	MetricsEnabled             bool  // Whether to enable metrics collection
-	JobsIntervals              JobsIntervals
-	ServerConfig               ServerConfig // Configuration for the server, including timeouts
+}
+
+// Quotas limit how much data is stored, 0 means unlimited. The global quotas
+// apply to all the queues together, while the per-queue ones live in the DB.
+type Quotas struct {
+	MaxMessages int64 `yaml:"max_messages"`
+	MaxBytes    int64 `yaml:"max_bytes"` // sum of the messages content sizes
 }
 
 type JobsIntervals struct {
@@ -23,8 +42,10 @@ type JobsIntervals struct {
 	FailedDqlMessagesCleanupMs  int64 // Interval for cleaning up failed messages from the DLQ
 	StaleMessagesCleanupMs      int64 // Interval for cleaning up stale messages from the regular queue and DLQ
 	QueuesDepthMetricsMs        int64 // Interval for collecting queue depth metrics
+	DbStatsMetricsMs            int64 // Interval for collecting DB file sizes, page cache and contention metrics
 	DbOptimizationMs            int64 // Interval for running PRAGMA optimize on the database
 	DbOptimizationMaxDurationMs int64 // Maximum duration for the PRAGMA optimize operation not to block the DB for too long
+	AuditLogCleanupMs           int64 // Interval for deleting the audit log entries older than the retention
 }
 
 type ServerConfig struct {
@@ -39,19 +60,22 @@ type ServerTimeouts struct {
 	Idle       time.Duration
 }
 
-func NewAppConfig(metricsEnabled bool, queueTtlHours, dlqTtlHours int) *AppConfigs {
+func NewAppConfig(metricsEnabled bool, queueTtlHours, dlqTtlHours int, globalQuotas Quotas) *AppConfigs {
 	pollingDuration := 30 * time.Second
 
 	return &AppConfigs{
-		MessageContentMaxSizeBytes: 256 * 1024,                // 256 KB
-		MaxProcessAfterDelayMs:     366 * 24 * 60 * 60 * 1000, // 366 days
-		MaxDeliveryAttempts:        5,
-		BackoffDelaysMs:            []int64{1000, 5 * 1000, 15 * 1000, 30 * 1000, 60 * 1000}, // 1s, 5s, 15s, 30s, 60s
-		QueueTtlMs:                 int64(queueTtlHours) * 60 * 60 * 1000,                    // Convert hours to milliseconds
-		DlqTtlMs:                   int64(dlqTtlHours) * 60 * 60 * 1000,                      // Convert hours to milliseconds
-		PollingDurationMs:          pollingDuration.Milliseconds(),                           // 30 seconds
-		MaxProcessingTimeMs:        5 * 60 * 1000,                                            // 5 minutes
-		MetricsEnabled:             metricsEnabled,
+		limits: Limits{
+			MessageContentMaxSizeBytes: 256 * 1024,                // 256 KB
+			MaxProcessAfterDelayMs:     366 * 24 * 60 * 60 * 1000, // 366 days
+			MaxDeliveryAttempts:        5,
+			BackoffDelaysMs:            []int64{1000, 5 * 1000, 15 * 1000, 30 * 1000, 60 * 1000}, // 1s, 5s, 15s, 30s, 60s
+			QueueTtlMs:                 int64(queueTtlHours) * 60 * 60 * 1000,                    // Convert hours to milliseconds
+			DlqTtlMs:                   int64(dlqTtlHours) * 60 * 60 * 1000,                      // Convert hours to milliseconds
+			MaxProcessingTimeMs:        5 * 60 * 1000,                                            // 5 minutes
+		},
+		PollingDurationMs: pollingDuration.Milliseconds(), // 30 seconds
+		MetricsEnabled:    metricsEnabled,
+		GlobalQuotas:      globalQuotas,
 		JobsIntervals: JobsIntervals{
 			ExpiredMessagesCleanupMs:    5 * 60 * 1000,  // 5 minutes
 			ExpiredDlqMessagesCleanupMs: 62 * 60 * 1000, // 62 minutes (1h2m)
@@ -59,8 +83,10 @@ func NewAppConfig(metricsEnabled bool, queueTtlHours, dlqTtlHours int) *AppConfi
 			FailedDqlMessagesCleanupMs:  89 * 60 * 1000, // 89 minutes (1h29m)
 			StaleMessagesCleanupMs:      3 * 60 * 1000,  // 3 minutes
 			QueuesDepthMetricsMs:        30 * 1000,      // 30 seconds
+			DbStatsMetricsMs:            30 * 1000,      // 30 seconds
 			DbOptimizationMs:            60 * 60 * 1000, // 1 hour, as SQLite docs suggest for the apps with long-running connections: https://www.sqlite.org/pragma.html#pragma_optimize
 			DbOptimizationMaxDurationMs: 5 * 1000,       // 5 seconds max duration for PRAGMA optimize
+			AuditLogCleanupMs:           67 * 60 * 1000, // 67 minutes (1h7m)
 		},
 		ServerConfig: ServerConfig{
 			Timeouts: ServerTimeouts{
@@ -73,3 +99,16 @@ func NewAppConfig(metricsEnabled bool, queueTtlHours, dlqTtlHours int) *AppConfi
 		},
 	}
 }
+
+// Limits returns the current limits. The caller must not modify the BackoffDelaysMs.
+func (ac *AppConfigs) Limits() Limits {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	return ac.limits
+}
+
+func (ac *AppConfigs) SetLimits(limits Limits) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.limits = limits
+}
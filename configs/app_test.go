@@ -9,7 +9,7 @@ import (
 // original bug here was milliseconds multiplied by time.Second, which produced
 // ~8.3-hour timeouts while the comments claimed 40-45 seconds.
 func TestServerTimeouts(t *testing.T) {
-	cfg := NewAppConfig(false, 24, 168)
+	cfg := NewAppConfig(false, 24, 168, Quotas{})
 	timeouts := cfg.ServerConfig.Timeouts
 
 	if timeouts.Handle != 40*time.Second {
@@ -40,7 +40,7 @@ func TestServerTimeouts(t *testing.T) {
 }
 
 func TestJobsIntervals(t *testing.T) {
-	cfg := NewAppConfig(false, 24, 168)
+	cfg := NewAppConfig(false, 24, 168, Quotas{})
 
 	// PRAGMA optimize is recommended hourly; running it every minute was a bug
 	if cfg.JobsIntervals.DbOptimizationMs != 60*60*1000 {
@@ -56,6 +56,7 @@ func TestJobsIntervals(t *testing.T) {
 		"FailedDqlMessagesCleanupMs":  cfg.JobsIntervals.FailedDqlMessagesCleanupMs,
 		"StaleMessagesCleanupMs":      cfg.JobsIntervals.StaleMessagesCleanupMs,
 		"QueuesDepthMetricsMs":        cfg.JobsIntervals.QueuesDepthMetricsMs,
+		"DbStatsMetricsMs":            cfg.JobsIntervals.DbStatsMetricsMs,
 	} {
 		if interval < 10_000 {
 			t.Errorf("%s = %dms, suspiciously low", name, interval)
@@ -64,7 +65,7 @@ func TestJobsIntervals(t *testing.T) {
 }
 
 func TestTtlConversion(t *testing.T) {
-	cfg := NewAppConfig(false, 24, 168)
+	cfg := NewAppConfig(false, 24, 168, Quotas{}).Limits()
 	if cfg.QueueTtlMs != 24*60*60*1000 {
 		t.Errorf("QueueTtlMs = %d, want 24h in ms", cfg.QueueTtlMs)
 	}
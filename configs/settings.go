@@ -0,0 +1,781 @@
+package configs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxMessageContentSizeBytes bounds the configurable content size: the API
+// caps the produce request body at 2MB, which must fit the JSON-escaped content.
+const maxMessageContentSizeBytes = 1024 * 1024
+
+// minJobInterval keeps the jobs from hammering the DB: each run gets the
+// interval minus 1s as its timeout, too.
+const minJobInterval = 10 * time.Second
+
+// Settings are all the Forq settings: the defaults, overridden by the config
+// file (FORQ_CONFIG_FILE), overridden by the env vars. The yaml keys are the
+// config file schema.
+type Settings struct {
+	Env        string             `yaml:"env"`
+	DbPath     string             `yaml:"db_path"`
+	Auth       AuthSettings       `yaml:"auth"`
+	Server     ServerSettings     `yaml:"server"`
+	Tls        TlsSettings        `yaml:"tls"`
+	Metrics    MetricsSettings    `yaml:"metrics"`
+	Tracing    TracingSettings    `yaml:"tracing"`
+	Health     HealthSettings     `yaml:"health"`
+	Oidc       OidcSettings       `yaml:"oidc"`
+	Sessions   SessionsSettings   `yaml:"sessions"`
+	Audit      AuditSettings      `yaml:"audit"`
+	Backup     BackupSettings     `yaml:"backup"`
+	Throttling ThrottlingSettings `yaml:"throttling"`
+	RateLimits RateLimitsSettings `yaml:"rate_limits"`
+	Messages   MessagesSettings   `yaml:"messages"`
+	Quotas     Quotas             `yaml:"quotas"`
+	Jobs       JobsSettings       `yaml:"jobs"`
+}
+
+type AuthSettings struct {
+	Secret      string `yaml:"secret"`
+	SecretsFile string `yaml:"secrets_file"`
+	Mode        string `yaml:"mode"`
+}
+
+type ServerSettings struct {
+	ApiAddr           string           `yaml:"api_addr"`
+	UiAddr            string           `yaml:"ui_addr"`
+	SocketMode        string           `yaml:"socket_mode"` // octal, e.g. "0660"
+	TrustProxyHeaders bool             `yaml:"trust_proxy_headers"`
+	Timeouts          TimeoutsSettings `yaml:"timeouts"`
+}
+
+type TimeoutsSettings struct {
+	Handle     time.Duration `yaml:"handle"`
+	Write      time.Duration `yaml:"write"`
+	Read       time.Duration `yaml:"read"`
+	ReadHeader time.Duration `yaml:"read_header"`
+	Idle       time.Duration `yaml:"idle"`
+}
+
+type TlsSettings struct {
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ClientCaFile       string `yaml:"client_ca_file"`
+	ClientCertRequired bool   `yaml:"client_cert_required"`
+}
+
+type MetricsSettings struct {
+	Enabled    bool           `yaml:"enabled"`
+	Backend    string         `yaml:"backend"`
+	AuthSecret string         `yaml:"auth_secret"` // of the /metrics endpoint, for the prometheus backend only
+	Statsd     StatsdSettings `yaml:"statsd"`
+}
+
+// StatsdSettings are for the statsd metrics backend, which pushes the metrics
+// in the DogStatsD format, i.e. with tags.
+type StatsdSettings struct {
+	Addr   string   `yaml:"addr"`   // udp://host:port, or unix:///path/to/dsd.socket for a Unix datagram socket
+	Prefix string   `yaml:"prefix"` // of the metric names
+	Tags   []string `yaml:"tags"`   // added to all the metrics, e.g. env:prod
+}
+
+// TracingSettings enable the OpenTelemetry trace export, if the endpoint is
+// set.
+type TracingSettings struct {
+	Endpoint    string  `yaml:"endpoint"`     // the OTLP/HTTP collector, e.g. http://localhost:4318
+	SampleRatio float64 `yaml:"sample_ratio"` // of the traces started by Forq, the sampled parents are always followed
+}
+
+// HealthSettings are for the /readyz readiness probe, and the drain before
+// the shutdown, which lets the load balancer notice the probe failing and the
+// consumers ack/nack their messages before the servers stop.
+type HealthSettings struct {
+	MinFreeDiskBytes      int64         `yaml:"min_free_disk_bytes"`     // on the DB's filesystem, 0 disables the check
+	MaxMissedJobTicks     int           `yaml:"max_missed_job_ticks"`    // a job that hasn't finished a tick for that many intervals is stuck
+	ShutdownDrain         time.Duration `yaml:"shutdown_drain"`          // no messages are handed out and /readyz fails, but the requests are still served, for that long on shutdown
+	ShutdownReleaseClaims bool          `yaml:"shutdown_release_claims"` // the messages handed out by this instance and not acked/nacked by the end of the drain are ready again right away
+}
+
+// OidcSettings enable the admin UI single sign-on, if the issuer is set.
+type OidcSettings struct {
+	IssuerUrl     string            `yaml:"issuer_url"`
+	ClientId      string            `yaml:"client_id"`
+	ClientSecret  string            `yaml:"client_secret"` // empty for a public client, PKCE protects the code exchange anyway
+	LoginSecret   string            `yaml:"login_secret"`  // seals the logins in progress into the browser's cookie, the client secret does if empty
+	RedirectUrl   string            `yaml:"redirect_url"`  // the UI's /oidc/callback, as registered at the IdP
+	Scopes        []string          `yaml:"scopes"`
+	GroupsClaim   string            `yaml:"groups_claim"`
+	AllowedGroups []string          `yaml:"allowed_groups"` // if set, the users must be in one of them
+	GroupRoles    map[string]string `yaml:"group_roles"`    // the highest role of the user's groups wins
+	DefaultRole   string            `yaml:"default_role"`   // for the users in none of the GroupRoles groups, empty rejects them
+}
+
+// SessionsSettings are the admin UI session timeouts: a session ends once
+// unused for IdleTimeout, or AbsoluteTimeout after its login at the latest.
+type SessionsSettings struct {
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	AbsoluteTimeout time.Duration `yaml:"absolute_timeout"`
+}
+
+// AuditSettings are for the audit log of the administrative actions.
+type AuditSettings struct {
+	Retention time.Duration `yaml:"retention"` // the older entries are deleted by the audit log cleanup job
+}
+
+// BackupSettings are for the scheduled backups of the DB, which are enabled if
+// the dir is set.
+type BackupSettings struct {
+	Dir      string        `yaml:"dir"`      // where the snapshots go, ideally another disk
+	Interval time.Duration `yaml:"interval"` // between the snapshots
+	Retain   int           `yaml:"retain"`   // the last snapshots kept, the older ones are deleted
+	Gzip     bool          `yaml:"gzip"`     // the snapshots are gzip-compressed
+}
+
+// ThrottlingSettings are for the lockouts after the failed auth attempts, per
+// client IP and per credential tried, e.g. a username.
+type ThrottlingSettings struct {
+	MaxFails   int           `yaml:"max_fails"` // within the window, to lock out
+	Window     time.Duration `yaml:"window"`
+	Lockout    time.Duration `yaml:"lockout"`     // the first one, each repeated one is twice as long
+	MaxLockout time.Duration `yaml:"max_lockout"` // also how long the repeated lockouts are remembered for
+	Persist    bool          `yaml:"persist"`     // in SQLite, to survive restarts and apply to all the instances sharing the DB
+}
+
+// RateLimitsSettings are the API request rate limits of the authenticated
+// clients, with separate budgets for the produce, the consume (incl. ack and
+// nack) and the admin routes.
+type RateLimitsSettings struct {
+	Produce RouteRateLimits `yaml:"produce"`
+	Consume RouteRateLimits `yaml:"consume"`
+	Admin   RouteRateLimits `yaml:"admin"`
+}
+
+// RouteRateLimits are per API key, or auth secret, and per client IP. Both
+// apply.
+type RouteRateLimits struct {
+	PerKey RateLimit `yaml:"per_key"`
+	PerIp  RateLimit `yaml:"per_ip"`
+}
+
+// RateLimit is a token bucket: RatePerSec requests per second on average, and
+// up to Burst at once. Zero rate means unlimited, zero burst means the rate
+// rounded up.
+type RateLimit struct {
+	RatePerSec float64 `yaml:"rate_per_sec"`
+	Burst      int     `yaml:"burst"`
+}
+
+type MessagesSettings struct {
+	MaxContentSizeBytes  int             `yaml:"max_content_size_bytes"`
+	MaxProcessAfterDelay time.Duration   `yaml:"max_process_after_delay"`
+	MaxDeliveryAttempts  int             `yaml:"max_delivery_attempts"`
+	BackoffDelays        []time.Duration `yaml:"backoff_delays"`
+	QueueTtl             time.Duration   `yaml:"queue_ttl"`
+	DlqTtl               time.Duration   `yaml:"dlq_ttl"`
+	PollingDuration      time.Duration   `yaml:"polling_duration"`
+	MaxProcessingTime    time.Duration   `yaml:"max_processing_time"`
+}
+
+type JobsSettings struct {
+	ExpiredMessagesCleanup    time.Duration `yaml:"expired_messages_cleanup"`
+	ExpiredDlqMessagesCleanup time.Duration `yaml:"expired_dlq_messages_cleanup"`
+	FailedMessagesCleanup     time.Duration `yaml:"failed_messages_cleanup"`
+	FailedDlqMessagesCleanup  time.Duration `yaml:"failed_dlq_messages_cleanup"`
+	StaleMessagesCleanup      time.Duration `yaml:"stale_messages_cleanup"`
+	QueuesDepthMetrics        time.Duration `yaml:"queues_depth_metrics"`
+	DbStatsMetrics            time.Duration `yaml:"db_stats_metrics"`
+	DbOptimization            time.Duration `yaml:"db_optimization"`
+	DbOptimizationMaxDuration time.Duration `yaml:"db_optimization_max_duration"`
+	AuditLogCleanup           time.Duration `yaml:"audit_log_cleanup"`
+}
+
+// Change is a setting that differs between two Settings. The secrets' values
+// are redacted.
+type Change struct {
+	Key        string // the yaml path, e.g. "messages.queue_ttl"
+	Old        string
+	New        string
+	Reloadable bool // applied on reload, or requires a restart otherwise
+}
+
+// secretKeys are never logged.
+var secretKeys = map[string]bool{
+	"auth.secret":         true,
+	"metrics.auth_secret": true,
+	"oidc.client_secret":  true,
+	"oidc.login_secret":   true,
+}
+
+// DefaultSettings are the NewAppConfig defaults, plus the ones of the env vars.
+func DefaultSettings() *Settings {
+	defaults := NewAppConfig(false, 24, 7*24, Quotas{})
+	limits := defaults.Limits()
+
+	backoffDelays := make([]time.Duration, 0, len(limits.BackoffDelaysMs))
+	for _, delayMs := range limits.BackoffDelaysMs {
+		backoffDelays = append(backoffDelays, msToDuration(delayMs))
+	}
+
+	return &Settings{
+		Env: common.ProEnv,
+		Auth: AuthSettings{
+			Mode: common.ApiKeyAuthMode,
+		},
+		Server: ServerSettings{
+			ApiAddr:    "localhost:8080", // safe default localhost only
+			UiAddr:     "localhost:8081", // safe default localhost only
+			SocketMode: "0660",           // the owner and the group, e.g. the app's user added to Forq's group
+			Timeouts: TimeoutsSettings{
+				Handle:     defaults.ServerConfig.Timeouts.Handle,
+				Write:      defaults.ServerConfig.Timeouts.Write,
+				Read:       defaults.ServerConfig.Timeouts.Read,
+				ReadHeader: defaults.ServerConfig.Timeouts.ReadHeader,
+				Idle:       defaults.ServerConfig.Timeouts.Idle,
+			},
+		},
+		Metrics: MetricsSettings{
+			Backend: common.PrometheusMetricsBackend,
+			Statsd: StatsdSettings{
+				Addr:   "udp://127.0.0.1:8125", // the DogStatsD default
+				Prefix: "forq.",
+			},
+		},
+		Tracing: TracingSettings{
+			SampleRatio: 1,
+		},
+		Health: HealthSettings{
+			MinFreeDiskBytes:  100 * 1024 * 1024, // 100MB, for the WAL and the VACUUMs
+			MaxMissedJobTicks: 3,
+		},
+		Oidc: OidcSettings{
+			Scopes:      []string{"openid", "profile", "email"},
+			GroupsClaim: "groups",
+		},
+		Sessions: SessionsSettings{
+			IdleTimeout:     24 * time.Hour,
+			AbsoluteTimeout: 7 * 24 * time.Hour,
+		},
+		Audit: AuditSettings{
+			Retention: 90 * 24 * time.Hour,
+		},
+		Backup: BackupSettings{
+			Interval: 24 * time.Hour,
+			Retain:   7,
+		},
+		Throttling: ThrottlingSettings{
+			MaxFails:   5,
+			Window:     time.Minute,
+			Lockout:    time.Minute,
+			MaxLockout: time.Hour,
+		},
+		Messages: MessagesSettings{
+			MaxContentSizeBytes:  limits.MessageContentMaxSizeBytes,
+			MaxProcessAfterDelay: msToDuration(limits.MaxProcessAfterDelayMs),
+			MaxDeliveryAttempts:  limits.MaxDeliveryAttempts,
+			BackoffDelays:        backoffDelays,
+			QueueTtl:             msToDuration(limits.QueueTtlMs),
+			DlqTtl:               msToDuration(limits.DlqTtlMs),
+			PollingDuration:      msToDuration(defaults.PollingDurationMs),
+			MaxProcessingTime:    msToDuration(limits.MaxProcessingTimeMs),
+		},
+		Jobs: JobsSettings{
+			ExpiredMessagesCleanup:    msToDuration(defaults.JobsIntervals.ExpiredMessagesCleanupMs),
+			ExpiredDlqMessagesCleanup: msToDuration(defaults.JobsIntervals.ExpiredDlqMessagesCleanupMs),
+			FailedMessagesCleanup:     msToDuration(defaults.JobsIntervals.FailedMessagesCleanupMs),
+			FailedDlqMessagesCleanup:  msToDuration(defaults.JobsIntervals.FailedDqlMessagesCleanupMs),
+			StaleMessagesCleanup:      msToDuration(defaults.JobsIntervals.StaleMessagesCleanupMs),
+			QueuesDepthMetrics:        msToDuration(defaults.JobsIntervals.QueuesDepthMetricsMs),
+			DbStatsMetrics:            msToDuration(defaults.JobsIntervals.DbStatsMetricsMs),
+			DbOptimization:            msToDuration(defaults.JobsIntervals.DbOptimizationMs),
+			DbOptimizationMaxDuration: msToDuration(defaults.JobsIntervals.DbOptimizationMaxDurationMs),
+			AuditLogCleanup:           msToDuration(defaults.JobsIntervals.AuditLogCleanupMs),
+		},
+	}
+}
+
+// LoadSettings loads the config file, if the path isn't empty, applies the env
+// vars on top of it, and validates the result. The validation error lists all
+// the problems found, one per line.
+func LoadSettings(path string) (*Settings, error) {
+	settings := DefaultSettings()
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open config file: %w", err)
+		}
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		// a typo in a key must not silently fall back to the default
+		decoder.KnownFields(true)
+		err = decoder.Decode(settings)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	err := settings.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	err = settings.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// applyEnv overrides the settings with the FORQ_* env vars that are set.
+func (s *Settings) applyEnv() error {
+	var errs []error
+
+	setString := func(name string, target *string) {
+		if v := os.Getenv(name); v != "" {
+			*target = v
+		}
+	}
+	setBool := func(name string, target *bool) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be true or false", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setInt := func(name string, target *int) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be an integer", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setFloat := func(name string, target *float64) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be a number", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setInt64 := func(name string, target *int64) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be an integer", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setList := func(name string, target *[]string) {
+		if v := os.Getenv(name); v != "" {
+			*target = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*target = append(*target, item)
+				}
+			}
+		}
+	}
+	setMap := func(name string, target *map[string]string) {
+		if v := os.Getenv(name); v != "" {
+			*target = make(map[string]string)
+			for _, pair := range strings.Split(v, ",") {
+				key, value, ok := strings.Cut(pair, "=")
+				if !ok {
+					errs = append(errs, fmt.Errorf("%s: must be comma-separated key=value pairs", name))
+					return
+				}
+				(*target)[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	setDuration := func(name string, target *time.Duration) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be a duration, e.g. 8h", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	setHours := func(name string, target *time.Duration) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be an integer number of hours", name))
+				return
+			}
+			*target = time.Duration(parsed) * time.Hour
+		}
+	}
+
+	setString("FORQ_ENV", &s.Env)
+	setString("FORQ_DB_PATH", &s.DbPath)
+	setString("FORQ_AUTH_SECRET", &s.Auth.Secret)
+	setString("FORQ_AUTH_SECRETS_FILE", &s.Auth.SecretsFile)
+	setString("FORQ_AUTH_MODE", &s.Auth.Mode)
+	setString("FORQ_API_ADDR", &s.Server.ApiAddr)
+	setString("FORQ_UI_ADDR", &s.Server.UiAddr)
+	setString("FORQ_SOCKET_MODE", &s.Server.SocketMode)
+	setBool("FORQ_TRUST_PROXY_HEADERS", &s.Server.TrustProxyHeaders)
+	setString("FORQ_TLS_CERT_FILE", &s.Tls.CertFile)
+	setString("FORQ_TLS_KEY_FILE", &s.Tls.KeyFile)
+	setString("FORQ_TLS_CLIENT_CA_FILE", &s.Tls.ClientCaFile)
+	setBool("FORQ_TLS_CLIENT_CERT_REQUIRED", &s.Tls.ClientCertRequired)
+	setBool("FORQ_METRICS_ENABLED", &s.Metrics.Enabled)
+	setString("FORQ_METRICS_BACKEND", &s.Metrics.Backend)
+	setString("FORQ_METRICS_AUTH_SECRET", &s.Metrics.AuthSecret)
+	setString("FORQ_STATSD_ADDR", &s.Metrics.Statsd.Addr)
+	setString("FORQ_STATSD_PREFIX", &s.Metrics.Statsd.Prefix)
+	setList("FORQ_STATSD_TAGS", &s.Metrics.Statsd.Tags)
+	setString("FORQ_TRACING_ENDPOINT", &s.Tracing.Endpoint)
+	setFloat("FORQ_TRACING_SAMPLE_RATIO", &s.Tracing.SampleRatio)
+	setInt64("FORQ_HEALTH_MIN_FREE_DISK_BYTES", &s.Health.MinFreeDiskBytes)
+	setInt("FORQ_HEALTH_MAX_MISSED_JOB_TICKS", &s.Health.MaxMissedJobTicks)
+	setDuration("FORQ_SHUTDOWN_DRAIN", &s.Health.ShutdownDrain)
+	setBool("FORQ_SHUTDOWN_RELEASE_CLAIMS", &s.Health.ShutdownReleaseClaims)
+	setString("FORQ_OIDC_ISSUER_URL", &s.Oidc.IssuerUrl)
+	setString("FORQ_OIDC_CLIENT_ID", &s.Oidc.ClientId)
+	setString("FORQ_OIDC_CLIENT_SECRET", &s.Oidc.ClientSecret)
+	setString("FORQ_OIDC_LOGIN_SECRET", &s.Oidc.LoginSecret)
+	setString("FORQ_OIDC_REDIRECT_URL", &s.Oidc.RedirectUrl)
+	setList("FORQ_OIDC_SCOPES", &s.Oidc.Scopes)
+	setString("FORQ_OIDC_GROUPS_CLAIM", &s.Oidc.GroupsClaim)
+	setList("FORQ_OIDC_ALLOWED_GROUPS", &s.Oidc.AllowedGroups)
+	setMap("FORQ_OIDC_GROUP_ROLES", &s.Oidc.GroupRoles)
+	setString("FORQ_OIDC_DEFAULT_ROLE", &s.Oidc.DefaultRole)
+	setDuration("FORQ_SESSION_IDLE_TIMEOUT", &s.Sessions.IdleTimeout)
+	setDuration("FORQ_SESSION_ABSOLUTE_TIMEOUT", &s.Sessions.AbsoluteTimeout)
+	setDuration("FORQ_AUDIT_RETENTION", &s.Audit.Retention)
+	setString("FORQ_BACKUP_DIR", &s.Backup.Dir)
+	setDuration("FORQ_BACKUP_INTERVAL", &s.Backup.Interval)
+	setInt("FORQ_BACKUP_RETAIN", &s.Backup.Retain)
+	setBool("FORQ_BACKUP_GZIP", &s.Backup.Gzip)
+	setInt("FORQ_THROTTLING_MAX_FAILS", &s.Throttling.MaxFails)
+	setDuration("FORQ_THROTTLING_WINDOW", &s.Throttling.Window)
+	setDuration("FORQ_THROTTLING_LOCKOUT", &s.Throttling.Lockout)
+	setDuration("FORQ_THROTTLING_MAX_LOCKOUT", &s.Throttling.MaxLockout)
+	setBool("FORQ_THROTTLING_PERSIST", &s.Throttling.Persist)
+	setFloat("FORQ_RATE_LIMIT_PRODUCE_PER_KEY", &s.RateLimits.Produce.PerKey.RatePerSec)
+	setFloat("FORQ_RATE_LIMIT_PRODUCE_PER_IP", &s.RateLimits.Produce.PerIp.RatePerSec)
+	setFloat("FORQ_RATE_LIMIT_CONSUME_PER_KEY", &s.RateLimits.Consume.PerKey.RatePerSec)
+	setFloat("FORQ_RATE_LIMIT_CONSUME_PER_IP", &s.RateLimits.Consume.PerIp.RatePerSec)
+	setFloat("FORQ_RATE_LIMIT_ADMIN_PER_KEY", &s.RateLimits.Admin.PerKey.RatePerSec)
+	setFloat("FORQ_RATE_LIMIT_ADMIN_PER_IP", &s.RateLimits.Admin.PerIp.RatePerSec)
+	setHours("FORQ_QUEUE_TTL_HOURS", &s.Messages.QueueTtl)
+	setHours("FORQ_DLQ_TTL_HOURS", &s.Messages.DlqTtl)
+	setInt64("FORQ_MAX_MESSAGES", &s.Quotas.MaxMessages)
+	setInt64("FORQ_MAX_BYTES", &s.Quotas.MaxBytes)
+
+	return errors.Join(errs...)
+}
+
+// Validate returns all the problems found, one per line.
+func (s *Settings) Validate() error {
+	var errs []error
+	check := func(ok bool, key string, problem string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(problem, args...)))
+		}
+	}
+
+	check(common.SupportedEnvs[s.Env], "env", "unsupported environment %q", s.Env)
+	check(s.DbPath != "", "db_path", "is required")
+
+	check(s.Auth.Secret != "" || s.Auth.SecretsFile != "", "auth.secret", "is required, unless auth.secrets_file is set")
+	check(s.Auth.Secret == "" || len(s.Auth.Secret) >= common.MinAuthSecretLength, "auth.secret", "must be at least %d characters", common.MinAuthSecretLength)
+	check(common.SupportedAuthModes[s.Auth.Mode], "auth.mode", "unsupported auth mode %q", s.Auth.Mode)
+
+	check(s.Server.ApiAddr != "", "server.api_addr", "is required")
+	check(s.Server.UiAddr != "", "server.ui_addr", "is required")
+	check(s.Server.ApiAddr != s.Server.UiAddr, "server.ui_addr", "must differ from server.api_addr")
+	_, err := s.SocketFileMode()
+	check(err == nil, "server.socket_mode", "must be octal permissions, e.g. 0660")
+
+	timeouts := s.Server.Timeouts
+	polling := s.Messages.PollingDuration
+	// otherwise every empty long poll gets cut off by the server
+	check(timeouts.Handle > polling, "server.timeouts.handle", "must exceed messages.polling_duration (%s)", polling)
+	check(timeouts.Write > timeouts.Handle, "server.timeouts.write", "must exceed server.timeouts.handle (%s)", timeouts.Handle)
+	check(timeouts.Read > polling, "server.timeouts.read", "must exceed messages.polling_duration (%s)", polling)
+	check(timeouts.ReadHeader > 0, "server.timeouts.read_header", "must be positive")
+	check(timeouts.Idle > 0, "server.timeouts.idle", "must be positive")
+
+	check((s.Tls.CertFile == "") == (s.Tls.KeyFile == ""), "tls.key_file", "must be set together with tls.cert_file")
+	check(s.Tls.ClientCaFile == "" || s.Tls.CertFile != "", "tls.client_ca_file", "requires tls.cert_file and tls.key_file")
+	check(!s.Tls.ClientCertRequired || s.Tls.ClientCaFile != "", "tls.client_cert_required", "requires tls.client_ca_file")
+
+	check(common.SupportedMetricsBackends[s.Metrics.Backend], "metrics.backend", "unsupported metrics backend %q", s.Metrics.Backend)
+	if s.Metrics.Enabled && s.Metrics.Backend == common.PrometheusMetricsBackend {
+		check(s.Metrics.AuthSecret != "", "metrics.auth_secret", "is required when metrics are enabled")
+		check(s.Metrics.AuthSecret == "" || len(s.Metrics.AuthSecret) >= common.MinAuthSecretLength, "metrics.auth_secret", "must be at least %d characters", common.MinAuthSecretLength)
+	}
+	if s.Metrics.Enabled && s.Metrics.Backend == common.StatsdMetricsBackend {
+		_, _, err := s.Metrics.Statsd.NetworkAddr()
+		check(err == nil, "metrics.statsd.addr", "must be udp://host:port or unix:///path/to/socket")
+		for _, tag := range s.Metrics.Statsd.Tags {
+			check(!strings.ContainsAny(tag, ",|#\n"), "metrics.statsd.tags", "tag %q must not contain any of: , | # or a newline", tag)
+		}
+	}
+
+	check(s.Tracing.Endpoint == "" || isAbsoluteUrl(s.Tracing.Endpoint), "tracing.endpoint", "must be an absolute URL, e.g. http://localhost:4318")
+	check(s.Tracing.SampleRatio >= 0 && s.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1")
+
+	check(s.Health.MinFreeDiskBytes >= 0, "health.min_free_disk_bytes", "must not be negative")
+	// a job finishes its ticks up to an interval apart, plus the tick duration
+	check(s.Health.MaxMissedJobTicks >= 2, "health.max_missed_job_ticks", "must be at least 2")
+	check(s.Health.ShutdownDrain >= 0 && s.Health.ShutdownDrain <= 5*time.Minute, "health.shutdown_drain", "must be between 0s and 5m")
+
+	if s.Oidc.IssuerUrl != "" {
+		check(isAbsoluteUrl(s.Oidc.IssuerUrl), "oidc.issuer_url", "must be an absolute URL")
+		check(s.Oidc.ClientId != "", "oidc.client_id", "is required with oidc.issuer_url")
+		check(isAbsoluteUrl(s.Oidc.RedirectUrl), "oidc.redirect_url", "must be the absolute URL of the UI's /oidc/callback")
+		check(s.Oidc.LoginSecret != "" || s.Oidc.ClientSecret != "", "oidc.login_secret", "is required without oidc.client_secret")
+		check(s.Oidc.LoginSecret == "" || len(s.Oidc.LoginSecret) >= common.MinAuthSecretLength, "oidc.login_secret", "must be at least %d characters", common.MinAuthSecretLength)
+		check(slices.Contains(s.Oidc.Scopes, "openid"), "oidc.scopes", "must include openid")
+		check(s.Oidc.GroupsClaim != "", "oidc.groups_claim", "is required with oidc.issuer_url")
+		check(len(s.Oidc.GroupRoles) > 0 || s.Oidc.DefaultRole != "", "oidc.group_roles", "or oidc.default_role is required, otherwise nobody can log in")
+		for group, role := range s.Oidc.GroupRoles {
+			_, ok := common.SupportedRoles[role]
+			check(ok, "oidc.group_roles."+group, "unsupported role %q", role)
+		}
+		_, ok := common.SupportedRoles[s.Oidc.DefaultRole]
+		check(s.Oidc.DefaultRole == "" || ok, "oidc.default_role", "unsupported role %q", s.Oidc.DefaultRole)
+	}
+
+	check(s.Sessions.IdleTimeout >= time.Minute, "sessions.idle_timeout", "must be at least 1m")
+	check(s.Sessions.AbsoluteTimeout >= s.Sessions.IdleTimeout, "sessions.absolute_timeout", "must be at least sessions.idle_timeout (%s)", s.Sessions.IdleTimeout)
+
+	check(s.Audit.Retention >= 24*time.Hour, "audit.retention", "must be at least 24h")
+
+	check(s.Backup.Interval >= time.Minute, "backup.interval", "must be at least 1m")
+	check(s.Backup.Retain >= 1, "backup.retain", "must be at least 1")
+
+	t := s.Throttling
+	check(t.MaxFails >= 1, "throttling.max_fails", "must be at least 1")
+	check(t.Window >= time.Second, "throttling.window", "must be at least 1s")
+	check(t.Lockout >= time.Second, "throttling.lockout", "must be at least 1s")
+	check(t.MaxLockout >= t.Lockout, "throttling.max_lockout", "must be at least throttling.lockout (%s)", t.Lockout)
+
+	rl := s.RateLimits
+	for _, limit := range []struct {
+		key string
+		RateLimit
+	}{
+		{"rate_limits.produce.per_key", rl.Produce.PerKey},
+		{"rate_limits.produce.per_ip", rl.Produce.PerIp},
+		{"rate_limits.consume.per_key", rl.Consume.PerKey},
+		{"rate_limits.consume.per_ip", rl.Consume.PerIp},
+		{"rate_limits.admin.per_key", rl.Admin.PerKey},
+		{"rate_limits.admin.per_ip", rl.Admin.PerIp},
+	} {
+		check(limit.RatePerSec >= 0, limit.key+".rate_per_sec", "must not be negative")
+		check(limit.Burst >= 0, limit.key+".burst", "must not be negative")
+	}
+
+	m := s.Messages
+	check(m.MaxContentSizeBytes > 0 && m.MaxContentSizeBytes <= maxMessageContentSizeBytes, "messages.max_content_size_bytes", "must be between 1 and %d", maxMessageContentSizeBytes)
+	check(m.MaxProcessAfterDelay > 0, "messages.max_process_after_delay", "must be positive")
+	check(m.MaxDeliveryAttempts >= 1, "messages.max_delivery_attempts", "must be at least 1")
+	check(len(m.BackoffDelays) > 0, "messages.backoff_delays", "must have at least one delay")
+	for i, delay := range m.BackoffDelays {
+		check(delay >= time.Millisecond, fmt.Sprintf("messages.backoff_delays[%d]", i), "must be at least 1ms")
+	}
+	check(m.QueueTtl >= time.Hour, "messages.queue_ttl", "must be at least 1h")
+	check(m.DlqTtl >= time.Hour, "messages.dlq_ttl", "must be at least 1h")
+	check(m.PollingDuration >= time.Second, "messages.polling_duration", "must be at least 1s")
+	check(m.MaxProcessingTime >= time.Second, "messages.max_processing_time", "must be at least 1s")
+
+	check(s.Quotas.MaxMessages >= 0, "quotas.max_messages", "must not be negative")
+	check(s.Quotas.MaxBytes >= 0, "quotas.max_bytes", "must not be negative")
+
+	for key, interval := range map[string]time.Duration{
+		"jobs.expired_messages_cleanup":     s.Jobs.ExpiredMessagesCleanup,
+		"jobs.expired_dlq_messages_cleanup": s.Jobs.ExpiredDlqMessagesCleanup,
+		"jobs.failed_messages_cleanup":      s.Jobs.FailedMessagesCleanup,
+		"jobs.failed_dlq_messages_cleanup":  s.Jobs.FailedDlqMessagesCleanup,
+		"jobs.stale_messages_cleanup":       s.Jobs.StaleMessagesCleanup,
+		"jobs.queues_depth_metrics":         s.Jobs.QueuesDepthMetrics,
+		"jobs.db_stats_metrics":             s.Jobs.DbStatsMetrics,
+		"jobs.db_optimization":              s.Jobs.DbOptimization,
+		"jobs.audit_log_cleanup":            s.Jobs.AuditLogCleanup,
+	} {
+		check(interval >= minJobInterval, key, "must be at least %s", minJobInterval)
+	}
+	check(s.Jobs.DbOptimizationMaxDuration >= time.Second, "jobs.db_optimization_max_duration", "must be at least 1s")
+
+	return errors.Join(errs...)
+}
+
+// SocketFileMode returns the permissions of the Unix domain sockets.
+func (s *Settings) SocketFileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s.Server.SocketMode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("socket mode %s is out of range", s.Server.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// NetworkAddr returns the network and the address to dial for the Addr:
+// udp and host:port, or unixgram and the socket path.
+func (ss StatsdSettings) NetworkAddr() (string, string, error) {
+	parsed, err := url.Parse(ss.Addr)
+	if err != nil {
+		return "", "", err
+	}
+	switch {
+	case parsed.Scheme == "udp" && parsed.Host != "" && parsed.Port() != "":
+		return "udp", parsed.Host, nil
+	case parsed.Scheme == "unix" && parsed.Path != "":
+		return "unixgram", parsed.Path, nil
+	}
+	return "", "", fmt.Errorf("unsupported statsd address %s", ss.Addr)
+}
+
+// AppConfigs returns the settings the services, the repo and the jobs run with.
+func (s *Settings) AppConfigs() *AppConfigs {
+	return &AppConfigs{
+		PollingDurationMs: s.Messages.PollingDuration.Milliseconds(),
+		MetricsEnabled:    s.Metrics.Enabled,
+		GlobalQuotas:      s.Quotas,
+		JobsIntervals: JobsIntervals{
+			ExpiredMessagesCleanupMs:    s.Jobs.ExpiredMessagesCleanup.Milliseconds(),
+			ExpiredDlqMessagesCleanupMs: s.Jobs.ExpiredDlqMessagesCleanup.Milliseconds(),
+			FailedMessagesCleanupMs:     s.Jobs.FailedMessagesCleanup.Milliseconds(),
+			FailedDqlMessagesCleanupMs:  s.Jobs.FailedDlqMessagesCleanup.Milliseconds(),
+			StaleMessagesCleanupMs:      s.Jobs.StaleMessagesCleanup.Milliseconds(),
+			QueuesDepthMetricsMs:        s.Jobs.QueuesDepthMetrics.Milliseconds(),
+			DbStatsMetricsMs:            s.Jobs.DbStatsMetrics.Milliseconds(),
+			DbOptimizationMs:            s.Jobs.DbOptimization.Milliseconds(),
+			DbOptimizationMaxDurationMs: s.Jobs.DbOptimizationMaxDuration.Milliseconds(),
+			AuditLogCleanupMs:           s.Jobs.AuditLogCleanup.Milliseconds(),
+		},
+		ServerConfig: ServerConfig{
+			Timeouts: ServerTimeouts{
+				Handle:     s.Server.Timeouts.Handle,
+				Write:      s.Server.Timeouts.Write,
+				Read:       s.Server.Timeouts.Read,
+				ReadHeader: s.Server.Timeouts.ReadHeader,
+				Idle:       s.Server.Timeouts.Idle,
+			},
+		},
+		limits: s.Limits(),
+	}
+}
+
+func (s *Settings) Limits() Limits {
+	backoffDelaysMs := make([]int64, 0, len(s.Messages.BackoffDelays))
+	for _, delay := range s.Messages.BackoffDelays {
+		backoffDelaysMs = append(backoffDelaysMs, delay.Milliseconds())
+	}
+
+	return Limits{
+		MessageContentMaxSizeBytes: s.Messages.MaxContentSizeBytes,
+		MaxProcessAfterDelayMs:     s.Messages.MaxProcessAfterDelay.Milliseconds(),
+		MaxDeliveryAttempts:        s.Messages.MaxDeliveryAttempts,
+		BackoffDelaysMs:            backoffDelaysMs,
+		QueueTtlMs:                 s.Messages.QueueTtl.Milliseconds(),
+		DlqTtlMs:                   s.Messages.DlqTtl.Milliseconds(),
+		MaxProcessingTimeMs:        s.Messages.MaxProcessingTime.Milliseconds(),
+	}
+}
+
+// Reload applies the reloadable settings of newSettings, which are the message
+// limits and the quotas, and returns all the changes, including the ones that
+// take a restart and are not applied. The caller passes the applied settings
+// on to the services.
+func (s *Settings) Reload(newSettings *Settings) []Change {
+	changes := s.changes(newSettings)
+
+	// the polling duration is tied to the server timeouts, which are fixed at startup
+	pollingDuration := s.Messages.PollingDuration
+	s.Messages = newSettings.Messages
+	s.Messages.PollingDuration = pollingDuration
+	s.Quotas = newSettings.Quotas
+	return changes
+}
+
+func isReloadable(key string) bool {
+	return key != "messages.polling_duration" &&
+		(strings.HasPrefix(key, "messages.") || strings.HasPrefix(key, "quotas."))
+}
+
+func (s *Settings) changes(newSettings *Settings) []Change {
+	oldValues := flatten(reflect.ValueOf(*s), "")
+	newValues := flatten(reflect.ValueOf(*newSettings), "")
+
+	var changes []Change
+	for _, key := range sortedKeys(oldValues) {
+		oldValue, newValue := oldValues[key], newValues[key]
+		if oldValue == newValue {
+			continue
+		}
+		if secretKeys[key] {
+			oldValue, newValue = "<redacted>", "<redacted>"
+		}
+		changes = append(changes, Change{Key: key, Old: oldValue, New: newValue, Reloadable: isReloadable(key)})
+	}
+	return changes
+}
+
+// flatten maps the yaml paths of the leaf settings to their printed values.
+func flatten(value reflect.Value, prefix string) map[string]string {
+	result := make(map[string]string)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.Type.Kind() == reflect.Struct {
+			for k, v := range flatten(value.Field(i), key+".") {
+				result[k] = v
+			}
+			continue
+		}
+		result[key] = fmt.Sprint(value.Field(i).Interface())
+	}
+	return result
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func isAbsoluteUrl(rawUrl string) bool {
+	parsed, err := url.Parse(rawUrl)
+	return err == nil && (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != ""
+}
+
+func msToDuration(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,273 @@
+package configs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forq.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestDefaultSettings pins the defaults to the NewAppConfig ones, so that
+// running without a config file changes nothing.
+func TestDefaultSettings(t *testing.T) {
+	settings := DefaultSettings()
+	settings.DbPath = "forq.db"
+	settings.Auth.Secret = testSecret
+	if err := settings.Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+
+	want := NewAppConfig(false, 24, 7*24, Quotas{})
+	got := settings.AppConfigs()
+	if !reflect.DeepEqual(got.Limits(), want.Limits()) {
+		t.Errorf("limits = %+v, want %+v", got.Limits(), want.Limits())
+	}
+	if got.PollingDurationMs != want.PollingDurationMs || got.JobsIntervals != want.JobsIntervals || got.ServerConfig != want.ServerConfig {
+		t.Errorf("app configs = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadSettings_FileAndEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, `
+db_path: /var/lib/forq/forq.db
+auth:
+  secret: `+testSecret+`
+server:
+  api_addr: unix:/run/forq/api.sock
+  socket_mode: "0600"
+messages:
+  max_delivery_attempts: 3
+  backoff_delays: [2s, 1m]
+  queue_ttl: 48h
+quotas:
+  max_messages: 1000
+`)
+	t.Setenv("FORQ_QUEUE_TTL_HOURS", "12")
+	t.Setenv("FORQ_MAX_BYTES", "1048576")
+
+	settings, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.DbPath != "/var/lib/forq/forq.db" || settings.Server.ApiAddr != "unix:/run/forq/api.sock" {
+		t.Errorf("file settings not applied: %+v", settings)
+	}
+	if mode, err := settings.SocketFileMode(); err != nil || mode != 0600 {
+		t.Errorf("socket mode = %v %v, want 0600", mode, err)
+	}
+	// the defaults fill the gaps
+	if settings.Server.UiAddr != "localhost:8081" || settings.Messages.DlqTtl != 7*24*time.Hour {
+		t.Errorf("defaults not applied: %+v", settings)
+	}
+
+	limits := settings.Limits()
+	if limits.MaxDeliveryAttempts != 3 || !reflect.DeepEqual(limits.BackoffDelaysMs, []int64{2000, 60000}) {
+		t.Errorf("limits = %+v", limits)
+	}
+	// the env vars win over the file
+	if settings.Messages.QueueTtl != 12*time.Hour {
+		t.Errorf("queue TTL = %v, want the env var's 12h", settings.Messages.QueueTtl)
+	}
+	if settings.Quotas != (Quotas{MaxMessages: 1000, MaxBytes: 1048576}) {
+		t.Errorf("quotas = %+v", settings.Quotas)
+	}
+}
+
+func TestLoadSettings_RejectsUnknownKeys(t *testing.T) {
+	path := writeConfigFile(t, "messages:\n  max_delivery_attempt: 3\n")
+
+	_, err := LoadSettings(path)
+	if err == nil || !strings.Contains(err.Error(), "max_delivery_attempt") {
+		t.Fatalf("error = %v, want the unknown key reported", err)
+	}
+}
+
+func TestLoadSettings_ReportsAllProblems(t *testing.T) {
+	path := writeConfigFile(t, `
+env: staging
+auth:
+  secret: too-short
+server:
+  socket_mode: "999"
+tls:
+  cert_file: /etc/forq/tls.crt
+tracing:
+  sample_ratio: 2
+sessions:
+  idle_timeout: 10s
+audit:
+  retention: 1h
+throttling:
+  lockout: 10m
+  max_lockout: 5m
+rate_limits:
+  consume:
+    per_ip:
+      burst: -1
+messages:
+  polling_duration: 1m
+  max_delivery_attempts: 0
+  backoff_delays: []
+health:
+  max_missed_job_ticks: 1
+jobs:
+  stale_messages_cleanup: 1s
+`)
+	t.Setenv("FORQ_DB_PATH", "forq.db")
+
+	_, err := LoadSettings(path)
+	if err == nil {
+		t.Fatal("invalid settings loaded")
+	}
+	for _, key := range []string{
+		"env:",
+		"auth.secret:",
+		"server.socket_mode:",
+		"tls.key_file:",
+		"server.timeouts.handle:",
+		"server.timeouts.read:",
+		"tracing.sample_ratio:",
+		"sessions.idle_timeout:",
+		"audit.retention:",
+		"throttling.max_lockout:",
+		"rate_limits.consume.per_ip.burst:",
+		"health.max_missed_job_ticks:",
+		"messages.max_delivery_attempts:",
+		"messages.backoff_delays:",
+		"jobs.stale_messages_cleanup:",
+	} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("problem with %s not reported in:\n%v", key, err)
+		}
+	}
+}
+
+func TestLoadSettings_InvalidEnvVar(t *testing.T) {
+	t.Setenv("FORQ_DB_PATH", "forq.db")
+	t.Setenv("FORQ_AUTH_SECRET", testSecret)
+	t.Setenv("FORQ_DLQ_TTL_HOURS", "a week")
+
+	_, err := LoadSettings("")
+	if err == nil || !strings.Contains(err.Error(), "FORQ_DLQ_TTL_HOURS") {
+		t.Fatalf("error = %v, want the env var reported", err)
+	}
+}
+
+func TestSettingsReload(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Auth.Secret = testSecret
+
+	newSettings := DefaultSettings()
+	newSettings.Auth.Secret = strings.Repeat("x", 32)
+	newSettings.Server.ApiAddr = "0.0.0.0:8080"
+	newSettings.Messages.MaxDeliveryAttempts = 10
+	newSettings.Messages.PollingDuration = 20 * time.Second
+	newSettings.Quotas.MaxMessages = 500
+
+	changes := settings.Reload(newSettings)
+
+	want := []Change{
+		{Key: "auth.secret", Old: "<redacted>", New: "<redacted>"},
+		{Key: "messages.max_delivery_attempts", Old: "5", New: "10", Reloadable: true},
+		{Key: "messages.polling_duration", Old: "30s", New: "20s"},
+		{Key: "quotas.max_messages", Old: "0", New: "500", Reloadable: true},
+		{Key: "server.api_addr", Old: "localhost:8080", New: "0.0.0.0:8080"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %+v\nwant %+v", changes, want)
+	}
+
+	// only the reloadable ones are applied
+	if settings.Messages.MaxDeliveryAttempts != 10 || settings.Quotas.MaxMessages != 500 {
+		t.Errorf("reloadable settings not applied: %+v", settings)
+	}
+	if settings.Messages.PollingDuration != 30*time.Second || settings.Server.ApiAddr != "localhost:8080" || settings.Auth.Secret != testSecret {
+		t.Errorf("restart-only settings applied: %+v", settings)
+	}
+}
+
+func TestLoadSettings_Oidc(t *testing.T) {
+	path := writeConfigFile(t, `
+db_path: forq.db
+auth:
+  secret: `+testSecret+`
+oidc:
+  issuer_url: https://idp.example.com
+  client_id: forq
+  redirect_url: https://forq.example.com/oidc/callback
+  allowed_groups: [engineering]
+`)
+	t.Setenv("FORQ_OIDC_LOGIN_SECRET", "oidc-login-secret-that-is-32-chars")
+	t.Setenv("FORQ_OIDC_GROUP_ROLES", "forq-admins=admin, sre=operator")
+
+	settings, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"forq-admins": "admin", "sre": "operator"}
+	if !reflect.DeepEqual(settings.Oidc.GroupRoles, want) || !reflect.DeepEqual(settings.Oidc.Scopes, []string{"openid", "profile", "email"}) {
+		t.Errorf("oidc = %+v", settings.Oidc)
+	}
+
+	t.Setenv("FORQ_OIDC_GROUP_ROLES", "forq-admins=root")
+	t.Setenv("FORQ_OIDC_REDIRECT_URL", "/oidc/callback")
+	t.Setenv("FORQ_OIDC_LOGIN_SECRET", "")
+	_, err = LoadSettings(path)
+	if err == nil || !strings.Contains(err.Error(), "oidc.group_roles.forq-admins:") || !strings.Contains(err.Error(), "oidc.redirect_url:") || !strings.Contains(err.Error(), "oidc.login_secret:") {
+		t.Fatalf("error = %v, want the unsupported role, the relative URL and the missing login secret reported", err)
+	}
+}
+
+func TestLoadSettings_StatsdMetrics(t *testing.T) {
+	path := writeConfigFile(t, `
+db_path: forq.db
+auth:
+  secret: `+testSecret+`
+metrics:
+  enabled: true
+  backend: statsd
+  statsd:
+    addr: unix:///var/run/datadog/dsd.socket
+`)
+	t.Setenv("FORQ_STATSD_TAGS", "env:prod, region:eu")
+
+	// no metrics.auth_secret, as there's no /metrics endpoint to protect
+	settings, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network, addr, err := settings.Metrics.Statsd.NetworkAddr()
+	if err != nil || network != "unixgram" || addr != "/var/run/datadog/dsd.socket" {
+		t.Errorf("network addr = %s %s %v", network, addr, err)
+	}
+	if settings.Metrics.Statsd.Prefix != "forq." || !reflect.DeepEqual(settings.Metrics.Statsd.Tags, []string{"env:prod", "region:eu"}) {
+		t.Errorf("statsd = %+v", settings.Metrics.Statsd)
+	}
+
+	t.Setenv("FORQ_STATSD_ADDR", "localhost:8125")
+	t.Setenv("FORQ_STATSD_TAGS", "env|prod")
+	_, err = LoadSettings(path)
+	if err == nil || !strings.Contains(err.Error(), "metrics.statsd.addr:") || !strings.Contains(err.Error(), "metrics.statsd.tags:") {
+		t.Fatalf("error = %v, want the address without a scheme and the broken tag reported", err)
+	}
+
+	t.Setenv("FORQ_METRICS_BACKEND", "graphite")
+	_, err = LoadSettings(path)
+	if err == nil || !strings.Contains(err.Error(), "metrics.backend:") {
+		t.Fatalf("error = %v, want the unsupported backend reported", err)
+	}
+}
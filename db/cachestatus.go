@@ -0,0 +1,104 @@
+package db
+
+/*
+#include <stdint.h>
+
+typedef struct sqlite3 sqlite3;
+typedef struct sqlite3_stmt sqlite3_stmt;
+typedef struct sqlite3_context sqlite3_context;
+typedef struct sqlite3_value sqlite3_value;
+typedef struct sqlite3_api_routines sqlite3_api_routines;
+
+int sqlite3_auto_extension(void (*xEntryPoint)(void));
+int sqlite3_create_function_v2(sqlite3*, const char*, int, int, void*,
+	void (*xFunc)(sqlite3_context*, int, sqlite3_value**),
+	void (*xStep)(sqlite3_context*, int, sqlite3_value**),
+	void (*xFinal)(sqlite3_context*),
+	void (*xDestroy)(void*));
+sqlite3 *sqlite3_context_db_handle(sqlite3_context*);
+void sqlite3_result_int(sqlite3_context*, int);
+int sqlite3_trace_v2(sqlite3*, unsigned, int (*)(unsigned, void*, void*, void*), void*);
+sqlite3 *sqlite3_db_handle(sqlite3_stmt*);
+int sqlite3_db_status(sqlite3*, int, int*, int*, int);
+
+// from sqlite3.h
+#define FORQ_SQLITE_UTF8 1
+#define FORQ_SQLITE_DIRECTONLY 0x000080000
+#define FORQ_SQLITE_TRACE_PROFILE 0x02
+#define FORQ_SQLITE_DBSTATUS_CACHE_HIT 7
+#define FORQ_SQLITE_DBSTATUS_CACHE_MISS 8
+
+static int64_t forq_cache_hits;
+static int64_t forq_cache_misses;
+
+// runs once a statement is done, on its own connection, so the connection is
+// neither borrowed nor waited for
+static int forq_collect_cache_status(unsigned type, void *ctx, void *stmt, void *elapsed) {
+	sqlite3 *db = sqlite3_db_handle((sqlite3_stmt*)stmt);
+	int cur, highwater;
+	if (sqlite3_db_status(db, FORQ_SQLITE_DBSTATUS_CACHE_HIT, &cur, &highwater, 1) == 0) {
+		__atomic_fetch_add(&forq_cache_hits, cur, __ATOMIC_RELAXED);
+	}
+	if (sqlite3_db_status(db, FORQ_SQLITE_DBSTATUS_CACHE_MISS, &cur, &highwater, 1) == 0) {
+		__atomic_fetch_add(&forq_cache_misses, cur, __ATOMIC_RELAXED);
+	}
+	return 0;
+}
+
+static void forq_track_cache_status(sqlite3_context *ctx, int argc, sqlite3_value **argv) {
+	sqlite3_result_int(ctx, sqlite3_trace_v2(sqlite3_context_db_handle(ctx), FORQ_SQLITE_TRACE_PROFILE, forq_collect_cache_status, 0));
+}
+
+static int forq_cache_status_extension(sqlite3 *db, char **errMsg, const sqlite3_api_routines *api) {
+	return sqlite3_create_function_v2(db, "forq_track_cache_status", 0, FORQ_SQLITE_UTF8 | FORQ_SQLITE_DIRECTONLY, 0,
+		forq_track_cache_status, 0, 0, 0);
+}
+
+static int forq_register_cache_status(void) {
+	return sqlite3_auto_extension((void (*)(void))forq_cache_status_extension);
+}
+
+static void forq_cache_status(int64_t *hits, int64_t *misses) {
+	*hits = __atomic_load_n(&forq_cache_hits, __ATOMIC_RELAXED);
+	*misses = __atomic_load_n(&forq_cache_misses, __ATOMIC_RELAXED);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// go-sqlite3 doesn't wrap sqlite3_db_status(), nor does it expose the SQLite
+// handles of the connections, so the page cache stats are collected in C: an
+// auto extension adds the forq_track_cache_status() SQL function to every new
+// connection, and the connections that call it add their page cache hits and
+// misses to the totals once each statement is done. SQLite is the one bundled
+// with go-sqlite3, so the functions are linked from there.
+
+// registerCacheStatus must be called before the first connection is opened.
+func registerCacheStatus() error {
+	if rc := C.forq_register_cache_status(); rc != 0 {
+		return fmt.Errorf("register the page cache status extension: %w", sqlite3.ErrNo(rc))
+	}
+	return nil
+}
+
+// trackCacheStatus makes the connection count its page cache hits and misses
+// into the totals.
+func trackCacheStatus(conn *sqlite3.SQLiteConn) error {
+	if _, err := conn.Exec("SELECT forq_track_cache_status()", nil); err != nil {
+		return fmt.Errorf("track the page cache status: %w", err)
+	}
+	return nil
+}
+
+// cacheStatus returns the page cache hits and misses of the tracked
+// connections of the process since its start.
+func cacheStatus() (hits int64, misses int64) {
+	var cHits, cMisses C.int64_t
+	C.forq_cache_status(&cHits, &cMisses)
+	return int64(cHits), int64(cMisses)
+}
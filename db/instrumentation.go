@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/n0rdy/forq/tracing"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentedDB starts a span around each query of the ForqRepo methods and
+// counts the SQLITE_BUSY and SQLITE_LOCKED errors. Only the execution is
+// traced, not the scanning of the rows, while the errors are counted on the
+// scanning too, as SQLite only steps through the statement there. The queries
+// of the transactions are neither traced nor counted.
+type instrumentedDB struct {
+	*sql.DB
+	errs *errorCounters
+}
+
+// errorCounters are shared by the read and the write pools.
+type errorCounters struct {
+	busy   atomic.Int64
+	locked atomic.Int64
+}
+
+func (idb instrumentedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	res, err := idb.DB.ExecContext(ctx, query, args...)
+	idb.errs.count(err)
+	tracing.End(span, err)
+	return res, err
+}
+
+func (idb instrumentedDB) QueryContext(ctx context.Context, query string, args ...any) (instrumentedRows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := idb.DB.QueryContext(ctx, query, args...)
+	idb.errs.count(err)
+	tracing.End(span, err)
+	return instrumentedRows{Rows: rows, errs: idb.errs}, err
+}
+
+func (idb instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...any) instrumentedRow {
+	ctx, span := startQuerySpan(ctx, query)
+	row := idb.DB.QueryRowContext(ctx, query, args...)
+	// sql.ErrNoRows only comes up on Scan, so it doesn't fail the span
+	tracing.End(span, row.Err())
+	return instrumentedRow{Row: row, errs: idb.errs}
+}
+
+type instrumentedRows struct {
+	*sql.Rows
+	errs *errorCounters
+}
+
+func (ir instrumentedRows) Err() error {
+	err := ir.Rows.Err()
+	ir.errs.count(err)
+	return err
+}
+
+type instrumentedRow struct {
+	*sql.Row
+	errs *errorCounters
+}
+
+func (ir instrumentedRow) Scan(dest ...any) error {
+	err := ir.Row.Scan(dest...)
+	ir.errs.count(err)
+	return err
+}
+
+func (ec *errorCounters) count(err error) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy:
+		ec.busy.Add(1)
+	case sqlite3.ErrLocked:
+		ec.locked.Add(1)
+	}
+}
+
+// startQuerySpan names the span after the ForqRepo method running the query,
+// e.g. "ForqRepo.InsertMessage". The caller is only looked up if the span is
+// sampled, so that it costs nothing with the tracing disabled.
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	query = strings.TrimSpace(query)
+	operation, _, _ := strings.Cut(query, " ")
+
+	ctx, span := tracing.Start(ctx, operation,
+		attribute.String("db.system.name", "sqlite"),
+		attribute.String("db.operation.name", strings.ToUpper(operation)),
+	)
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("db.query.text", strings.Join(strings.Fields(query), " ")))
+		// 0 is this function, 1 is the instrumentedDB method
+		if pc, _, _, ok := runtime.Caller(2); ok {
+			if fn := runtime.FuncForPC(pc); fn != nil {
+				span.SetName(repoMethodName(fn.Name()))
+			}
+		}
+	}
+	return ctx, span
+}
+
+// repoMethodName turns "github.com/n0rdy/forq/db.(*ForqRepo).InsertMessage"
+// into "ForqRepo.InsertMessage".
+func repoMethodName(funcName string) string {
+	funcName = funcName[strings.LastIndex(funcName, "/")+1:]
+	funcName = strings.TrimPrefix(funcName, "db.")
+	return strings.NewReplacer("(*", "", ")", "").Replace(funcName)
+}
@@ -0,0 +1,5 @@
+-- drops tables
+DROP TABLE IF EXISTS queue_settings;
+
+-- drops columns
+ALTER TABLE messages DROP COLUMN origin_queue;
@@ -0,0 +1,18 @@
+-- Queue the message was moved to the DLQ from. Recorded on every DLQ move, so that requeueing works
+-- for custom and shared DLQs, where the origin can't be derived from the DLQ name.
+ALTER TABLE messages ADD COLUMN origin_queue TEXT; -- NULL for messages in regular queues
+
+-- backfills the messages already sitting in DLQs, which were all named "<origin>-dlq".
+-- 4 = len(common.DlqSuffix) ("-dlq") as of this migration: it is a literal, as the migrations are frozen,
+-- and the suffix of these older messages doesn't change if common.DlqSuffix ever does.
+UPDATE messages SET origin_queue = substr(queue, 1, length(queue) - 4) WHERE is_dlq = TRUE;
+
+-- Per-queue settings. Queues themselves are implicit (created by the first produced message),
+-- so a row exists only for the queues whose settings were changed from the defaults.
+CREATE TABLE queue_settings
+(
+    queue      TEXT PRIMARY KEY,
+    dlq_policy TEXT    NOT NULL DEFAULT 'default', -- default = "<queue>-dlq", none = drop failed messages, custom = dlq_name
+    dlq_name   TEXT,                               -- DLQ to move failed messages to for the custom policy, can be shared by several queues
+    updated_at INTEGER NOT NULL                    -- Unix milliseconds - Last update timestamp
+);
@@ -0,0 +1,4 @@
+-- drops columns
+ALTER TABLE queue_settings DROP COLUMN max_in_flight;
+ALTER TABLE queue_settings DROP COLUMN delivery_burst;
+ALTER TABLE queue_settings DROP COLUMN delivery_rate_per_sec;
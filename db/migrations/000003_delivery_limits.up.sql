@@ -0,0 +1,4 @@
+-- Per-queue delivery limits, enforced on consuming. NULL means unlimited.
+ALTER TABLE queue_settings ADD COLUMN delivery_rate_per_sec REAL;  -- token bucket refill rate, deliveries per second
+ALTER TABLE queue_settings ADD COLUMN delivery_burst INTEGER;      -- token bucket size, defaults to the rate rounded up
+ALTER TABLE queue_settings ADD COLUMN max_in_flight INTEGER;       -- max messages in the processing status at once
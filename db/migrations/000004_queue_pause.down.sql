@@ -0,0 +1,2 @@
+-- drops columns
+ALTER TABLE queue_settings DROP COLUMN paused_at;
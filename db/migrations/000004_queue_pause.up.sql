@@ -0,0 +1,2 @@
+-- Paused queues don't deliver messages to consumers, while producing still works.
+ALTER TABLE queue_settings ADD COLUMN paused_at INTEGER; -- Unix milliseconds - when the queue was paused, NULL if it is not
@@ -0,0 +1,7 @@
+-- drops indexes
+DROP INDEX IF EXISTS idx_queue_usage;
+
+-- drops columns
+ALTER TABLE queue_settings DROP COLUMN max_bytes;
+ALTER TABLE queue_settings DROP COLUMN max_messages;
+ALTER TABLE messages DROP COLUMN content_size;
@@ -0,0 +1,11 @@
+-- Content size in bytes, stored separately so that the quotas usage can be summed up without reading the content
+ALTER TABLE messages ADD COLUMN content_size INTEGER NOT NULL DEFAULT 0;
+UPDATE messages SET content_size = length(CAST(content AS BLOB));
+
+-- Covering index for the quotas usage: content_size is the last column of the rows, and reading it from the table
+-- would mean walking the overflow pages of the large contents
+CREATE INDEX idx_queue_usage ON messages (queue, content_size);
+
+-- Per-queue quotas, NULL means unlimited
+ALTER TABLE queue_settings ADD COLUMN max_messages INTEGER;
+ALTER TABLE queue_settings ADD COLUMN max_bytes INTEGER; -- sum of the messages content sizes
@@ -0,0 +1,9 @@
+-- Named API keys with per-queue scopes. FORQ_AUTH_SECRET keeps working on top of them as the bootstrap superuser key.
+CREATE TABLE api_keys
+(
+    id         TEXT PRIMARY KEY,
+    name       TEXT    NOT NULL UNIQUE,
+    key_hash   TEXT    NOT NULL UNIQUE, -- hex-encoded SHA-256 of the key, the key itself is shown only once on creation
+    scopes     TEXT    NOT NULL,        -- JSON array of {"permission": "produce|consume|admin", "queues": "<pattern>"}
+    created_at INTEGER NOT NULL         -- Unix milliseconds - Creation timestamp
+);
@@ -0,0 +1,9 @@
+-- Admin UI accounts. FORQ_AUTH_SECRET and the admin API keys keep working on top of them for the UI login, as admins.
+CREATE TABLE users
+(
+    id            TEXT PRIMARY KEY,
+    username      TEXT    NOT NULL UNIQUE,
+    password_hash TEXT    NOT NULL, -- bcrypt
+    role          TEXT    NOT NULL, -- viewer|operator|admin
+    created_at    INTEGER NOT NULL  -- Unix milliseconds - Creation timestamp
+);
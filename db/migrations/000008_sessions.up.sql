@@ -0,0 +1,13 @@
+-- Admin UI sessions, persisted so that a restart or a deploy doesn't log everyone out.
+CREATE TABLE sessions
+(
+    id_hash        TEXT PRIMARY KEY, -- hex-encoded SHA-256 of the session ID, the ID itself is only in the ForqSession cookie
+    username       TEXT    NOT NULL, -- the SessionUser name, e.g. "jane", "oidc:jane" or "api_key:deployer"
+    role           TEXT    NOT NULL, -- viewer|operator|admin
+    created_at     INTEGER NOT NULL, -- Unix milliseconds - for the absolute timeout
+    last_active_at INTEGER NOT NULL, -- Unix milliseconds - for the idle timeout, updated at most once a minute
+    client_ip      TEXT    NOT NULL,
+    user_agent     TEXT    NOT NULL
+);
+
+CREATE INDEX idx_sessions_username ON sessions (username);
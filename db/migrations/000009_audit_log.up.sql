@@ -0,0 +1,24 @@
+-- The administrative actions taken via the admin UI and the admin API: who did what to which queue, and whether it worked.
+CREATE TABLE audit_log
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT, -- AUTOINCREMENT, so that the IDs of the entries deleted by the retention job are never reused
+    created_at INTEGER NOT NULL, -- Unix milliseconds
+    actor      TEXT    NOT NULL, -- e.g. "jane", "oidc:jane", "api_key:deployer" or "auth_secret"
+    source_ip  TEXT    NOT NULL,
+    action     TEXT    NOT NULL, -- e.g. "dlq.delete_all", see common.*AuditAction
+    queue      TEXT,             -- NULL for the actions not on a queue, e.g. logins
+    message_id TEXT,
+    count      INTEGER,          -- the number of messages affected by the bulk actions
+    outcome    TEXT    NOT NULL, -- success|failure
+    details    TEXT    NOT NULL  -- e.g. the target of the action or the error code
+);
+
+CREATE INDEX idx_audit_log_created_at ON audit_log (created_at);
+
+-- append-only: the entries can only be deleted, by the retention job
+CREATE TRIGGER audit_log_no_update
+    BEFORE UPDATE
+    ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,10 @@
+-- The lockouts after the failed auth attempts, if persisted (FORQ_THROTTLING_PERSIST): they survive restarts,
+-- and apply to all the instances sharing the DB. The failed attempts themselves are counted in memory.
+CREATE TABLE auth_lockouts
+(
+    subject      TEXT PRIMARY KEY, -- "ip:<client IP>", or the credential, e.g. "user:jane" or "auth_secret:<key ID>"
+    locked_until INTEGER NOT NULL, -- Unix milliseconds
+    strikes      INTEGER NOT NULL  -- the lockouts in a row, each one doubles the lockout duration
+);
+
+CREATE INDEX idx_auth_lockouts_locked_until ON auth_lockouts (locked_until);
@@ -0,0 +1,2 @@
+-- drops columns
+ALTER TABLE messages DROP COLUMN traceparent;
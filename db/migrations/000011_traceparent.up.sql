@@ -0,0 +1,3 @@
+-- The W3C trace context of the produce request, returned on consume, so that the spans of the consumer link to the
+-- trace of the producer.
+ALTER TABLE messages ADD COLUMN traceparent TEXT; -- NULL if the producer wasn't traced, e.g. "00-<trace-id>-<span-id>-01"
@@ -0,0 +1,3 @@
+-- When the message entered its current queue, i.e. when its TTL started: set by the DLQ moves, the requeues and the
+-- redrives, so that resuming a paused queue knows how long the TTL of each message was frozen.
+ALTER TABLE messages ADD COLUMN queued_at INTEGER; -- Unix milliseconds, NULL if the message is still in the queue it was produced to, see received_at
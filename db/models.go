@@ -1,5 +1,7 @@
 package db
 
+import "time"
+
 type NewMessage struct {
 	Id           string
 	QueueName    string
@@ -8,14 +10,25 @@ type NewMessage struct {
 	ReceivedAt   int64
 	UpdatedAt    int64
 	ExpiresAfter int64
+	Traceparent  *string // the W3C trace context of the producer, if traced
 }
 
 type MessageForConsuming struct {
-	Id      string
-	Content string
+	Id           string
+	Content      string
+	ProcessAfter int64 // when the message became ready to be consumed
 	// ProcessingStartedAt fences this delivery: it is returned to the consumer
 	// as the receipt and must match on ack/nack.
 	ProcessingStartedAt int64
+	Traceparent         *string
+}
+
+// ClaimedMessage is a delivery handed out to a consumer, fenced by its
+// receipt, as in MessageForConsuming.
+type ClaimedMessage struct {
+	Id        string
+	QueueName string
+	Receipt   int64
 }
 
 type MessageMetadata struct {
@@ -34,6 +47,7 @@ type MessageDetails struct {
 	ProcessAfter        int64
 	ProcessingStartedAt *int64
 	FailureReason       *string
+	OriginQueue         *string
 	ReceivedAt          int64
 	UpdatedAt           int64
 	ExpiresAfter        int64
@@ -43,4 +57,112 @@ type QueueMetadata struct {
 	Name          string
 	MessagesCount int
 	IsDLQ         bool
+	Paused        bool
+}
+
+type QueueSettings struct {
+	QueueName          string
+	DlqPolicy          string
+	DlqName            *string
+	DeliveryRatePerSec *float64
+	DeliveryBurst      *int
+	MaxInFlight        *int
+	PausedAt           *int64
+	MaxMessages        *int64
+	MaxBytes           *int64
+	UpdatedAt          int64
+}
+
+type QueueUsage struct {
+	QueueName     string
+	MessagesCount int64
+	ContentBytes  int64
+}
+
+// DbStats are the sizes of the DB files at the moment, and the counters since
+// the start.
+type DbStats struct {
+	FileBytes         int64
+	WalBytes          int64
+	FreelistPages     int64
+	CacheHits         int64 // of the page caches of the read connections
+	CacheMisses       int64
+	BusyErrors        int64 // SQLITE_BUSY returned by the queries
+	LockedErrors      int64 // SQLITE_LOCKED returned by the queries
+	WriteWaits        int64 // times a query waited for the write connection
+	WriteWaitDuration time.Duration
+}
+
+type QueueOldestReady struct {
+	QueueName string
+	ReadyAt   int64 // process_after of the oldest ready message
+}
+
+// RedriveFilter selects which DLQ messages are redriven and where to. Zero
+// values mean "no filter" for FailureReason, ReceivedAfter and ReceivedBefore.
+type RedriveFilter struct {
+	TargetQueueName string
+	FailureReason   string
+	ReceivedAfter   int64
+	ReceivedBefore  int64
+	// SpacingMs staggers process_after of the redriven messages, so they
+	// become visible gradually instead of all at once. 0 means no spacing.
+	SpacingMs float64
+}
+
+type ApiKey struct {
+	Id        string
+	Name      string
+	KeyHash   string
+	Scopes    string // JSON array of common.ApiKeyScope
+	CreatedAt int64
+}
+
+type User struct {
+	Id           string
+	Username     string
+	PasswordHash string
+	Role         string
+	CreatedAt    int64
+}
+
+type Session struct {
+	IdHash       string
+	Username     string
+	Role         string
+	CreatedAt    int64
+	LastActiveAt int64
+	ClientIp     string
+	UserAgent    string
+}
+
+type AuditEntry struct {
+	Id        int64
+	CreatedAt int64
+	Actor     string
+	SourceIp  string
+	Action    string
+	Queue     *string
+	MessageId *string
+	Count     *int64
+	Outcome   string
+	Details   string
+}
+
+// AuditFilter selects the audit log entries, the newest first. Zero values
+// mean "no filter". BeforeId is the cursor of the next page.
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Queue    string
+	Outcome  string
+	Since    int64
+	Until    int64
+	BeforeId int64
+}
+
+type Lockout struct {
+	Subject     string
+	LockedUntil int64
+	Strikes     int
 }
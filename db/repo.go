@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
@@ -18,9 +20,11 @@ import (
 )
 
 type ForqRepo struct {
-	dbRead     *sql.DB
-	dbWrite    *sql.DB
+	dbRead     instrumentedDB
+	dbWrite    instrumentedDB
+	dbPath     string
 	appConfigs *configs.AppConfigs
+	errs       *errorCounters
 }
 
 const (
@@ -61,22 +65,36 @@ var registerOnce sync.Once
 
 func registerDrivers() {
 	registerOnce.Do(func() {
+		// the page cache metrics are nice to have, so their failures are not fatal
+		cacheStatusRegistered := true
+		if err := registerCacheStatus(); err != nil {
+			log.Warn().Err(err).Msg("page cache metrics unavailable")
+			cacheStatusRegistered = false
+		}
+
 		sql.Register(readDriverName, &sqlite3.SQLiteDriver{
-			ConnectHook: makeConnectHook(readPragmas),
+			// only the reads are tracked, so that the single writer doesn't
+			// pay for it
+			ConnectHook: makeConnectHook(readPragmas, cacheStatusRegistered),
 		})
 		sql.Register(writeDriverName, &sqlite3.SQLiteDriver{
-			ConnectHook: makeConnectHook(writePragmas),
+			ConnectHook: makeConnectHook(writePragmas, false),
 		})
 	})
 }
 
-func makeConnectHook(pragmas []string) func(*sqlite3.SQLiteConn) error {
+func makeConnectHook(pragmas []string, trackCache bool) func(*sqlite3.SQLiteConn) error {
 	return func(conn *sqlite3.SQLiteConn) error {
 		for _, p := range pragmas {
 			if _, err := conn.Exec(p, nil); err != nil {
 				return fmt.Errorf("apply %q: %w", p, err)
 			}
 		}
+		if trackCache {
+			if err := trackCacheStatus(conn); err != nil {
+				log.Warn().Err(err).Msg("page cache metrics unavailable for a read connection")
+			}
+		}
 		return nil
 	}
 }
@@ -116,27 +134,32 @@ func NewSQLiteRepo(dbPath string, appConfigs *configs.AppConfigs) (*ForqRepo, er
 		return nil, fmt.Errorf("ping write database: %w", err)
 	}
 
+	errs := &errorCounters{}
 	return &ForqRepo{
-		dbRead:     dbRead,
-		dbWrite:    dbWrite,
+		dbRead:     instrumentedDB{DB: dbRead, errs: errs},
+		dbWrite:    instrumentedDB{DB: dbWrite, errs: errs},
+		dbPath:     dbPath,
 		appConfigs: appConfigs,
+		errs:       errs,
 	}, nil
 }
 
 func (fr *ForqRepo) InsertMessage(newMessage *NewMessage, ctx context.Context) error {
 	query := `
-		INSERT INTO messages (id, queue, content, process_after, received_at, updated_at, expires_after)
-		VALUES (?, ?, ?, ?, ?, ?, ?);
+		INSERT INTO messages (id, queue, content, content_size, process_after, received_at, updated_at, expires_after, traceparent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
 	`
 
 	_, err := fr.dbWrite.ExecContext(ctx, query,
 		newMessage.Id,           // id
 		newMessage.QueueName,    // queue
 		newMessage.Content,      // content
+		len(newMessage.Content), // content_size
 		newMessage.ProcessAfter, // process_after
 		newMessage.ReceivedAt,   // received_at
 		newMessage.UpdatedAt,    // updated_at
 		newMessage.ExpiresAfter, // expires_after
+		newMessage.Traceparent,  // traceparent
 	)
 	if err != nil {
 		log.Error().Err(err).Str("queue", newMessage.QueueName).Msg("failed to insert new message")
@@ -145,9 +168,33 @@ func (fr *ForqRepo) InsertMessage(newMessage *NewMessage, ctx context.Context) e
 	return nil
 }
 
-func (fr *ForqRepo) SelectMessageForConsuming(queueName string, ctx context.Context) (*MessageForConsuming, error) {
+// SelectMessageForConsuming claims the oldest ready message of the queue,
+// unless the queue is paused. maxInFlight > 0 caps how many messages of the queue can be in processing at
+// once: the check and the claim run as one statement on the single write
+// connection, so concurrent consumers can't overshoot it.
+func (fr *ForqRepo) SelectMessageForConsuming(queueName string, maxInFlight int, ctx context.Context) (*MessageForConsuming, error) {
 	nowMs := time.Now().UnixMilli()
 
+	inFlightCondition := ""
+	args := []any{
+		common.ProcessingStatus, // SET status = ?
+		nowMs,                   // processing_started_at = ?
+		nowMs,                   // updated_at = ?
+		queueName,               // WHERE queue = ?
+		common.ReadyStatus,      // AND status = ?
+		nowMs,                   // AND process_after <= ?
+		queueName,               // AND NOT EXISTS (... WHERE queue = ? ...)
+	}
+	if maxInFlight > 0 {
+		// uses `idx_for_requeueuing` to count the processing messages
+		inFlightCondition = "AND (SELECT COUNT(*) FROM messages WHERE queue = ? AND status = ?) < ?"
+		args = append(args,
+			queueName,               // AND (... WHERE queue = ?
+			common.ProcessingStatus, // AND status = ?)
+			maxInFlight,             // < ?
+		)
+	}
+
 	// we are ignoring expires_after here for performance boost reasons, as expired messaged are cleanup by the jobs.
 	// This query uses COVERING INDEX via `idx_queue_ready_for_consuming`, so it is very fast.
 	query := `
@@ -163,20 +210,15 @@ func (fr *ForqRepo) SelectMessageForConsuming(queueName string, ctx context.Cont
             WHERE queue = ?
               AND status = ?
               AND process_after <= ?
+              AND NOT EXISTS (SELECT 1 FROM queue_settings WHERE queue = ? AND paused_at IS NOT NULL)
+              ` + inFlightCondition + `
             ORDER BY received_at ASC
             LIMIT 1
         )
-        RETURNING id, content, processing_started_at;`
+        RETURNING id, content, process_after, processing_started_at, traceparent;`
 
 	var msg MessageForConsuming
-	err := fr.dbWrite.QueryRowContext(ctx, query,
-		common.ProcessingStatus, // SET status = ?
-		nowMs,                   // processing_started_at = ?
-		nowMs,                   // updated_at = ?
-		queueName,               // WHERE queue = ?
-		common.ReadyStatus,      // AND status = ?
-		nowMs,                   // AND process_after <= ?
-	).Scan(&msg.Id, &msg.Content, &msg.ProcessingStartedAt)
+	err := fr.dbWrite.QueryRowContext(ctx, query, args...).Scan(&msg.Id, &msg.Content, &msg.ProcessAfter, &msg.ProcessingStartedAt, &msg.Traceparent)
 
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
@@ -214,7 +256,7 @@ func (fr *ForqRepo) SelectMessageMetadata(messageId string, queueName string, ct
 func (fr *ForqRepo) SelectMessageDetails(messageId string, queueName string, ctx context.Context) (*MessageDetails, error) {
 	query := `
 		SELECT id, content, status, attempts, process_after, processing_started_at, failure_reason,
-		       received_at, updated_at, expires_after
+		       origin_queue, received_at, updated_at, expires_after
 		FROM messages
 		WHERE id = ? AND queue = ?;`
 
@@ -223,7 +265,7 @@ func (fr *ForqRepo) SelectMessageDetails(messageId string, queueName string, ctx
 		messageId, // WHERE id = ?
 		queueName, // AND queue = ?
 	).Scan(&msgDetails.Id, &msgDetails.Content, &msgDetails.Status, &msgDetails.Attempts, &msgDetails.ProcessAfter,
-		&msgDetails.ProcessingStartedAt, &msgDetails.FailureReason, &msgDetails.ReceivedAt, &msgDetails.UpdatedAt,
+		&msgDetails.ProcessingStartedAt, &msgDetails.FailureReason, &msgDetails.OriginQueue, &msgDetails.ReceivedAt, &msgDetails.UpdatedAt,
 		&msgDetails.ExpiresAfter)
 
 	if err != nil {
@@ -238,10 +280,11 @@ func (fr *ForqRepo) SelectMessageDetails(messageId string, queueName string, ctx
 
 func (fr *ForqRepo) SelectAllQueuesWithStats(ctx context.Context) ([]QueueMetadata, error) {
 	query := `
-		SELECT queue, COUNT(*) as messages_count, is_dlq
-		FROM messages
-		GROUP BY queue, is_dlq
-		ORDER BY queue ASC;`
+		SELECT m.queue, COUNT(*) as messages_count, m.is_dlq,
+		       EXISTS (SELECT 1 FROM queue_settings qs WHERE qs.queue = m.queue AND qs.paused_at IS NOT NULL) as paused
+		FROM messages m
+		GROUP BY m.queue, m.is_dlq
+		ORDER BY m.queue ASC;`
 
 	rows, err := fr.dbRead.QueryContext(ctx, query)
 	if err != nil {
@@ -253,7 +296,7 @@ func (fr *ForqRepo) SelectAllQueuesWithStats(ctx context.Context) ([]QueueMetada
 	var queues []QueueMetadata
 	for rows.Next() {
 		var q QueueMetadata
-		if err := rows.Scan(&q.Name, &q.MessagesCount, &q.IsDLQ); err != nil {
+		if err := rows.Scan(&q.Name, &q.MessagesCount, &q.IsDLQ, &q.Paused); err != nil {
 			log.Error().Err(err).Msg("failed to scan queue metadata")
 			return nil, common.ErrInternal
 		}
@@ -269,16 +312,18 @@ func (fr *ForqRepo) SelectAllQueuesWithStats(ctx context.Context) ([]QueueMetada
 
 func (fr *ForqRepo) SelectQueueStats(queueName string, ctx context.Context) (*QueueMetadata, error) {
 	query := `
-		SELECT queue, COUNT(*) as messages_count, is_dlq
-		FROM messages
-		WHERE queue = ?
-		GROUP BY queue, is_dlq;`
+		SELECT m.queue, COUNT(*) as messages_count, m.is_dlq,
+		       EXISTS (SELECT 1 FROM queue_settings qs WHERE qs.queue = m.queue AND qs.paused_at IS NOT NULL) as paused
+		FROM messages m
+		WHERE m.queue = ?
+		GROUP BY m.queue, m.is_dlq;`
 
 	var queueStats QueueMetadata
 	err := fr.dbRead.QueryRowContext(ctx, query, queueName).Scan(
 		&queueStats.Name,
 		&queueStats.MessagesCount,
 		&queueStats.IsDLQ,
+		&queueStats.Paused,
 	)
 
 	if err != nil {
@@ -341,6 +386,7 @@ func (fr *ForqRepo) SelectMessagesForUI(queueName string, cursor string, limit i
 
 func (fr *ForqRepo) UpdateMessageOnConsumingFailure(messageId string, queueName string, receipt int64, ctx context.Context) error {
 	nowMs := time.Now().UnixMilli()
+	limits := fr.appConfigs.Limits()
 
 	// processing_started_at = receipt fences the nack to this exact delivery:
 	// a late nack from a consumer whose message was already reclaimed and
@@ -357,17 +403,17 @@ func (fr *ForqRepo) UpdateMessageOnConsumingFailure(messageId string, queueName
             END,
             processing_started_at = NULL,
             updated_at = ?
-        WHERE id = ? AND queue = ? AND status = ? AND processing_started_at = ?;`, fr.processAfterCases(nowMs))
+        WHERE id = ? AND queue = ? AND status = ? AND processing_started_at = ?;`, processAfterCases(nowMs, limits.BackoffDelaysMs))
 
 	result, err := fr.dbWrite.ExecContext(ctx, query,
-		fr.appConfigs.MaxDeliveryAttempts, // WHEN attempts = ? (status check)
-		common.FailedStatus,               // THEN ?  		-- failed if no more attempts left
-		common.ReadyStatus,                // ELSE ?		-- ready if there are attempts left
-		nowMs,                             // updated_at = ?
-		messageId,                         // WHERE id = ?
-		queueName,                         // AND queue = ?
-		common.ProcessingStatus,           // AND status = ?
-		receipt,                           // AND processing_started_at = ?
+		limits.MaxDeliveryAttempts, // WHEN attempts = ? (status check)
+		common.FailedStatus,        // THEN ?  		-- failed if no more attempts left
+		common.ReadyStatus,         // ELSE ?		-- ready if there are attempts left
+		nowMs,                      // updated_at = ?
+		messageId,                  // WHERE id = ?
+		queueName,                  // AND queue = ?
+		common.ProcessingStatus,    // AND status = ?
+		receipt,                    // AND processing_started_at = ?
 	)
 
 	if err != nil {
@@ -401,14 +447,15 @@ func (fr *ForqRepo) UpdateStaleMessages(ctx context.Context) (int64, error) {
             updated_at = ?
         WHERE status = ? AND processing_started_at < ?;`
 
+	limits := fr.appConfigs.Limits()
 	res, err := fr.dbWrite.ExecContext(ctx, query,
-		fr.appConfigs.MaxDeliveryAttempts, // WHEN attempts >= ? (status check)
-		common.FailedStatus,               // THEN ?  			-- failed if no more attempts left
-		common.ReadyStatus,                // ELSE ?			-- ready if there are attempts left
-		nowMs,                             // process_after = ? -- immediate retry
-		nowMs,                             // updated_at = ?
-		common.ProcessingStatus,           // WHERE status = ?
-		nowMs-fr.appConfigs.MaxProcessingTimeMs, // AND processing_started_at < ?;
+		limits.MaxDeliveryAttempts,       // WHEN attempts >= ? (status check)
+		common.FailedStatus,              // THEN ?  			-- failed if no more attempts left
+		common.ReadyStatus,               // ELSE ?			-- ready if there are attempts left
+		nowMs,                            // process_after = ? -- immediate retry
+		nowMs,                            // updated_at = ?
+		common.ProcessingStatus,          // WHERE status = ?
+		nowMs-limits.MaxProcessingTimeMs, // AND processing_started_at < ?;
 	)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to update stale messages")
@@ -426,31 +473,104 @@ func (fr *ForqRepo) UpdateStaleMessages(ctx context.Context) (int64, error) {
 	return rowsAffected, nil
 }
 
+// ReleaseMessages makes the claimed messages ready again right away, as the
+// stale messages recovery would do once the max processing time is over. The
+// receipt fences each of them: a message acked, nacked or reclaimed meanwhile
+// is left alone. Returns how many were released.
+func (fr *ForqRepo) ReleaseMessages(claims []ClaimedMessage, ctx context.Context) (int64, error) {
+	if len(claims) == 0 {
+		return 0, nil
+	}
+	nowMs := time.Now().UnixMilli()
+	limits := fr.appConfigs.Limits()
+
+	tx, err := fr.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to begin transaction for releasing messages")
+		return 0, common.ErrInternal
+	}
+	defer tx.Rollback()
+
+	var released int64
+	for _, claim := range claims {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE messages
+			SET
+				status = CASE
+					WHEN attempts >= ? THEN ?	-- failed if no more attempts left
+					ELSE ?						-- ready if there are attempts left
+				END,
+				process_after = ?,				-- immediate retry, as for the stale messages
+				processing_started_at = NULL,
+				updated_at = ?
+			WHERE id = ? AND queue = ? AND status = ? AND processing_started_at = ?;`,
+			limits.MaxDeliveryAttempts, // WHEN attempts >= ? (status check)
+			common.FailedStatus,        // THEN ?  		-- failed if no more attempts left
+			common.ReadyStatus,         // ELSE ?		-- ready if there are attempts left
+			nowMs,                      // process_after = ?
+			nowMs,                      // updated_at = ?
+			claim.Id,                   // WHERE id = ?
+			claim.QueueName,            // AND queue = ?
+			common.ProcessingStatus,    // AND status = ?
+			claim.Receipt,              // AND processing_started_at = ?
+		)
+		if err != nil {
+			log.Error().Err(err).Str("queue", claim.QueueName).Str("message_id", claim.Id).Msg("failed to release message")
+			return 0, common.ErrInternal
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get rows affected after releasing message")
+			return 0, common.ErrInternal
+		}
+		released += rowsAffected
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("failed to commit releasing messages")
+		return 0, common.ErrInternal
+	}
+	return released, nil
+}
+
 func (fr *ForqRepo) UpdateFailedMessagesForRegularQueues(ctx context.Context) (int64, error) {
 	nowMs := time.Now().UnixMilli()
 
+	// queues with the "none" DLQ policy are left out here, their failed
+	// messages are dropped by DeleteFailedMessagesWithoutDlq instead.
+	// All SET expressions see the pre-update row, so origin_queue gets the
+	// regular queue name.
 	query := `
         UPDATE messages
         SET
             attempts = 0,
             status = ?,
-            queue = queue || ?,
+            origin_queue = queue,
+            queue = COALESCE(
+                (SELECT qs.dlq_name FROM queue_settings qs WHERE qs.queue = messages.queue AND qs.dlq_policy = ?),
+                queue || ?
+            ),
             is_dlq = TRUE,              -- Set DLQ flag
             process_after = ?,
             processing_started_at = NULL,
             failure_reason = ?,
             updated_at = ?,
-            expires_after = ?
-        WHERE status = ? AND is_dlq = FALSE;`
+            expires_after = ?,
+            queued_at = ?
+        WHERE status = ? AND is_dlq = FALSE
+          AND queue NOT IN (SELECT queue FROM queue_settings WHERE dlq_policy = ?);`
 
 	res, err := fr.dbWrite.ExecContext(ctx, query,
 		common.ReadyStatus,                     // status = ?
-		common.DlqSuffix,                       // queue = queue || ?
+		common.CustomDlqPolicy,                 // AND qs.dlq_policy = ?
+		common.DlqSuffix,                       // queue || ?
 		nowMs,                                  // process_after = ?
 		common.MaxAttemptsReachedFailureReason, // failure_reason = ?
 		nowMs,                                  // updated_at = ?
-		nowMs+fr.appConfigs.DlqTtlMs,           // expires_after = ?
+		nowMs+fr.appConfigs.Limits().DlqTtlMs,  // expires_after = ?
+		nowMs,                                  // queued_at = ?
 		common.FailedStatus,                    // WHERE status = ?
+		common.NoDlqPolicy,                     // AND queue NOT IN (... dlq_policy = ?)
 	)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to update failed messages for regular queues")
@@ -475,21 +595,30 @@ func (fr *ForqRepo) UpdateExpiredMessagesForRegularQueues(ctx context.Context) (
 	// idx_expired with a != constraint on its leading column, while IN expands
 	// to two index range scans. Batched so one huge backlog doesn't hold the
 	// single write connection for the whole sweep.
+	// see UpdateFailedMessagesForRegularQueues for the DLQ policy handling.
+	// Paused queues are skipped: their messages can't be consumed, so their TTL
+	// is frozen until ResumeQueue shifts it by the paused duration.
 	query := `
         UPDATE messages
         SET
             attempts = 0,
             status = ?,
-            queue = queue || ?,
+            origin_queue = queue,
+            queue = COALESCE(
+                (SELECT qs.dlq_name FROM queue_settings qs WHERE qs.queue = messages.queue AND qs.dlq_policy = ?),
+                queue || ?
+            ),
             is_dlq = TRUE,              -- Set DLQ flag
             process_after = ?,
             processing_started_at = NULL,
             failure_reason = ?,
             updated_at = ?,
-            expires_after = ?
+            expires_after = ?,
+            queued_at = ?
         WHERE id IN (
             SELECT id FROM messages
             WHERE status IN (?, ?) AND is_dlq = FALSE AND expires_after < ?
+              AND queue NOT IN (SELECT queue FROM queue_settings WHERE dlq_policy = ? OR paused_at IS NOT NULL)
             LIMIT ?
         );`
 
@@ -501,16 +630,19 @@ func (fr *ForqRepo) UpdateExpiredMessagesForRegularQueues(ctx context.Context) (
 		}
 
 		res, err := fr.dbWrite.ExecContext(ctx, query,
-			common.ReadyStatus,                 // status = ?
-			common.DlqSuffix,                   // queue = queue || ?
-			nowMs,                              // process_after = ?
-			common.MessageExpiredFailureReason, // failure_reason = ?
-			nowMs,                              // updated_at = ?
-			nowMs+fr.appConfigs.DlqTtlMs,       // expires_after = ?
-			common.ReadyStatus,                 // WHERE status IN (?,
-			common.FailedStatus,                //                  ?)
-			nowMs,                              // AND expires_after < ?
-			sweepBatchSize,                     // LIMIT ?
+			common.ReadyStatus,                    // status = ?
+			common.CustomDlqPolicy,                // AND qs.dlq_policy = ?
+			common.DlqSuffix,                      // queue || ?
+			nowMs,                                 // process_after = ?
+			common.MessageExpiredFailureReason,    // failure_reason = ?
+			nowMs,                                 // updated_at = ?
+			nowMs+fr.appConfigs.Limits().DlqTtlMs, // expires_after = ?
+			nowMs,                                 // queued_at = ?
+			common.ReadyStatus,                    // WHERE status IN (?,
+			common.FailedStatus,                   //                  ?)
+			nowMs,                                 // AND expires_after < ?
+			common.NoDlqPolicy,                    // AND queue NOT IN (... dlq_policy = ? ...)
+			sweepBatchSize,                        // LIMIT ?
 		)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to update expired messages for regular queues")
@@ -530,32 +662,159 @@ func (fr *ForqRepo) UpdateExpiredMessagesForRegularQueues(ctx context.Context) (
 	}
 }
 
+// DeleteFailedMessagesWithoutDlq drops the failed messages of the queues with
+// the "none" DLQ policy - the counterpart of UpdateFailedMessagesForRegularQueues.
+func (fr *ForqRepo) DeleteFailedMessagesWithoutDlq(ctx context.Context) (int64, error) {
+	query := `
+        DELETE FROM messages
+        WHERE status = ? AND is_dlq = FALSE
+          AND queue IN (SELECT queue FROM queue_settings WHERE dlq_policy = ?);`
+
+	res, err := fr.dbWrite.ExecContext(ctx, query,
+		common.FailedStatus, // WHERE status = ?
+		common.NoDlqPolicy,  // AND queue IN (... dlq_policy = ?)
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete failed messages for queues without DLQ")
+		return 0, common.ErrInternal
+	}
+	if res == nil {
+		return 0, nil
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get rows affected after deleting failed messages for queues without DLQ")
+		return 0, common.ErrInternal
+	}
+	return rowsAffected, nil
+}
+
+// DeleteExpiredMessagesWithoutDlq drops the expired messages of the queues with
+// the "none" DLQ policy - the counterpart of UpdateExpiredMessagesForRegularQueues.
+func (fr *ForqRepo) DeleteExpiredMessagesWithoutDlq(ctx context.Context) (int64, error) {
+	nowMs := time.Now().UnixMilli()
+
+	// see UpdateExpiredMessagesForRegularQueues for the status IN + batching rationale
+	query := `
+        DELETE FROM messages
+        WHERE id IN (
+            SELECT id FROM messages
+            WHERE status IN (?, ?) AND is_dlq = FALSE AND expires_after < ?
+              AND queue IN (SELECT queue FROM queue_settings WHERE dlq_policy = ? AND paused_at IS NULL)
+            LIMIT ?
+        );`
+
+	var totalRowsAffected int64
+	for {
+		if err := ctx.Err(); err != nil {
+			log.Warn().Err(err).Msg("expired messages without DLQ sweep interrupted, will continue next run")
+			return totalRowsAffected, nil
+		}
+
+		res, err := fr.dbWrite.ExecContext(ctx, query,
+			common.ReadyStatus,  // WHERE status IN (?,
+			common.FailedStatus, //                  ?)
+			nowMs,               // AND expires_after < ?
+			common.NoDlqPolicy,  // AND queue IN (... dlq_policy = ? ...)
+			sweepBatchSize,      // LIMIT ?
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to delete expired messages for queues without DLQ")
+			return totalRowsAffected, common.ErrInternal
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get rows affected after deleting expired messages for queues without DLQ")
+			return totalRowsAffected, common.ErrInternal
+		}
+		totalRowsAffected += rowsAffected
+
+		if rowsAffected < sweepBatchSize {
+			return totalRowsAffected, nil
+		}
+	}
+}
+
+// UpdateFailedMessagesForChainedDlqs moves the messages that failed in a DLQ
+// with the "custom" policy to the next DLQ of the chain instead of deleting
+// them. origin_queue and expires_after are left as is: the origin is still
+// the regular queue the message was produced to, and keeping the DLQ TTL
+// running guarantees that a misconfigured cycle of DLQs can't keep a message
+// around forever.
+func (fr *ForqRepo) UpdateFailedMessagesForChainedDlqs(ctx context.Context) (int64, error) {
+	nowMs := time.Now().UnixMilli()
+
+	query := `
+        UPDATE messages
+        SET
+            attempts = 0,
+            status = ?,
+            queue = (SELECT qs.dlq_name FROM queue_settings qs WHERE qs.queue = messages.queue),
+            process_after = ?,
+            processing_started_at = NULL,
+            failure_reason = ?,
+            updated_at = ?
+        WHERE status = ? AND is_dlq = TRUE
+          AND queue IN (SELECT queue FROM queue_settings WHERE dlq_policy = ?);`
+
+	res, err := fr.dbWrite.ExecContext(ctx, query,
+		common.ReadyStatus,                     // status = ?
+		nowMs,                                  // process_after = ?
+		common.MaxAttemptsReachedFailureReason, // failure_reason = ?
+		nowMs,                                  // updated_at = ?
+		common.FailedStatus,                    // WHERE status = ?
+		common.CustomDlqPolicy,                 // AND queue IN (... dlq_policy = ?)
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update failed messages for chained DLQs")
+		return 0, common.ErrInternal
+	}
+	if res == nil {
+		return 0, nil
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get rows affected after updating failed messages for chained DLQs")
+		return 0, common.ErrInternal
+	}
+	return rowsAffected, nil
+}
+
+// RequeueDlqMessages moves the DLQ messages back to the queues they came from.
+// The origin is recorded per message, as a custom DLQ can be shared by
+// several queues. Messages without a recorded origin fall back to the
+// queue name without the DLQ suffix.
 func (fr *ForqRepo) RequeueDlqMessages(queueName string, ctx context.Context) (int64, error) {
 	nowMs := time.Now().UnixMilli()
-	destinationQueueName := strings.TrimSuffix(queueName, common.DlqSuffix)
 
 	query := `
 		UPDATE messages
 		SET
-			queue = ?, 			-- Move back to regular queue
-			is_dlq = FALSE,     -- Unset DLQ flag
+			queue = COALESCE(origin_queue, substr(queue, 1, length(queue) - ?)), 	-- Move back to regular queue
+			origin_queue = NULL,
+			is_dlq = FALSE,     	-- Unset DLQ flag
 			status = ?,
 			attempts = 0,
 			process_after = ?,
 			processing_started_at = NULL,
 			failure_reason = NULL,
 			updated_at = ?,
-			expires_after = ?
+			expires_after = ?,
+			queued_at = ?
 		WHERE queue = ? AND status != ?;`
 
 	res, err := fr.dbWrite.ExecContext(ctx, query,
-		destinationQueueName,           // queue = ? -- Move back to regular queue
-		common.ReadyStatus,             // status = ?
-		nowMs,                          // process_after = ?
-		nowMs,                          // updated_at = ?
-		nowMs+fr.appConfigs.QueueTtlMs, // expires_after = ?
-		queueName,                      // WHERE queue = ?
-		common.ProcessingStatus,        // AND status != ?
+		len(common.DlqSuffix), // length(queue) - ?
+		common.ReadyStatus,    // status = ?
+		nowMs,                 // process_after = ?
+		nowMs,                 // updated_at = ?
+		nowMs+fr.appConfigs.Limits().QueueTtlMs, // expires_after = ?
+		nowMs,                   // queued_at = ?
+		queueName,               // WHERE queue = ?
+		common.ProcessingStatus, // AND status != ?
 	)
 	if err != nil {
 		log.Error().Err(err).Str("queue", queueName).Msg("failed to update messages by moving from DLQ to regular")
@@ -572,31 +831,33 @@ func (fr *ForqRepo) RequeueDlqMessages(queueName string, ctx context.Context) (i
 
 func (fr *ForqRepo) RequeueDlqMessage(messageId string, queueName string, ctx context.Context) error {
 	nowMs := time.Now().UnixMilli()
-	destinationQueueName := strings.TrimSuffix(queueName, common.DlqSuffix)
 
 	query := `
 		UPDATE messages
 		SET
-			queue = ?, 			-- Move back to regular queue
-			is_dlq = FALSE,     -- Unset DLQ flag
+			queue = COALESCE(origin_queue, substr(queue, 1, length(queue) - ?)), 	-- Move back to regular queue
+			origin_queue = NULL,
+			is_dlq = FALSE,     	-- Unset DLQ flag
 			status = ?,
 			attempts = 0,
 			process_after = ?,
 			processing_started_at = NULL,
 			failure_reason = NULL,
 			updated_at = ?,
-			expires_after = ?
+			expires_after = ?,
+			queued_at = ?
 		WHERE id = ? AND queue = ? AND status != ?;`
 
 	result, err := fr.dbWrite.ExecContext(ctx, query,
-		destinationQueueName,           // queue = ? -- Move back to regular queue
-		common.ReadyStatus,             // status = ?
-		nowMs,                          // process_after = ?
-		nowMs,                          // updated_at = ?
-		nowMs+fr.appConfigs.QueueTtlMs, // expires_after = ?
-		messageId,                      // WHERE id = ?
-		queueName,                      // AND queue = ?
-		common.ProcessingStatus,        // AND status != ?
+		len(common.DlqSuffix), // length(queue) - ?
+		common.ReadyStatus,    // status = ?
+		nowMs,                 // process_after = ?
+		nowMs,                 // updated_at = ?
+		nowMs+fr.appConfigs.Limits().QueueTtlMs, // expires_after = ?
+		nowMs,                   // queued_at = ?
+		messageId,               // WHERE id = ?
+		queueName,               // AND queue = ?
+		common.ProcessingStatus, // AND status != ?
 	)
 	if err != nil {
 		log.Error().Err(err).Str("queue", queueName).Str("message_id", messageId).Msg("failed to update message by moving from DLQ to regular")
@@ -614,6 +875,76 @@ func (fr *ForqRepo) RequeueDlqMessage(messageId string, queueName string, ctx co
 	return nil
 }
 
+// RedriveDlqMessages moves the DLQ messages matching the filter into an
+// arbitrary target queue. Instead of throttling the move itself (a single
+// UPDATE is much cheaper than 100k small ones), the rate limit is applied by
+// staggering process_after in received_at order: the messages land in the
+// target queue immediately, but become visible to consumers one by one.
+func (fr *ForqRepo) RedriveDlqMessages(queueName string, filter *RedriveFilter, ctx context.Context) (int64, error) {
+	nowMs := time.Now().UnixMilli()
+
+	conditions := []string{"queue = ?", "is_dlq = TRUE", "status != ?"}
+	filterArgs := []interface{}{queueName, common.ProcessingStatus}
+	if filter.FailureReason != "" {
+		conditions = append(conditions, "failure_reason = ?")
+		filterArgs = append(filterArgs, filter.FailureReason)
+	}
+	if filter.ReceivedAfter > 0 {
+		conditions = append(conditions, "received_at >= ?")
+		filterArgs = append(filterArgs, filter.ReceivedAfter)
+	}
+	if filter.ReceivedBefore > 0 {
+		conditions = append(conditions, "received_at < ?")
+		filterArgs = append(filterArgs, filter.ReceivedBefore)
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE messages
+		SET
+			queue = ?,
+			origin_queue = NULL,
+			is_dlq = FALSE,
+			status = ?,
+			attempts = 0,
+			process_after = ? + CAST((r.position - 1) * ? AS INTEGER),
+			processing_started_at = NULL,
+			failure_reason = NULL,
+			updated_at = ?,
+			expires_after = ? + CAST((r.position - 1) * ? AS INTEGER),
+			queued_at = ?
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY received_at ASC) AS position
+			FROM messages
+			WHERE %s
+		) AS r
+		WHERE messages.id = r.id;`, strings.Join(conditions, " AND "))
+
+	args := []interface{}{
+		filter.TargetQueueName, // queue = ?
+		common.ReadyStatus,     // status = ?
+		nowMs,                  // process_after = ? + ...
+		filter.SpacingMs,       // ... (r.position - 1) * ?
+		nowMs,                  // updated_at = ?
+		nowMs + fr.appConfigs.Limits().QueueTtlMs, // expires_after = ? + ...
+		filter.SpacingMs, // ... (r.position - 1) * ?
+		nowMs,            // queued_at = ?
+	}
+	args = append(args, filterArgs...)
+
+	res, err := fr.dbWrite.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Str("target_queue", filter.TargetQueueName).Msg("failed to redrive DLQ messages")
+		return 0, common.ErrInternal
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to get rows affected after redriving DLQ messages")
+		return 0, common.ErrInternal
+	}
+	return rowsAffected, nil
+}
+
 func (fr *ForqRepo) DeleteMessageFromDlq(messageId string, queueName string, ctx context.Context) error {
 	query := `
 		DELETE FROM messages
@@ -640,35 +971,32 @@ func (fr *ForqRepo) DeleteMessageFromDlq(messageId string, queueName string, ctx
 	return nil
 }
 
-func (fr *ForqRepo) DeleteMessageOnAck(messageId string, queueName string, receipt int64, ctx context.Context) error {
+// DeleteMessageOnAck returns the attempts it took to process the message.
+func (fr *ForqRepo) DeleteMessageOnAck(messageId string, queueName string, receipt int64, ctx context.Context) (int, error) {
 	// processing_started_at = receipt fences the ack to this exact delivery -
 	// see UpdateMessageOnConsumingFailure for the rationale.
 	query := `
 		DELETE FROM messages
-		WHERE id = ? AND queue = ? AND status = ? AND processing_started_at = ?;`
+		WHERE id = ? AND queue = ? AND status = ? AND processing_started_at = ?
+		RETURNING attempts;`
 
-	result, err := fr.dbWrite.ExecContext(ctx, query,
+	var attempts int
+	err := fr.dbWrite.QueryRowContext(ctx, query,
 		messageId,               // WHERE id = ?
 		queueName,               // AND queue = ?
 		common.ProcessingStatus, // AND status = ?
 		receipt,                 // AND processing_started_at = ?
-	)
-	if err != nil {
-		log.Error().Err(err).Str("queue", queueName).Msg("failed to delete message on ack")
-		return common.ErrInternal
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Error().Err(err).Str("queue", queueName).Msg("failed to get rows affected after delete on ack")
-		return common.ErrInternal
-	}
+	).Scan(&attempts)
 
-	if rowsAffected == 0 {
+	if errors.Is(err, sql.ErrNoRows) {
 		log.Warn().Str("queue", queueName).Str("message_id", messageId).Msg("no rows deleted on ack, message was either deleted already or does not exist")
-		return common.ErrNotFoundMessage
+		return 0, common.ErrNotFoundMessage
 	}
-	return nil
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to delete message on ack")
+		return 0, common.ErrInternal
+	}
+	return attempts, nil
 }
 
 func (fr *ForqRepo) DeleteFailedMessagesFromDlq(ctx context.Context) (int64, error) {
@@ -704,6 +1032,7 @@ func (fr *ForqRepo) DeleteExpiredMessagesFromDlq(ctx context.Context) (int64, er
         WHERE id IN (
             SELECT id FROM messages
             WHERE status IN (?, ?) AND is_dlq = TRUE AND expires_after < ?
+              AND queue NOT IN (SELECT queue FROM queue_settings WHERE paused_at IS NOT NULL)
             LIMIT ?
         );`
 
@@ -763,48 +1092,1004 @@ func (fr *ForqRepo) DeleteAllMessagesFromQueue(queueName string, ctx context.Con
 	return rowsAffected, nil
 }
 
-func (fr *ForqRepo) Ping(ctx context.Context) error {
-	err := fr.dbRead.PingContext(ctx)
+func (fr *ForqRepo) SelectQueueSettings(queueName string, ctx context.Context) (*QueueSettings, error) {
+	query := `
+		SELECT queue, dlq_policy, dlq_name, delivery_rate_per_sec, delivery_burst, max_in_flight, paused_at, max_messages, max_bytes, updated_at
+		FROM queue_settings
+		WHERE queue = ?;`
+
+	var settings QueueSettings
+	err := fr.dbRead.QueryRowContext(ctx, query, queueName).Scan(
+		&settings.QueueName,
+		&settings.DlqPolicy,
+		&settings.DlqName,
+		&settings.DeliveryRatePerSec,
+		&settings.DeliveryBurst,
+		&settings.MaxInFlight,
+		&settings.PausedAt,
+		&settings.MaxMessages,
+		&settings.MaxBytes,
+		&settings.UpdatedAt,
+	)
+
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to select queue settings")
+		return nil, common.ErrInternal
 	}
-	return fr.dbWrite.PingContext(ctx)
+	return &settings, nil
 }
 
-func (fr *ForqRepo) Optimize(ctx context.Context) error {
-	// SQLite docs recommend running "PRAGMA optimize;" periodically to optimize the database
-	// https://www.sqlite.org/pragma.html#pragma_optimize
-	_, err := fr.dbWrite.ExecContext(ctx, "PRAGMA optimize;")
+// SelectQueuesWithDeliveryLimits returns the settings of the queues that have
+// any delivery limit set.
+func (fr *ForqRepo) SelectQueuesWithDeliveryLimits(ctx context.Context) ([]QueueSettings, error) {
+	query := `
+		SELECT queue, dlq_policy, dlq_name, delivery_rate_per_sec, delivery_burst, max_in_flight, paused_at, max_messages, max_bytes, updated_at
+		FROM queue_settings
+		WHERE delivery_rate_per_sec IS NOT NULL OR max_in_flight IS NOT NULL;`
+
+	return fr.selectQueuesSettings(query, ctx)
+}
+
+// SelectQueuesWithQuotas returns the settings of the queues that have any
+// quota set.
+func (fr *ForqRepo) SelectQueuesWithQuotas(ctx context.Context) ([]QueueSettings, error) {
+	query := `
+		SELECT queue, dlq_policy, dlq_name, delivery_rate_per_sec, delivery_burst, max_in_flight, paused_at, max_messages, max_bytes, updated_at
+		FROM queue_settings
+		WHERE max_messages IS NOT NULL OR max_bytes IS NOT NULL;`
+
+	return fr.selectQueuesSettings(query, ctx)
+}
+
+// SelectQueuesUsage counts the messages and their content sizes per queue.
+// This query uses COVERING INDEX via `idx_queue_usage`.
+func (fr *ForqRepo) SelectQueuesUsage(ctx context.Context) ([]QueueUsage, error) {
+	query := `
+		SELECT queue, COUNT(*), COALESCE(SUM(content_size), 0)
+		FROM messages
+		GROUP BY queue;`
+
+	rows, err := fr.dbRead.QueryContext(ctx, query)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to optimize database")
-		return common.ErrInternal
+		log.Error().Err(err).Msg("failed to select queues usage")
+		return nil, common.ErrInternal
 	}
-	return nil
+	defer rows.Close()
+
+	var result []QueueUsage
+	for rows.Next() {
+		var usage QueueUsage
+		if err := rows.Scan(&usage.QueueName, &usage.MessagesCount, &usage.ContentBytes); err != nil {
+			log.Error().Err(err).Msg("failed to scan queue usage")
+			return nil, common.ErrInternal
+		}
+		result = append(result, usage)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over queue usage rows")
+		return nil, common.ErrInternal
+	}
+	return result, nil
 }
 
-func (fr *ForqRepo) Close() error {
-	var err1, err2 error
-	if fr.dbRead != nil {
-		err1 = fr.dbRead.Close()
+// SelectOldestReadyMessages returns, per queue, when its oldest ready message
+// became ready: the queues without ready messages are left out.
+// This query only reads the ready messages, searched by status via `idx_expired`.
+func (fr *ForqRepo) SelectOldestReadyMessages(ctx context.Context) ([]QueueOldestReady, error) {
+	query := `
+		SELECT queue, MIN(process_after)
+		FROM messages
+		WHERE status = ? AND process_after <= ?
+		GROUP BY queue;`
+
+	rows, err := fr.dbRead.QueryContext(ctx, query,
+		common.ReadyStatus,     // WHERE status = ?
+		time.Now().UnixMilli(), // AND process_after <= ?
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select oldest ready messages")
+		return nil, common.ErrInternal
 	}
-	if fr.dbWrite != nil {
-		err2 = fr.dbWrite.Close()
+	defer rows.Close()
+
+	var result []QueueOldestReady
+	for rows.Next() {
+		var oldest QueueOldestReady
+		if err := rows.Scan(&oldest.QueueName, &oldest.ReadyAt); err != nil {
+			log.Error().Err(err).Msg("failed to scan oldest ready message")
+			return nil, common.ErrInternal
+		}
+		result = append(result, oldest)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over oldest ready message rows")
+		return nil, common.ErrInternal
 	}
+	return result, nil
+}
 
-	if err1 != nil {
-		return err1
+func (fr *ForqRepo) UpsertQueueDlqPolicy(queueName string, dlqPolicy string, dlqName *string, ctx context.Context) error {
+	query := `
+		INSERT INTO queue_settings (queue, dlq_policy, dlq_name, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (queue) DO UPDATE SET
+			dlq_policy = excluded.dlq_policy,
+			dlq_name = excluded.dlq_name,
+			updated_at = excluded.updated_at;`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		queueName,              // queue
+		dlqPolicy,              // dlq_policy
+		dlqName,                // dlq_name
+		time.Now().UnixMilli(), // updated_at
+	)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to upsert queue DLQ policy")
+		return common.ErrInternal
 	}
-	return err2
+	return nil
+}
+
+func (fr *ForqRepo) UpsertQueueDeliveryLimits(queueName string, ratePerSec *float64, burst *int, maxInFlight *int, ctx context.Context) error {
+	query := `
+		INSERT INTO queue_settings (queue, delivery_rate_per_sec, delivery_burst, max_in_flight, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (queue) DO UPDATE SET
+			delivery_rate_per_sec = excluded.delivery_rate_per_sec,
+			delivery_burst = excluded.delivery_burst,
+			max_in_flight = excluded.max_in_flight,
+			updated_at = excluded.updated_at;`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		queueName,              // queue
+		ratePerSec,             // delivery_rate_per_sec
+		burst,                  // delivery_burst
+		maxInFlight,            // max_in_flight
+		time.Now().UnixMilli(), // updated_at
+	)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to upsert queue delivery limits")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+func (fr *ForqRepo) UpsertQueueQuotas(queueName string, maxMessages *int64, maxBytes *int64, ctx context.Context) error {
+	query := `
+		INSERT INTO queue_settings (queue, max_messages, max_bytes, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (queue) DO UPDATE SET
+			max_messages = excluded.max_messages,
+			max_bytes = excluded.max_bytes,
+			updated_at = excluded.updated_at;`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		queueName,              // queue
+		maxMessages,            // max_messages
+		maxBytes,               // max_bytes
+		time.Now().UnixMilli(), // updated_at
+	)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to upsert queue quotas")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// PauseQueue stops the deliveries of the queue. Pausing a paused queue keeps
+// the original pause time, so that ResumeQueue shifts the TTLs by the whole
+// paused duration.
+func (fr *ForqRepo) PauseQueue(queueName string, ctx context.Context) error {
+	nowMs := time.Now().UnixMilli()
+
+	query := `
+		INSERT INTO queue_settings (queue, paused_at, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (queue) DO UPDATE SET
+			paused_at = COALESCE(queue_settings.paused_at, excluded.paused_at),
+			updated_at = excluded.updated_at;`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		queueName, // queue
+		nowMs,     // paused_at
+		nowMs,     // updated_at
+	)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to pause queue")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// ResumeQueue resumes the deliveries of the queue. The expiry sweeps skip
+// paused queues, so the messages' expires_after is moved forward by the paused
+// duration: a message gets the same time to be consumed as if the queue was
+// never paused. Returns false if the queue wasn't paused.
+func (fr *ForqRepo) ResumeQueue(queueName string, ctx context.Context) (bool, error) {
+	nowMs := time.Now().UnixMilli()
+
+	tx, err := fr.dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to begin transaction for resuming queue")
+		return false, common.ErrInternal
+	}
+	defer tx.Rollback()
+
+	var pausedAt int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT paused_at
+		FROM queue_settings
+		WHERE queue = ? AND paused_at IS NOT NULL;`,
+		queueName,
+	).Scan(&pausedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to select paused queue")
+		return false, common.ErrInternal
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE queue_settings
+		SET paused_at = NULL, updated_at = ?
+		WHERE queue = ?;`,
+		nowMs,     // updated_at = ?
+		queueName, // WHERE queue = ?
+	)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to resume queue")
+		return false, common.ErrInternal
+	}
+
+	// the messages that entered the queue mid-pause, i.e. produced, requeued, redriven or moved to a DLQ, were frozen
+	// only since they entered it, not since the pause started
+	_, err = tx.ExecContext(ctx, `
+		UPDATE messages
+		SET expires_after = expires_after + MAX(0, ? - MAX(COALESCE(queued_at, received_at), ?))
+		WHERE queue = ?;`,
+		nowMs,     // MAX(0, ? - ...)
+		pausedAt,  // MAX(COALESCE(...), ?)
+		queueName, // WHERE queue = ?
+	)
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to shift expiration of the resumed queue messages")
+		return false, common.ErrInternal
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Str("queue", queueName).Msg("failed to commit resuming queue")
+		return false, common.ErrInternal
+	}
+	return true, nil
+}
+
+func (fr *ForqRepo) InsertApiKey(apiKey *ApiKey, ctx context.Context) error {
+	query := `
+		INSERT INTO api_keys (id, name, key_hash, scopes, created_at)
+		VALUES (?, ?, ?, ?, ?);`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		apiKey.Id,        // id
+		apiKey.Name,      // name
+		apiKey.KeyHash,   // key_hash
+		apiKey.Scopes,    // scopes
+		apiKey.CreatedAt, // created_at
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			// the key hash is random, so it's the name that's taken
+			return common.ErrBadRequestKeyNameTaken
+		}
+		log.Error().Err(err).Str("api_key_name", apiKey.Name).Msg("failed to insert API key")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+func (fr *ForqRepo) SelectAllApiKeys(ctx context.Context) ([]ApiKey, error) {
+	query := `
+		SELECT id, name, key_hash, scopes, created_at
+		FROM api_keys
+		ORDER BY name;`
+
+	rows, err := fr.dbRead.QueryContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select API keys")
+		return nil, common.ErrInternal
+	}
+	defer rows.Close()
+
+	var result []ApiKey
+	for rows.Next() {
+		var apiKey ApiKey
+		err := rows.Scan(&apiKey.Id, &apiKey.Name, &apiKey.KeyHash, &apiKey.Scopes, &apiKey.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan API key")
+			return nil, common.ErrInternal
+		}
+		result = append(result, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over API keys rows")
+		return nil, common.ErrInternal
+	}
+	return result, nil
+}
+
+// DeleteApiKey returns the hash of the deleted key, or an empty string if
+// there is no such key.
+func (fr *ForqRepo) DeleteApiKey(id string, ctx context.Context) (string, error) {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = ?
+		RETURNING key_hash;`
+
+	var keyHash string
+	err := fr.dbWrite.QueryRowContext(ctx, query,
+		id, // WHERE id = ?
+	).Scan(&keyHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		log.Error().Err(err).Str("api_key_id", id).Msg("failed to delete API key")
+		return "", common.ErrInternal
+	}
+	return keyHash, nil
+}
+
+func (fr *ForqRepo) InsertUser(user *User, ctx context.Context) error {
+	query := `
+		INSERT INTO users (id, username, password_hash, role, created_at)
+		VALUES (?, ?, ?, ?, ?);`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		user.Id,           // id
+		user.Username,     // username
+		user.PasswordHash, // password_hash
+		user.Role,         // role
+		user.CreatedAt,    // created_at
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return common.ErrBadRequestUsernameTaken
+		}
+		log.Error().Err(err).Str("username", user.Username).Msg("failed to insert user")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+func (fr *ForqRepo) SelectAllUsers(ctx context.Context) ([]User, error) {
+	query := `
+		SELECT id, username, password_hash, role, created_at
+		FROM users
+		ORDER BY username;`
+
+	rows, err := fr.dbRead.QueryContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select users")
+		return nil, common.ErrInternal
+	}
+	defer rows.Close()
+
+	var result []User
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Id, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan user")
+			return nil, common.ErrInternal
+		}
+		result = append(result, user)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over users rows")
+		return nil, common.ErrInternal
+	}
+	return result, nil
+}
+
+// SelectUserByUsername returns nil if there is no such user.
+func (fr *ForqRepo) SelectUserByUsername(username string, ctx context.Context) (*User, error) {
+	query := `
+		SELECT id, username, password_hash, role, created_at
+		FROM users
+		WHERE username = ?;`
+
+	var user User
+	err := fr.dbRead.QueryRowContext(ctx, query,
+		username, // WHERE username = ?
+	).Scan(&user.Id, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Error().Err(err).Str("username", username).Msg("failed to select user")
+		return nil, common.ErrInternal
+	}
+	return &user, nil
+}
+
+// UpdateUserRole returns the username of the updated user, or an empty string
+// if there is no such user.
+func (fr *ForqRepo) UpdateUserRole(id string, role string, ctx context.Context) (string, error) {
+	query := `
+		UPDATE users
+		SET role = ?
+		WHERE id = ?
+		RETURNING username;`
+
+	return fr.updateUser(query, id, role, ctx)
+}
+
+// UpdateUserPassword returns the username of the updated user, or an empty
+// string if there is no such user.
+func (fr *ForqRepo) UpdateUserPassword(id string, passwordHash string, ctx context.Context) (string, error) {
+	query := `
+		UPDATE users
+		SET password_hash = ?
+		WHERE id = ?
+		RETURNING username;`
+
+	return fr.updateUser(query, id, passwordHash, ctx)
+}
+
+func (fr *ForqRepo) updateUser(query string, id string, value string, ctx context.Context) (string, error) {
+	var username string
+	err := fr.dbWrite.QueryRowContext(ctx, query,
+		value, // SET ... = ?
+		id,    // WHERE id = ?
+	).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		log.Error().Err(err).Str("user_id", id).Msg("failed to update user")
+		return "", common.ErrInternal
+	}
+	return username, nil
+}
+
+// DeleteUser returns the username of the deleted user, or an empty string if
+// there is no such user.
+func (fr *ForqRepo) DeleteUser(id string, ctx context.Context) (string, error) {
+	query := `
+		DELETE FROM users
+		WHERE id = ?
+		RETURNING username;`
+
+	var username string
+	err := fr.dbWrite.QueryRowContext(ctx, query,
+		id, // WHERE id = ?
+	).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		log.Error().Err(err).Str("user_id", id).Msg("failed to delete user")
+		return "", common.ErrInternal
+	}
+	return username, nil
+}
+
+func (fr *ForqRepo) InsertSession(session *Session, ctx context.Context) error {
+	query := `
+		INSERT INTO sessions (id_hash, username, role, created_at, last_active_at, client_ip, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?, ?);`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		session.IdHash,       // id_hash
+		session.Username,     // username
+		session.Role,         // role
+		session.CreatedAt,    // created_at
+		session.LastActiveAt, // last_active_at
+		session.ClientIp,     // client_ip
+		session.UserAgent,    // user_agent
+	)
+	if err != nil {
+		log.Error().Err(err).Str("username", session.Username).Msg("failed to insert session")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+func (fr *ForqRepo) SelectAllSessions(ctx context.Context) ([]Session, error) {
+	query := `
+		SELECT id_hash, username, role, created_at, last_active_at, client_ip, user_agent
+		FROM sessions
+		ORDER BY last_active_at DESC;`
+
+	rows, err := fr.dbRead.QueryContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select sessions")
+		return nil, common.ErrInternal
+	}
+	defer rows.Close()
+
+	var result []Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.IdHash, &session.Username, &session.Role, &session.CreatedAt, &session.LastActiveAt, &session.ClientIp, &session.UserAgent)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan session")
+			return nil, common.ErrInternal
+		}
+		result = append(result, session)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over sessions rows")
+		return nil, common.ErrInternal
+	}
+	return result, nil
+}
+
+// SelectSession returns nil if there is no such session.
+func (fr *ForqRepo) SelectSession(idHash string, ctx context.Context) (*Session, error) {
+	query := `
+		SELECT id_hash, username, role, created_at, last_active_at, client_ip, user_agent
+		FROM sessions
+		WHERE id_hash = ?;`
+
+	var session Session
+	err := fr.dbRead.QueryRowContext(ctx, query,
+		idHash, // WHERE id_hash = ?
+	).Scan(&session.IdHash, &session.Username, &session.Role, &session.CreatedAt, &session.LastActiveAt, &session.ClientIp, &session.UserAgent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Error().Err(err).Msg("failed to select session")
+		return nil, common.ErrInternal
+	}
+	return &session, nil
+}
+
+func (fr *ForqRepo) UpdateSessionLastActive(idHash string, lastActiveAt int64, ctx context.Context) error {
+	query := `
+		UPDATE sessions
+		SET last_active_at = ?
+		WHERE id_hash = ?;`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		lastActiveAt, // SET last_active_at = ?
+		idHash,       // WHERE id_hash = ?
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to update session last activity")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// DeleteSession returns the username of the deleted session, or an empty
+// string if there is no such session.
+func (fr *ForqRepo) DeleteSession(idHash string, ctx context.Context) (string, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE id_hash = ?
+		RETURNING username;`
+
+	var username string
+	err := fr.dbWrite.QueryRowContext(ctx, query,
+		idHash, // WHERE id_hash = ?
+	).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		log.Error().Err(err).Msg("failed to delete session")
+		return "", common.ErrInternal
+	}
+	return username, nil
+}
+
+func (fr *ForqRepo) DeleteUserSessions(username string, ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE username = ?;`
+
+	return fr.deleteSessions(query, []any{
+		username, // WHERE username = ?
+	}, ctx)
+}
+
+// DeleteExpiredSessions deletes the sessions idle since before idleBefore, or
+// created before createdBefore.
+func (fr *ForqRepo) DeleteExpiredSessions(idleBefore int64, createdBefore int64, ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE last_active_at < ? OR created_at < ?;`
+
+	return fr.deleteSessions(query, []any{
+		idleBefore,    // WHERE last_active_at < ?
+		createdBefore, // OR created_at < ?
+	}, ctx)
+}
+
+func (fr *ForqRepo) deleteSessions(query string, args []any, ctx context.Context) (int64, error) {
+	res, err := fr.dbWrite.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete sessions")
+		return 0, common.ErrInternal
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get rows affected after deleting sessions")
+		return 0, common.ErrInternal
+	}
+	return rowsAffected, nil
+}
+
+func (fr *ForqRepo) InsertAuditEntry(entry *AuditEntry, ctx context.Context) error {
+	query := `
+		INSERT INTO audit_log (created_at, actor, source_ip, action, queue, message_id, count, outcome, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		entry.CreatedAt, // created_at
+		entry.Actor,     // actor
+		entry.SourceIp,  // source_ip
+		entry.Action,    // action
+		entry.Queue,     // queue
+		entry.MessageId, // message_id
+		entry.Count,     // count
+		entry.Outcome,   // outcome
+		entry.Details,   // details
+	)
+	if err != nil {
+		log.Error().Err(err).Str("actor", entry.Actor).Str("action", entry.Action).Msg("failed to insert audit log entry")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// SelectAuditEntries returns up to limit entries matching the filter, the
+// newest first.
+func (fr *ForqRepo) SelectAuditEntries(filter *AuditFilter, limit int, ctx context.Context) ([]AuditEntry, error) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Queue != "" {
+		conditions = append(conditions, "queue = ?")
+		args = append(args, filter.Queue)
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, "outcome = ?")
+		args = append(args, filter.Outcome)
+	}
+	if filter.Since > 0 {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until > 0 {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until)
+	}
+	if filter.BeforeId > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeId)
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT id, created_at, actor, source_ip, action, queue, message_id, count, outcome, details
+		FROM audit_log
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT ?;`, strings.Join(conditions, " AND "))
+
+	rows, err := fr.dbRead.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select audit log entries")
+		return nil, common.ErrInternal
+	}
+	defer rows.Close()
+
+	var result []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(&entry.Id, &entry.CreatedAt, &entry.Actor, &entry.SourceIp, &entry.Action, &entry.Queue, &entry.MessageId, &entry.Count, &entry.Outcome, &entry.Details)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan audit log entry")
+			return nil, common.ErrInternal
+		}
+		result = append(result, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over audit log rows")
+		return nil, common.ErrInternal
+	}
+	return result, nil
+}
+
+func (fr *ForqRepo) DeleteAuditEntriesOlderThan(createdBefore int64, ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM audit_log
+		WHERE created_at < ?;`
+
+	res, err := fr.dbWrite.ExecContext(ctx, query,
+		createdBefore, // WHERE created_at < ?
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete old audit log entries")
+		return 0, common.ErrInternal
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get rows affected after deleting old audit log entries")
+		return 0, common.ErrInternal
+	}
+	return rowsAffected, nil
+}
+
+// UpsertLockout never shortens a lockout or lowers its strikes, e.g. when two
+// instances lock the same subject out at the same time.
+func (fr *ForqRepo) UpsertLockout(lockout *Lockout, ctx context.Context) error {
+	query := `
+		INSERT INTO auth_lockouts (subject, locked_until, strikes)
+		VALUES (?, ?, ?)
+		ON CONFLICT (subject) DO UPDATE
+		SET locked_until = MAX(locked_until, excluded.locked_until),
+			strikes = MAX(strikes, excluded.strikes);`
+
+	_, err := fr.dbWrite.ExecContext(ctx, query,
+		lockout.Subject,     // subject
+		lockout.LockedUntil, // locked_until
+		lockout.Strikes,     // strikes
+	)
+	if err != nil {
+		log.Error().Err(err).Str("subject", lockout.Subject).Msg("failed to upsert auth lockout")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// SelectLockout returns nil if the subject has never been locked out, or its
+// strikes have been forgotten already.
+func (fr *ForqRepo) SelectLockout(subject string, ctx context.Context) (*Lockout, error) {
+	query := `
+		SELECT subject, locked_until, strikes
+		FROM auth_lockouts
+		WHERE subject = ?;`
+
+	var lockout Lockout
+	err := fr.dbRead.QueryRowContext(ctx, query,
+		subject, // WHERE subject = ?
+	).Scan(&lockout.Subject, &lockout.LockedUntil, &lockout.Strikes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Error().Err(err).Str("subject", subject).Msg("failed to select auth lockout")
+		return nil, common.ErrInternal
+	}
+	return &lockout, nil
+}
+
+// SelectActiveLockouts returns the lockouts that end after now, the longest
+// first.
+func (fr *ForqRepo) SelectActiveLockouts(now int64, ctx context.Context) ([]Lockout, error) {
+	query := `
+		SELECT subject, locked_until, strikes
+		FROM auth_lockouts
+		WHERE locked_until > ?
+		ORDER BY locked_until DESC;`
+
+	rows, err := fr.dbRead.QueryContext(ctx, query,
+		now, // WHERE locked_until > ?
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select auth lockouts")
+		return nil, common.ErrInternal
+	}
+	defer rows.Close()
+
+	var result []Lockout
+	for rows.Next() {
+		var lockout Lockout
+		err := rows.Scan(&lockout.Subject, &lockout.LockedUntil, &lockout.Strikes)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan auth lockout")
+			return nil, common.ErrInternal
+		}
+		result = append(result, lockout)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over auth lockouts rows")
+		return nil, common.ErrInternal
+	}
+	return result, nil
+}
+
+// DeleteLockout returns false if there is no such lockout.
+func (fr *ForqRepo) DeleteLockout(subject string, ctx context.Context) (bool, error) {
+	query := `
+		DELETE FROM auth_lockouts
+		WHERE subject = ?;`
+
+	res, err := fr.dbWrite.ExecContext(ctx, query,
+		subject, // WHERE subject = ?
+	)
+	if err != nil {
+		log.Error().Err(err).Str("subject", subject).Msg("failed to delete auth lockout")
+		return false, common.ErrInternal
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get rows affected after deleting auth lockout")
+		return false, common.ErrInternal
+	}
+	return rowsAffected > 0, nil
+}
+
+// DeleteLockoutsEndedBefore deletes the lockouts whose strikes are not
+// remembered anymore.
+func (fr *ForqRepo) DeleteLockoutsEndedBefore(lockedUntil int64, ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM auth_lockouts
+		WHERE locked_until < ?;`
+
+	res, err := fr.dbWrite.ExecContext(ctx, query,
+		lockedUntil, // WHERE locked_until < ?
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete ended auth lockouts")
+		return 0, common.ErrInternal
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get rows affected after deleting ended auth lockouts")
+		return 0, common.ErrInternal
+	}
+	return rowsAffected, nil
+}
+
+func (fr *ForqRepo) Ping(ctx context.Context) error {
+	err := fr.dbRead.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+	return fr.dbWrite.PingContext(ctx)
+}
+
+func (fr *ForqRepo) Optimize(ctx context.Context) error {
+	// SQLite docs recommend running "PRAGMA optimize;" periodically to optimize the database
+	// https://www.sqlite.org/pragma.html#pragma_optimize
+	_, err := fr.dbWrite.ExecContext(ctx, "PRAGMA optimize;")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to optimize database")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// Backup writes a consistent snapshot of the DB into path, which must not
+// exist or be empty. VACUUM INTO runs on a read connection: it reads a single
+// snapshot of the WAL, so the writes go on meanwhile.
+// https://www.sqlite.org/lang_vacuum.html#vacuuminto
+func (fr *ForqRepo) Backup(path string, ctx context.Context) error {
+	_, err := fr.dbRead.ExecContext(ctx, "VACUUM INTO ?;", path)
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("failed to back up database")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// SelectDbStats returns the sizes of the DB files and the counters of the DB
+// usage since the start.
+func (fr *ForqRepo) SelectDbStats(ctx context.Context) (*DbStats, error) {
+	var stats DbStats
+
+	dbInfo, err := os.Stat(fr.dbPath)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to stat the DB file")
+		return nil, common.ErrInternal
+	}
+	stats.FileBytes = dbInfo.Size()
+
+	// the WAL file only exists while the DB is open, but it's checkpointed rather than removed
+	walInfo, err := os.Stat(fr.dbPath + "-wal")
+	if err == nil {
+		stats.WalBytes = walInfo.Size()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Error().Err(err).Msg("failed to stat the WAL file")
+		return nil, common.ErrInternal
+	}
+
+	err = fr.dbRead.QueryRowContext(ctx, "PRAGMA freelist_count;").Scan(&stats.FreelistPages)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select freelist count")
+		return nil, common.ErrInternal
+	}
+
+	stats.CacheHits, stats.CacheMisses = cacheStatus()
+	stats.BusyErrors = fr.errs.busy.Load()
+	stats.LockedErrors = fr.errs.locked.Load()
+
+	writeStats := fr.dbWrite.Stats()
+	stats.WriteWaits = writeStats.WaitCount
+	stats.WriteWaitDuration = writeStats.WaitDuration
+	return &stats, nil
+}
+
+func (fr *ForqRepo) Close() error {
+	var err1, err2 error
+	if fr.dbRead.DB != nil {
+		err1 = fr.dbRead.Close()
+	}
+	if fr.dbWrite.DB != nil {
+		err2 = fr.dbWrite.Close()
+	}
+
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+func (fr *ForqRepo) selectQueuesSettings(query string, ctx context.Context) ([]QueueSettings, error) {
+	rows, err := fr.dbRead.QueryContext(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to select queues settings")
+		return nil, common.ErrInternal
+	}
+	defer rows.Close()
+
+	var result []QueueSettings
+	for rows.Next() {
+		var settings QueueSettings
+		err := rows.Scan(
+			&settings.QueueName,
+			&settings.DlqPolicy,
+			&settings.DlqName,
+			&settings.DeliveryRatePerSec,
+			&settings.DeliveryBurst,
+			&settings.MaxInFlight,
+			&settings.PausedAt,
+			&settings.MaxMessages,
+			&settings.MaxBytes,
+			&settings.UpdatedAt,
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to scan queue settings")
+			return nil, common.ErrInternal
+		}
+		result = append(result, settings)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("error iterating over queue settings rows")
+		return nil, common.ErrInternal
+	}
+	return result, nil
 }
 
-func (fr *ForqRepo) processAfterCases(nowMs int64) string {
+func processAfterCases(nowMs int64, backoffDelaysMs []int64) string {
 	var processAfterCases strings.Builder
 
 	// builds WHEN clauses for each backoff delay.
 	// `attempts` was already incremented when the message was claimed for
 	// consuming, so the first failed delivery arrives here with attempts = 1.
-	for i, delay := range fr.appConfigs.BackoffDelaysMs {
-		if i < len(fr.appConfigs.BackoffDelaysMs)-1 {
+	for i, delay := range backoffDelaysMs {
+		if i < len(backoffDelaysMs)-1 {
 			processAfterCases.WriteString(fmt.Sprintf("WHEN attempts = %d THEN %d ", i+1, nowMs+delay))
 		} else {
 			processAfterCases.WriteString(fmt.Sprintf("ELSE %d ", nowMs+delay))
@@ -48,7 +48,7 @@ func TestInsertAndConsume_FIFO(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	msg, err := repo.SelectMessageForConsuming("orders", ctx)
+	msg, err := repo.SelectMessageForConsuming("orders", 0, ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -59,7 +59,7 @@ func TestInsertAndConsume_FIFO(t *testing.T) {
 		t.Fatal("expected a non-zero delivery receipt (processing_started_at)")
 	}
 
-	msg2, err := repo.SelectMessageForConsuming("orders", ctx)
+	msg2, err := repo.SelectMessageForConsuming("orders", 0, ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,7 +68,7 @@ func TestInsertAndConsume_FIFO(t *testing.T) {
 	}
 
 	// queue drained: consuming again finds nothing
-	msg3, err := repo.SelectMessageForConsuming("orders", ctx)
+	msg3, err := repo.SelectMessageForConsuming("orders", 0, ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,11 +85,11 @@ func TestConsume_ClaimedMessageIsInvisible(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if msg, _ := repo.SelectMessageForConsuming("orders", ctx); msg == nil {
+	if msg, _ := repo.SelectMessageForConsuming("orders", 0, ctx); msg == nil {
 		t.Fatal("expected a message")
 	}
 	// same message must not be claimable twice
-	if msg, _ := repo.SelectMessageForConsuming("orders", ctx); msg != nil {
+	if msg, _ := repo.SelectMessageForConsuming("orders", 0, ctx); msg != nil {
 		t.Fatalf("claimed message was claimable again: %+v", msg)
 	}
 }
@@ -104,11 +104,39 @@ func TestConsume_DelayedMessageNotVisible(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if msg, _ := repo.SelectMessageForConsuming("orders", ctx); msg != nil {
+	if msg, _ := repo.SelectMessageForConsuming("orders", 0, ctx); msg != nil {
 		t.Fatalf("delayed message was delivered early: %+v", msg)
 	}
 }
 
+func TestConsume_MaxInFlight(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := repo.InsertMessage(newMessage(t, "orders", fmt.Sprintf("msg-%d", i)), ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, _ := repo.SelectMessageForConsuming("orders", 2, ctx)
+	second, _ := repo.SelectMessageForConsuming("orders", 2, ctx)
+	if first == nil || second == nil {
+		t.Fatal("expected two messages within the in-flight limit")
+	}
+	if msg, _ := repo.SelectMessageForConsuming("orders", 2, ctx); msg != nil {
+		t.Fatalf("message delivered above the in-flight limit: %+v", msg)
+	}
+
+	// acking frees up a slot
+	if _, err := repo.DeleteMessageOnAck(first.Id, "orders", first.ProcessingStartedAt, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := repo.SelectMessageForConsuming("orders", 2, ctx); msg == nil {
+		t.Fatal("expected a message after an ack freed a slot")
+	}
+}
+
 func TestAck_ReceiptFencing(t *testing.T) {
 	repo, _, _ := testutil.NewTestRepo(t)
 	ctx := context.Background()
@@ -116,24 +144,28 @@ func TestAck_ReceiptFencing(t *testing.T) {
 	if err := repo.InsertMessage(newMessage(t, "orders", "x"), ctx); err != nil {
 		t.Fatal(err)
 	}
-	msg, err := repo.SelectMessageForConsuming("orders", ctx)
+	msg, err := repo.SelectMessageForConsuming("orders", 0, ctx)
 	if err != nil || msg == nil {
 		t.Fatalf("consume failed: %v %v", err, msg)
 	}
 
 	// wrong receipt must not delete the delivery
-	err = repo.DeleteMessageOnAck(msg.Id, "orders", msg.ProcessingStartedAt+1, ctx)
+	_, err = repo.DeleteMessageOnAck(msg.Id, "orders", msg.ProcessingStartedAt+1, ctx)
 	if !errors.Is(err, common.ErrNotFoundMessage) {
 		t.Fatalf("ack with wrong receipt: got %v, want ErrNotFoundMessage", err)
 	}
 
 	// correct receipt deletes
-	if err := repo.DeleteMessageOnAck(msg.Id, "orders", msg.ProcessingStartedAt, ctx); err != nil {
+	attempts, err := repo.DeleteMessageOnAck(msg.Id, "orders", msg.ProcessingStartedAt, ctx)
+	if err != nil {
 		t.Fatalf("ack with correct receipt failed: %v", err)
 	}
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
 
 	// double ack is a 0-row no-op reported as not found
-	err = repo.DeleteMessageOnAck(msg.Id, "orders", msg.ProcessingStartedAt, ctx)
+	_, err = repo.DeleteMessageOnAck(msg.Id, "orders", msg.ProcessingStartedAt, ctx)
 	if !errors.Is(err, common.ErrNotFoundMessage) {
 		t.Fatalf("double ack: got %v, want ErrNotFoundMessage", err)
 	}
@@ -149,8 +181,8 @@ func TestNack_BackoffDelays(t *testing.T) {
 
 	// the claim increments attempts, so failure N arrives with attempts = N;
 	// the documented delays are 1s, 5s, 15s, 30s, 60s
-	for attempt, wantDelayMs := range appConfigs.BackoffDelaysMs {
-		msg, err := repo.SelectMessageForConsuming("orders", ctx)
+	for attempt, wantDelayMs := range appConfigs.Limits().BackoffDelaysMs {
+		msg, err := repo.SelectMessageForConsuming("orders", 0, ctx)
 		if err != nil || msg == nil {
 			t.Fatalf("attempt %d: consume failed: %v %v", attempt+1, err, msg)
 		}
@@ -174,7 +206,7 @@ func TestNack_BackoffDelays(t *testing.T) {
 			t.Fatalf("attempt %d: attempts = %d", attempt+1, attempts)
 		}
 
-		isLastAttempt := attempt == appConfigs.MaxDeliveryAttempts-1
+		isLastAttempt := attempt == appConfigs.Limits().MaxDeliveryAttempts-1
 		if isLastAttempt {
 			if status != common.FailedStatus {
 				t.Fatalf("after %d attempts status = %d, want failed (%d)", attempts, status, common.FailedStatus)
@@ -201,7 +233,7 @@ func TestNack_ReceiptFencing(t *testing.T) {
 	if err := repo.InsertMessage(newMessage(t, "orders", "x"), ctx); err != nil {
 		t.Fatal(err)
 	}
-	msg, _ := repo.SelectMessageForConsuming("orders", ctx)
+	msg, _ := repo.SelectMessageForConsuming("orders", 0, ctx)
 
 	err := repo.UpdateMessageOnConsumingFailure(msg.Id, "orders", msg.ProcessingStartedAt+1, ctx)
 	if !errors.Is(err, common.ErrNotFoundMessage) {
@@ -217,8 +249,8 @@ func TestStaleRecovery_FencesLateAck(t *testing.T) {
 		t.Fatal(err)
 	}
 	// consumer A claims and then goes silent past the visibility timeout
-	msgA, _ := repo.SelectMessageForConsuming("orders", ctx)
-	staleTs := time.Now().UnixMilli() - appConfigs.MaxProcessingTimeMs - 1
+	msgA, _ := repo.SelectMessageForConsuming("orders", 0, ctx)
+	staleTs := time.Now().UnixMilli() - appConfigs.Limits().MaxProcessingTimeMs - 1
 	if _, err := rawDB.Exec("UPDATE messages SET processing_started_at = ? WHERE id = ?", staleTs, msgA.Id); err != nil {
 		t.Fatal(err)
 	}
@@ -239,20 +271,20 @@ func TestStaleRecovery_FencesLateAck(t *testing.T) {
 	time.Sleep(5 * time.Millisecond)
 
 	// consumer B claims the redelivery
-	msgB, _ := repo.SelectMessageForConsuming("orders", ctx)
+	msgB, _ := repo.SelectMessageForConsuming("orders", 0, ctx)
 	if msgB == nil {
 		t.Fatal("expected redelivery after stale recovery")
 	}
 
 	// A's late ack carries the receipt it was given (now rewound in the DB by
 	// the test, but A never learns that) - it must NOT delete B's delivery
-	err = repo.DeleteMessageOnAck(msgA.Id, "orders", msgA.ProcessingStartedAt, ctx)
+	_, err = repo.DeleteMessageOnAck(msgA.Id, "orders", msgA.ProcessingStartedAt, ctx)
 	if !errors.Is(err, common.ErrNotFoundMessage) {
 		t.Fatalf("late ack from timed-out consumer: got %v, want ErrNotFoundMessage", err)
 	}
 
 	// B's ack with B's receipt succeeds
-	if err := repo.DeleteMessageOnAck(msgB.Id, "orders", msgB.ProcessingStartedAt, ctx); err != nil {
+	if _, err := repo.DeleteMessageOnAck(msgB.Id, "orders", msgB.ProcessingStartedAt, ctx); err != nil {
 		t.Fatalf("B's ack failed: %v", err)
 	}
 }
@@ -409,6 +441,83 @@ func TestRequeueDlqMessages(t *testing.T) {
 	}
 }
 
+func TestRedriveDlqMessages_FiltersAndStaggers(t *testing.T) {
+	repo, appConfigs, rawDB := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	nowMs := time.Now().UnixMilli()
+	seed := []struct {
+		reason     string
+		receivedAt int64
+	}{
+		{common.MaxAttemptsReachedFailureReason, nowMs - 3000},
+		{common.MaxAttemptsReachedFailureReason, nowMs - 2000},
+		{common.MaxAttemptsReachedFailureReason, nowMs - 1000},
+		{common.MessageExpiredFailureReason, nowMs - 2500},
+	}
+	for _, m := range seed {
+		id, _ := uuid.NewV7()
+		_, err := rawDB.Exec(`INSERT INTO messages (id, queue, is_dlq, content, status, attempts, process_after, received_at, updated_at, expires_after, failure_reason)
+			VALUES (?, 'orders-dlq', TRUE, 'x', ?, 0, ?, ?, ?, ?, ?)`,
+			id.String(), common.ReadyStatus, nowMs, m.receivedAt, nowMs, nowMs+60_000, m.reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only max-attempts failures received before the newest one, 10 msg/s
+	redriven, err := repo.RedriveDlqMessages("orders-dlq", &db.RedriveFilter{
+		TargetQueueName: "orders-quarantine",
+		FailureReason:   common.MaxAttemptsReachedFailureReason,
+		ReceivedBefore:  nowMs - 1500,
+		SpacingMs:       100,
+	}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redriven != 2 {
+		t.Fatalf("redriven %d, want 2", redriven)
+	}
+
+	rows, err := rawDB.Query(`SELECT is_dlq, failure_reason, process_after, expires_after FROM messages
+		WHERE queue = 'orders-quarantine' ORDER BY received_at ASC`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var processAfters []int64
+	for rows.Next() {
+		var isDlq bool
+		var failureReason sql.NullString
+		var processAfter, expiresAfter int64
+		if err := rows.Scan(&isDlq, &failureReason, &processAfter, &expiresAfter); err != nil {
+			t.Fatal(err)
+		}
+		if isDlq || failureReason.Valid {
+			t.Fatalf("redriven message still looks like a DLQ one: is_dlq=%v failure_reason=%v", isDlq, failureReason)
+		}
+		if expiresAfter != processAfter+appConfigs.Limits().QueueTtlMs {
+			t.Fatalf("expires_after = %d, want process_after + queue TTL (%d)", expiresAfter, processAfter+appConfigs.Limits().QueueTtlMs)
+		}
+		processAfters = append(processAfters, processAfter)
+	}
+	if len(processAfters) != 2 {
+		t.Fatalf("target queue has %d messages, want 2", len(processAfters))
+	}
+	if processAfters[1]-processAfters[0] != 100 {
+		t.Fatalf("process_after spacing = %dms, want 100ms", processAfters[1]-processAfters[0])
+	}
+
+	var leftInDlq int
+	if err := rawDB.QueryRow("SELECT COUNT(*) FROM messages WHERE queue = 'orders-dlq'").Scan(&leftInDlq); err != nil {
+		t.Fatal(err)
+	}
+	if leftInDlq != 2 {
+		t.Fatalf("%d messages left in DLQ, want 2", leftInDlq)
+	}
+}
+
 func TestSelectMessagesForUI_KeysetPagination(t *testing.T) {
 	repo, _, _ := testutil.NewTestRepo(t)
 	ctx := context.Background()
@@ -482,3 +591,364 @@ func TestQueueStats(t *testing.T) {
 		t.Fatalf("expected nil stats for unknown queue, got %+v", missing)
 	}
 }
+
+func TestDlqPolicies(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	sharedDlq := "shared-dlq"
+	if err := repo.UpsertQueueDlqPolicy("orders", common.CustomDlqPolicy, &sharedDlq, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpsertQueueDlqPolicy("emails", common.CustomDlqPolicy, &sharedDlq, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpsertQueueDlqPolicy("metrics", common.NoDlqPolicy, nil, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]string{}
+	for _, queue := range []string{"orders", "emails", "metrics", "payments"} {
+		msg := newMessage(t, queue, "doomed")
+		if err := repo.InsertMessage(msg, ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rawDB.Exec("UPDATE messages SET status = ? WHERE id = ?", common.FailedStatus, msg.Id); err != nil {
+			t.Fatal(err)
+		}
+		ids[queue] = msg.Id
+	}
+
+	moved, err := repo.UpdateFailedMessagesForRegularQueues(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 3 {
+		t.Fatalf("moved %d, want 3", moved)
+	}
+	dropped, err := repo.DeleteFailedMessagesWithoutDlq(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 1 {
+		t.Fatalf("dropped %d, want 1", dropped)
+	}
+
+	wantQueues := map[string]string{"orders": "shared-dlq", "emails": "shared-dlq", "payments": "payments-dlq"}
+	for origin, wantQueue := range wantQueues {
+		var queue, originQueue string
+		if err := rawDB.QueryRow("SELECT queue, origin_queue FROM messages WHERE id = ?", ids[origin]).Scan(&queue, &originQueue); err != nil {
+			t.Fatal(err)
+		}
+		if queue != wantQueue || originQueue != origin {
+			t.Fatalf("message from %s: queue=%s origin_queue=%s", origin, queue, originQueue)
+		}
+	}
+
+	// requeueing a shared DLQ sends every message back to where it came from
+	requeued, err := repo.RequeueDlqMessages("shared-dlq", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued != 2 {
+		t.Fatalf("requeued %d, want 2", requeued)
+	}
+	for _, origin := range []string{"orders", "emails"} {
+		var queue string
+		if err := rawDB.QueryRow("SELECT queue FROM messages WHERE id = ?", ids[origin]).Scan(&queue); err != nil {
+			t.Fatal(err)
+		}
+		if queue != origin {
+			t.Fatalf("message from %s requeued into %s", origin, queue)
+		}
+	}
+}
+
+func TestDlqPolicies_ChainedDlqs(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	archiveDlq := "archive-dlq"
+	if err := repo.UpsertQueueDlqPolicy("orders-dlq", common.CustomDlqPolicy, &archiveDlq, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	nowMs := time.Now().UnixMilli()
+	id, _ := uuid.NewV7()
+	_, err := rawDB.Exec(`INSERT INTO messages (id, queue, origin_queue, is_dlq, content, status, attempts, process_after, received_at, updated_at, expires_after, failure_reason)
+		VALUES (?, 'orders-dlq', 'orders', TRUE, 'x', ?, 5, ?, ?, ?, ?, 'max_attempts_reached')`,
+		id.String(), common.FailedStatus, nowMs, nowMs, nowMs, nowMs+1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := repo.UpdateFailedMessagesForChainedDlqs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 {
+		t.Fatalf("moved %d, want 1", moved)
+	}
+
+	var queue, originQueue string
+	var status int
+	if err := rawDB.QueryRow("SELECT queue, origin_queue, status FROM messages WHERE id = ?", id.String()).Scan(&queue, &originQueue, &status); err != nil {
+		t.Fatal(err)
+	}
+	if queue != "archive-dlq" || originQueue != "orders" || status != common.ReadyStatus {
+		t.Fatalf("after chaining: queue=%s origin_queue=%s status=%d", queue, originQueue, status)
+	}
+}
+
+func TestPauseAndResumeQueue(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.PauseQueue("orders", ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// producing still works, consuming doesn't
+	msg := newMessage(t, "orders", "x")
+	if err := repo.InsertMessage(msg, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.SelectMessageForConsuming("orders", 0, ctx); got != nil {
+		t.Fatalf("paused queue delivered a message: %+v", got)
+	}
+
+	// the TTL is frozen while paused: the message was produced 20s ago, the pause started 10s ago, and the message expired 1s ago
+	nowMs := time.Now().UnixMilli()
+	if _, err := rawDB.Exec("UPDATE queue_settings SET paused_at = ? WHERE queue = 'orders'", nowMs-10_000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rawDB.Exec("UPDATE messages SET received_at = ?, expires_after = ? WHERE id = ?", nowMs-20_000, nowMs-1000, msg.Id); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := repo.UpdateExpiredMessagesForRegularQueues(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 0 {
+		t.Fatalf("expired %d messages of a paused queue", moved)
+	}
+
+	resumed, err := repo.ResumeQueue("orders", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed {
+		t.Fatal("expected the queue to be resumed")
+	}
+	var expiresAfter int64
+	if err := rawDB.QueryRow("SELECT expires_after FROM messages WHERE id = ?", msg.Id).Scan(&expiresAfter); err != nil {
+		t.Fatal(err)
+	}
+	if expiresAfter < nowMs+8_000 {
+		t.Fatalf("expires_after was not shifted by the paused duration: %d ms from now", expiresAfter-nowMs)
+	}
+
+	if got, _ := repo.SelectMessageForConsuming("orders", 0, ctx); got == nil {
+		t.Fatal("expected a message after resuming")
+	}
+
+	// resuming a queue that isn't paused is a no-op
+	resumed, err = repo.ResumeQueue("orders", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed {
+		t.Fatal("resumed a queue that wasn't paused")
+	}
+}
+
+func TestResumeQueueShiftsMessagesProducedMidPause(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	if err := repo.PauseQueue("orders", ctx); err != nil {
+		t.Fatal(err)
+	}
+	msg := newMessage(t, "orders", "x")
+	if err := repo.InsertMessage(msg, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pause started 10s ago, but the message was produced only 2s ago
+	nowMs := time.Now().UnixMilli()
+	if _, err := rawDB.Exec("UPDATE queue_settings SET paused_at = ? WHERE queue = 'orders'", nowMs-10_000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rawDB.Exec("UPDATE messages SET received_at = ?, expires_after = ? WHERE id = ?", nowMs-2_000, nowMs+60_000, msg.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.ResumeQueue("orders", ctx); err != nil {
+		t.Fatal(err)
+	}
+	var expiresAfter int64
+	if err := rawDB.QueryRow("SELECT expires_after FROM messages WHERE id = ?", msg.Id).Scan(&expiresAfter); err != nil {
+		t.Fatal(err)
+	}
+	// shifted by the ~2s the message spent in the paused queue, not by the whole 10s pause
+	shift := expiresAfter - (nowMs + 60_000)
+	if shift < 2_000 || shift >= 10_000 {
+		t.Fatalf("expires_after shifted by %d ms, expected ~2000 ms", shift)
+	}
+}
+
+func TestResumeQueueShiftsMessagesRequeuedMidPause(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	// a DLQ message produced to "orders" a minute ago
+	nowMs := time.Now().UnixMilli()
+	id, _ := uuid.NewV7()
+	_, err := rawDB.Exec(`INSERT INTO messages (id, queue, origin_queue, is_dlq, content, status, process_after, received_at, updated_at, expires_after)
+		VALUES (?, 'orders-dlq', 'orders', TRUE, 'x', ?, ?, ?, ?, ?)`,
+		id.String(), common.ReadyStatus, nowMs-60_000, nowMs-60_000, nowMs-60_000, nowMs+60_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "orders" has been paused for 10s when the message is requeued into it
+	if err := repo.PauseQueue("orders", ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rawDB.Exec("UPDATE queue_settings SET paused_at = ? WHERE queue = 'orders'", nowMs-10_000); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RequeueDlqMessage(id.String(), "orders-dlq", ctx); err != nil {
+		t.Fatal(err)
+	}
+	var requeuedExpiresAfter int64
+	if err := rawDB.QueryRow("SELECT expires_after FROM messages WHERE id = ?", id.String()).Scan(&requeuedExpiresAfter); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.ResumeQueue("orders", ctx); err != nil {
+		t.Fatal(err)
+	}
+	var expiresAfter int64
+	if err := rawDB.QueryRow("SELECT expires_after FROM messages WHERE id = ?", id.String()).Scan(&expiresAfter); err != nil {
+		t.Fatal(err)
+	}
+	// the TTL restarted on the requeue, so it was frozen for a moment only, not for the whole 10s pause
+	if shift := expiresAfter - requeuedExpiresAfter; shift >= 5_000 {
+		t.Fatalf("expires_after shifted by %d ms, expected ~0 ms", shift)
+	}
+}
+
+func TestSelectQueuesUsage(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	for _, content := range []string{"hello", "wörld"} {
+		if err := repo.InsertMessage(newMessage(t, "orders", content), ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := repo.SelectQueuesUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// content sizes are in bytes, not characters: "ö" takes 2 bytes in UTF-8
+	if len(usage) != 1 || usage[0].QueueName != "orders" || usage[0].MessagesCount != 2 || usage[0].ContentBytes != 11 {
+		t.Fatalf("usage = %+v", usage)
+	}
+}
+
+func TestSelectOldestReadyMessages(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	nowMs := time.Now().UnixMilli()
+	for _, processAfter := range []int64{nowMs - 2_000, nowMs - 1_000, nowMs + 60_000} {
+		msg := newMessage(t, "orders", "x")
+		msg.ProcessAfter = processAfter
+		if err := repo.InsertMessage(msg, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// delayed, so not ready yet
+	delayed := newMessage(t, "payments", "x")
+	delayed.ProcessAfter = nowMs + 60_000
+	if err := repo.InsertMessage(delayed, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	oldest, err := repo.SelectOldestReadyMessages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(oldest) != 1 || oldest[0].QueueName != "orders" || oldest[0].ReadyAt != nowMs-2_000 {
+		t.Fatalf("oldest ready = %+v", oldest)
+	}
+}
+
+func TestSelectDbStats(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	for range 10 {
+		if err := repo.InsertMessage(newMessage(t, "orders", "x"), ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.SelectQueuesUsage(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := repo.SelectDbStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.FileBytes <= 0 || stats.WalBytes <= 0 {
+		t.Fatalf("file sizes = %d and %d, want the DB and the WAL written", stats.FileBytes, stats.WalBytes)
+	}
+	if stats.CacheHits <= 0 {
+		t.Fatalf("cache hits = %d after the queries", stats.CacheHits)
+	}
+
+	// the counters are totals, which the reads keep adding to
+	if _, err := repo.SelectQueuesUsage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	again, err := repo.SelectDbStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.CacheHits <= stats.CacheHits || again.CacheMisses < stats.CacheMisses {
+		t.Fatalf("cache counters didn't grow with the reads: %+v -> %+v", stats, again)
+	}
+}
+
+func TestDeleteAuditEntriesOlderThan(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ctx := context.Background()
+
+	nowMs := time.Now().UnixMilli()
+	for _, createdAt := range []int64{nowMs - 3_000, nowMs - 2_000, nowMs} {
+		entry := &db.AuditEntry{CreatedAt: createdAt, Actor: "jane", Action: common.LoginAuditAction, Outcome: common.SuccessAuditOutcome}
+		if err := repo.InsertAuditEntry(entry, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := repo.DeleteAuditEntriesOlderThan(nowMs-1_000, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted = %d, want 2", deleted)
+	}
+
+	entries, err := repo.SelectAuditEntries(&db.AuditFilter{}, 10, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].CreatedAt != nowMs {
+		t.Fatalf("entries = %+v, want the newest one only", entries)
+	}
+}
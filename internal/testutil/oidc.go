@@ -0,0 +1,209 @@
+package testutil
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const TestOidcClientId = "forq-test"
+
+type oidcCode struct {
+	challenge string
+	nonce     string
+}
+
+// TestOidcProvider is a minimal OpenID Connect IdP for the SSO tests: it logs
+// in whoever SetUser says, without a login page, and checks the PKCE
+// challenge like the real IdPs do.
+type TestOidcProvider struct {
+	Url      string
+	key      *rsa.PrivateKey
+	username string
+	groups   []string
+	codes    map[string]oidcCode
+	mu       sync.Mutex
+}
+
+// NewTestOidcProvider starts the IdP, logging in "alice" with no groups.
+func NewTestOidcProvider(t *testing.T) *TestOidcProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &TestOidcProvider{
+		key:      key,
+		username: "alice",
+		codes:    make(map[string]oidcCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	p.Url = srv.URL
+	return p
+}
+
+// SetUser sets the user the next logins are for.
+func (p *TestOidcProvider) SetUser(username string, groups ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.username = username
+	p.groups = groups
+}
+
+// Authorize follows the authorization URL, as the browser would, and returns
+// the code and state of the redirect back to the client.
+func (p *TestOidcProvider) Authorize(t *testing.T, authUrl string) (string, string) {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want 302", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func (p *TestOidcProvider) discovery(w http.ResponseWriter, req *http.Request) {
+	writeJson(w, map[string]any{
+		"issuer":                                p.Url,
+		"authorization_endpoint":                p.Url + "/authorize",
+		"token_endpoint":                        p.Url + "/token",
+		"jwks_uri":                              p.Url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *TestOidcProvider) jwks(w http.ResponseWriter, req *http.Request) {
+	writeJson(w, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *TestOidcProvider) authorize(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != TestOidcClientId || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	p.mu.Lock()
+	p.codes[code] = oidcCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	p.mu.Unlock()
+
+	redirectUrl, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirectQuery := redirectUrl.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirectUrl.RawQuery = redirectQuery.Encode()
+	http.Redirect(w, req, redirectUrl.String(), http.StatusFound)
+}
+
+func (p *TestOidcProvider) token(w http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	code, exists := p.codes[req.FormValue("code")]
+	delete(p.codes, req.FormValue("code"))
+	username, groups := p.username, p.groups
+	p.mu.Unlock()
+
+	verifierHash := sha256.Sum256([]byte(req.FormValue("code_verifier")))
+	if !exists || base64.RawURLEncoding.EncodeToString(verifierHash[:]) != code.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := p.sign(map[string]any{
+		"iss":                p.Url,
+		"sub":                "sub-" + username,
+		"aud":                TestOidcClientId,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              code.nonce,
+		"preferred_username": username,
+		"groups":             groups,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns the claims as an RS256 JWT.
+func (p *TestOidcProvider) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJson(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -28,7 +28,7 @@ func NewTestRepo(t *testing.T) (*db.ForqRepo, *configs.AppConfigs, *sql.DB) {
 	dbPath := filepath.Join(t.TempDir(), "forq_test.db")
 	ApplyMigrations(t, dbPath)
 
-	appConfigs := configs.NewAppConfig(false, 24, 168)
+	appConfigs := configs.NewAppConfig(false, 24, 168, configs.Quotas{})
 	repo, err := db.NewSQLiteRepo(dbPath, appConfigs)
 	if err != nil {
 		t.Fatalf("failed to create repo: %v", err)
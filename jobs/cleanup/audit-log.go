@@ -0,0 +1,24 @@
+package cleanup
+
+import (
+	"context"
+	"time"
+
+	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/jobs"
+	"github.com/n0rdy/forq/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+func NewAuditLogCleanupJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64, retention time.Duration) *jobs.Runner {
+	return jobs.NewRunner(metricsService, "audit-log-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) error {
+		rowsAffected, err := repo.DeleteAuditEntriesOlderThan(time.Now().Add(-retention).UnixMilli(), ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to delete old audit log entries by AuditLogCleanupJob")
+		} else if rowsAffected > 0 {
+			log.Debug().Int64("count", rowsAffected).Msg("old audit log entries deleted")
+		}
+		return err
+	})
+}
@@ -11,12 +11,13 @@ import (
 )
 
 func NewExpiredDlqMessagesCleanupJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64) *jobs.Runner {
-	return jobs.NewRunner("expired-dlq-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) {
+	return jobs.NewRunner(metricsService, "expired-dlq-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) error {
 		rowsAffected, err := repo.DeleteExpiredMessagesFromDlq(ctx)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to delete expired DLQ messages by ExpiredDlqMessagesCleanupJob")
 		} else {
 			metricsService.IncMessagesCleanupTotalBy(rowsAffected, metrics.ExpiredCleanupReason)
 		}
+		return err
 	})
 }
@@ -2,6 +2,7 @@ package cleanup
 
 import (
 	"context"
+	"errors"
 
 	"github.com/n0rdy/forq/db"
 	"github.com/n0rdy/forq/jobs"
@@ -11,12 +12,21 @@ import (
 )
 
 func NewExpiredMessagesCleanupJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64) *jobs.Runner {
-	return jobs.NewRunner("expired-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) {
-		rowsAffected, err := repo.UpdateExpiredMessagesForRegularQueues(ctx)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to update expired messages by ExpiredMessagesCleanupJob")
+	return jobs.NewRunner(metricsService, "expired-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) error {
+		rowsAffected, moveErr := repo.UpdateExpiredMessagesForRegularQueues(ctx)
+		if moveErr != nil {
+			log.Error().Err(moveErr).Msg("failed to update expired messages by ExpiredMessagesCleanupJob")
 		} else {
 			metricsService.IncMessagesMovedToDlqTotalBy(rowsAffected, metrics.ExpiredMovedToDlqReason)
 		}
+
+		// queues with the "none" DLQ policy
+		rowsAffected, dropErr := repo.DeleteExpiredMessagesWithoutDlq(ctx)
+		if dropErr != nil {
+			log.Error().Err(dropErr).Msg("failed to delete expired messages without DLQ by ExpiredMessagesCleanupJob")
+		} else {
+			metricsService.IncMessagesDroppedTotalBy(rowsAffected, metrics.ExpiredDroppedReason)
+		}
+		return errors.Join(moveErr, dropErr)
 	})
 }
@@ -11,12 +11,23 @@ import (
 )
 
 func NewFailedDlqMessagesCleanupJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64) *jobs.Runner {
-	return jobs.NewRunner("failed-dlq-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) {
-		rowsAffected, err := repo.DeleteFailedMessagesFromDlq(ctx)
+	return jobs.NewRunner(metricsService, "failed-dlq-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) error {
+		// DLQs with the "custom" policy pass their failed messages down the chain
+		// first, so that only the rest is deleted below. On error, nothing is
+		// deleted this time, as the chained messages would be deleted too.
+		rowsAffected, err := repo.UpdateFailedMessagesForChainedDlqs(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to update failed chained DLQ messages by FailedDlqMessagesCleanupJob")
+			return err
+		}
+		metricsService.IncMessagesMovedToDlqTotalBy(rowsAffected, metrics.FailedMovedToDlqReason)
+
+		rowsAffected, err = repo.DeleteFailedMessagesFromDlq(ctx)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to delete failed DLQ messages by FailedDlqMessagesCleanupJob")
 		} else {
 			metricsService.IncMessagesCleanupTotalBy(rowsAffected, metrics.FailedCleanupReason)
 		}
+		return err
 	})
 }
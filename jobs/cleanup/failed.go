@@ -2,6 +2,7 @@ package cleanup
 
 import (
 	"context"
+	"errors"
 
 	"github.com/n0rdy/forq/db"
 	"github.com/n0rdy/forq/jobs"
@@ -11,12 +12,21 @@ import (
 )
 
 func NewFailedMessagesCleanupJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64) *jobs.Runner {
-	return jobs.NewRunner("failed-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) {
-		rowsAffected, err := repo.UpdateFailedMessagesForRegularQueues(ctx)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to update failed messages by FailedMessagesCleanupJob")
+	return jobs.NewRunner(metricsService, "failed-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) error {
+		rowsAffected, moveErr := repo.UpdateFailedMessagesForRegularQueues(ctx)
+		if moveErr != nil {
+			log.Error().Err(moveErr).Msg("failed to update failed messages by FailedMessagesCleanupJob")
 		} else {
 			metricsService.IncMessagesMovedToDlqTotalBy(rowsAffected, metrics.FailedMovedToDlqReason)
 		}
+
+		// queues with the "none" DLQ policy
+		rowsAffected, dropErr := repo.DeleteFailedMessagesWithoutDlq(ctx)
+		if dropErr != nil {
+			log.Error().Err(dropErr).Msg("failed to delete failed messages without DLQ by FailedMessagesCleanupJob")
+		} else {
+			metricsService.IncMessagesDroppedTotalBy(rowsAffected, metrics.FailedDroppedReason)
+		}
+		return errors.Join(moveErr, dropErr)
 	})
 }
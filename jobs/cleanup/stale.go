@@ -11,12 +11,13 @@ import (
 )
 
 func NewStaleMessagesCleanupJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64) *jobs.Runner {
-	return jobs.NewRunner("stale-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) {
+	return jobs.NewRunner(metricsService, "stale-messages-cleanup", intervalMs, intervalMs-1000, func(ctx context.Context) error {
 		rowsAffected, err := repo.UpdateStaleMessages(ctx)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to update stale messages by StaleMessagesCleanupJob")
 		} else {
 			metricsService.IncMessagesStaleRecoveredTotalBy(rowsAffected)
 		}
+		return err
 	})
 }
@@ -0,0 +1,23 @@
+package maintenance
+
+import (
+	"context"
+
+	"github.com/n0rdy/forq/jobs"
+	"github.com/n0rdy/forq/metrics"
+	"github.com/n0rdy/forq/services"
+
+	"github.com/rs/zerolog/log"
+)
+
+func NewBackupJob(metricsService metrics.Service, backupService *services.BackupService, intervalMs int64) *jobs.Runner {
+	return jobs.NewRunner(metricsService, "backup", intervalMs, intervalMs-1000, func(ctx context.Context) error {
+		path, err := backupService.ScheduledBackup(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to back up the database or to delete the old backups by BackupJob")
+		} else {
+			log.Info().Str("path", path).Msg("database backed up")
+		}
+		return err
+	})
+}
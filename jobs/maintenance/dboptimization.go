@@ -5,11 +5,12 @@ import (
 
 	"github.com/n0rdy/forq/db"
 	"github.com/n0rdy/forq/jobs"
+	"github.com/n0rdy/forq/metrics"
 )
 
-func NewDbOptimizationJob(repo *db.ForqRepo, intervalMs int64, maxDurationMs int64) *jobs.Runner {
-	return jobs.NewRunner("db-optimization", intervalMs, maxDurationMs, func(ctx context.Context) {
+func NewDbOptimizationJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64, maxDurationMs int64) *jobs.Runner {
+	return jobs.NewRunner(metricsService, "db-optimization", intervalMs, maxDurationMs, func(ctx context.Context) error {
 		// errors are already logged inside Optimize
-		_ = repo.Optimize(ctx)
+		return repo.Optimize(ctx)
 	})
 }
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/jobs"
+	"github.com/n0rdy/forq/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+func NewDbStatsMetricsJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64) *jobs.Runner {
+	// the repo's counters are totals since the start, while the metrics are incremented by the difference:
+	// the ticks never overlap, so no locking is needed
+	var prev db.DbStats
+
+	return jobs.NewRunner(metricsService, "db-stats-metrics", intervalMs, intervalMs-1000, func(ctx context.Context) error {
+		stats, err := repo.SelectDbStats(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to fetch DB stats by DbStatsMetricsJob")
+			return err
+		}
+
+		metricsService.SetDbFileBytes(stats.FileBytes)
+		metricsService.SetDbWalBytes(stats.WalBytes)
+		metricsService.SetDbFreelistPages(stats.FreelistPages)
+		metricsService.IncDbCacheHitsTotalBy(stats.CacheHits - prev.CacheHits)
+		metricsService.IncDbCacheMissesTotalBy(stats.CacheMisses - prev.CacheMisses)
+		metricsService.IncDbErrorsTotalBy(stats.BusyErrors-prev.BusyErrors, metrics.BusyDbError)
+		metricsService.IncDbErrorsTotalBy(stats.LockedErrors-prev.LockedErrors, metrics.LockedDbError)
+		metricsService.IncDbWriteWaitsTotalBy(stats.WriteWaits - prev.WriteWaits)
+		metricsService.IncDbWriteWaitSecondsTotalBy(stats.WriteWaitDuration - prev.WriteWaitDuration)
+
+		prev = *stats
+		return nil
+	})
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"context"
+	"time"
 
 	"github.com/n0rdy/forq/db"
 	"github.com/n0rdy/forq/jobs"
@@ -11,18 +12,32 @@ import (
 )
 
 func NewQueuesDepthMetricsJob(metricsService metrics.Service, repo *db.ForqRepo, intervalMs int64) *jobs.Runner {
-	return jobs.NewRunner("queues-depth-metrics", intervalMs, intervalMs-1000, func(ctx context.Context) {
-		queuesStats, err := repo.SelectAllQueuesWithStats(ctx)
+	return jobs.NewRunner(metricsService, "queues-depth-metrics", intervalMs, intervalMs-1000, func(ctx context.Context) error {
+		queuesUsage, err := repo.SelectQueuesUsage(ctx)
 		if err != nil {
-			log.Error().Err(err).Msg("failed to fetch queues stats by QueuesDepthMetricsJob")
-			return
+			log.Error().Err(err).Msg("failed to fetch queues usage by QueuesDepthMetricsJob")
+			return err
+		}
+
+		oldestReady, err := repo.SelectOldestReadyMessages(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to fetch oldest ready messages by QueuesDepthMetricsJob")
+			return err
 		}
 
 		// reset so drained/purged queues drop to absent instead of
 		// reporting their last non-zero depth forever
 		metricsService.ResetQueueDepths()
-		for _, qs := range queuesStats {
-			metricsService.SetQueueDepth(qs.Name, int64(qs.MessagesCount))
+		for _, qu := range queuesUsage {
+			metricsService.SetQueueDepth(qu.QueueName, qu.MessagesCount)
+			metricsService.SetQueueContentBytes(qu.QueueName, qu.ContentBytes)
+			// 0 unless overridden below: all the messages of the queue are either delayed or in processing
+			metricsService.SetQueueOldestReadyMessageAge(qu.QueueName, 0)
+		}
+		now := time.Now()
+		for _, or := range oldestReady {
+			metricsService.SetQueueOldestReadyMessageAge(or.QueueName, now.Sub(time.UnixMilli(or.ReadyAt)))
 		}
+		return nil
 	})
 }
@@ -2,56 +2,100 @@ package jobs
 
 import (
 	"context"
+	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/n0rdy/forq/metrics"
+	"github.com/n0rdy/forq/tracing"
+
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Runner executes a tick function on a fixed interval with a per-tick context
 // timeout and panic recovery. All background jobs share this lifecycle: the
 // single goroutine per job means ticks never overlap themselves, and a panic
 // in one tick is logged and swallowed so a background job can never take down
-// the whole process - the next tick runs as usual.
+// the whole process - the next tick runs as usual. A tick fails if it returns
+// an error or panics: the errors are expected to be logged by the tick, the
+// runner only records the failure in the metrics.
+//
+// The runner also remembers when its last tick finished, failed or not, so
+// that the readiness probe can tell a stuck job, e.g. one whose tick hangs.
 type Runner struct {
-	ticker  *time.Ticker
-	done    chan struct{}
-	stopped chan struct{}
+	name     string
+	interval time.Duration
+	lastTick atomic.Int64 // Unix timestamp in milliseconds, the start of the runner before the first tick
+	ticker   *time.Ticker
+	done     chan struct{}
+	stopped  chan struct{}
 }
 
-func NewRunner(name string, intervalMs int64, tickTimeoutMs int64, tick func(ctx context.Context)) *Runner {
-	ticker := time.NewTicker(time.Duration(intervalMs) * time.Millisecond)
-	done := make(chan struct{})
-	stopped := make(chan struct{})
+func NewRunner(metricsService metrics.Service, name string, intervalMs int64, tickTimeoutMs int64, tick func(ctx context.Context) error) *Runner {
+	interval := time.Duration(intervalMs) * time.Millisecond
+	r := &Runner{
+		name:     name,
+		interval: interval,
+		ticker:   time.NewTicker(interval),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	r.lastTick.Store(time.Now().UnixMilli())
 
 	go func() {
-		defer close(stopped)
+		defer close(r.stopped)
 		for {
 			select {
-			case <-ticker.C:
-				runTick(name, tickTimeoutMs, tick)
-			case <-done:
+			case <-r.ticker.C:
+				runTick(metricsService, name, tickTimeoutMs, tick)
+				r.lastTick.Store(time.Now().UnixMilli())
+			case <-r.done:
 				return
 			}
 		}
 	}()
 
-	return &Runner{
-		ticker:  ticker,
-		done:    done,
-		stopped: stopped,
-	}
+	return r
 }
 
-func runTick(name string, tickTimeoutMs int64, tick func(ctx context.Context)) {
+func (r *Runner) Name() string {
+	return r.name
+}
+
+func (r *Runner) Interval() time.Duration {
+	return r.interval
+}
+
+// LastTickAt returns when the last tick finished, or when the runner started,
+// if no tick has finished yet.
+func (r *Runner) LastTickAt() time.Time {
+	return time.UnixMilli(r.lastTick.Load())
+}
+
+// runTick traces each tick as the root span of its own trace.
+func runTick(metricsService metrics.Service, name string, tickTimeoutMs int64, tick func(ctx context.Context) error) {
+	ctx, span := tracing.Start(context.Background(), "job "+name, attribute.String("forq.job", name))
+	start := time.Now()
+	var err error
 	defer func() {
 		if r := recover(); r != nil {
 			log.Error().Interface("panic", r).Str("job", name).Msg("panic in background job tick, will run again on the next tick")
+			err = fmt.Errorf("panic: %v", r)
+		}
+		tracing.End(span, err)
+
+		metricsService.ObserveJobTickDuration(name, time.Since(start))
+		if err != nil {
+			metricsService.IncJobTickFailuresTotal(name)
+		} else {
+			metricsService.SetJobLastSuccessTime(name, time.Now())
 		}
 	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(tickTimeoutMs)*time.Millisecond)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(tickTimeoutMs)*time.Millisecond)
 	defer cancel()
-	tick(ctx)
+	err = tick(ctx)
 }
 
 // Close stops the runner and waits for any in-flight tick to finish, so the
@@ -2,9 +2,14 @@ package jobs
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/metrics"
 )
 
 // TestRunner_RecoversFromPanic pins the property the runner exists for: a
@@ -13,11 +18,12 @@ import (
 func TestRunner_RecoversFromPanic(t *testing.T) {
 	var ticks atomic.Int64
 
-	runner := NewRunner("panicky", 20, 10, func(ctx context.Context) {
+	runner := NewRunner(metrics.NewMetricsService(configs.MetricsSettings{}), "panicky", 20, 10, func(ctx context.Context) error {
 		n := ticks.Add(1)
 		if n == 1 {
 			panic("tick 1 explodes")
 		}
+		return nil
 	})
 	defer runner.Close()
 
@@ -34,11 +40,12 @@ func TestRunner_RecoversFromPanic(t *testing.T) {
 func TestRunner_TickContextHasDeadline(t *testing.T) {
 	gotDeadline := make(chan bool, 1)
 
-	runner := NewRunner("deadline-check", 20, 500, func(ctx context.Context) {
+	runner := NewRunner(metrics.NewMetricsService(configs.MetricsSettings{}), "deadline-check", 20, 500, func(ctx context.Context) error {
 		select {
 		case gotDeadline <- func() bool { _, ok := ctx.Deadline(); return ok }():
 		default:
 		}
+		return nil
 	})
 	defer runner.Close()
 
@@ -54,8 +61,9 @@ func TestRunner_TickContextHasDeadline(t *testing.T) {
 
 func TestRunner_CloseStopsTicking(t *testing.T) {
 	var ticks atomic.Int64
-	runner := NewRunner("stoppable", 10, 5, func(ctx context.Context) {
+	runner := NewRunner(metrics.NewMetricsService(configs.MetricsSettings{}), "stoppable", 10, 5, func(ctx context.Context) error {
 		ticks.Add(1)
+		return nil
 	})
 
 	time.Sleep(50 * time.Millisecond)
@@ -68,3 +76,82 @@ func TestRunner_CloseStopsTicking(t *testing.T) {
 		t.Fatalf("runner kept ticking after Close: %d -> %d", after, ticks.Load())
 	}
 }
+
+// recordingMetricsService records the job metrics, the rest are no-ops.
+type recordingMetricsService struct {
+	metrics.Service
+	mu        sync.Mutex
+	durations int
+	failures  int
+	successes int
+}
+
+func (rms *recordingMetricsService) ObserveJobTickDuration(job string, duration time.Duration) {
+	rms.mu.Lock()
+	defer rms.mu.Unlock()
+	rms.durations++
+}
+
+func (rms *recordingMetricsService) IncJobTickFailuresTotal(job string) {
+	rms.mu.Lock()
+	defer rms.mu.Unlock()
+	rms.failures++
+}
+
+func (rms *recordingMetricsService) SetJobLastSuccessTime(job string, at time.Time) {
+	rms.mu.Lock()
+	defer rms.mu.Unlock()
+	rms.successes++
+}
+
+func TestRunner_RecordsTickMetrics(t *testing.T) {
+	recorder := &recordingMetricsService{Service: metrics.NewMetricsService(configs.MetricsSettings{})}
+	var ticks atomic.Int64
+
+	runner := NewRunner(recorder, "flaky", 10, 5, func(ctx context.Context) error {
+		switch ticks.Add(1) {
+		case 1:
+			return errors.New("tick 1 fails")
+		case 2:
+			panic("tick 2 explodes")
+		}
+		return nil
+	})
+	for ticks.Load() < 3 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	runner.Close()
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.failures != 2 || recorder.successes < 1 || recorder.durations != recorder.failures+recorder.successes {
+		t.Fatalf("failures = %d, successes = %d, durations = %d, want 2 failures and a duration per tick",
+			recorder.failures, recorder.successes, recorder.durations)
+	}
+}
+
+func TestRunner_LastTickAt(t *testing.T) {
+	var ticks atomic.Int64
+	runner := NewRunner(metrics.NewMetricsService(configs.MetricsSettings{}), "tracked", 20, 10, func(ctx context.Context) error {
+		ticks.Add(1)
+		return errors.New("failed ticks count as finished, too")
+	})
+	defer runner.Close()
+
+	started := runner.LastTickAt()
+	if time.Since(started) > time.Second || runner.Interval() != 20*time.Millisecond || runner.Name() != "tracked" {
+		t.Fatalf("runner = %s %v %v", runner.Name(), runner.Interval(), started)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for ticks.Load() < 2 {
+		select {
+		case <-deadline:
+			t.Fatal("the runner doesn't tick")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !runner.LastTickAt().After(started) {
+		t.Errorf("last tick %v isn't after the start %v", runner.LastTickAt(), started)
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -18,12 +18,16 @@ import (
 	"github.com/n0rdy/forq/common"
 	"github.com/n0rdy/forq/configs"
 	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/jobs"
 	"github.com/n0rdy/forq/jobs/cleanup"
 	"github.com/n0rdy/forq/jobs/maintenance"
 	metricsJobs "github.com/n0rdy/forq/jobs/metrics"
 	"github.com/n0rdy/forq/metrics"
 	"github.com/n0rdy/forq/services"
+	"github.com/n0rdy/forq/systemd"
+	"github.com/n0rdy/forq/tracing"
 	"github.com/n0rdy/forq/ui"
+	"github.com/n0rdy/forq/utils"
 
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
 
@@ -32,24 +36,112 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-const (
-	minAuthSecretLength = 32
-)
-
 func main() {
-	env := getEnv()
-	authSecret := getAuthSecret()
-	metricsEnabled, metricsAuthSecret := getMetricsConfigs()
-	queueTtlHours, dlqTtlHours := getTtlConfigs()
-	apiAddr, uiAddr := getServerAddrs()
-	trustProxyHeaders := getTrustProxyHeaders()
-
-	dbPath := getDbPath()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// created first, as it consumes the NOTIFY_SOCKET env var
+	notifier := systemd.NewNotifier()
+	defer notifier.Close()
+
+	configFile := os.Getenv("FORQ_CONFIG_FILE")
+	settings, err := configs.LoadSettings(configFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid configuration")
+	}
+	socketMode, _ := settings.SocketFileMode() // validated already
+	env := settings.Env
+	metricsEnabled := settings.Metrics.Enabled
+	// the /metrics endpoint is for Prometheus only, statsd gets the metrics pushed
+	prometheusEnabled := metricsEnabled && settings.Metrics.Backend == common.PrometheusMetricsBackend
+	apiAddr, uiAddr := settings.Server.ApiAddr, settings.Server.UiAddr
+	trustProxyHeaders := settings.Server.TrustProxyHeaders
+	if trustProxyHeaders {
+		log.Warn().Msg("trust_proxy_headers=true: client IP will be read from X-Forwarded-For. " +
+			"Forq MUST be behind a reverse proxy that strips or replaces incoming X-Forwarded-For from clients, " +
+			"otherwise IPs are spoofable and throttling can be bypassed.")
+	}
+
+	dbPath := resolveDbPath(settings.DbPath)
 	log.Info().Msgf("using database file at: %s", dbPath)
 
+	appConfigs := settings.AppConfigs()
+	monitoringService := services.NewMonitoringService(dbPath, settings.Health)
+
+	var tlsService *services.TlsService
+	if settings.Tls.CertFile != "" {
+		tlsService, err = services.NewTlsService(settings.Tls.CertFile, settings.Tls.KeyFile, settings.Tls.ClientCaFile, settings.Tls.ClientCertRequired)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load TLS certificate")
+		}
+		defer tlsService.Close()
+	}
+
+	// requestsCtx is the BaseContext of both servers, so request contexts (incl.
+	// in-flight long polls) are cancelled right away on shutdown, once drained,
+	// instead of holding Shutdown() for up to 30s.
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	serverFailedCh := make(chan struct{})
+	var serverFailedOnce sync.Once
+
+	var apiProtocols http.Protocols
+	apiProtocols.SetUnencryptedHTTP2(true)
+	apiProtocols.SetHTTP1(true)
+	apiProtocols.SetHTTP2(true)
+
+	// only the probes are served until the startup is done, see StartupHandler
+	apiHandler := api.NewStartupHandler(monitoringService, env)
+	apiServer := &http.Server{
+		Addr:              apiAddr,
+		Handler:           api.TimeoutHandler(apiHandler, appConfigs.ServerConfig.Timeouts.Handle),
+		WriteTimeout:      appConfigs.ServerConfig.Timeouts.Write,
+		ReadTimeout:       appConfigs.ServerConfig.Timeouts.Read,
+		ReadHeaderTimeout: appConfigs.ServerConfig.Timeouts.ReadHeader,
+		IdleTimeout:       appConfigs.ServerConfig.Timeouts.Idle,
+		Protocols:         &apiProtocols,
+		BaseContext:       func(net.Listener) context.Context { return requestsCtx },
+	}
+	if tlsService != nil {
+		apiServer.TLSConfig = tlsService.ServerConfig(true)
+	}
+
+	// the listeners are created upfront, so that a taken address or socket fails the startup right away,
+	// and the API one before the migrations, so that the probes are answered while they run
+	activatedListeners, err := systemd.ActivatedListeners()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to take over the systemd activated sockets")
+	}
+	apiListener, err := listen(apiAddr, socketMode, activatedListeners)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to listen on %s", apiAddr)
+	}
+	uiListener, err := listen(uiAddr, socketMode, activatedListeners)
+	if err != nil {
+		apiListener.Close()
+		log.Fatal().Err(err).Msgf("failed to listen on %s", uiAddr)
+	}
+
+	// Start API server
+	go func() {
+		log.Info().Bool("tls", tlsService != nil).Msgf("Starting API server on %s", apiAddr)
+		err := serve(apiServer, apiListener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warn().Err(err).Msg("API server failed")
+			serverFailedOnce.Do(func() { close(serverFailedCh) })
+		}
+	}()
+
 	runMigrations(dbPath)
 
-	appConfigs := configs.NewAppConfig(metricsEnabled, queueTtlHours, dlqTtlHours)
+	// closed last, so that the spans of the shutdown get exported too
+	tracingProvider, err := tracing.NewProvider(settings.Tracing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to set up tracing")
+	}
+	defer tracingProvider.Close()
 
 	repo, err := db.NewSQLiteRepo(dbPath, appConfigs)
 	if err != nil {
@@ -57,14 +149,45 @@ func main() {
 	}
 	defer repo.Close()
 
-	monitoringService := services.NewMonitoringService(repo)
-	metricsService := metrics.NewMetricsService(metricsEnabled)
-	queuesService := services.NewQueuesService(repo)
-	messagesService := services.NewMessagesService(metricsService, repo, appConfigs)
-	sessionsService := services.NewSessionsService()
+	metricsService := metrics.NewMetricsService(settings.Metrics)
+	defer metricsService.Close()
+	limitingService, err := services.NewLimitingService(repo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load queues delivery limits")
+	}
+	quotasService, err := services.NewQuotasService(metricsService, repo, appConfigs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load queues quotas")
+	}
+	defer quotasService.Close()
+	queuesService := services.NewQueuesService(repo, limitingService, quotasService)
+	messagesService := services.NewMessagesService(metricsService, repo, limitingService, quotasService, appConfigs)
+	drainService := services.NewDrainService(monitoringService, messagesService, settings.Health)
+	backupService := services.NewBackupService(repo, dbPath, settings.Backup)
+	sessionsService := services.NewSessionsService(repo, settings.Sessions.IdleTimeout, settings.Sessions.AbsoluteTimeout)
 	defer sessionsService.Close()
-	throttlingService := services.NewThrottlingService()
+	throttlingService := services.NewThrottlingService(repo, settings.Throttling)
 	defer throttlingService.Close()
+	rateLimitingService := services.NewRateLimitingService(metricsService, settings.RateLimits)
+	defer rateLimitingService.Close()
+	apiKeysService, err := services.NewApiKeysService(repo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load API keys")
+	}
+	usersService := services.NewUsersService(repo)
+	oidcService := services.NewOidcService(settings.Oidc)
+	auditService := services.NewAuditService(repo)
+	authSecretsService, err := services.NewAuthSecretsService(metricsService, settings.Auth.Secret, settings.Auth.SecretsFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load auth secrets")
+	}
+	var metricsAuthSecretsService *services.AuthSecretsService
+	if prometheusEnabled {
+		metricsAuthSecretsService, err = services.NewAuthSecretsService(metricsService, settings.Metrics.AuthSecret, "")
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load metrics auth secret")
+		}
+	}
 
 	expiredMessagesCleanupJob := cleanup.NewExpiredMessagesCleanupJob(metricsService, repo, appConfigs.JobsIntervals.ExpiredMessagesCleanupMs)
 	defer expiredMessagesCleanupJob.Close()
@@ -76,45 +199,68 @@ func main() {
 	defer failedDlqMessagesCleanupJob.Close()
 	staleMessagesCleanupJob := cleanup.NewStaleMessagesCleanupJob(metricsService, repo, appConfigs.JobsIntervals.StaleMessagesCleanupMs)
 	defer staleMessagesCleanupJob.Close()
-	dbOptimizationJob := maintenance.NewDbOptimizationJob(repo, appConfigs.JobsIntervals.DbOptimizationMs, appConfigs.JobsIntervals.DbOptimizationMaxDurationMs)
+	auditLogCleanupJob := cleanup.NewAuditLogCleanupJob(metricsService, repo, appConfigs.JobsIntervals.AuditLogCleanupMs, settings.Audit.Retention)
+	defer auditLogCleanupJob.Close()
+	dbOptimizationJob := maintenance.NewDbOptimizationJob(metricsService, repo, appConfigs.JobsIntervals.DbOptimizationMs, appConfigs.JobsIntervals.DbOptimizationMaxDurationMs)
 	defer dbOptimizationJob.Close()
+	// watched by the readiness probe
+	runners := []*jobs.Runner{
+		expiredMessagesCleanupJob,
+		expiredDlqMessagesCleanupJob,
+		failedMessagesCleanupJob,
+		failedDlqMessagesCleanupJob,
+		staleMessagesCleanupJob,
+		auditLogCleanupJob,
+		dbOptimizationJob,
+	}
+
+	if settings.Backup.Dir != "" {
+		backupJob := maintenance.NewBackupJob(metricsService, backupService, settings.Backup.Interval.Milliseconds())
+		defer backupJob.Close()
+		runners = append(runners, backupJob)
+	}
 
 	if metricsEnabled {
 		queuesDepthMetricsJob := metricsJobs.NewQueuesDepthMetricsJob(metricsService, repo, appConfigs.JobsIntervals.QueuesDepthMetricsMs)
 		defer queuesDepthMetricsJob.Close()
+		dbStatsMetricsJob := metricsJobs.NewDbStatsMetricsJob(metricsService, repo, appConfigs.JobsIntervals.DbStatsMetricsMs)
+		defer dbStatsMetricsJob.Close()
+		runners = append(runners, queuesDepthMetricsJob, dbStatsMetricsJob)
 	}
 
-	// shutdownCtx is cancelled on SIGINT/SIGTERM. It is also the BaseContext of
-	// both servers, so request contexts (incl. in-flight long polls) are cancelled
-	// immediately on shutdown instead of holding Shutdown() for up to 30s.
+	// shutdownCtx is cancelled on SIGINT/SIGTERM.
 	shutdownCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stopSignals()
 
-	serverFailedCh := make(chan struct{})
-	var serverFailedOnce sync.Once
-
-	apiRouter := api.NewRouter(monitoringService, messagesService, throttlingService, authSecret, metricsEnabled, metricsAuthSecret, env, trustProxyHeaders)
-
-	var apiProtocols http.Protocols
-	apiProtocols.SetUnencryptedHTTP2(true)
-	apiProtocols.SetHTTP1(true)
+	// SIGHUP reloads the auth secrets file, so that secrets can be rotated without a restart,
+	// the TLS files, which are also reloaded on change anyway, and the config file
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	defer signal.Stop(reloadCh)
+	go func() {
+		for range reloadCh {
+			log.Info().Msg("SIGHUP received, reloading auth secrets and configuration")
+			reloadSettings(settings, configFile, appConfigs, quotasService)
+			if err := authSecretsService.Reload(); err != nil {
+				log.Error().Err(err).Msg("failed to reload auth secrets, keeping the previous ones")
+			}
+			if tlsService != nil {
+				if err := tlsService.Reload(); err != nil {
+					log.Error().Err(err).Msg("failed to reload TLS files, keeping the previous ones")
+				}
+			}
+		}
+	}()
 
-	apiServer := &http.Server{
-		Addr:              apiAddr,
-		Handler:           http.TimeoutHandler(apiRouter.NewRouter(), appConfigs.ServerConfig.Timeouts.Handle, "timeout"),
-		WriteTimeout:      appConfigs.ServerConfig.Timeouts.Write,
-		ReadTimeout:       appConfigs.ServerConfig.Timeouts.Read,
-		ReadHeaderTimeout: appConfigs.ServerConfig.Timeouts.ReadHeader,
-		IdleTimeout:       appConfigs.ServerConfig.Timeouts.Idle,
-		Protocols:         &apiProtocols,
-		BaseContext:       func(net.Listener) context.Context { return shutdownCtx },
-	}
+	apiRouter := api.NewRouter(metricsService, monitoringService, drainService, backupService, messagesService, queuesService, throttlingService, rateLimitingService, apiKeysService, sessionsService, auditService, authSecretsService, settings.Auth.Mode, prometheusEnabled, metricsAuthSecretsService, env, trustProxyHeaders)
+	apiHandler.Started(apiRouter.NewRouter())
 
-	uiRouter := ui.NewRouter(messagesService, sessionsService, queuesService, throttlingService, authSecret, env, trustProxyHeaders)
+	uiRouter := ui.NewRouter(messagesService, sessionsService, queuesService, throttlingService, apiKeysService, usersService, oidcService, auditService, authSecretsService, env, trustProxyHeaders)
 
 	var uiProtocols http.Protocols
 	uiProtocols.SetUnencryptedHTTP2(true)
 	uiProtocols.SetHTTP1(true)
+	uiProtocols.SetHTTP2(true)
 
 	uiServer := &http.Server{
 		Addr:              uiAddr,
@@ -124,29 +270,27 @@ func main() {
 		ReadHeaderTimeout: appConfigs.ServerConfig.Timeouts.ReadHeader,
 		IdleTimeout:       appConfigs.ServerConfig.Timeouts.Idle,
 		Protocols:         &uiProtocols,
-		BaseContext:       func(net.Listener) context.Context { return shutdownCtx },
+		BaseContext:       func(net.Listener) context.Context { return requestsCtx },
+	}
+	if tlsService != nil {
+		uiServer.TLSConfig = tlsService.ServerConfig(false)
 	}
-
-	// Start API server
-	go func() {
-		log.Info().Msgf("Starting API server on %s", apiAddr)
-		err := apiServer.ListenAndServe()
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Warn().Err(err).Msg("API server failed")
-			serverFailedOnce.Do(func() { close(serverFailedCh) })
-		}
-	}()
 
 	// Start UI server
 	go func() {
-		log.Info().Msgf("Starting UI server on %s", uiAddr)
-		err := uiServer.ListenAndServe()
+		log.Info().Bool("tls", tlsService != nil).Msgf("Starting UI server on %s", uiAddr)
+		err := serve(uiServer, uiListener)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Warn().Err(err).Msg("UI server failed")
 			serverFailedOnce.Do(func() { close(serverFailedCh) })
 		}
 	}()
 
+	// the migrations are applied, the DB is open, the jobs run, and the listeners accept connections
+	monitoringService.Started(repo, runners)
+	notifier.Ready()
+	notifier.StartWatchdog(monitoringService.IsHealthy)
+
 	// Block until a shutdown signal arrives or one of the servers dies.
 	select {
 	case <-shutdownCtx.Done():
@@ -155,12 +299,22 @@ func main() {
 		log.Warn().Msg("server failure, shutting down")
 	}
 
-	// cancel shutdownCtx on BOTH trigger paths: on the server-failure path it
-	// is not cancelled yet, and in-flight request contexts (incl. long polls
-	// on the surviving server) hang off it via BaseContext - without this they
-	// would hold Shutdown until its deadline instead of returning immediately
+	notifier.Stopping()
+	// a second signal kills Forq right away, e.g. if the drain is too long
 	stopSignals()
 
+	// the readiness probe fails and no messages are handed out from now on,
+	// so that the load balancer stops sending requests, while the ones that
+	// still come, e.g. the ack/nack, are served. The drain might be started
+	// already by an operator, it's waited for anyway
+	drainService.Drain()
+	<-drainService.Done()
+
+	// cancel requestsCtx on BOTH trigger paths: in-flight request contexts
+	// (incl. long polls) hang off it via BaseContext - without this they would
+	// hold Shutdown until its deadline instead of returning immediately
+	cancelRequests()
+
 	// In-flight requests see their contexts cancelled via BaseContext, so this
 	// deadline only needs to cover response writing, not the 30s long poll.
 	gracefulCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -184,127 +338,61 @@ func main() {
 	// first, repo last).
 }
 
-func getEnv() string {
-	env := os.Getenv("FORQ_ENV")
-	if env == "" {
-		env = common.ProEnv // Default to production environment
-	}
-
-	if !common.SupportedEnvs[env] {
-		log.Fatal().Msgf("unsupported environment: %s", env)
-	}
-	return env
-}
-
-func getAuthSecret() string {
-	authSecret := os.Getenv("FORQ_AUTH_SECRET")
-	if authSecret == "" {
-		log.Fatal().Msg("auth secret is not provided: set FORQ_AUTH_SECRET environment variable")
+// listen takes over the socket activated by systemd for the "systemd:<name>" addresses.
+func listen(addr string, socketMode os.FileMode, activatedListeners map[string]net.Listener) (net.Listener, error) {
+	name, isActivated := strings.CutPrefix(addr, systemd.AddrPrefix)
+	if !isActivated {
+		return utils.Listen(addr, socketMode)
 	}
-	if len(authSecret) < minAuthSecretLength {
-		log.Fatal().Msgf("auth secret is too short: must be at least %d characters", minAuthSecretLength)
+	listener, ok := activatedListeners[name]
+	if !ok {
+		return nil, fmt.Errorf("no socket named %q is passed by systemd, check FileDescriptorName in the .socket unit", name)
 	}
-	return authSecret
+	return listener, nil
 }
 
-func getTrustProxyHeaders() bool {
-	v := os.Getenv("FORQ_TRUST_PROXY_HEADERS")
-	if v == "" {
-		return false
-	}
-	trust, err := strconv.ParseBool(v)
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed to parse FORQ_TRUST_PROXY_HEADERS env var")
-	}
-	if trust {
-		log.Warn().Msg("FORQ_TRUST_PROXY_HEADERS=true: client IP will be read from X-Forwarded-For. " +
-			"Forq MUST be behind a reverse proxy that strips or replaces incoming X-Forwarded-For from clients, " +
-			"otherwise IPs are spoofable and throttling can be bypassed.")
+// serve serves HTTPS if the server has the TLS config, the cert and key come
+// from it then.
+func serve(server *http.Server, listener net.Listener) error {
+	if server.TLSConfig != nil {
+		return server.ServeTLS(listener, "", "")
 	}
-	return trust
+	return server.Serve(listener)
 }
 
-func getMetricsConfigs() (bool, string) {
-	metricsEnabledEnv := os.Getenv("FORQ_METRICS_ENABLED")
-	if metricsEnabledEnv == "" {
-		return false, "" // Metrics disabled by default
-	}
-
-	metricsEnabled, err := strconv.ParseBool(metricsEnabledEnv)
+// reloadSettings applies the reloadable settings, and logs the changes that need a restart.
+// On error, the current settings stay in effect.
+func reloadSettings(settings *configs.Settings, configFile string, appConfigs *configs.AppConfigs, quotasService *services.QuotasService) {
+	newSettings, err := configs.LoadSettings(configFile)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to parse FORQ_METRICS_ENABLED env var")
-	}
-
-	if !metricsEnabled {
-		return false, ""
-	}
-
-	metricsAuthSecret := os.Getenv("FORQ_METRICS_AUTH_SECRET")
-	if metricsAuthSecret == "" {
-		log.Fatal().Msg("FORQ_METRICS_AUTH_SECRET env var is required when metrics are enabled")
-	}
-	if len(metricsAuthSecret) < minAuthSecretLength {
-		log.Fatal().Msgf("metrics auth secret is too short: must be at least %d characters", minAuthSecretLength)
-	}
-	return true, metricsAuthSecret
-}
-
-func getTtlConfigs() (int, int) {
-	queueTtlEnv := os.Getenv("FORQ_QUEUE_TTL_HOURS")
-	dlqTtlEnv := os.Getenv("FORQ_DLQ_TTL_HOURS")
-
-	// Default values
-	queueTtlHours := 24   // 1 day
-	dlqTtlHours := 7 * 24 // 7 days
-
-	if queueTtlEnv != "" {
-		parsed, err := strconv.Atoi(queueTtlEnv)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to parse FORQ_QUEUE_TTL_HOURS env var")
-		}
-		if parsed < 1 {
-			log.Fatal().Msg("FORQ_QUEUE_TTL_HOURS must be at least 1 hour")
-		}
-		queueTtlHours = parsed
+		log.Error().Err(err).Msg("failed to reload configuration, keeping the current one")
+		return
 	}
 
-	if dlqTtlEnv != "" {
-		parsed, err := strconv.Atoi(dlqTtlEnv)
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to parse FORQ_DLQ_TTL_HOURS env var")
+	changes := settings.Reload(newSettings)
+	for _, change := range changes {
+		if change.Reloadable {
+			log.Info().Str("key", change.Key).Str("old", change.Old).Str("new", change.New).Msg("configuration changed")
+		} else {
+			log.Warn().Str("key", change.Key).Str("old", change.Old).Str("new", change.New).Msg("configuration change requires a restart, ignored")
 		}
-		if parsed < 1 {
-			log.Fatal().Msg("FORQ_DLQ_TTL_HOURS must be at least 1 hour")
-		}
-		dlqTtlHours = parsed
-	}
-
-	return queueTtlHours, dlqTtlHours
-}
-
-func getServerAddrs() (string, string) {
-	apiAddr := os.Getenv("FORQ_API_ADDR")
-	if apiAddr == "" {
-		apiAddr = "localhost:8080" // safe default localhost only
 	}
-
-	uiAddr := os.Getenv("FORQ_UI_ADDR")
-	if uiAddr == "" {
-		uiAddr = "localhost:8081" // safe default localhost only
+	if len(changes) == 0 {
+		log.Info().Msg("configuration unchanged")
 	}
 
-	return apiAddr, uiAddr
+	appConfigs.SetLimits(settings.Limits())
+	quotasService.SetGlobalLimits(services.QuotaLimits{
+		MaxMessages: settings.Quotas.MaxMessages,
+		MaxBytes:    settings.Quotas.MaxBytes,
+	})
 }
 
-func getDbPath() string {
-	dbPath := os.Getenv("FORQ_DB_PATH")
-	if dbPath == "" {
-		log.Fatal().Msg("database path is not provided: set FORQ_DB_PATH environment variable")
-	}
-
+// resolveDbPath returns the absolute DB path, and creates its directory.
+func resolveDbPath(dbPath string) string {
 	absPath, err := filepath.Abs(dbPath)
 	if err != nil {
-		log.Fatal().Err(err).Msgf("failed to resolve absolute path for FORQ_DB_PATH=%s", dbPath)
+		log.Fatal().Err(err).Msgf("failed to resolve absolute path for db_path=%s", dbPath)
 	}
 
 	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
@@ -1,5 +1,7 @@
 package metrics
 
+import "time"
+
 type NoopMetricsService struct {
 }
 
@@ -7,6 +9,10 @@ func newNoopMetricsService() *NoopMetricsService {
 	return &NoopMetricsService{}
 }
 
+func (nms *NoopMetricsService) Close() error {
+	return nil
+}
+
 func (nms *NoopMetricsService) IncMessagesProducedTotalBy(count int64, queueName string) {
 	// no-op
 }
@@ -31,10 +37,30 @@ func (nms *NoopMetricsService) SetQueueDepth(queueName string, depth int64) {
 	// no-op
 }
 
+func (nms *NoopMetricsService) SetQueueContentBytes(queueName string, bytes int64) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) SetQueueOldestReadyMessageAge(queueName string, age time.Duration) {
+	// no-op
+}
+
 func (nms *NoopMetricsService) ResetQueueDepths() {
 	// no-op
 }
 
+func (nms *NoopMetricsService) ObserveMessageWaitDuration(queueName string, wait time.Duration) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) ObserveMessageProcessingDuration(queueName string, processing time.Duration) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) ObserveMessageAttemptsAtAck(queueName string, attempts int) {
+	// no-op
+}
+
 func (nms *NoopMetricsService) IncMessagesMovedToDlqTotalBy(count int64, reason string) {
 	// no-op
 }
@@ -46,3 +72,67 @@ func (nms *NoopMetricsService) IncMessagesStaleRecoveredTotalBy(count int64) {
 func (nms *NoopMetricsService) IncMessagesCleanupTotalBy(count int64, reason string) {
 	// no-op
 }
+
+func (nms *NoopMetricsService) IncMessagesDroppedTotalBy(count int64, reason string) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncQuotaRejectionsTotal(queueName string, quota string) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncDeprecatedAuthSecretUsesTotal(secretId string) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncRateLimitedRequestsTotal(route string, limit string) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) ObserveHttpRequestDuration(method string, route string, code int, duration time.Duration) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) SetDbFileBytes(bytes int64) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) SetDbWalBytes(bytes int64) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) SetDbFreelistPages(pages int64) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncDbCacheHitsTotalBy(count int64) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncDbCacheMissesTotalBy(count int64) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncDbErrorsTotalBy(count int64, errorType string) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncDbWriteWaitsTotalBy(count int64) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncDbWriteWaitSecondsTotalBy(wait time.Duration) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) ObserveJobTickDuration(job string, duration time.Duration) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) IncJobTickFailuresTotal(job string) {
+	// no-op
+}
+
+func (nms *NoopMetricsService) SetJobLastSuccessTime(job string, at time.Time) {
+	// no-op
+}
@@ -1,9 +1,8 @@
 package metrics
 
 import (
-	"strings"
-
-	"github.com/n0rdy/forq/common"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -15,9 +14,30 @@ type PrometheusMetricsService struct {
 	messagesNackedTotal         *prometheus.CounterVec
 	messagesRequeuedTotal       *prometheus.CounterVec
 	queueDepth                  *prometheus.GaugeVec
+	queueContentBytes           *prometheus.GaugeVec
+	queueOldestReadyMessageAge  *prometheus.GaugeVec
+	messageWaitSeconds          *prometheus.HistogramVec
+	messageProcessingSeconds    *prometheus.HistogramVec
+	messageAttemptsAtAck        *prometheus.HistogramVec
 	messagesMovedToDlqTotal     *prometheus.CounterVec
 	messagesStaleRecoveredTotal prometheus.Counter
 	messagesCleanupTotal        *prometheus.CounterVec
+	messagesDroppedTotal        *prometheus.CounterVec
+	quotaRejectionsTotal        *prometheus.CounterVec
+	deprecatedAuthSecretUses    *prometheus.CounterVec
+	rateLimitedRequestsTotal    *prometheus.CounterVec
+	httpRequestDurationSeconds  *prometheus.HistogramVec
+	dbFileBytes                 prometheus.Gauge
+	dbWalBytes                  prometheus.Gauge
+	dbFreelistPages             prometheus.Gauge
+	dbCacheHitsTotal            prometheus.Counter
+	dbCacheMissesTotal          prometheus.Counter
+	dbErrorsTotal               *prometheus.CounterVec
+	dbWriteWaitsTotal           prometheus.Counter
+	dbWriteWaitSecondsTotal     prometheus.Counter
+	jobTickDurationSeconds      *prometheus.HistogramVec
+	jobTickFailuresTotal        *prometheus.CounterVec
+	jobLastSuccessTimestamp     *prometheus.GaugeVec
 }
 
 func newPrometheusMetricsService() *PrometheusMetricsService {
@@ -72,6 +92,54 @@ func newPrometheusMetricsService() *PrometheusMetricsService {
 			[]string{"queue_name", "queue_type"},
 		),
 
+		queueContentBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "forq_queue_content_bytes",
+				Help: "Current total size of the messages content in the queue, in bytes. This is what the bytes quotas are checked against",
+			},
+			[]string{"queue_name", "queue_type"},
+		),
+
+		// the age is counted from process_after, so the delayed messages and the nack-ed ones in backoff
+		// don't age before they are ready to be consumed
+		queueOldestReadyMessageAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "forq_queue_oldest_ready_message_age_seconds",
+				Help: "Current age of the oldest message of the queue that is ready to be consumed, i.e. how long it has been waiting for a consumer",
+			},
+			[]string{"queue_name", "queue_type"},
+		),
+
+		// same as for the oldest ready message age, the wait is counted from process_after:
+		// from the produce, unless the message was delayed or is retried after a nack
+		messageWaitSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "forq_message_wait_seconds",
+				Help:    "Time the consumed messages waited in the queue, from being produced (or ready after a delay or a nack backoff) to being consumed",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600},
+			},
+			[]string{"queue_name", "queue_type"},
+		),
+
+		// the buckets top out around the default max processing time of 5 minutes, after which the message is stale
+		messageProcessingSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "forq_message_processing_seconds",
+				Help:    "Time the consumers took to process the acked messages, from being consumed to being acked",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+			},
+			[]string{"queue_name", "queue_type"},
+		),
+
+		messageAttemptsAtAck: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "forq_message_attempts_at_ack",
+				Help:    "Number of delivery attempts it took to process the acked messages",
+				Buckets: prometheus.LinearBuckets(1, 1, 10),
+			},
+			[]string{"queue_name", "queue_type"},
+		),
+
 		// no queue name label here, as the moving op is performed by the cronjob,
 		// so it will have a performance impact on SQL query to have to group by queue name instead of doing fire-and-forget UPDATE.
 		// queue type is not relevant here, as this metric shows when the message is moved from the Regular queue to DQL.
@@ -102,6 +170,140 @@ func newPrometheusMetricsService() *PrometheusMetricsService {
 			},
 			[]string{"reason"},
 		),
+
+		// no queue name label here for the same reason as for forq_messages_moved_to_dlq_total:
+		// this is the counterpart of moving to DLQ for the queues with the "none" DLQ policy.
+		messagesDroppedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "forq_messages_dropped_total",
+				Help: "Total number of failed or expired messages dropped instead of moving to DLQ, as their queue has no DLQ",
+			},
+			[]string{"reason"},
+		),
+
+		quotaRejectionsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "forq_quota_rejections_total",
+				Help: "Total number of messages rejected on producing, as the queue or global quota was exceeded",
+			},
+			[]string{"queue_name", "quota"},
+		),
+
+		// secret_id is a short hash of the secret, as the secret itself must never end up in the metrics
+		deprecatedAuthSecretUses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "forq_deprecated_auth_secret_uses_total",
+				Help: "Total number of requests authenticated with an auth secret that has an expiry, i.e. is being rotated out",
+			},
+			[]string{"secret_id"},
+		),
+
+		// neither the API key nor the IP are labels, as there can be too many of them
+		rateLimitedRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "forq_rate_limited_requests_total",
+				Help: "Total number of API requests rejected, as the per API key or the per IP rate limit of the route was exceeded",
+			},
+			[]string{"route", "limit"},
+		),
+
+		// route is the chi route pattern, e.g. "/api/v1/queues/{queue}/messages", never the raw path, as the
+		// queue names and message IDs would blow up the cardinality. The long polling consumes take up to the
+		// polling duration, hence the upper buckets.
+		httpRequestDurationSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "forq_http_request_duration_seconds",
+				Help:    "Duration of the API requests, per endpoint",
+				Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+			},
+			[]string{"method", "route", "code"},
+		),
+
+		dbFileBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "forq_db_file_size_bytes",
+				Help: "Current size of the SQLite DB file, in bytes",
+			},
+		),
+
+		dbWalBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "forq_db_wal_size_bytes",
+				Help: "Current size of the SQLite write-ahead log file, in bytes",
+			},
+		),
+
+		// the free pages are reused by the next inserts, the file only shrinks on VACUUM
+		dbFreelistPages: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "forq_db_freelist_pages",
+				Help: "Current number of unused pages in the SQLite DB file",
+			},
+		),
+
+		// counters rather than a ratio gauge, so that the hit rate can be computed over any time range
+		dbCacheHitsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "forq_db_cache_hits_total",
+				Help: "Total number of SQLite page cache hits of all the DB connections",
+			},
+		),
+
+		dbCacheMissesTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "forq_db_cache_misses_total",
+				Help: "Total number of SQLite page cache misses of all the DB connections",
+			},
+		),
+
+		dbErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "forq_db_errors_total",
+				Help: "Total number of queries that failed, as the SQLite DB was busy or locked",
+			},
+			[]string{"error"},
+		),
+
+		dbWriteWaitsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "forq_db_write_waits_total",
+				Help: "Total number of queries that waited for the single write DB connection",
+			},
+		),
+
+		dbWriteWaitSecondsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "forq_db_write_wait_seconds_total",
+				Help: "Total time the queries waited for the single write DB connection, in seconds",
+			},
+		),
+
+		// most ticks run a query or two, so anything above 30 seconds is a sign of trouble regardless of the exact value
+		// job_name rather than job, which is the label Prometheus sets to the scrape job
+		jobTickDurationSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "forq_job_tick_duration_seconds",
+				Help:    "Duration of the background job ticks",
+				Buckets: []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 30},
+			},
+			[]string{"job_name"},
+		),
+
+		jobTickFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "forq_job_tick_failures_total",
+				Help: "Total number of background job ticks that failed with an error or a panic",
+			},
+			[]string{"job_name"},
+		),
+
+		jobLastSuccessTimestamp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "forq_job_last_success_timestamp_seconds",
+				Help: "Unix time of the last successful tick of the background job",
+			},
+			[]string{"job_name"},
+		),
 	}
 
 	prometheus.MustRegister(srv.messagesProducedTotal)
@@ -110,27 +312,53 @@ func newPrometheusMetricsService() *PrometheusMetricsService {
 	prometheus.MustRegister(srv.messagesNackedTotal)
 	prometheus.MustRegister(srv.messagesRequeuedTotal)
 	prometheus.MustRegister(srv.queueDepth)
+	prometheus.MustRegister(srv.queueContentBytes)
+	prometheus.MustRegister(srv.queueOldestReadyMessageAge)
+	prometheus.MustRegister(srv.messageWaitSeconds)
+	prometheus.MustRegister(srv.messageProcessingSeconds)
+	prometheus.MustRegister(srv.messageAttemptsAtAck)
 	prometheus.MustRegister(srv.messagesMovedToDlqTotal)
 	prometheus.MustRegister(srv.messagesStaleRecoveredTotal)
 	prometheus.MustRegister(srv.messagesCleanupTotal)
+	prometheus.MustRegister(srv.messagesDroppedTotal)
+	prometheus.MustRegister(srv.quotaRejectionsTotal)
+	prometheus.MustRegister(srv.deprecatedAuthSecretUses)
+	prometheus.MustRegister(srv.rateLimitedRequestsTotal)
+	prometheus.MustRegister(srv.httpRequestDurationSeconds)
+	prometheus.MustRegister(srv.dbFileBytes)
+	prometheus.MustRegister(srv.dbWalBytes)
+	prometheus.MustRegister(srv.dbFreelistPages)
+	prometheus.MustRegister(srv.dbCacheHitsTotal)
+	prometheus.MustRegister(srv.dbCacheMissesTotal)
+	prometheus.MustRegister(srv.dbErrorsTotal)
+	prometheus.MustRegister(srv.dbWriteWaitsTotal)
+	prometheus.MustRegister(srv.dbWriteWaitSecondsTotal)
+	prometheus.MustRegister(srv.jobTickDurationSeconds)
+	prometheus.MustRegister(srv.jobTickFailuresTotal)
+	prometheus.MustRegister(srv.jobLastSuccessTimestamp)
 
 	return srv
 }
 
+// Close is a no-op: the metrics are scraped, not pushed.
+func (pms *PrometheusMetricsService) Close() error {
+	return nil
+}
+
 func (pms *PrometheusMetricsService) IncMessagesProducedTotalBy(count int64, queueName string) {
-	pms.messagesProducedTotal.WithLabelValues(queueName, pms.queueType(queueName)).Add(float64(count))
+	pms.messagesProducedTotal.WithLabelValues(queueName, queueType(queueName)).Add(float64(count))
 }
 
 func (pms *PrometheusMetricsService) IncMessagesConsumedTotalBy(count int64, queueName string) {
-	pms.messagesConsumedTotal.WithLabelValues(queueName, pms.queueType(queueName)).Add(float64(count))
+	pms.messagesConsumedTotal.WithLabelValues(queueName, queueType(queueName)).Add(float64(count))
 }
 
 func (pms *PrometheusMetricsService) IncMessagesAckedTotalBy(count int64, queueName string) {
-	pms.messagesAckedTotal.WithLabelValues(queueName, pms.queueType(queueName)).Add(float64(count))
+	pms.messagesAckedTotal.WithLabelValues(queueName, queueType(queueName)).Add(float64(count))
 }
 
 func (pms *PrometheusMetricsService) IncMessagesNackedTotalBy(count int64, queueName string) {
-	pms.messagesNackedTotal.WithLabelValues(queueName, pms.queueType(queueName)).Add(float64(count))
+	pms.messagesNackedTotal.WithLabelValues(queueName, queueType(queueName)).Add(float64(count))
 }
 
 func (pms *PrometheusMetricsService) IncMessagesRequeuedTotalBy(count int64, queueName string) {
@@ -138,15 +366,37 @@ func (pms *PrometheusMetricsService) IncMessagesRequeuedTotalBy(count int64, que
 }
 
 func (pms *PrometheusMetricsService) SetQueueDepth(queueName string, depth int64) {
-	pms.queueDepth.WithLabelValues(queueName, pms.queueType(queueName)).Set(float64(depth))
+	pms.queueDepth.WithLabelValues(queueName, queueType(queueName)).Set(float64(depth))
+}
+
+func (pms *PrometheusMetricsService) SetQueueContentBytes(queueName string, bytes int64) {
+	pms.queueContentBytes.WithLabelValues(queueName, queueType(queueName)).Set(float64(bytes))
+}
+
+func (pms *PrometheusMetricsService) SetQueueOldestReadyMessageAge(queueName string, age time.Duration) {
+	pms.queueOldestReadyMessageAge.WithLabelValues(queueName, queueType(queueName)).Set(age.Seconds())
 }
 
-// ResetQueueDepths drops all queue depth series before a collection cycle
-// repopulates them: a queue that drained to zero (or was purged) vanishes from
-// the stats query, and without the reset its gauge would report the last
-// non-zero depth forever.
+// ResetQueueDepths drops all queue depth, content size and oldest ready
+// message age series before a collection cycle repopulates them: a queue that
+// drained to zero (or was purged) vanishes from the stats query, and without
+// the reset its gauges would report the last non-zero values forever.
 func (pms *PrometheusMetricsService) ResetQueueDepths() {
 	pms.queueDepth.Reset()
+	pms.queueContentBytes.Reset()
+	pms.queueOldestReadyMessageAge.Reset()
+}
+
+func (pms *PrometheusMetricsService) ObserveMessageWaitDuration(queueName string, wait time.Duration) {
+	pms.messageWaitSeconds.WithLabelValues(queueName, queueType(queueName)).Observe(wait.Seconds())
+}
+
+func (pms *PrometheusMetricsService) ObserveMessageProcessingDuration(queueName string, processing time.Duration) {
+	pms.messageProcessingSeconds.WithLabelValues(queueName, queueType(queueName)).Observe(processing.Seconds())
+}
+
+func (pms *PrometheusMetricsService) ObserveMessageAttemptsAtAck(queueName string, attempts int) {
+	pms.messageAttemptsAtAck.WithLabelValues(queueName, queueType(queueName)).Observe(float64(attempts))
 }
 
 func (pms *PrometheusMetricsService) IncMessagesMovedToDlqTotalBy(count int64, reason string) {
@@ -161,9 +411,66 @@ func (pms *PrometheusMetricsService) IncMessagesCleanupTotalBy(count int64, reas
 	pms.messagesCleanupTotal.WithLabelValues(reason).Add(float64(count))
 }
 
-func (pms *PrometheusMetricsService) queueType(queueName string) string {
-	if strings.HasSuffix(queueName, common.DlqSuffix) {
-		return "dlq"
-	}
-	return "regular"
+func (pms *PrometheusMetricsService) IncMessagesDroppedTotalBy(count int64, reason string) {
+	pms.messagesDroppedTotal.WithLabelValues(reason).Add(float64(count))
+}
+
+func (pms *PrometheusMetricsService) IncQuotaRejectionsTotal(queueName string, quota string) {
+	pms.quotaRejectionsTotal.WithLabelValues(queueName, quota).Inc()
+}
+
+func (pms *PrometheusMetricsService) IncDeprecatedAuthSecretUsesTotal(secretId string) {
+	pms.deprecatedAuthSecretUses.WithLabelValues(secretId).Inc()
+}
+
+func (pms *PrometheusMetricsService) IncRateLimitedRequestsTotal(route string, limit string) {
+	pms.rateLimitedRequestsTotal.WithLabelValues(route, limit).Inc()
+}
+
+func (pms *PrometheusMetricsService) ObserveHttpRequestDuration(method string, route string, code int, duration time.Duration) {
+	pms.httpRequestDurationSeconds.WithLabelValues(method, route, strconv.Itoa(code)).Observe(duration.Seconds())
+}
+
+func (pms *PrometheusMetricsService) SetDbFileBytes(bytes int64) {
+	pms.dbFileBytes.Set(float64(bytes))
+}
+
+func (pms *PrometheusMetricsService) SetDbWalBytes(bytes int64) {
+	pms.dbWalBytes.Set(float64(bytes))
+}
+
+func (pms *PrometheusMetricsService) SetDbFreelistPages(pages int64) {
+	pms.dbFreelistPages.Set(float64(pages))
+}
+
+func (pms *PrometheusMetricsService) IncDbCacheHitsTotalBy(count int64) {
+	pms.dbCacheHitsTotal.Add(float64(count))
+}
+
+func (pms *PrometheusMetricsService) IncDbCacheMissesTotalBy(count int64) {
+	pms.dbCacheMissesTotal.Add(float64(count))
+}
+
+func (pms *PrometheusMetricsService) IncDbErrorsTotalBy(count int64, errorType string) {
+	pms.dbErrorsTotal.WithLabelValues(errorType).Add(float64(count))
+}
+
+func (pms *PrometheusMetricsService) IncDbWriteWaitsTotalBy(count int64) {
+	pms.dbWriteWaitsTotal.Add(float64(count))
+}
+
+func (pms *PrometheusMetricsService) IncDbWriteWaitSecondsTotalBy(wait time.Duration) {
+	pms.dbWriteWaitSecondsTotal.Add(wait.Seconds())
+}
+
+func (pms *PrometheusMetricsService) ObserveJobTickDuration(job string, duration time.Duration) {
+	pms.jobTickDurationSeconds.WithLabelValues(job).Observe(duration.Seconds())
+}
+
+func (pms *PrometheusMetricsService) IncJobTickFailuresTotal(job string) {
+	pms.jobTickFailuresTotal.WithLabelValues(job).Inc()
+}
+
+func (pms *PrometheusMetricsService) SetJobLastSuccessTime(job string, at time.Time) {
+	pms.jobLastSuccessTimestamp.WithLabelValues(job).Set(float64(at.UnixMilli()) / 1000)
 }
@@ -0,0 +1,53 @@
+package metrics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/metrics"
+)
+
+// TestPrometheusMetricsService touches every metric, as the tests of the other
+// packages run with the no-op implementation. It can only be created once per
+// process, as the metrics are registered globally.
+func TestPrometheusMetricsService(t *testing.T) {
+	ms := metrics.NewMetricsService(configs.MetricsSettings{Enabled: true, Backend: common.PrometheusMetricsBackend})
+
+	ms.IncMessagesProducedTotalBy(1, "orders")
+	ms.IncMessagesConsumedTotalBy(1, "orders")
+	ms.IncMessagesAckedTotalBy(1, "orders")
+	ms.IncMessagesNackedTotalBy(1, "orders")
+	ms.IncMessagesRequeuedTotalBy(1, "orders")
+	ms.SetQueueDepth("orders", 1)
+	ms.SetQueueContentBytes("orders", 1)
+	ms.SetQueueOldestReadyMessageAge("orders", time.Second)
+	ms.ResetQueueDepths()
+	ms.ObserveMessageWaitDuration("orders", time.Second)
+	ms.ObserveMessageProcessingDuration("orders", time.Second)
+	ms.ObserveMessageAttemptsAtAck("orders", 1)
+	ms.IncMessagesMovedToDlqTotalBy(1, metrics.FailedMovedToDlqReason)
+	ms.IncMessagesStaleRecoveredTotalBy(1)
+	ms.IncMessagesCleanupTotalBy(1, metrics.ExpiredCleanupReason)
+	ms.IncMessagesDroppedTotalBy(1, metrics.FailedDroppedReason)
+	ms.IncQuotaRejectionsTotal("orders", metrics.MessagesQuota)
+	ms.IncDeprecatedAuthSecretUsesTotal("0123abcd")
+	ms.IncRateLimitedRequestsTotal("produce", metrics.KeyRateLimit)
+	ms.ObserveHttpRequestDuration("POST", "/api/v1/queues/{queue}/messages", 204, time.Millisecond)
+	ms.SetDbFileBytes(1)
+	ms.SetDbWalBytes(1)
+	ms.SetDbFreelistPages(1)
+	ms.IncDbCacheHitsTotalBy(1)
+	ms.IncDbCacheMissesTotalBy(1)
+	ms.IncDbErrorsTotalBy(1, metrics.BusyDbError)
+	ms.IncDbWriteWaitsTotalBy(1)
+	ms.IncDbWriteWaitSecondsTotalBy(time.Millisecond)
+	ms.ObserveJobTickDuration("stale-messages-cleanup", time.Millisecond)
+	ms.IncJobTickFailuresTotal("stale-messages-cleanup")
+	ms.SetJobLastSuccessTime("stale-messages-cleanup", time.Now())
+
+	if err := ms.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,5 +1,13 @@
 package metrics
 
+import (
+	"strings"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+)
+
 const (
 	FailedMovedToDlqReason  = "failed"
 	ExpiredMovedToDlqReason = "expired"
@@ -7,6 +15,18 @@ const (
 	FailedCleanupReason        = "failed"
 	ExpiredCleanupReason       = "expired"
 	DeletedByUserCleanupReason = "deleted_by_user"
+
+	FailedDroppedReason  = "failed"
+	ExpiredDroppedReason = "expired"
+
+	MessagesQuota = "messages"
+	BytesQuota    = "bytes"
+
+	KeyRateLimit = "key"
+	IpRateLimit  = "ip"
+
+	BusyDbError   = "busy"
+	LockedDbError = "locked"
 )
 
 type Service interface {
@@ -16,15 +36,50 @@ type Service interface {
 	IncMessagesNackedTotalBy(count int64, queueName string)
 	IncMessagesRequeuedTotalBy(count int64, queueName string)
 	SetQueueDepth(queueName string, depth int64)
+	SetQueueContentBytes(queueName string, bytes int64)
+	SetQueueOldestReadyMessageAge(queueName string, age time.Duration)
 	ResetQueueDepths()
+	ObserveMessageWaitDuration(queueName string, wait time.Duration)
+	ObserveMessageProcessingDuration(queueName string, processing time.Duration)
+	ObserveMessageAttemptsAtAck(queueName string, attempts int)
 	IncMessagesMovedToDlqTotalBy(count int64, reason string)
 	IncMessagesStaleRecoveredTotalBy(count int64)
 	IncMessagesCleanupTotalBy(count int64, reason string)
+	IncMessagesDroppedTotalBy(count int64, reason string)
+	IncQuotaRejectionsTotal(queueName string, quota string)
+	IncDeprecatedAuthSecretUsesTotal(secretId string)
+	IncRateLimitedRequestsTotal(route string, limit string)
+	ObserveHttpRequestDuration(method string, route string, code int, duration time.Duration)
+	SetDbFileBytes(bytes int64)
+	SetDbWalBytes(bytes int64)
+	SetDbFreelistPages(pages int64)
+	IncDbCacheHitsTotalBy(count int64)
+	IncDbCacheMissesTotalBy(count int64)
+	IncDbErrorsTotalBy(count int64, errorType string)
+	IncDbWriteWaitsTotalBy(count int64)
+	IncDbWriteWaitSecondsTotalBy(wait time.Duration)
+	ObserveJobTickDuration(job string, duration time.Duration)
+	IncJobTickFailuresTotal(job string)
+	SetJobLastSuccessTime(job string, at time.Time)
+	Close() error
+}
+
+// NewMetricsService returns the implementation of the settings' backend, or
+// the no-op one if the metrics are disabled.
+func NewMetricsService(settings configs.MetricsSettings) Service {
+	if !settings.Enabled {
+		return newNoopMetricsService()
+	}
+	if settings.Backend == common.StatsdMetricsBackend {
+		network, addr, _ := settings.Statsd.NetworkAddr() // validated already
+		return newStatsdMetricsService(network, addr, settings.Statsd.Prefix, settings.Statsd.Tags)
+	}
+	return newPrometheusMetricsService()
 }
 
-func NewMetricsService(metricsEnabled bool) Service {
-	if metricsEnabled {
-		return newPrometheusMetricsService()
+func queueType(queueName string) string {
+	if strings.HasSuffix(queueName, common.DlqSuffix) {
+		return "dlq"
 	}
-	return newNoopMetricsService()
+	return "regular"
 }
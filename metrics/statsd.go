@@ -0,0 +1,378 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	statsdQueueSize      = 8192 // metrics waiting to be sent, the ones beyond are dropped
+	statsdFlushInterval  = time.Second
+	statsdUdpPacketSize  = 1432 // fits the Ethernet MTU, as in the official DogStatsD clients
+	statsdUnixPacketSize = 8192
+
+	statsdCounter   = "c"
+	statsdGauge     = "g"
+	statsdTiming    = "ms"
+	statsdHistogram = "h"
+)
+
+type statsdMetric struct {
+	name  string
+	kind  string
+	value float64
+	tags  []string
+}
+
+// StatsdMetricsService pushes the metrics in the DogStatsD format, i.e. with
+// the labels of the Prometheus implementation as tags, over UDP or a Unix
+// datagram socket. The callers only queue the metrics, the background
+// goroutine batches them into packets and sends them, so the network never
+// slows the API down: if the queue is full, the metrics are dropped instead.
+//
+// The durations are sent as timings in milliseconds, the statsd way, and the
+// counters are not aggregated by Forq, as the server does it anyway.
+type StatsdMetricsService struct {
+	network    string
+	addr       string
+	prefix     string
+	tags       string // the constant ones, comma-separated
+	packetSize int
+	queue      chan statsdMetric
+	dropped    atomic.Int64
+	done       chan struct{}
+	closed     chan struct{}
+	// the queues with gauges sent in the current and the previous cycle of
+	// the queues depth job, see ResetQueueDepths
+	reportedQueues map[string]struct{}
+	previousQueues map[string]struct{}
+	queuesMu       sync.Mutex
+}
+
+func newStatsdMetricsService(network string, addr string, prefix string, tags []string) *StatsdMetricsService {
+	packetSize := statsdUdpPacketSize
+	if network == "unixgram" {
+		packetSize = statsdUnixPacketSize
+	}
+
+	sms := &StatsdMetricsService{
+		network:        network,
+		addr:           addr,
+		prefix:         prefix,
+		tags:           strings.Join(tags, ","),
+		packetSize:     packetSize,
+		queue:          make(chan statsdMetric, statsdQueueSize),
+		done:           make(chan struct{}),
+		closed:         make(chan struct{}),
+		reportedQueues: make(map[string]struct{}),
+		previousQueues: make(map[string]struct{}),
+	}
+	go sms.run()
+
+	log.Info().Str("network", network).Str("addr", addr).Msg("pushing metrics to statsd")
+	return sms
+}
+
+// Close sends the queued metrics and stops the background goroutine. The
+// metrics recorded afterward are dropped.
+func (sms *StatsdMetricsService) Close() error {
+	close(sms.done)
+	<-sms.closed
+	return nil
+}
+
+func (sms *StatsdMetricsService) IncMessagesProducedTotalBy(count int64, queueName string) {
+	sms.enqueue("messages_produced", statsdCounter, float64(count), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) IncMessagesConsumedTotalBy(count int64, queueName string) {
+	sms.enqueue("messages_consumed", statsdCounter, float64(count), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) IncMessagesAckedTotalBy(count int64, queueName string) {
+	sms.enqueue("messages_acked", statsdCounter, float64(count), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) IncMessagesNackedTotalBy(count int64, queueName string) {
+	sms.enqueue("messages_nacked", statsdCounter, float64(count), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) IncMessagesRequeuedTotalBy(count int64, queueName string) {
+	sms.enqueue("messages_requeued", statsdCounter, float64(count), "queue_name:"+queueName)
+}
+
+func (sms *StatsdMetricsService) SetQueueDepth(queueName string, depth int64) {
+	sms.queuesMu.Lock()
+	sms.reportedQueues[queueName] = struct{}{}
+	sms.queuesMu.Unlock()
+
+	sms.enqueue("queue_depth", statsdGauge, float64(depth), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) SetQueueContentBytes(queueName string, bytes int64) {
+	sms.enqueue("queue_content_bytes", statsdGauge, float64(bytes), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) SetQueueOldestReadyMessageAge(queueName string, age time.Duration) {
+	sms.enqueue("queue_oldest_ready_message_age_seconds", statsdGauge, age.Seconds(), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+// ResetQueueDepths sends zeros for the queues that had gauges sent in the
+// previous cycle but not in the one that just ended, e.g. drained or purged:
+// unlike Prometheus, the statsd servers keep reporting the last value of a
+// gauge that is no longer sent. As the reset comes before the new cycle's
+// gauges, the zeros are sent one cycle late.
+func (sms *StatsdMetricsService) ResetQueueDepths() {
+	sms.queuesMu.Lock()
+	var disappeared []string
+	for queueName := range sms.previousQueues {
+		if _, ok := sms.reportedQueues[queueName]; !ok {
+			disappeared = append(disappeared, queueName)
+		}
+	}
+	sms.previousQueues = sms.reportedQueues
+	sms.reportedQueues = make(map[string]struct{}, len(sms.previousQueues))
+	sms.queuesMu.Unlock()
+
+	// not via SetQueueDepth, so that the zeroed queues are not counted as reported
+	for _, queueName := range disappeared {
+		sms.enqueue("queue_depth", statsdGauge, 0, "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+		sms.enqueue("queue_content_bytes", statsdGauge, 0, "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+		sms.enqueue("queue_oldest_ready_message_age_seconds", statsdGauge, 0, "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+	}
+}
+
+func (sms *StatsdMetricsService) ObserveMessageWaitDuration(queueName string, wait time.Duration) {
+	sms.enqueue("message_wait", statsdTiming, milliseconds(wait), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) ObserveMessageProcessingDuration(queueName string, processing time.Duration) {
+	sms.enqueue("message_processing", statsdTiming, milliseconds(processing), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) ObserveMessageAttemptsAtAck(queueName string, attempts int) {
+	sms.enqueue("message_attempts_at_ack", statsdHistogram, float64(attempts), "queue_name:"+queueName, "queue_type:"+queueType(queueName))
+}
+
+func (sms *StatsdMetricsService) IncMessagesMovedToDlqTotalBy(count int64, reason string) {
+	sms.enqueue("messages_moved_to_dlq", statsdCounter, float64(count), "reason:"+reason)
+}
+
+func (sms *StatsdMetricsService) IncMessagesStaleRecoveredTotalBy(count int64) {
+	sms.enqueue("messages_stale_recovered", statsdCounter, float64(count))
+}
+
+func (sms *StatsdMetricsService) IncMessagesCleanupTotalBy(count int64, reason string) {
+	sms.enqueue("messages_cleanup", statsdCounter, float64(count), "reason:"+reason)
+}
+
+func (sms *StatsdMetricsService) IncMessagesDroppedTotalBy(count int64, reason string) {
+	sms.enqueue("messages_dropped", statsdCounter, float64(count), "reason:"+reason)
+}
+
+func (sms *StatsdMetricsService) IncQuotaRejectionsTotal(queueName string, quota string) {
+	sms.enqueue("quota_rejections", statsdCounter, 1, "queue_name:"+queueName, "quota:"+quota)
+}
+
+func (sms *StatsdMetricsService) IncDeprecatedAuthSecretUsesTotal(secretId string) {
+	sms.enqueue("deprecated_auth_secret_uses", statsdCounter, 1, "secret_id:"+secretId)
+}
+
+func (sms *StatsdMetricsService) IncRateLimitedRequestsTotal(route string, limit string) {
+	sms.enqueue("rate_limited_requests", statsdCounter, 1, "route:"+route, "limit:"+limit)
+}
+
+func (sms *StatsdMetricsService) ObserveHttpRequestDuration(method string, route string, code int, duration time.Duration) {
+	sms.enqueue("http_request_duration", statsdTiming, milliseconds(duration), "method:"+method, "route:"+route, "code:"+strconv.Itoa(code))
+}
+
+func (sms *StatsdMetricsService) SetDbFileBytes(bytes int64) {
+	sms.enqueue("db_file_size_bytes", statsdGauge, float64(bytes))
+}
+
+func (sms *StatsdMetricsService) SetDbWalBytes(bytes int64) {
+	sms.enqueue("db_wal_size_bytes", statsdGauge, float64(bytes))
+}
+
+func (sms *StatsdMetricsService) SetDbFreelistPages(pages int64) {
+	sms.enqueue("db_freelist_pages", statsdGauge, float64(pages))
+}
+
+func (sms *StatsdMetricsService) IncDbCacheHitsTotalBy(count int64) {
+	sms.enqueue("db_cache_hits", statsdCounter, float64(count))
+}
+
+func (sms *StatsdMetricsService) IncDbCacheMissesTotalBy(count int64) {
+	sms.enqueue("db_cache_misses", statsdCounter, float64(count))
+}
+
+func (sms *StatsdMetricsService) IncDbErrorsTotalBy(count int64, errorType string) {
+	sms.enqueue("db_errors", statsdCounter, float64(count), "error:"+errorType)
+}
+
+func (sms *StatsdMetricsService) IncDbWriteWaitsTotalBy(count int64) {
+	sms.enqueue("db_write_waits", statsdCounter, float64(count))
+}
+
+func (sms *StatsdMetricsService) IncDbWriteWaitSecondsTotalBy(wait time.Duration) {
+	sms.enqueue("db_write_wait_seconds", statsdCounter, wait.Seconds())
+}
+
+func (sms *StatsdMetricsService) ObserveJobTickDuration(job string, duration time.Duration) {
+	sms.enqueue("job_tick_duration", statsdTiming, milliseconds(duration), "job_name:"+job)
+}
+
+func (sms *StatsdMetricsService) IncJobTickFailuresTotal(job string) {
+	sms.enqueue("job_tick_failures", statsdCounter, 1, "job_name:"+job)
+}
+
+func (sms *StatsdMetricsService) SetJobLastSuccessTime(job string, at time.Time) {
+	sms.enqueue("job_last_success_timestamp_seconds", statsdGauge, float64(at.UnixMilli())/1000, "job_name:"+job)
+}
+
+// enqueue never blocks: the metric is dropped if the queue is full, e.g. if
+// the sending can't keep up with a burst.
+func (sms *StatsdMetricsService) enqueue(name string, kind string, value float64, tags ...string) {
+	select {
+	case sms.queue <- statsdMetric{name: name, kind: kind, value: value, tags: tags}:
+	default:
+		sms.dropped.Add(1)
+	}
+}
+
+// run batches the queued metrics into packets of up to packetSize bytes, one
+// metric per line, and sends each one once full or every statsdFlushInterval.
+func (sms *StatsdMetricsService) run() {
+	defer close(sms.closed)
+
+	sender := &statsdSender{network: sms.network, addr: sms.addr}
+	defer sender.close()
+
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+
+	packet := make([]byte, 0, sms.packetSize)
+	add := func(metric statsdMetric) {
+		line := sms.format(metric)
+		if len(packet) > 0 && len(packet)+1+len(line) > sms.packetSize {
+			sender.send(packet)
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+
+	for {
+		select {
+		case metric := <-sms.queue:
+			add(metric)
+		case <-ticker.C:
+			sender.send(packet)
+			packet = packet[:0]
+			if dropped := sms.dropped.Swap(0); dropped > 0 {
+				log.Warn().Int64("dropped", dropped).Msg("statsd metrics queue is full, metrics dropped")
+			}
+		case <-sms.done:
+			for {
+				select {
+				case metric := <-sms.queue:
+					add(metric)
+				default:
+					sender.send(packet)
+					return
+				}
+			}
+		}
+	}
+}
+
+// format returns the metric line: <prefix><name>:<value>|<kind>|#<tags>
+func (sms *StatsdMetricsService) format(metric statsdMetric) []byte {
+	line := make([]byte, 0, 128)
+	line = append(line, sms.prefix...)
+	line = append(line, metric.name...)
+	line = append(line, ':')
+	line = strconv.AppendFloat(line, metric.value, 'f', -1, 64)
+	line = append(line, '|')
+	line = append(line, metric.kind...)
+
+	if len(metric.tags) == 0 && sms.tags == "" {
+		return line
+	}
+	line = append(line, "|#"...)
+	for i, tag := range metric.tags {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, tag...)
+	}
+	if sms.tags != "" {
+		if len(metric.tags) > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, sms.tags...)
+	}
+	return line
+}
+
+// statsdSender (re)connects lazily, so that Forq can start before the statsd
+// server, and a restarted server, e.g. a new Unix socket, is picked up. The
+// packets that fail to send are dropped, as statsd is lossy anyway.
+type statsdSender struct {
+	network string
+	addr    string
+	conn    net.Conn
+	failing bool // to log the failures once, rather than for every packet
+}
+
+func (ss *statsdSender) send(packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+
+	if ss.conn == nil {
+		conn, err := net.Dial(ss.network, ss.addr)
+		if err != nil {
+			ss.fail(err)
+			return
+		}
+		ss.conn = conn
+	}
+
+	_, err := ss.conn.Write(packet)
+	if err != nil {
+		ss.close()
+		ss.fail(err)
+		return
+	}
+	if ss.failing {
+		ss.failing = false
+		log.Info().Str("addr", ss.addr).Msg("sending metrics to statsd again")
+	}
+}
+
+func (ss *statsdSender) fail(err error) {
+	if !ss.failing {
+		ss.failing = true
+		log.Warn().Err(err).Str("addr", ss.addr).Msg("failed to send metrics to statsd, dropping them until it's reachable")
+	}
+}
+
+func (ss *statsdSender) close() {
+	if ss.conn != nil {
+		ss.conn.Close()
+		ss.conn = nil
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,167 @@
+package metrics_test
+
+import (
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/metrics"
+)
+
+func statsdSettings(addr string) configs.MetricsSettings {
+	return configs.MetricsSettings{
+		Enabled: true,
+		Backend: common.StatsdMetricsBackend,
+		Statsd: configs.StatsdSettings{
+			Addr:   addr,
+			Prefix: "forq.",
+			Tags:   []string{"env:test"},
+		},
+	}
+}
+
+// readLines reads the packets until no more arrive, and splits them into the
+// metric lines.
+func readLines(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	var lines []string
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return lines
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func TestStatsdMetricsService_Udp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ms := metrics.NewMetricsService(statsdSettings("udp://" + conn.LocalAddr().String()))
+	ms.IncMessagesProducedTotalBy(3, "orders")
+	ms.IncMessagesMovedToDlqTotalBy(1, metrics.FailedMovedToDlqReason)
+	ms.SetQueueDepth("orders-dlq", 7)
+	ms.ObserveMessageWaitDuration("orders", 1500*time.Microsecond)
+	ms.ObserveMessageAttemptsAtAck("orders", 2)
+	ms.IncMessagesStaleRecoveredTotalBy(1)
+	// Close sends the queued metrics right away, rather than on the next flush
+	if err := ms.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, conn)
+	for _, want := range []string{
+		"forq.messages_produced:3|c|#queue_name:orders,queue_type:regular,env:test",
+		"forq.messages_moved_to_dlq:1|c|#reason:failed,env:test",
+		"forq.queue_depth:7|g|#queue_name:orders-dlq,queue_type:dlq,env:test",
+		"forq.message_wait:1.5|ms|#queue_name:orders,queue_type:regular,env:test",
+		"forq.message_attempts_at_ack:2|h|#queue_name:orders,queue_type:regular,env:test",
+		"forq.messages_stale_recovered:1|c|#env:test",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("line %q not sent, got:\n%s", want, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestStatsdMetricsService_UnixDatagram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ms := metrics.NewMetricsService(statsdSettings("unix://" + path))
+	for range 1000 {
+		ms.IncMessagesAckedTotalBy(1, "orders")
+	}
+	if err := ms.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, conn)
+	if len(lines) != 1000 {
+		t.Fatalf("got %d lines, want 1000, batched into the packets", len(lines))
+	}
+	if lines[0] != "forq.messages_acked:1|c|#queue_name:orders,queue_type:regular,env:test" {
+		t.Errorf("line = %q", lines[0])
+	}
+}
+
+// TestStatsdMetricsService_NeverBlocks records far more metrics than the queue
+// holds, with nothing listening: they are dropped instead of waiting.
+func TestStatsdMetricsService_NeverBlocks(t *testing.T) {
+	ms := metrics.NewMetricsService(statsdSettings("unix://" + filepath.Join(t.TempDir(), "missing.socket")))
+	defer ms.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for range 100_000 {
+			ms.IncMessagesProducedTotalBy(1, "orders")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording the metrics blocked")
+	}
+}
+
+func TestStatsdMetricsService_ZeroesDisappearedQueues(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ms := metrics.NewMetricsService(statsdSettings("udp://" + conn.LocalAddr().String()))
+	// the cycles of the queues depth job: "orders" is drained after the first one
+	ms.ResetQueueDepths()
+	ms.SetQueueDepth("orders", 3)
+	ms.SetQueueDepth("emails", 1)
+	ms.ResetQueueDepths()
+	ms.SetQueueDepth("emails", 1)
+	ms.ResetQueueDepths()
+	ms.SetQueueDepth("emails", 1)
+	ms.ResetQueueDepths()
+	if err := ms.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, conn)
+	for _, want := range []string{
+		"forq.queue_depth:0|g|#queue_name:orders,queue_type:regular,env:test",
+		"forq.queue_content_bytes:0|g|#queue_name:orders,queue_type:regular,env:test",
+	} {
+		if count := countLines(lines, want); count != 1 {
+			t.Errorf("line %q sent %d times, want once, got:\n%s", want, count, strings.Join(lines, "\n"))
+		}
+	}
+	if count := countLines(lines, "forq.queue_depth:0|g|#queue_name:emails,queue_type:regular,env:test"); count != 0 {
+		t.Errorf("zeroed the depth of a queue that is still reported, got:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func countLines(lines []string, line string) int {
+	count := 0
+	for _, l := range lines {
+		if l == line {
+			count++
+		}
+	}
+	return count
+}
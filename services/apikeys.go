@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/db"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	apiKeyPrefix    = "forq_" // makes the keys recognizable, e.g. by secret scanners
+	apiKeyBytes     = 32
+	maxApiKeyScopes = 100
+)
+
+// apiKeyNameRegex keeps the names printable in logs and in the admin UI.
+var apiKeyNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// Principal is the caller authenticated by an API key.
+type Principal struct {
+	Name string
+	// Superuser is the FORQ_AUTH_SECRET bootstrap key, which is allowed everything.
+	Superuser bool
+	Scopes    []common.ApiKeyScope
+}
+
+// Allows reports whether the principal has the permission on the queue. A
+// scope also covers the default DLQ ("<queue>-dlq") of the queues it matches,
+// and the admin permission implies produce and consume.
+func (p *Principal) Allows(permission string, queueName string) bool {
+	if p.Superuser {
+		return true
+	}
+	originQueue := strings.TrimSuffix(queueName, common.DlqSuffix)
+	for _, scope := range p.Scopes {
+		if scope.Permission != permission && scope.Permission != common.AdminPermission {
+			continue
+		}
+		if matchesQueuePattern(scope.Queues, queueName) || matchesQueuePattern(scope.Queues, originQueue) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGlobalAdmin reports whether the principal is an admin of every queue,
+// which is required to manage the API keys themselves.
+func (p *Principal) IsGlobalAdmin() bool {
+	if p.Superuser {
+		return true
+	}
+	for _, scope := range p.Scopes {
+		if scope.Permission == common.AdminPermission && scope.Queues == common.AllQueuesPattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ApiKeysService manages the named API keys. Only the SHA-256 hashes of the
+// keys are stored: the keys are random 256-bit values, so a slow password hash
+// would add nothing but latency. The keys are cached by hash, as they are
+// checked on every API request, and Forq being a single process, updating the
+// cache on every write keeps it in sync.
+type ApiKeysService struct {
+	forqRepo *db.ForqRepo
+	keys     map[string]*Principal // by key hash
+	byName   map[string]*Principal // the same principals, for the TLS client certificates
+	mu       sync.RWMutex
+}
+
+func NewApiKeysService(forqRepo *db.ForqRepo) (*ApiKeysService, error) {
+	as := &ApiKeysService{
+		forqRepo: forqRepo,
+		keys:     make(map[string]*Principal),
+		byName:   make(map[string]*Principal),
+	}
+
+	apiKeys, err := forqRepo.SelectAllApiKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for _, apiKey := range apiKeys {
+		scopes, err := scopesFromDb(&apiKey)
+		if err != nil {
+			return nil, err
+		}
+		principal := &Principal{Name: apiKey.Name, Scopes: scopes}
+		as.keys[apiKey.KeyHash] = principal
+		as.byName[apiKey.Name] = principal
+	}
+	return as, nil
+}
+
+// Authenticate returns nil if the key is unknown. The lookup is by hash, so
+// it doesn't leak the stored keys through timing.
+func (as *ApiKeysService) Authenticate(key string) *Principal {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil
+	}
+
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	return as.keys[hashApiKey(key)]
+}
+
+// PrincipalByName returns nil if there is no API key with the name. It lets
+// the TLS client certificates act as the API key named after their common
+// name: the key defines the scopes, and deleting it revokes the certificate
+// too, with no CRLs involved.
+func (as *ApiKeysService) PrincipalByName(name string) *Principal {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	return as.byName[name]
+}
+
+func (as *ApiKeysService) CreateApiKey(newKeyReq common.NewApiKeyRequest, ctx context.Context) (*common.NewApiKeyResponse, error) {
+	if !apiKeyNameRegex.MatchString(newKeyReq.Name) {
+		log.Error().Str("api_key_name", newKeyReq.Name).Msg("invalid API key name")
+		return nil, common.ErrBadRequestInvalidKeyName
+	}
+	if !areValidScopes(newKeyReq.Scopes) {
+		log.Error().Str("api_key_name", newKeyReq.Name).Msg("invalid API key scopes")
+		return nil, common.ErrBadRequestInvalidScopes
+	}
+
+	keyBytes := make([]byte, apiKeyBytes)
+	_, err := rand.Read(keyBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate API key")
+		return nil, common.ErrInternal
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(keyBytes)
+
+	scopesJson, err := json.Marshal(newKeyReq.Scopes)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal API key scopes")
+		return nil, common.ErrInternal
+	}
+
+	apiKey := db.ApiKey{
+		Id:        uuid.NewString(),
+		Name:      newKeyReq.Name,
+		KeyHash:   hashApiKey(key),
+		Scopes:    string(scopesJson),
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	err = as.forqRepo.InsertApiKey(&apiKey, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{Name: apiKey.Name, Scopes: newKeyReq.Scopes}
+	as.mu.Lock()
+	as.keys[apiKey.KeyHash] = principal
+	as.byName[apiKey.Name] = principal
+	as.mu.Unlock()
+
+	log.Info().Str("api_key_name", apiKey.Name).Msg("API key created")
+	return &common.NewApiKeyResponse{
+		ApiKeyResponse: common.ApiKeyResponse{
+			Id:        apiKey.Id,
+			Name:      apiKey.Name,
+			Scopes:    newKeyReq.Scopes,
+			CreatedAt: apiKey.CreatedAt,
+		},
+		Key: key,
+	}, nil
+}
+
+func (as *ApiKeysService) GetApiKeys(ctx context.Context) ([]common.ApiKeyResponse, error) {
+	apiKeys, err := as.forqRepo.SelectAllApiKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]common.ApiKeyResponse, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		scopes, err := scopesFromDb(&apiKey)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, common.ApiKeyResponse{
+			Id:        apiKey.Id,
+			Name:      apiKey.Name,
+			Scopes:    scopes,
+			CreatedAt: apiKey.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+// DeleteApiKey revokes the key immediately: the next request with it is
+// rejected. It returns the name of the deleted key, whose admin UI sessions
+// are for the caller to end.
+func (as *ApiKeysService) DeleteApiKey(id string, ctx context.Context) (string, error) {
+	keyHash, err := as.forqRepo.DeleteApiKey(id, ctx)
+	if err != nil {
+		return "", err
+	}
+	if keyHash == "" {
+		return "", common.ErrNotFoundApiKey
+	}
+
+	var name string
+	as.mu.Lock()
+	if principal, ok := as.keys[keyHash]; ok {
+		name = principal.Name
+		delete(as.byName, principal.Name)
+	}
+	delete(as.keys, keyHash)
+	as.mu.Unlock()
+
+	log.Info().Str("api_key_id", id).Msg("API key deleted")
+	return name, nil
+}
+
+func areValidScopes(scopes []common.ApiKeyScope) bool {
+	if len(scopes) == 0 || len(scopes) > maxApiKeyScopes {
+		return false
+	}
+	for _, scope := range scopes {
+		if !common.SupportedPermissions[scope.Permission] || !common.IsValidQueuePattern(scope.Queues) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesQueuePattern(pattern string, queueName string) bool {
+	// queue names can't contain "/", so path.Match's "*" spans the whole name.
+	// The error is for malformed patterns only, which are rejected on creation.
+	matched, _ := path.Match(pattern, queueName)
+	return matched
+}
+
+func hashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func scopesFromDb(apiKey *db.ApiKey) ([]common.ApiKeyScope, error) {
+	var scopes []common.ApiKeyScope
+	err := json.Unmarshal([]byte(apiKey.Scopes), &scopes)
+	if err != nil {
+		log.Error().Err(err).Str("api_key_name", apiKey.Name).Msg("failed to unmarshal API key scopes")
+		return nil, common.ErrInternal
+	}
+	return scopes, nil
+}
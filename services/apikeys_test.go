@@ -0,0 +1,103 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/services"
+)
+
+func TestPrincipalAllows(t *testing.T) {
+	principal := &services.Principal{
+		Name: "orders-service",
+		Scopes: []common.ApiKeyScope{
+			{Permission: common.ProducePermission, Queues: "orders-*"},
+			{Permission: common.AdminPermission, Queues: "emails"},
+		},
+	}
+
+	tests := []struct {
+		permission string
+		queue      string
+		want       bool
+	}{
+		{common.ProducePermission, "orders-eu", true},
+		{common.ProducePermission, "orders", false},
+		{common.ConsumePermission, "orders-eu", false},
+		{common.AdminPermission, "orders-eu", false},
+		// admin implies produce and consume
+		{common.AdminPermission, "emails", true},
+		{common.ConsumePermission, "emails", true},
+		// a scope covers the default DLQ of the queue
+		{common.AdminPermission, "emails-dlq", true},
+		{common.AdminPermission, "emails-eu", false},
+		{common.ProducePermission, "payments", false},
+	}
+	for _, tc := range tests {
+		if got := principal.Allows(tc.permission, tc.queue); got != tc.want {
+			t.Errorf("Allows(%q, %q) = %v, want %v", tc.permission, tc.queue, got, tc.want)
+		}
+	}
+
+	if principal.IsGlobalAdmin() {
+		t.Error("an admin of some queues must not be a global admin")
+	}
+	superuser := &services.Principal{Superuser: true}
+	if !superuser.Allows(common.AdminPermission, "anything") || !superuser.IsGlobalAdmin() {
+		t.Error("the superuser must be allowed everything")
+	}
+}
+
+func TestApiKeysService_PersistsHashedKeys(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	apiKeysService, err := services.NewApiKeysService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := apiKeysService.CreateApiKey(common.NewApiKeyRequest{
+		Name:   "orders-service",
+		Scopes: []common.ApiKeyScope{{Permission: common.ConsumePermission, Queues: "orders"}},
+	}, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int
+	err = rawDB.QueryRow("SELECT COUNT(*) FROM api_keys WHERE key_hash = ?", newKey.Key).Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatal("the key must not be stored in plain text")
+	}
+
+	// a restart loads the keys from the DB
+	reloaded, err := services.NewApiKeysService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal := reloaded.Authenticate(newKey.Key)
+	if principal == nil || principal.Name != "orders-service" || !principal.Allows(common.ConsumePermission, "orders") {
+		t.Fatalf("reloaded principal: %+v", principal)
+	}
+	if reloaded.Authenticate("forq_unknown") != nil {
+		t.Fatal("unknown key authenticated")
+	}
+	if reloaded.PrincipalByName("orders-service") != principal {
+		t.Fatal("reloaded principal not found by name")
+	}
+
+	// deleting the key revokes it by name too, i.e. for the TLS client certificates
+	name, err := reloaded.DeleteApiKey(newKey.Id, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "orders-service" {
+		t.Fatalf("deleted key name: %q, want orders-service", name)
+	}
+	if reloaded.Authenticate(newKey.Key) != nil || reloaded.PrincipalByName("orders-service") != nil {
+		t.Fatal("deleted key still authenticates")
+	}
+}
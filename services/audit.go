@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/db"
+)
+
+// auditExportBatchSize is how many entries the export reads at a time, so
+// that a large export doesn't hold a read connection for its whole duration.
+const auditExportBatchSize = 500
+
+// AuditEvent is an administrative action to record. Err is the outcome of
+// the action: nil on success.
+type AuditEvent struct {
+	Actor     string
+	SourceIp  string
+	Action    string
+	Queue     string
+	MessageId string
+	Count     *int64 // for the bulk actions, ignored on failure
+	Details   string // e.g. the API key, user or session acted on
+	Err       error
+}
+
+// AuditService records the administrative actions in the append-only audit
+// log table. The old entries are deleted by the audit log cleanup job.
+type AuditService struct {
+	forqRepo *db.ForqRepo
+}
+
+func NewAuditService(forqRepo *db.ForqRepo) *AuditService {
+	return &AuditService{
+		forqRepo: forqRepo,
+	}
+}
+
+// Record doesn't return an error: the action has been taken already, so a
+// failure to record it is logged by the repo rather than failing the request.
+func (as *AuditService) Record(event AuditEvent, ctx context.Context) {
+	entry := db.AuditEntry{
+		CreatedAt: time.Now().UnixMilli(),
+		Actor:     event.Actor,
+		SourceIp:  event.SourceIp,
+		Action:    event.Action,
+		Queue:     nilIfEmpty(event.Queue),
+		MessageId: nilIfEmpty(event.MessageId),
+		Count:     event.Count,
+		Outcome:   common.SuccessAuditOutcome,
+		Details:   event.Details,
+	}
+	if event.Err != nil {
+		entry.Outcome = common.FailureAuditOutcome
+		entry.Count = nil
+		errCode := common.ErrCodeInternal
+		var fe common.ForqError
+		if errors.As(event.Err, &fe) {
+			errCode = fe.Code
+		}
+		if entry.Details == "" {
+			entry.Details = errCode
+		} else {
+			entry.Details += ": " + errCode
+		}
+	}
+
+	// not cancelled with the request: the action is done even if the client is gone
+	as.forqRepo.InsertAuditEntry(&entry, context.WithoutCancel(ctx))
+}
+
+// GetEntries returns up to limit entries older than the beforeId cursor, the
+// newest first. beforeId is 0 for the first page.
+func (as *AuditService) GetEntries(filter common.AuditFilter, beforeId int64, limit int, ctx context.Context) ([]common.AuditEntryResponse, error) {
+	entries, err := as.forqRepo.SelectAuditEntries(toDbAuditFilter(filter, beforeId), limit, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]common.AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, toAuditEntryResponse(entry))
+	}
+	return result, nil
+}
+
+// Export passes all the entries matching the filter to write, the newest
+// first, reading them in batches.
+func (as *AuditService) Export(filter common.AuditFilter, write func(common.AuditEntryResponse) error, ctx context.Context) error {
+	var beforeId int64
+	for {
+		entries, err := as.forqRepo.SelectAuditEntries(toDbAuditFilter(filter, beforeId), auditExportBatchSize, ctx)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := write(toAuditEntryResponse(entry)); err != nil {
+				return err
+			}
+		}
+		if len(entries) < auditExportBatchSize {
+			return nil
+		}
+		beforeId = entries[len(entries)-1].Id
+	}
+}
+
+// RedriveAuditDetails and the ones below describe the requests of the audited
+// actions the same way for the admin UI and the admin API.
+func RedriveAuditDetails(redriveReq common.RedriveRequest) string {
+	details := "target: " + redriveReq.TargetQueue
+	if redriveReq.FailureReason != "" {
+		details += ", failure reason: " + redriveReq.FailureReason
+	}
+	if redriveReq.ReceivedAfter > 0 {
+		details += fmt.Sprintf(", received after: %d", redriveReq.ReceivedAfter)
+	}
+	if redriveReq.ReceivedBefore > 0 {
+		details += fmt.Sprintf(", received before: %d", redriveReq.ReceivedBefore)
+	}
+	if redriveReq.RatePerSecond > 0 {
+		details += fmt.Sprintf(", rate: %d/s", redriveReq.RatePerSecond)
+	}
+	return details
+}
+
+func DlqPolicyAuditDetails(policyReq common.DlqPolicyRequest) string {
+	if policyReq.DlqName == "" {
+		return "policy: " + policyReq.DlqPolicy
+	}
+	return "policy: " + policyReq.DlqPolicy + ", DLQ: " + policyReq.DlqName
+}
+
+func DeliveryLimitsAuditDetails(limitsReq common.DeliveryLimitsRequest) string {
+	return fmt.Sprintf("rate: %g/s, burst: %d, max in flight: %d", limitsReq.RatePerSecond, limitsReq.Burst, limitsReq.MaxInFlight)
+}
+
+func QuotasAuditDetails(quotasReq common.QuotasRequest) string {
+	return fmt.Sprintf("max messages: %d, max bytes: %d", quotasReq.MaxMessages, quotasReq.MaxBytes)
+}
+
+func toDbAuditFilter(filter common.AuditFilter, beforeId int64) *db.AuditFilter {
+	return &db.AuditFilter{
+		Actor:    filter.Actor,
+		Action:   filter.Action,
+		Queue:    filter.Queue,
+		Outcome:  filter.Outcome,
+		Since:    filter.Since,
+		Until:    filter.Until,
+		BeforeId: beforeId,
+	}
+}
+
+func toAuditEntryResponse(entry db.AuditEntry) common.AuditEntryResponse {
+	resp := common.AuditEntryResponse{
+		Id:        entry.Id,
+		CreatedAt: entry.CreatedAt,
+		Actor:     entry.Actor,
+		SourceIp:  entry.SourceIp,
+		Action:    entry.Action,
+		Count:     entry.Count,
+		Outcome:   entry.Outcome,
+		Details:   entry.Details,
+	}
+	if entry.Queue != nil {
+		resp.Queue = *entry.Queue
+	}
+	if entry.MessageId != nil {
+		resp.MessageId = *entry.MessageId
+	}
+	return resp
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
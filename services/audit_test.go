@@ -0,0 +1,108 @@
+package services_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/services"
+)
+
+func TestAuditService_RecordAndFilter(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	auditService := services.NewAuditService(repo)
+
+	deletedCount := int64(42)
+	auditService.Record(services.AuditEvent{
+		Actor:    "jane",
+		SourceIp: "10.0.0.1",
+		Action:   common.DeleteAllDlqMessagesAuditAction,
+		Queue:    "orders-dlq",
+		Count:    &deletedCount,
+	}, t.Context())
+	auditService.Record(services.AuditEvent{
+		Actor:    "api_key:deployer",
+		SourceIp: "10.0.0.2",
+		Action:   common.DeleteAllDlqMessagesAuditAction,
+		Queue:    "orders",
+		Count:    &deletedCount,
+		Err:      common.ErrBadRequestDlqOnlyOp,
+	}, t.Context())
+	auditService.Record(services.AuditEvent{
+		Actor:   "jane",
+		Action:  common.CreateApiKeyAuditAction,
+		Details: "name: deployer",
+		Err:     fmt.Errorf("disk full"),
+	}, t.Context())
+
+	entries, err := auditService.GetEntries(common.AuditFilter{Outcome: common.FailureAuditOutcome}, 0, 10, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("failures = %+v, want 2", entries)
+	}
+	// the newest first
+	if entries[0].Details != "name: deployer: internal" {
+		t.Errorf("details of an internal error = %q", entries[0].Details)
+	}
+	if entries[1].Details != common.ErrCodeBadRequestDlqOnlyOp || entries[1].Count != nil {
+		t.Errorf("failed bulk action = %+v, want the error code and no count", entries[1])
+	}
+
+	entries, err = auditService.GetEntries(common.AuditFilter{Actor: "jane", Queue: "orders-dlq"}, 0, 10, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Outcome != common.SuccessAuditOutcome || entries[0].Count == nil || *entries[0].Count != 42 || entries[0].SourceIp != "10.0.0.1" {
+		t.Fatalf("filtered entries = %+v", entries)
+	}
+
+	// append-only
+	if _, err := rawDB.Exec("UPDATE audit_log SET outcome = ?", common.SuccessAuditOutcome); err == nil {
+		t.Fatal("audit log entries updated")
+	}
+}
+
+func TestAuditService_Export(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	auditService := services.NewAuditService(repo)
+
+	// more than an export batch
+	const total = 1200
+	for i := range total {
+		auditService.Record(services.AuditEvent{
+			Actor:     "jane",
+			Action:    common.RequeueDlqMessageAuditAction,
+			Queue:     "orders-dlq",
+			MessageId: fmt.Sprintf("message-%d", i),
+		}, t.Context())
+	}
+
+	var exported []common.AuditEntryResponse
+	err := auditService.Export(common.AuditFilter{}, func(entry common.AuditEntryResponse) error {
+		exported = append(exported, entry)
+		return nil
+	}, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != total {
+		t.Fatalf("exported %d entries, want %d", len(exported), total)
+	}
+	for i := 1; i < len(exported); i++ {
+		if exported[i].Id >= exported[i-1].Id {
+			t.Fatalf("entries out of order at %d: %d after %d", i, exported[i].Id, exported[i-1].Id)
+		}
+	}
+
+	// the UI pages the same way
+	page, err := auditService.GetEntries(common.AuditFilter{}, exported[49].Id, 50, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 50 || page[0].Id != exported[50].Id {
+		t.Fatalf("second page starts at %+v, want %d", page[0], exported[50].Id)
+	}
+}
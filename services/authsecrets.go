@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	deprecatedSecretLogIntervalMs = 60 * 1000 // logs each deprecated secret at most once a minute, the metric counts every use
+	authSecretIdLength            = 8         // hex chars of the secret's SHA-256, enough to tell the secrets apart
+)
+
+type authSecret struct {
+	value     []byte
+	id        string // short hash of the secret, safe to log
+	expiresAt int64  // Unix milliseconds, 0 means never
+}
+
+// AuthSecretsService holds the concurrently valid auth secrets: the one from
+// the env var, which never expires, and the ones from the secrets file, which
+// can be reloaded without a restart. That makes rotation a matter of adding
+// the new secret to the file, moving the clients over, and dropping the old
+// one - with its expiry set in the meantime, its uses are logged and counted,
+// so it's clear when nobody uses it anymore.
+//
+// The secrets file has one secret per line, optionally followed by its expiry
+// in RFC 3339, e.g. "<secret> 2026-12-31T23:59:59Z". Empty lines and lines
+// starting with "#" are ignored.
+type AuthSecretsService struct {
+	metricsService  metrics.Service
+	primarySecret   string
+	secretsFilePath string
+	secrets         []authSecret
+	mu              sync.RWMutex
+
+	lastDeprecatedLogMs map[string]int64 // by secret ID
+	logMu               sync.Mutex
+}
+
+// NewAuthSecretsService accepts an empty primarySecret or secretsFilePath, but
+// not both.
+func NewAuthSecretsService(metricsService metrics.Service, primarySecret string, secretsFilePath string) (*AuthSecretsService, error) {
+	as := &AuthSecretsService{
+		metricsService:      metricsService,
+		primarySecret:       primarySecret,
+		secretsFilePath:     secretsFilePath,
+		lastDeprecatedLogMs: make(map[string]int64),
+	}
+
+	err := as.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// Reload re-reads the secrets file. On error, the previous secrets stay in
+// effect, so a typo in the file can't lock all the clients out.
+func (as *AuthSecretsService) Reload() error {
+	var secrets []authSecret
+	if as.primarySecret != "" {
+		secrets = append(secrets, newAuthSecret(as.primarySecret, 0))
+	}
+
+	if as.secretsFilePath != "" {
+		fileSecrets, err := readSecretsFile(as.secretsFilePath)
+		if err != nil {
+			return err
+		}
+		secrets = append(secrets, fileSecrets...)
+	}
+
+	if len(secrets) == 0 {
+		return fmt.Errorf("no auth secrets configured")
+	}
+
+	as.mu.Lock()
+	as.secrets = secrets
+	as.mu.Unlock()
+
+	log.Info().Int("secrets", len(secrets)).Msg("auth secrets loaded")
+	return nil
+}
+
+// IsValid compares the secret against all the configured ones in constant
+// time: the loop doesn't stop at the first match.
+func (as *AuthSecretsService) IsValid(secret string) bool {
+	as.mu.RLock()
+	var matched *authSecret
+	for i := range as.secrets {
+		if subtle.ConstantTimeCompare([]byte(secret), as.secrets[i].value) == 1 {
+			matched = &as.secrets[i]
+		}
+	}
+	as.mu.RUnlock()
+
+	if matched == nil {
+		return false
+	}
+	return as.checkExpiry(matched)
+}
+
+// SigningSecret returns the secret with the ID, for verifying the HMAC request
+// signatures, or nil if there is no such secret or it has expired. The ID is
+// public, so the lookup doesn't need to be constant time.
+func (as *AuthSecretsService) SigningSecret(id string) []byte {
+	as.mu.RLock()
+	var matched *authSecret
+	for i := range as.secrets {
+		if as.secrets[i].id == id {
+			matched = &as.secrets[i]
+			break
+		}
+	}
+	as.mu.RUnlock()
+
+	if matched == nil || !as.checkExpiry(matched) {
+		return nil
+	}
+	return matched.value
+}
+
+// IsActive reports whether the secret with the ID is still configured and
+// unexpired, e.g. for the admin UI sessions logged in with it. Unlike
+// SigningSecret, it doesn't count as a use of a deprecated secret.
+func (as *AuthSecretsService) IsActive(id string) bool {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	nowMs := time.Now().UnixMilli()
+	for i := range as.secrets {
+		if as.secrets[i].id == id {
+			return as.secrets[i].expiresAt == 0 || nowMs < as.secrets[i].expiresAt
+		}
+	}
+	return false
+}
+
+// checkExpiry reports whether the secret is still valid, and logs and counts
+// the uses of the deprecated ones.
+func (as *AuthSecretsService) checkExpiry(matched *authSecret) bool {
+	if matched.expiresAt == 0 {
+		return true
+	}
+
+	nowMs := time.Now().UnixMilli()
+	if nowMs >= matched.expiresAt {
+		log.Warn().Str("secret_id", matched.id).Msg("expired auth secret used")
+		return false
+	}
+
+	as.metricsService.IncDeprecatedAuthSecretUsesTotal(matched.id)
+	as.logMu.Lock()
+	if nowMs-as.lastDeprecatedLogMs[matched.id] >= deprecatedSecretLogIntervalMs {
+		as.lastDeprecatedLogMs[matched.id] = nowMs
+		log.Warn().
+			Str("secret_id", matched.id).
+			Time("expires_at", time.UnixMilli(matched.expiresAt)).
+			Msg("deprecated auth secret used, move the client to a newer secret before it expires")
+	}
+	as.logMu.Unlock()
+	return true
+}
+
+func readSecretsFile(path string) ([]authSecret, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open auth secrets file: %w", err)
+	}
+	defer file.Close()
+
+	var secrets []authSecret
+	lineNum := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// the errors don't include the line content, as it is a secret
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("auth secrets file line %d: expected a secret and an optional expiry", lineNum)
+		}
+		if len(fields[0]) < common.MinAuthSecretLength {
+			return nil, fmt.Errorf("auth secrets file line %d: secret is too short, must be at least %d characters", lineNum, common.MinAuthSecretLength)
+		}
+
+		var expiresAt int64
+		if len(fields) == 2 {
+			expiry, err := time.Parse(time.RFC3339, fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("auth secrets file line %d: expiry must be in RFC 3339, e.g. 2026-12-31T23:59:59Z", lineNum)
+			}
+			expiresAt = expiry.UnixMilli()
+		}
+		secrets = append(secrets, newAuthSecret(fields[0], expiresAt))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read auth secrets file: %w", err)
+	}
+	return secrets, nil
+}
+
+func newAuthSecret(value string, expiresAt int64) authSecret {
+	hash := sha256.Sum256([]byte(value))
+	return authSecret{
+		value:     []byte(value),
+		id:        hex.EncodeToString(hash[:])[:authSecretIdLength],
+		expiresAt: expiresAt,
+	}
+}
@@ -0,0 +1,98 @@
+package services_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/metrics"
+	"github.com/n0rdy/forq/services"
+	"github.com/n0rdy/forq/signing"
+)
+
+const (
+	primarySecret    = "primary-secret-that-is-32-chars-long"
+	newSecret        = "new-secret-that-is-32-chars-long!"
+	deprecatedSecret = "deprecated-secret-that-is-32-chars"
+	expiredSecret    = "expired-secret-that-is-32-chars-x"
+)
+
+func writeSecretsFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthSecretsService(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	writeSecretsFile(t, path, "# rotation in progress\n\n"+
+		newSecret+"\n"+
+		deprecatedSecret+" "+future+"\n"+
+		expiredSecret+" "+past+"\n")
+
+	svc, err := services.NewAuthSecretsService(metrics.NewMetricsService(configs.MetricsSettings{}), primarySecret, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{primarySecret, newSecret, deprecatedSecret} {
+		if !svc.IsValid(secret) {
+			t.Errorf("expected %q to be valid", secret)
+		}
+	}
+	for _, secret := range []string{expiredSecret, "", "unknown-secret-that-is-32-chars-long", "# rotation in progress"} {
+		if svc.IsValid(secret) {
+			t.Errorf("expected %q to be invalid", secret)
+		}
+	}
+
+	if !svc.IsActive(signing.KeyId(deprecatedSecret)) || svc.IsActive(signing.KeyId(expiredSecret)) {
+		t.Error("only the unexpired secrets must be active")
+	}
+
+	// the deprecated secret is dropped from the file
+	writeSecretsFile(t, path, newSecret+"\n")
+	if err := svc.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if svc.IsValid(deprecatedSecret) || svc.IsActive(signing.KeyId(deprecatedSecret)) {
+		t.Error("dropped secret is still valid after reload")
+	}
+	if !svc.IsValid(newSecret) || !svc.IsValid(primarySecret) {
+		t.Error("remaining secrets must stay valid after reload")
+	}
+
+	// a broken file keeps the previous secrets in effect
+	writeSecretsFile(t, path, "too-short\n")
+	if err := svc.Reload(); err == nil {
+		t.Fatal("expected an error for a too short secret")
+	}
+	if !svc.IsValid(newSecret) {
+		t.Error("failed reload must keep the previous secrets")
+	}
+}
+
+func TestAuthSecretsService_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	metricsService := metrics.NewMetricsService(configs.MetricsSettings{})
+
+	for name, content := range map[string]string{
+		"bad expiry":   newSecret + " tomorrow\n",
+		"extra fields": newSecret + " 2030-01-01T00:00:00Z extra\n",
+		"no secrets":   "# nothing here\n",
+	} {
+		writeSecretsFile(t, path, content)
+		if _, err := services.NewAuthSecretsService(metricsService, "", path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := services.NewAuthSecretsService(metricsService, "", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file: expected an error")
+	}
+}
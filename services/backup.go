@@ -0,0 +1,236 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/db"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	backupTimeFormat = "20060102T150405Z"
+	gzipExtension    = ".gz"
+)
+
+// backupFileRegex matches the snapshots of the scheduled backups, the only
+// files the retention deletes.
+var backupFileRegex = regexp.MustCompile(`^forq-\d{8}T\d{6}Z\.db(\.gz)?$`)
+
+// BackupService takes the snapshots of the DB, for the scheduled backups, the
+// `forq backup` command and the download via the admin API. Each snapshot is a
+// full copy of the DB on disk, so they are taken one at a time: the others wait
+// until the current one is closed.
+type BackupService struct {
+	forqRepo  *db.ForqRepo
+	dbDir     string
+	dir       string
+	retain    int
+	gzip      bool
+	snapshots chan struct{} // holds the snapshot being taken or read
+}
+
+func NewBackupService(forqRepo *db.ForqRepo, dbPath string, settings configs.BackupSettings) *BackupService {
+	return &BackupService{
+		forqRepo:  forqRepo,
+		dbDir:     filepath.Dir(dbPath),
+		dir:       settings.Dir,
+		retain:    settings.Retain,
+		gzip:      settings.Gzip,
+		snapshots: make(chan struct{}, 1),
+	}
+}
+
+// BackupFileName is the name of the snapshot taken at that time, sortable by
+// the time.
+func BackupFileName(at time.Time, compressed bool) string {
+	name := "forq-" + at.UTC().Format(backupTimeFormat) + ".db"
+	if compressed {
+		name += gzipExtension
+	}
+	return name
+}
+
+// Snapshot is a consistent copy of the DB in a temp file, which is removed on
+// Close.
+type Snapshot struct {
+	file    *os.File
+	release func()
+}
+
+// Snapshot takes the snapshot next to the DB, rather than in the temp dir,
+// which is often a small tmpfs.
+func (bs *BackupService) Snapshot(ctx context.Context) (*Snapshot, error) {
+	return bs.snapshot(bs.dbDir, ctx)
+}
+
+func (bs *BackupService) snapshot(dir string, ctx context.Context) (*Snapshot, error) {
+	select {
+	case bs.snapshots <- struct{}{}:
+	case <-ctx.Done():
+		log.Warn().Err(ctx.Err()).Msg("gave up waiting for the previous backup to finish")
+		return nil, common.ErrInternal
+	}
+	release := func() { <-bs.snapshots }
+
+	// VACUUM INTO takes an empty file too, and the temp one is only readable by the owner
+	file, err := os.CreateTemp(dir, ".forq-backup-*.db")
+	if err != nil {
+		release()
+		log.Error().Err(err).Str("dir", dir).Msg("failed to create the backup file")
+		return nil, common.ErrInternal
+	}
+	snapshot := &Snapshot{file: file, release: release}
+
+	err = bs.forqRepo.Backup(file.Name(), ctx)
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// WriteTo writes the snapshot into w, gzip-compressed if asked.
+func (s *Snapshot) WriteTo(w io.Writer, compress bool) error {
+	// VACUUM INTO writes through its own handle, so the file is read from the start
+	_, err := s.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	if !compress {
+		_, err = io.Copy(w, s.file)
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	_, err = io.Copy(gw, s.file)
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (s *Snapshot) Close() error {
+	defer s.release()
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// BackupToFile writes the snapshot into path, gzip-compressed if it ends with
+// ".gz". The snapshot is taken next to it and only renamed once complete and
+// synced, along with the dir after the rename, so that neither a failed backup
+// nor a crash right after a successful one leaves a partial file behind.
+func (bs *BackupService) BackupToFile(path string, ctx context.Context) error {
+	dir := filepath.Dir(path)
+	snapshot, err := bs.snapshot(dir, ctx)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	if filepath.Ext(path) != gzipExtension {
+		// VACUUM INTO doesn't sync the file it writes
+		err = snapshot.file.Sync()
+		if err == nil {
+			err = os.Rename(snapshot.file.Name(), path)
+		}
+		if err == nil {
+			err = syncDir(dir)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("failed to move the backup file")
+			return common.ErrInternal
+		}
+		return nil
+	}
+
+	compressed, err := os.CreateTemp(dir, ".forq-backup-*.db.gz")
+	if err != nil {
+		log.Error().Err(err).Str("dir", dir).Msg("failed to create the compressed backup file")
+		return common.ErrInternal
+	}
+	defer os.Remove(compressed.Name())
+	err = snapshot.WriteTo(compressed, true)
+	if err == nil {
+		err = compressed.Sync()
+	}
+	if closeErr := compressed.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(compressed.Name(), path)
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("failed to write the compressed backup file")
+		return common.ErrInternal
+	}
+	return nil
+}
+
+// syncDir persists the renames in the dir.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// ScheduledBackup writes a snapshot into the backups dir, and deletes the
+// snapshots beyond the retained ones. Returns the path of the snapshot.
+func (bs *BackupService) ScheduledBackup(ctx context.Context) (string, error) {
+	err := os.MkdirAll(bs.dir, 0o700)
+	if err != nil {
+		log.Error().Err(err).Str("dir", bs.dir).Msg("failed to create the backups dir")
+		return "", common.ErrInternal
+	}
+
+	path := filepath.Join(bs.dir, BackupFileName(time.Now(), bs.gzip))
+	err = bs.BackupToFile(path, ctx)
+	if err != nil {
+		return "", err
+	}
+	return path, bs.deleteOldBackups()
+}
+
+func (bs *BackupService) deleteOldBackups() error {
+	entries, err := os.ReadDir(bs.dir)
+	if err != nil {
+		log.Error().Err(err).Str("dir", bs.dir).Msg("failed to list the backups")
+		return common.ErrInternal
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && backupFileRegex.MatchString(entry.Name()) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	if len(backups) <= bs.retain {
+		return nil
+	}
+	// the names sort by the time, the oldest first
+	slices.Sort(backups)
+	for _, name := range backups[:len(backups)-bs.retain] {
+		err := os.Remove(filepath.Join(bs.dir, name))
+		if err != nil {
+			log.Error().Err(err).Str("backup", name).Msg("failed to delete the old backup")
+			return common.ErrInternal
+		}
+		log.Debug().Str("backup", name).Msg("old backup deleted")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package services_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/metrics"
+	"github.com/n0rdy/forq/services"
+)
+
+func newBackupService(t *testing.T, settings configs.BackupSettings) *services.BackupService {
+	t.Helper()
+	messagesService, _, repo := newServicesWithRepo(t, metrics.NewMetricsService(configs.MetricsSettings{}))
+	if err := messagesService.ProcessNewMessage(common.NewMessageRequest{Content: "backed up"}, "orders", context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return services.NewBackupService(repo, filepath.Join(t.TempDir(), "forq.db"), settings)
+}
+
+func countMessages(t *testing.T, path string) int {
+	t.Helper()
+	snapshot, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Close()
+	var count int
+	if err := snapshot.QueryRow("SELECT COUNT(*) FROM messages WHERE content = 'backed up'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestBackupService_ScheduledBackup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	settings := configs.DefaultSettings().Backup
+	settings.Dir = dir
+	settings.Retain = 2
+	bs := newBackupService(t, settings)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"forq-20200101T000000Z.db", "forq-20200102T000000Z.db.gz", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := bs.ScheduledBackup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := countMessages(t, path); count != 1 {
+		t.Errorf("messages in the backup = %d, want 1", count)
+	}
+
+	// the oldest snapshot is deleted, the other files are left alone
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"forq-20200102T000000Z.db.gz", filepath.Base(path), "notes.txt"}
+	if !slices.Equal(names, want) {
+		t.Errorf("backups dir = %v, want %v", names, want)
+	}
+}
+
+func TestBackupService_BackupToFileCompressed(t *testing.T) {
+	bs := newBackupService(t, configs.DefaultSettings().Backup)
+	path := filepath.Join(t.TempDir(), "forq.db.gz")
+
+	if err := bs.BackupToFile(path, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte("SQLite format 3\x00")) {
+		t.Fatal("not an SQLite database")
+	}
+	uncompressed := filepath.Join(t.TempDir(), "forq.db")
+	if err := os.WriteFile(uncompressed, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if count := countMessages(t, uncompressed); count != 1 {
+		t.Errorf("messages in the backup = %d, want 1", count)
+	}
+
+	// no temp files are left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".forq-backup-") {
+			t.Errorf("temp file %s left", entry.Name())
+		}
+	}
+}
+
+func TestBackupService_SnapshotsOneAtATime(t *testing.T) {
+	bs := newBackupService(t, configs.DefaultSettings().Backup)
+
+	snapshot, err := bs.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := bs.Snapshot(ctx); err == nil {
+		t.Fatal("second snapshot taken while the first one is open")
+	}
+
+	snapshot.Close()
+	next, err := bs.Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("snapshot after the previous one is closed: %v", err)
+	}
+	next.Close()
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/n0rdy/forq/configs"
+
+	"github.com/rs/zerolog/log"
+)
+
+// releaseClaimsTimeoutMs bounds the release of the claims, as it runs right
+// before the servers stop.
+const releaseClaimsTimeoutMs = 5 * 1000
+
+// DrainService takes the instance out of the rotation before it stops, on
+// shutdown or on the operator's request: /readyz fails and no messages are
+// handed out from then on, while the consumers have the grace period to
+// ack/nack the messages they hold. There is no way back from the drain, but
+// a restart.
+type DrainService struct {
+	monitoringService *MonitoringService
+	messagesService   *MessagesService
+	gracePeriod       time.Duration
+	releaseClaims     bool
+
+	once   sync.Once
+	doneCh chan struct{}
+}
+
+func NewDrainService(monitoringService *MonitoringService, messagesService *MessagesService, settings configs.HealthSettings) *DrainService {
+	return &DrainService{
+		monitoringService: monitoringService,
+		messagesService:   messagesService,
+		gracePeriod:       settings.ShutdownDrain,
+		releaseClaims:     settings.ShutdownReleaseClaims,
+		doneCh:            make(chan struct{}),
+	}
+}
+
+// Drain starts the drain, unless it's started already, which it tells.
+func (ds *DrainService) Drain() (started bool) {
+	ds.once.Do(func() {
+		started = true
+		log.Info().Dur("grace_period", ds.gracePeriod).Bool("release_claims", ds.releaseClaims).Msg("draining")
+
+		ds.monitoringService.Draining()
+		ds.messagesService.Drain()
+		go ds.finish()
+	})
+	return started
+}
+
+// Done is closed once the grace period is over and the claims are released.
+func (ds *DrainService) Done() <-chan struct{} {
+	return ds.doneCh
+}
+
+func (ds *DrainService) finish() {
+	defer close(ds.doneCh)
+	time.Sleep(ds.gracePeriod)
+
+	if !ds.releaseClaims {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), releaseClaimsTimeoutMs*time.Millisecond)
+	defer cancel()
+	released, err := ds.messagesService.ReleaseClaims(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to release the claimed messages")
+		return
+	}
+	log.Info().Int64("released", released).Msg("claimed messages released")
+}
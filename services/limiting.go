@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/n0rdy/forq/db"
+)
+
+// DeliveryLimits caps how fast the messages of a queue are handed out to
+// consumers. Zero values mean unlimited.
+type DeliveryLimits struct {
+	RatePerSec  float64
+	Burst       int
+	MaxInFlight int
+}
+
+type tokenBucket struct {
+	limits   DeliveryLimits
+	tokens   float64
+	lastMs   int64
+	capacity float64
+}
+
+// LimitingService enforces the per-queue delivery limits. The limits are
+// persisted in the DB and cached here, as they are checked on every consume
+// poll. Forq is a single process, so updating the cache on every write keeps
+// it in sync. The token buckets are in-memory only: a process restart refills
+// them.
+type LimitingService struct {
+	buckets map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+func NewLimitingService(forqRepo *db.ForqRepo) (*LimitingService, error) {
+	ls := &LimitingService{
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	queuesSettings, err := forqRepo.SelectQueuesWithDeliveryLimits(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for _, settings := range queuesSettings {
+		ls.SetLimits(settings.QueueName, deliveryLimitsFromDb(&settings))
+	}
+	return ls, nil
+}
+
+// SetLimits replaces the limits of the queue. A new token bucket starts full,
+// while an existing one keeps its tokens, capped at the new burst: otherwise
+// every update, e.g. a config reload, would hand the consumers a fresh burst
+// above the rate.
+func (ls *LimitingService) SetLimits(queueName string, limits DeliveryLimits) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if limits.RatePerSec <= 0 && limits.MaxInFlight <= 0 {
+		delete(ls.buckets, queueName)
+		return
+	}
+
+	capacity := float64(limits.Burst)
+	if capacity <= 0 {
+		capacity = math.Max(1, math.Ceil(limits.RatePerSec))
+	}
+	nowMs := time.Now().UnixMilli()
+
+	b, ok := ls.buckets[queueName]
+	if !ok {
+		ls.buckets[queueName] = &tokenBucket{
+			limits:   limits,
+			tokens:   capacity,
+			lastMs:   nowMs,
+			capacity: capacity,
+		}
+		return
+	}
+	// the tokens earned so far are at the old rate
+	b.refill(nowMs)
+	b.limits = limits
+	b.capacity = capacity
+	b.tokens = math.Min(b.tokens, capacity)
+}
+
+func (ls *LimitingService) MaxInFlight(queueName string) int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	b, ok := ls.buckets[queueName]
+	if !ok {
+		return 0
+	}
+	return b.limits.MaxInFlight
+}
+
+// TryAcquire takes a delivery token of the queue. If there is none, it returns
+// false and how long to wait for the next one.
+func (ls *LimitingService) TryAcquire(queueName string) (bool, time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	b, ok := ls.buckets[queueName]
+	if !ok || b.limits.RatePerSec <= 0 {
+		return true, 0
+	}
+
+	b.refill(time.Now().UnixMilli())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	waitMs := math.Ceil((1 - b.tokens) * 1000 / b.limits.RatePerSec)
+	return false, time.Duration(waitMs) * time.Millisecond
+}
+
+// Refund gives back a token taken by TryAcquire that wasn't used, e.g. the
+// queue turned out to be empty.
+func (ls *LimitingService) Refund(queueName string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	b, ok := ls.buckets[queueName]
+	if !ok || b.limits.RatePerSec <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.capacity, b.tokens+1)
+}
+
+func (b *tokenBucket) refill(nowMs int64) {
+	elapsedMs := nowMs - b.lastMs
+	if elapsedMs <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.capacity, b.tokens+float64(elapsedMs)*b.limits.RatePerSec/1000)
+	b.lastMs = nowMs
+}
+
+func deliveryLimitsFromDb(settings *db.QueueSettings) DeliveryLimits {
+	var limits DeliveryLimits
+	if settings.DeliveryRatePerSec != nil {
+		limits.RatePerSec = *settings.DeliveryRatePerSec
+	}
+	if settings.DeliveryBurst != nil {
+		limits.Burst = *settings.DeliveryBurst
+	}
+	if settings.MaxInFlight != nil {
+		limits.MaxInFlight = *settings.MaxInFlight
+	}
+	return limits
+}
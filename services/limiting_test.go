@@ -0,0 +1,40 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/services"
+)
+
+func TestLimitingService_UpdateKeepsTokens(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ls, err := services.NewLimitingService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := services.DeliveryLimits{RatePerSec: 0.1, Burst: 3}
+	ls.SetLimits("orders", limits)
+	for i := range limits.Burst {
+		if ok, _ := ls.TryAcquire("orders"); !ok {
+			t.Fatalf("token %d of the burst not acquired", i+1)
+		}
+	}
+
+	// re-applying the same limits, e.g. on a config reload, doesn't refill the bucket
+	ls.SetLimits("orders", limits)
+	if ok, _ := ls.TryAcquire("orders"); ok {
+		t.Fatal("the update refilled the bucket")
+	}
+
+	// a lower burst caps the tokens left
+	ls.SetLimits("emails", limits)
+	ls.SetLimits("emails", services.DeliveryLimits{RatePerSec: 0.1, Burst: 1})
+	if ok, _ := ls.TryAcquire("emails"); !ok {
+		t.Fatal("no token left after lowering the burst")
+	}
+	if ok, _ := ls.TryAcquire("emails"); ok {
+		t.Fatal("more tokens than the new burst")
+	}
+}
@@ -2,39 +2,66 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/n0rdy/forq/common"
 	"github.com/n0rdy/forq/configs"
 	"github.com/n0rdy/forq/db"
 	"github.com/n0rdy/forq/metrics"
+	"github.com/n0rdy/forq/tracing"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	processAfterBufferMs = 10 * 1000 // 10 seconds buffer for process_after in case of clock skew or network delays
+	maxRedriveRatePerSec = 10_000    // above this the staggering is below 1ms per message, so it is as good as unlimited
+	pollingIntervalMs    = 500       // how often a long poll re-checks an empty queue
+	minClaimsPruneSize   = 1024      // the tracked claims are pruned of the stale ones once there are that many
 )
 
 type MessagesService struct {
-	metricsService metrics.Service
-	forqRepo       *db.ForqRepo
-	appConfigs     *configs.AppConfigs
+	metricsService  metrics.Service
+	forqRepo        *db.ForqRepo
+	limitingService *LimitingService
+	quotasService   *QuotasService
+	appConfigs      *configs.AppConfigs
+
+	draining  atomic.Bool
+	drainedCh chan struct{} // closed on Drain, to end the long polls right away
+
+	// the messages handed out by this instance and not acked/nacked yet, so
+	// that they can be released on drain. Keyed by the message ID.
+	claimsMu        sync.Mutex
+	claims          map[string]db.ClaimedMessage
+	claimsPruneSize int
 }
 
-func NewMessagesService(metricsService metrics.Service, forqRepo *db.ForqRepo, appConfigs *configs.AppConfigs) *MessagesService {
+func NewMessagesService(metricsService metrics.Service, forqRepo *db.ForqRepo, limitingService *LimitingService, quotasService *QuotasService, appConfigs *configs.AppConfigs) *MessagesService {
 	return &MessagesService{
-		metricsService: metricsService,
-		forqRepo:       forqRepo,
-		appConfigs:     appConfigs,
+		metricsService:  metricsService,
+		forqRepo:        forqRepo,
+		limitingService: limitingService,
+		quotasService:   quotasService,
+		appConfigs:      appConfigs,
+		drainedCh:       make(chan struct{}),
+		claims:          make(map[string]db.ClaimedMessage),
+		claimsPruneSize: minClaimsPruneSize,
 	}
 }
 
-func (ms *MessagesService) ProcessNewMessage(newMessage common.NewMessageRequest, queueName string, ctx context.Context) error {
+func (ms *MessagesService) ProcessNewMessage(newMessage common.NewMessageRequest, queueName string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.ProcessNewMessage", attribute.String("forq.queue", queueName))
+	defer func() { tracing.End(span, err) }()
+
 	// producing directly into a "-dlq" queue would create rows with the DLQ
 	// suffix but is_dlq = FALSE, confusing the dashboard/queue-page/DLQ-move
 	// logic (and a 5x failure would mint "foo-dlq-dlq"). DLQ messages are
@@ -44,7 +71,8 @@ func (ms *MessagesService) ProcessNewMessage(newMessage common.NewMessageRequest
 		return common.ErrBadRequestProduceToDlq
 	}
 
-	if len(newMessage.Content) > ms.appConfigs.MessageContentMaxSizeBytes {
+	limits := ms.appConfigs.Limits()
+	if len(newMessage.Content) > limits.MessageContentMaxSizeBytes {
 		log.Error().Int("size", len(newMessage.Content)).Msg("message content exceeds limit")
 		return common.ErrBadRequestContentExceedsLimit
 	}
@@ -59,7 +87,7 @@ func (ms *MessagesService) ProcessNewMessage(newMessage common.NewMessageRequest
 			log.Error().Int64("process_after", newMessage.ProcessAfter).Msg("process_after is in the past")
 			return common.ErrBadRequestProcessAfterInPast
 		}
-		if newMessage.ProcessAfter > nowMs+ms.appConfigs.MaxProcessAfterDelayMs {
+		if newMessage.ProcessAfter > nowMs+limits.MaxProcessAfterDelayMs {
 			log.Error().Int64("process_after", newMessage.ProcessAfter).Msg("process_after is too far in the future")
 			return common.ErrBadRequestProcessAfterTooFar
 		}
@@ -79,34 +107,82 @@ func (ms *MessagesService) ProcessNewMessage(newMessage common.NewMessageRequest
 		ProcessAfter: processAfter,
 		ReceivedAt:   nowMs,
 		UpdatedAt:    nowMs,
-		ExpiresAfter: processAfter + ms.appConfigs.QueueTtlMs,
+		ExpiresAfter: processAfter + limits.QueueTtlMs,
+	}
+	// the span of this call if traced, or the producer's one passed through
+	if traceparent := tracing.Traceparent(ctx); traceparent != "" {
+		messageToInsert.Traceparent = &traceparent
+	}
+
+	contentBytes := int64(len(newMessage.Content))
+	err = ms.quotasService.Reserve(queueName, contentBytes)
+	if err != nil {
+		log.Warn().Str("queue", queueName).Str("quota", err.Error()).Msg("message rejected, quota exceeded")
+		return err
 	}
 
 	err = ms.forqRepo.InsertMessage(&messageToInsert, ctx)
 	if err != nil {
+		ms.quotasService.Release(queueName, contentBytes)
 		return err
 	}
+	ms.quotasService.Commit(queueName, contentBytes)
 	ms.metricsService.IncMessagesProducedTotalBy(1, queueName)
 	return nil
 }
 
-func (ms *MessagesService) GetMessageForConsuming(queueName string, ctx context.Context) (*common.MessageResponse, error) {
+// GetMessageForConsuming long polls the queue for a message. If the queue has
+// delivery limits, the poll waits for a free delivery token and in-flight slot
+// instead of failing, so consumers don't have to implement their own limiters.
+// While draining, no message is handed out: the new polls fail, and the
+// ongoing ones return no message.
+func (ms *MessagesService) GetMessageForConsuming(queueName string, ctx context.Context) (_ *common.MessageResponse, err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.GetMessageForConsuming", attribute.String("forq.queue", queueName))
+	defer func() { tracing.End(span, err) }()
+
+	if ms.draining.Load() {
+		return nil, common.ErrServiceDraining
+	}
+
 	start := time.Now()
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+	// Reset is safe on an active timer since Go 1.23: no stale tick is delivered
+	timer := time.NewTimer(pollingIntervalMs * time.Millisecond)
+	defer timer.Stop()
 
 	for {
-		message, err := ms.forqRepo.SelectMessageForConsuming(queueName, ctx)
-		if err != nil {
-			return nil, err
+		waitFor := pollingIntervalMs * time.Millisecond
+
+		if ms.draining.Load() {
+			return nil, nil
 		}
-		if message != nil {
-			ms.metricsService.IncMessagesConsumedTotalBy(1, queueName)
-			return &common.MessageResponse{
-				Id:      message.Id,
-				Content: message.Content,
-				Receipt: strconv.FormatInt(message.ProcessingStartedAt, 10),
-			}, nil
+
+		acquired, tokenWait := ms.limitingService.TryAcquire(queueName)
+		if acquired {
+			message, err := ms.forqRepo.SelectMessageForConsuming(queueName, ms.limitingService.MaxInFlight(queueName), ctx)
+			if err != nil {
+				ms.limitingService.Refund(queueName)
+				return nil, err
+			}
+			if message != nil {
+				ms.trackClaim(db.ClaimedMessage{Id: message.Id, QueueName: queueName, Receipt: message.ProcessingStartedAt})
+				ms.metricsService.IncMessagesConsumedTotalBy(1, queueName)
+				ms.metricsService.ObserveMessageWaitDuration(queueName, time.Duration(message.ProcessingStartedAt-message.ProcessAfter)*time.Millisecond)
+				resp := &common.MessageResponse{
+					Id:      message.Id,
+					Content: message.Content,
+					Receipt: strconv.FormatInt(message.ProcessingStartedAt, 10),
+				}
+				span.SetAttributes(attribute.String("forq.message_id", message.Id))
+				if message.Traceparent != nil {
+					resp.Traceparent = *message.Traceparent
+					tracing.LinkTo(span, resp.Traceparent)
+				}
+				return resp, nil
+			}
+			// the queue is empty or at its max in-flight: the token wasn't used
+			ms.limitingService.Refund(queueName)
+		} else if tokenWait < waitFor {
+			waitFor = tokenWait
 		}
 
 		// no message found, check if we should keep polling. Return nil if polling duration exceeded
@@ -114,9 +190,12 @@ func (ms *MessagesService) GetMessageForConsuming(queueName string, ctx context.
 			return nil, nil
 		}
 
+		timer.Reset(waitFor)
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 			// continue polling
+		case <-ms.drainedCh:
+			return nil, nil
 		case <-ctx.Done():
 			// client disconnected or request timed out - normal for long polling, not an error
 			return nil, nil
@@ -124,27 +203,38 @@ func (ms *MessagesService) GetMessageForConsuming(queueName string, ctx context.
 	}
 }
 
-func (ms *MessagesService) AckMessage(messageId string, queueName string, receipt string, ctx context.Context) error {
+func (ms *MessagesService) AckMessage(messageId string, queueName string, receipt string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.AckMessage", attribute.String("forq.queue", queueName), attribute.String("forq.message_id", messageId))
+	defer func() { tracing.End(span, err) }()
+
 	parsedReceipt, err := ms.parseReceipt(receipt)
 	if err != nil {
 		return err
 	}
 
-	err = ms.forqRepo.DeleteMessageOnAck(messageId, queueName, parsedReceipt, ctx)
+	attempts, err := ms.forqRepo.DeleteMessageOnAck(messageId, queueName, parsedReceipt, ctx)
+	ms.untrackClaim(messageId, parsedReceipt, err)
 	if err != nil {
 		return err
 	}
 	ms.metricsService.IncMessagesAckedTotalBy(1, queueName)
+	// the receipt is the processing_started_at of the delivery
+	ms.metricsService.ObserveMessageProcessingDuration(queueName, time.Since(time.UnixMilli(parsedReceipt)))
+	ms.metricsService.ObserveMessageAttemptsAtAck(queueName, attempts)
 	return nil
 }
 
-func (ms *MessagesService) NackMessage(messageId string, queueName string, receipt string, ctx context.Context) error {
+func (ms *MessagesService) NackMessage(messageId string, queueName string, receipt string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.NackMessage", attribute.String("forq.queue", queueName), attribute.String("forq.message_id", messageId))
+	defer func() { tracing.End(span, err) }()
+
 	parsedReceipt, err := ms.parseReceipt(receipt)
 	if err != nil {
 		return err
 	}
 
 	err = ms.forqRepo.UpdateMessageOnConsumingFailure(messageId, queueName, parsedReceipt, ctx)
+	ms.untrackClaim(messageId, parsedReceipt, err)
 	if err != nil {
 		return err
 	}
@@ -152,6 +242,62 @@ func (ms *MessagesService) NackMessage(messageId string, queueName string, recei
 	return nil
 }
 
+// Drain stops handing out the messages, for good: the ack/nack keep working.
+func (ms *MessagesService) Drain() {
+	if ms.draining.CompareAndSwap(false, true) {
+		close(ms.drainedCh)
+	}
+}
+
+// ReleaseClaims makes the messages handed out by this instance and not
+// acked/nacked yet ready again right away, rather than after the max
+// processing time. Meant for the end of the drain: a consumer still processing
+// one of them will fail to ack/nack it. Returns how many were released.
+func (ms *MessagesService) ReleaseClaims(ctx context.Context) (int64, error) {
+	ms.claimsMu.Lock()
+	claims := make([]db.ClaimedMessage, 0, len(ms.claims))
+	for _, claim := range ms.claims {
+		claims = append(claims, claim)
+	}
+	clear(ms.claims)
+	ms.claimsMu.Unlock()
+
+	return ms.forqRepo.ReleaseMessages(claims, ctx)
+}
+
+// trackClaim prunes the claims that are stale by now, once they are twice as
+// many as after the last pruning: their consumers are likely gone, and the
+// stale messages recovery takes care of them.
+func (ms *MessagesService) trackClaim(claim db.ClaimedMessage) {
+	ms.claimsMu.Lock()
+	defer ms.claimsMu.Unlock()
+
+	ms.claims[claim.Id] = claim
+	if len(ms.claims) < ms.claimsPruneSize {
+		return
+	}
+	staleBefore := time.Now().UnixMilli() - ms.appConfigs.Limits().MaxProcessingTimeMs
+	for id, c := range ms.claims {
+		if c.Receipt < staleBefore {
+			delete(ms.claims, id)
+		}
+	}
+	ms.claimsPruneSize = max(minClaimsPruneSize, 2*len(ms.claims))
+}
+
+// untrackClaim forgets the claim once the ack/nack went through, or the
+// message is not in processing with this receipt anymore.
+func (ms *MessagesService) untrackClaim(messageId string, receipt int64, err error) {
+	if err != nil && !errors.Is(err, common.ErrNotFoundMessage) {
+		return
+	}
+	ms.claimsMu.Lock()
+	defer ms.claimsMu.Unlock()
+	if claim, ok := ms.claims[messageId]; ok && claim.Receipt == receipt {
+		delete(ms.claims, messageId)
+	}
+}
+
 // parseReceipt validates the delivery receipt echoed back by the consumer.
 // A missing receipt gets a distinct error code, as it is the loud signal of an
 // outdated SDK/client rather than a malformed value.
@@ -166,27 +312,34 @@ func (ms *MessagesService) parseReceipt(receipt string) (int64, error) {
 	return parsed, nil
 }
 
-func (ms *MessagesService) RequeueAllDlqMessages(queueName string, ctx context.Context) error {
+// RequeueAllDlqMessages returns the number of messages requeued.
+func (ms *MessagesService) RequeueAllDlqMessages(queueName string, ctx context.Context) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.RequeueAllDlqMessages", attribute.String("forq.queue", queueName))
+	defer func() { tracing.End(span, err) }()
+
 	if !strings.HasSuffix(queueName, common.DlqSuffix) {
 		log.Error().Str("queue", queueName).Msg("attempt to requeue non-DLQ queue: only DLQ queues are supported for requeueing")
-		return common.ErrBadRequestDlqOnlyOp
+		return 0, common.ErrBadRequestDlqOnlyOp
 	}
 
 	rowsAffected, err := ms.forqRepo.RequeueDlqMessages(queueName, ctx)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	ms.metricsService.IncMessagesRequeuedTotalBy(rowsAffected, queueName)
-	return nil
+	return rowsAffected, nil
 }
 
-func (ms *MessagesService) RequeueDlqMessage(messageId string, queueName string, ctx context.Context) error {
+func (ms *MessagesService) RequeueDlqMessage(messageId string, queueName string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.RequeueDlqMessage", attribute.String("forq.queue", queueName), attribute.String("forq.message_id", messageId))
+	defer func() { tracing.End(span, err) }()
+
 	if !strings.HasSuffix(queueName, common.DlqSuffix) {
 		log.Error().Str("queue", queueName).Msg("attempt to requeue non-DLQ queue: only DLQ queues are supported for requeueing")
 		return common.ErrBadRequestDlqOnlyOp
 	}
 
-	err := ms.forqRepo.RequeueDlqMessage(messageId, queueName, ctx)
+	err = ms.forqRepo.RequeueDlqMessage(messageId, queueName, ctx)
 	if err != nil {
 		return err
 	}
@@ -194,27 +347,76 @@ func (ms *MessagesService) RequeueDlqMessage(messageId string, queueName string,
 	return nil
 }
 
-func (ms *MessagesService) DeleteAllDlqMessages(queueName string, ctx context.Context) error {
+func (ms *MessagesService) RedriveDlqMessages(queueName string, redriveReq common.RedriveRequest, ctx context.Context) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.RedriveDlqMessages", attribute.String("forq.queue", queueName))
+	defer func() { tracing.End(span, err) }()
+
+	if !strings.HasSuffix(queueName, common.DlqSuffix) {
+		log.Error().Str("queue", queueName).Msg("attempt to redrive non-DLQ queue: only DLQ queues are supported for redriving")
+		return 0, common.ErrBadRequestDlqOnlyOp
+	}
+	// same reasoning as for producing: a DLQ can only be filled via the
+	// failure/expiry paths, so it can't be a redrive target either
+	if !common.IsValidQueueName(redriveReq.TargetQueue) || strings.HasSuffix(redriveReq.TargetQueue, common.DlqSuffix) {
+		log.Error().Str("queue", queueName).Str("target_queue", redriveReq.TargetQueue).Msg("invalid redrive target queue")
+		return 0, common.ErrBadRequestInvalidTargetQueue
+	}
+	if redriveReq.FailureReason != "" && !common.SupportedFailureReasons[redriveReq.FailureReason] {
+		log.Error().Str("failure_reason", redriveReq.FailureReason).Msg("unsupported failure reason for redrive")
+		return 0, common.ErrBadRequestInvalidFailReason
+	}
+	if redriveReq.RatePerSecond < 0 || redriveReq.RatePerSecond > maxRedriveRatePerSec {
+		log.Error().Int("rate_per_second", redriveReq.RatePerSecond).Msg("invalid redrive rate")
+		return 0, common.ErrBadRequestInvalidRate
+	}
+
+	var spacingMs float64
+	if redriveReq.RatePerSecond > 0 {
+		spacingMs = 1000.0 / float64(redriveReq.RatePerSecond)
+	}
+
+	rowsAffected, err := ms.forqRepo.RedriveDlqMessages(queueName, &db.RedriveFilter{
+		TargetQueueName: redriveReq.TargetQueue,
+		FailureReason:   redriveReq.FailureReason,
+		ReceivedAfter:   redriveReq.ReceivedAfter,
+		ReceivedBefore:  redriveReq.ReceivedBefore,
+		SpacingMs:       spacingMs,
+	}, ctx)
+	if err != nil {
+		return 0, err
+	}
+	ms.metricsService.IncMessagesRequeuedTotalBy(rowsAffected, redriveReq.TargetQueue)
+	return rowsAffected, nil
+}
+
+// DeleteAllDlqMessages returns the number of messages deleted.
+func (ms *MessagesService) DeleteAllDlqMessages(queueName string, ctx context.Context) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.DeleteAllDlqMessages", attribute.String("forq.queue", queueName))
+	defer func() { tracing.End(span, err) }()
+
 	if !strings.HasSuffix(queueName, common.DlqSuffix) {
 		log.Error().Str("queue", queueName).Msg("attempt to delete non-DLQ queue: only DLQ queues are supported for deleting all messages")
-		return common.ErrBadRequestDlqOnlyOp
+		return 0, common.ErrBadRequestDlqOnlyOp
 	}
 
 	rowsAffected, err := ms.forqRepo.DeleteAllMessagesFromQueue(queueName, ctx)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	ms.metricsService.IncMessagesCleanupTotalBy(rowsAffected, metrics.DeletedByUserCleanupReason)
-	return nil
+	return rowsAffected, nil
 }
 
-func (ms *MessagesService) DeleteDlqMessage(messageId string, queueName string, ctx context.Context) error {
+func (ms *MessagesService) DeleteDlqMessage(messageId string, queueName string, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.DeleteDlqMessage", attribute.String("forq.queue", queueName), attribute.String("forq.message_id", messageId))
+	defer func() { tracing.End(span, err) }()
+
 	if !strings.HasSuffix(queueName, common.DlqSuffix) {
 		log.Error().Str("queue", queueName).Msg("attempt to delete non-DLQ queue: only DLQ queues are supported for deleting messages")
 		return common.ErrBadRequestDlqOnlyOp
 	}
 
-	err := ms.forqRepo.DeleteMessageFromDlq(messageId, queueName, ctx)
+	err = ms.forqRepo.DeleteMessageFromDlq(messageId, queueName, ctx)
 	if err != nil {
 		return err
 	}
@@ -222,7 +424,10 @@ func (ms *MessagesService) DeleteDlqMessage(messageId string, queueName string,
 	return nil
 }
 
-func (ms *MessagesService) GetMessagesForUI(queueName string, cursor string, limit int, ctx context.Context) (*common.MessagesComponentData, error) {
+func (ms *MessagesService) GetMessagesForUI(queueName string, cursor string, limit int, ctx context.Context) (_ *common.MessagesComponentData, err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.GetMessagesForUI", attribute.String("forq.queue", queueName))
+	defer func() { tracing.End(span, err) }()
+
 	// fetches limit+1 to check if there are more messages
 	dbMessages, err := ms.forqRepo.SelectMessagesForUI(queueName, cursor, limit+1, ctx)
 	if err != nil {
@@ -258,7 +463,10 @@ func (ms *MessagesService) GetMessagesForUI(queueName string, cursor string, lim
 	}, nil
 }
 
-func (ms *MessagesService) GetMessageDetails(messageId string, queueName string, ctx context.Context) (*common.MessageDetails, error) {
+func (ms *MessagesService) GetMessageDetails(messageId string, queueName string, ctx context.Context) (_ *common.MessageDetails, err error) {
+	ctx, span := tracing.Start(ctx, "MessagesService.GetMessageDetails", attribute.String("forq.queue", queueName), attribute.String("forq.message_id", messageId))
+	defer func() { tracing.End(span, err) }()
+
 	dbMessage, err := ms.forqRepo.SelectMessageDetails(messageId, queueName, ctx)
 	if err != nil {
 		return nil, err
@@ -277,6 +485,11 @@ func (ms *MessagesService) GetMessageDetails(messageId string, queueName string,
 		failureReason = *dbMessage.FailureReason
 	}
 
+	originQueue := ""
+	if dbMessage.OriginQueue != nil {
+		originQueue = *dbMessage.OriginQueue
+	}
+
 	return &common.MessageDetails{
 		ID:                  dbMessage.Id,
 		Content:             dbMessage.Content,
@@ -287,6 +500,7 @@ func (ms *MessagesService) GetMessageDetails(messageId string, queueName string,
 		ProcessAfter:        ms.formatTimestamp(dbMessage.ProcessAfter),
 		ProcessingStartedAt: processingStartedAt,
 		FailureReason:       failureReason,
+		OriginQueue:         originQueue,
 		UpdatedAt:           ms.formatTimestamp(dbMessage.UpdatedAt),
 	}, nil
 }
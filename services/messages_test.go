@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/db"
 	"github.com/n0rdy/forq/internal/testutil"
 	"github.com/n0rdy/forq/metrics"
 	"github.com/n0rdy/forq/services"
@@ -15,10 +17,39 @@ import (
 
 func newMessagesService(t *testing.T) *services.MessagesService {
 	t.Helper()
-	repo, appConfigs, _ := testutil.NewTestRepo(t)
+	svc, _ := newServices(t)
+	return svc
+}
+
+func newServices(t *testing.T) (*services.MessagesService, *services.QueuesService) {
+	t.Helper()
 	// metrics disabled -> noop implementation, avoids duplicate Prometheus
 	// registration across tests
-	return services.NewMessagesService(metrics.NewMetricsService(false), repo, appConfigs)
+	return newServicesWithMetrics(t, metrics.NewMetricsService(configs.MetricsSettings{}))
+}
+
+func newServicesWithMetrics(t *testing.T, metricsService metrics.Service) (*services.MessagesService, *services.QueuesService) {
+	t.Helper()
+	messagesService, queuesService, _ := newServicesWithRepo(t, metricsService)
+	return messagesService, queuesService
+}
+
+// newServicesWithRepo also returns the repo the services use, for the services
+// built on top of them.
+func newServicesWithRepo(t *testing.T, metricsService metrics.Service) (*services.MessagesService, *services.QueuesService, *db.ForqRepo) {
+	t.Helper()
+	repo, appConfigs, _ := testutil.NewTestRepo(t)
+	limitingService, err := services.NewLimitingService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotasService, err := services.NewQuotasService(metricsService, repo, appConfigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { quotasService.Close() })
+	messagesService := services.NewMessagesService(metricsService, repo, limitingService, quotasService, appConfigs)
+	return messagesService, services.NewQueuesService(repo, limitingService, quotasService), repo
 }
 
 func TestProcessNewMessage_Validation(t *testing.T) {
@@ -80,6 +111,65 @@ func TestProcessNewMessage_Validation(t *testing.T) {
 	}
 }
 
+func TestProcessNewMessage_QueueQuotas(t *testing.T) {
+	svc, queuesSvc := newServices(t)
+	ctx := context.Background()
+
+	if err := queuesSvc.UpdateQuotas("orders", common.QuotasRequest{MaxMessages: 2}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := queuesSvc.UpdateQuotas("emails", common.QuotasRequest{MaxBytes: 10}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "orders", ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "orders", ctx); !errors.Is(err, common.ErrTooManyRequestsQuotaMessages) {
+		t.Fatalf("produce over the messages quota: got %v, want ErrTooManyRequestsQuotaMessages", err)
+	}
+
+	if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "0123456789"}, "emails", ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "emails", ctx); !errors.Is(err, common.ErrTooManyRequestsQuotaBytes) {
+		t.Fatalf("produce over the bytes quota: got %v, want ErrTooManyRequestsQuotaBytes", err)
+	}
+
+	// queues without quotas are not affected
+	if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "payments", ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessNewMessage_GlobalQuotas(t *testing.T) {
+	repo, appConfigs, _ := testutil.NewTestRepo(t)
+	appConfigs.GlobalQuotas = configs.Quotas{MaxMessages: 3}
+	metricsService := metrics.NewMetricsService(configs.MetricsSettings{})
+	limitingService, err := services.NewLimitingService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotasService, err := services.NewQuotasService(metricsService, repo, appConfigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { quotasService.Close() })
+	svc := services.NewMessagesService(metricsService, repo, limitingService, quotasService, appConfigs)
+	ctx := context.Background()
+
+	for _, queue := range []string{"orders", "emails", "payments"} {
+		if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, queue, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "reports", ctx); !errors.Is(err, common.ErrTooManyRequestsQuotaMessages) {
+		t.Fatalf("produce over the global quota: got %v, want ErrTooManyRequestsQuotaMessages", err)
+	}
+}
+
 func TestConsume_ReturnsOpaqueReceipt(t *testing.T) {
 	svc := newMessagesService(t)
 	ctx := context.Background()
@@ -103,6 +193,55 @@ func TestConsume_ReturnsOpaqueReceipt(t *testing.T) {
 	}
 }
 
+// recordingMetricsService records the message latencies, the rest are no-ops.
+type recordingMetricsService struct {
+	metrics.Service
+	waits       []time.Duration
+	processings []time.Duration
+	attempts    []int
+}
+
+func (rms *recordingMetricsService) ObserveMessageWaitDuration(queueName string, wait time.Duration) {
+	rms.waits = append(rms.waits, wait)
+}
+
+func (rms *recordingMetricsService) ObserveMessageProcessingDuration(queueName string, processing time.Duration) {
+	rms.processings = append(rms.processings, processing)
+}
+
+func (rms *recordingMetricsService) ObserveMessageAttemptsAtAck(queueName string, attempts int) {
+	rms.attempts = append(rms.attempts, attempts)
+}
+
+func TestConsumeAndAck_LatencyMetrics(t *testing.T) {
+	recorder := &recordingMetricsService{Service: metrics.NewMetricsService(configs.MetricsSettings{})}
+	svc, _ := newServicesWithMetrics(t, recorder)
+	ctx := context.Background()
+
+	if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "orders", ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	msg, err := svc.GetMessageForConsuming("orders", ctx)
+	if err != nil || msg == nil {
+		t.Fatalf("consume failed: %v %v", err, msg)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := svc.AckMessage(msg.Id, "orders", msg.Receipt, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.waits) != 1 || recorder.waits[0] < 50*time.Millisecond {
+		t.Errorf("waits = %v, want one of at least 50ms", recorder.waits)
+	}
+	if len(recorder.processings) != 1 || recorder.processings[0] < 30*time.Millisecond {
+		t.Errorf("processings = %v, want one of at least 30ms", recorder.processings)
+	}
+	if len(recorder.attempts) != 1 || recorder.attempts[0] != 1 {
+		t.Errorf("attempts = %v, want [1]", recorder.attempts)
+	}
+}
+
 func TestConsume_ClientDisconnectIsNotAnError(t *testing.T) {
 	svc := newMessagesService(t)
 
@@ -127,6 +266,109 @@ func TestConsume_ClientDisconnectIsNotAnError(t *testing.T) {
 	}
 }
 
+func TestConsume_Draining(t *testing.T) {
+	svc := newMessagesService(t)
+	ctx := context.Background()
+
+	if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "orders", ctx); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := svc.GetMessageForConsuming("orders", ctx)
+	if err != nil || claimed == nil {
+		t.Fatalf("consume failed: %v %v", err, claimed)
+	}
+
+	// the ongoing long poll returns no message right away
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		svc.Drain()
+	}()
+	start := time.Now()
+	msg, err := svc.GetMessageForConsuming("empty-queue", ctx)
+	if err != nil || msg != nil {
+		t.Fatalf("long poll on drain = %+v, %v", msg, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("long poll did not return promptly on drain (%v)", elapsed)
+	}
+
+	if _, err := svc.GetMessageForConsuming("orders", ctx); !errors.Is(err, common.ErrServiceDraining) {
+		t.Fatalf("consume while draining: %v", err)
+	}
+	// the messages handed out before the drain can still be acked
+	if err := svc.AckMessage(claimed.Id, "orders", claimed.Receipt, ctx); err != nil {
+		t.Fatalf("ack while draining: %v", err)
+	}
+}
+
+func TestReleaseClaims(t *testing.T) {
+	svc := newMessagesService(t)
+	ctx := context.Background()
+
+	var claimed []*common.MessageResponse
+	for range 3 {
+		if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "orders", ctx); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := svc.GetMessageForConsuming("orders", ctx)
+		if err != nil || msg == nil {
+			t.Fatalf("consume failed: %v %v", err, msg)
+		}
+		claimed = append(claimed, msg)
+	}
+	if err := svc.AckMessage(claimed[0].Id, "orders", claimed[0].Receipt, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.NackMessage(claimed[1].Id, "orders", claimed[1].Receipt, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the message neither acked nor nacked is released
+	released, err := svc.ReleaseClaims(ctx)
+	if err != nil || released != 1 {
+		t.Fatalf("release = %d, %v, want 1", released, err)
+	}
+	if err := svc.AckMessage(claimed[2].Id, "orders", claimed[2].Receipt, ctx); !errors.Is(err, common.ErrNotFoundMessage) {
+		t.Fatalf("ack of the released message: %v", err)
+	}
+	// it's ready right away, while the nacked one waits for its backoff
+	msg, err := svc.GetMessageForConsuming("orders", ctx)
+	if err != nil || msg == nil || msg.Id != claimed[2].Id {
+		t.Fatalf("consume after release = %+v, %v", msg, err)
+	}
+
+	if released, err := svc.ReleaseClaims(ctx); err != nil || released != 1 {
+		t.Fatalf("second release = %d, %v, want the redelivered message only", released, err)
+	}
+}
+
+func TestConsume_DeliveryRateLimit(t *testing.T) {
+	svc, queuesSvc := newServices(t)
+	ctx := context.Background()
+
+	err := queuesSvc.UpdateDeliveryLimits("orders", common.DeliveryLimitsRequest{RatePerSecond: 5, Burst: 1}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := svc.ProcessNewMessage(common.NewMessageRequest{Content: "x"}, "orders", ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first delivery uses the burst, the other two wait 200ms each
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		msg, err := svc.GetMessageForConsuming("orders", ctx)
+		if err != nil || msg == nil {
+			t.Fatalf("consume %d: %v %v", i, err, msg)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Fatalf("3 deliveries at 5/s took only %v", elapsed)
+	}
+}
+
 func TestAckNack_ReceiptRequired(t *testing.T) {
 	svc := newMessagesService(t)
 	ctx := context.Background()
@@ -158,10 +400,10 @@ func TestRequeueAndDelete_DlqOnly(t *testing.T) {
 	svc := newMessagesService(t)
 	ctx := context.Background()
 
-	if err := svc.RequeueAllDlqMessages("orders", ctx); !errors.Is(err, common.ErrBadRequestDlqOnlyOp) {
+	if _, err := svc.RequeueAllDlqMessages("orders", ctx); !errors.Is(err, common.ErrBadRequestDlqOnlyOp) {
 		t.Fatalf("requeue-all on non-DLQ: got %v, want ErrBadRequestDlqOnlyOp", err)
 	}
-	if err := svc.DeleteAllDlqMessages("orders", ctx); !errors.Is(err, common.ErrBadRequestDlqOnlyOp) {
+	if _, err := svc.DeleteAllDlqMessages("orders", ctx); !errors.Is(err, common.ErrBadRequestDlqOnlyOp) {
 		t.Fatalf("delete-all on non-DLQ: got %v, want ErrBadRequestDlqOnlyOp", err)
 	}
 	if err := svc.DeleteDlqMessage("0199164b-4dea-78d9-9b4c-c699d5037962", "orders", ctx); !errors.Is(err, common.ErrBadRequestDlqOnlyOp) {
@@ -169,6 +411,66 @@ func TestRequeueAndDelete_DlqOnly(t *testing.T) {
 	}
 }
 
+func TestRedriveDlqMessages_Validation(t *testing.T) {
+	svc := newMessagesService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		queue   string
+		req     common.RedriveRequest
+		wantErr error
+	}{
+		{
+			name:    "non-DLQ source",
+			queue:   "orders",
+			req:     common.RedriveRequest{TargetQueue: "quarantine"},
+			wantErr: common.ErrBadRequestDlqOnlyOp,
+		},
+		{
+			name:    "missing target",
+			queue:   "orders-dlq",
+			req:     common.RedriveRequest{},
+			wantErr: common.ErrBadRequestInvalidTargetQueue,
+		},
+		{
+			name:    "DLQ target",
+			queue:   "orders-dlq",
+			req:     common.RedriveRequest{TargetQueue: "emails-dlq"},
+			wantErr: common.ErrBadRequestInvalidTargetQueue,
+		},
+		{
+			name:    "unknown failure reason",
+			queue:   "orders-dlq",
+			req:     common.RedriveRequest{TargetQueue: "quarantine", FailureReason: "bored"},
+			wantErr: common.ErrBadRequestInvalidFailReason,
+		},
+		{
+			name:    "negative rate",
+			queue:   "orders-dlq",
+			req:     common.RedriveRequest{TargetQueue: "quarantine", RatePerSecond: -1},
+			wantErr: common.ErrBadRequestInvalidRate,
+		},
+		{
+			name:  "valid",
+			queue: "orders-dlq",
+			req:   common.RedriveRequest{TargetQueue: "quarantine", FailureReason: common.MessageExpiredFailureReason, RatePerSecond: 50},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.RedriveDlqMessages(tt.queue, tt.req, ctx)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestGetMessagesForUI_Pagination(t *testing.T) {
 	svc := newMessagesService(t)
 	ctx := context.Background()
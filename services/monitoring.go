@@ -2,21 +2,153 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
 	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/jobs"
+	"github.com/n0rdy/forq/utils"
 )
 
+const (
+	startingPhase = "starting"
+	readyPhase    = "ready"
+	drainingPhase = "draining"
+)
+
+// MonitoringService answers the health checks. It's created before the
+// migrations run, so that the readiness probe can tell Forq is still
+// starting: the repo and the jobs only come with Started.
 type MonitoringService struct {
-	repo *db.ForqRepo
+	dbDir             string
+	minFreeDiskBytes  int64
+	maxMissedJobTicks int
+
+	mu    sync.RWMutex
+	phase string
+	repo  *db.ForqRepo
+	jobs  []*jobs.Runner
 }
 
-func NewMonitoringService(repo *db.ForqRepo) *MonitoringService {
+func NewMonitoringService(dbPath string, settings configs.HealthSettings) *MonitoringService {
 	return &MonitoringService{
-		repo: repo,
+		dbDir:             filepath.Dir(dbPath),
+		minFreeDiskBytes:  settings.MinFreeDiskBytes,
+		maxMissedJobTicks: settings.MaxMissedJobTicks,
+		phase:             startingPhase,
 	}
 }
 
+// Started marks Forq ready, once the DB is migrated and the jobs run.
+func (ms *MonitoringService) Started(repo *db.ForqRepo, runners []*jobs.Runner) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.repo = repo
+	ms.jobs = runners
+	ms.phase = readyPhase
+}
+
+// Draining marks Forq not ready for good, as the drain has begun.
+func (ms *MonitoringService) Draining() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.phase = drainingPhase
+}
+
+// IsHealthy tells whether the DB responds. It's the /healthcheck and the
+// systemd watchdog check.
 func (ms *MonitoringService) IsHealthy(ctx context.Context) bool {
-	err := ms.repo.Ping(ctx)
+	ms.mu.RLock()
+	repo := ms.repo
+	ms.mu.RUnlock()
+	if repo == nil {
+		return false
+	}
+
+	err := repo.Ping(ctx)
 	return err == nil
 }
+
+// Readiness runs all the readiness checks, rather than stopping at the first
+// failed one, so that the verbose /readyz tells everything that's wrong.
+// While starting or draining, the other checks are skipped.
+func (ms *MonitoringService) Readiness(ctx context.Context) *common.ReadinessResponse {
+	ms.mu.RLock()
+	phase, repo, runners := ms.phase, ms.repo, ms.jobs
+	ms.mu.RUnlock()
+
+	lifecycleCheck := common.ReadinessCheckResponse{Name: "lifecycle", Ok: phase == readyPhase, Message: phase}
+	if phase != readyPhase {
+		return &common.ReadinessResponse{Ready: false, Checks: []common.ReadinessCheckResponse{lifecycleCheck}}
+	}
+
+	checks := []common.ReadinessCheckResponse{
+		lifecycleCheck,
+		runCheck("database", func() (bool, string) { return ms.checkDatabase(repo, ctx) }),
+		runCheck("disk", ms.checkDisk),
+		runCheck("jobs", func() (bool, string) { return ms.checkJobs(runners) }),
+	}
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.Ok
+	}
+	return &common.ReadinessResponse{Ready: ready, Checks: checks}
+}
+
+func runCheck(name string, check func() (bool, string)) common.ReadinessCheckResponse {
+	start := time.Now()
+	ok, message := check()
+	return common.ReadinessCheckResponse{
+		Name:      name,
+		Ok:        ok,
+		Message:   message,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+}
+
+func (ms *MonitoringService) checkDatabase(repo *db.ForqRepo, ctx context.Context) (bool, string) {
+	err := repo.Ping(ctx)
+	if err != nil {
+		return false, "the database doesn't respond"
+	}
+	return true, ""
+}
+
+// checkDisk passes if the free space can't be told, e.g. on Windows: it's
+// better to serve than to be taken out of the load balancer for good.
+func (ms *MonitoringService) checkDisk() (bool, string) {
+	if ms.minFreeDiskBytes == 0 {
+		return true, "disabled"
+	}
+
+	free, err := utils.DiskFreeBytes(ms.dbDir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return true, "not supported on this OS"
+	}
+	if err != nil {
+		return false, fmt.Sprintf("failed to get the free space: %v", err)
+	}
+	message := fmt.Sprintf("%d bytes free, %d required", free, ms.minFreeDiskBytes)
+	return free >= ms.minFreeDiskBytes, message
+}
+
+func (ms *MonitoringService) checkJobs(runners []*jobs.Runner) (bool, string) {
+	var stuck []string
+	for _, runner := range runners {
+		missed := time.Since(runner.LastTickAt()) / runner.Interval()
+		if missed >= time.Duration(ms.maxMissedJobTicks) {
+			stuck = append(stuck, runner.Name())
+		}
+	}
+	if len(stuck) > 0 {
+		return false, fmt.Sprintf("no finished tick in %d intervals: %s", ms.maxMissedJobTicks, strings.Join(stuck, ", "))
+	}
+	return true, ""
+}
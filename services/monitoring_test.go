@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/jobs"
+	"github.com/n0rdy/forq/metrics"
+)
+
+func failedChecks(readiness *common.ReadinessResponse) map[string]string {
+	failed := make(map[string]string)
+	for _, check := range readiness.Checks {
+		if !check.Ok {
+			failed[check.Name] = check.Message
+		}
+	}
+	return failed
+}
+
+func TestMonitoringService_Lifecycle(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ms := NewMonitoringService(filepath.Join(t.TempDir(), "forq.db"), configs.DefaultSettings().Health)
+	ctx := context.Background()
+
+	if ms.IsHealthy(ctx) {
+		t.Error("healthy before the repo is there")
+	}
+	if readiness := ms.Readiness(ctx); readiness.Ready || failedChecks(readiness)["lifecycle"] != startingPhase {
+		t.Errorf("readiness while starting = %+v", readiness)
+	}
+
+	ms.Started(repo, nil)
+	if !ms.IsHealthy(ctx) {
+		t.Error("not healthy once started")
+	}
+	if readiness := ms.Readiness(ctx); !readiness.Ready || len(readiness.Checks) != 4 {
+		t.Errorf("readiness once started = %+v", readiness)
+	}
+
+	ms.Draining()
+	if readiness := ms.Readiness(ctx); readiness.Ready || failedChecks(readiness)["lifecycle"] != drainingPhase {
+		t.Errorf("readiness while draining = %+v", readiness)
+	}
+	// the liveness is not affected
+	if !ms.IsHealthy(ctx) {
+		t.Error("not healthy while draining")
+	}
+}
+
+func TestMonitoringService_LowDiskSpace(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	settings := configs.DefaultSettings().Health
+	settings.MinFreeDiskBytes = 1 << 62
+	ms := NewMonitoringService(filepath.Join(t.TempDir(), "forq.db"), settings)
+	ms.Started(repo, nil)
+
+	readiness := ms.Readiness(context.Background())
+	failed := failedChecks(readiness)
+	if readiness.Ready || !strings.Contains(failed["disk"], "required") || len(failed) != 1 {
+		t.Errorf("readiness = %+v", readiness)
+	}
+}
+
+func TestMonitoringService_StuckJob(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	ms := NewMonitoringService(filepath.Join(t.TempDir(), "forq.db"), configs.DefaultSettings().Health)
+
+	metricsService := metrics.NewMetricsService(configs.MetricsSettings{})
+	healthy := jobs.NewRunner(metricsService, "healthy", 10, 1000, func(ctx context.Context) error {
+		return nil
+	})
+	defer healthy.Close()
+	unblock := make(chan struct{})
+	stuck := jobs.NewRunner(metricsService, "stuck", 10, 1000, func(ctx context.Context) error {
+		<-unblock
+		return nil
+	})
+	defer stuck.Close()
+	defer close(unblock)
+
+	ms.Started(repo, []*jobs.Runner{healthy, stuck})
+	// 3 intervals of 10ms with no finished tick, with a margin
+	time.Sleep(100 * time.Millisecond)
+
+	readiness := ms.Readiness(context.Background())
+	failed := failedChecks(readiness)
+	if readiness.Ready || !strings.HasSuffix(failed["jobs"], ": stuck") {
+		t.Errorf("readiness = %+v", readiness)
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcLoginExpiry = 10 * time.Minute
+	// bounds the first login while the IdP is down or slow
+	oidcDiscoveryTimeout = 10 * time.Second
+)
+
+// oidcLogin is what the callback needs to complete the login. It is sealed
+// into the browser's cookie rather than kept on the server, so that the
+// unauthenticated /oidc/login doesn't take any server memory.
+type oidcLogin struct {
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"e"` // Unix milliseconds
+}
+
+// OidcService logs the admin UI users in with the company IdP: the
+// authorization code flow with PKCE. The IdP is discovered on the first login
+// rather than on startup, so that Forq doesn't depend on the IdP being up to
+// start.
+type OidcService struct {
+	settings   configs.OidcSettings
+	provider   *oidc.Provider
+	providerMu sync.Mutex
+	// seals the logins in progress: derived from the configured secret, so
+	// that the logins begun before a restart can still be completed after it
+	loginKey []byte
+}
+
+// NewOidcService returns nil if OIDC is not configured.
+func NewOidcService(settings configs.OidcSettings) *OidcService {
+	if settings.IssuerUrl == "" {
+		return nil
+	}
+	return &OidcService{
+		settings: settings,
+		loginKey: oidcLoginKey(settings),
+	}
+}
+
+// BeginLogin returns the sealed login to bind to the browser with a cookie,
+// and the IdP URL to redirect it to.
+func (oidcs *OidcService) BeginLogin(ctx context.Context) (string, string, error) {
+	oauth2Config, _, err := oidcs.clients(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	login := oidcLogin{
+		State:     rand.Text(),
+		Nonce:     rand.Text(),
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(oidcLoginExpiry).UnixMilli(),
+	}
+	sealedLogin, err := oidcs.sealLogin(login)
+	if err != nil {
+		return "", "", err
+	}
+
+	authUrl := oauth2Config.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier))
+	return sealedLogin, authUrl, nil
+}
+
+// CompleteLogin exchanges the code for the ID token, and maps the user's
+// groups to a role. It returns nil if the user is not allowed to log in. The
+// sealed login comes from the browser's cookie, and the state from the IdP's
+// redirect: they must match, so that a callback URL can't be used to log
+// someone else in. A replayed callback is rejected by the IdP, as the code is
+// single-use.
+func (oidcs *OidcService) CompleteLogin(sealedLogin string, state string, code string, ctx context.Context) (*SessionUser, error) {
+	login, err := oidcs.openLogin(sealedLogin)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().UnixMilli() > login.ExpiresAt {
+		log.Error().Msg("expired OIDC login")
+		return nil, common.ErrInternal
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(login.State), []byte(state)) != 1 {
+		log.Error().Msg("OIDC callback state doesn't match the browser's")
+		return nil, common.ErrInternal
+	}
+
+	oauth2Config, verifier, err := oidcs.clients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to exchange OIDC authorization code")
+		return nil, common.ErrInternal
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Error().Msg("OIDC token response without id_token")
+		return nil, common.ErrInternal
+	}
+	idToken, err := verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid OIDC ID token")
+		return nil, common.ErrInternal
+	}
+	if idToken.Nonce != login.Nonce {
+		log.Error().Msg("OIDC ID token nonce mismatch")
+		return nil, common.ErrInternal
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		log.Error().Err(err).Msg("failed to parse OIDC ID token claims")
+		return nil, common.ErrInternal
+	}
+
+	username := usernameFromClaims(claims, idToken.Subject)
+	groups := groupsFromClaims(claims, oidcs.settings.GroupsClaim)
+	role := oidcs.roleOf(groups)
+	if role == "" {
+		log.Warn().Str("username", username).Strs("groups", groups).Msg("OIDC user is not allowed to log in")
+		return nil, nil
+	}
+	return &SessionUser{Name: "oidc:" + username, Role: role}, nil
+}
+
+// roleOf returns an empty string if the groups are not allowed in.
+func (oidcs *OidcService) roleOf(groups []string) string {
+	isAllowed := func(group string) bool {
+		return slices.Contains(oidcs.settings.AllowedGroups, group)
+	}
+	if len(oidcs.settings.AllowedGroups) > 0 && !slices.ContainsFunc(groups, isAllowed) {
+		return ""
+	}
+
+	role := oidcs.settings.DefaultRole
+	for _, group := range groups {
+		groupRole, ok := oidcs.settings.GroupRoles[group]
+		if ok && common.SupportedRoles[groupRole] > common.SupportedRoles[role] {
+			role = groupRole
+		}
+	}
+	return role
+}
+
+func (oidcs *OidcService) clients(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	provider, err := oidcs.discover(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oauth2Config := &oauth2.Config{
+		ClientID:     oidcs.settings.ClientId,
+		ClientSecret: oidcs.settings.ClientSecret,
+		RedirectURL:  oidcs.settings.RedirectUrl,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       oidcs.settings.Scopes,
+	}
+	verifier := provider.Verifier(&oidc.Config{ClientID: oidcs.settings.ClientId})
+	return oauth2Config, verifier, nil
+}
+
+// discover returns the provider, discovered on the first call. The mutex
+// isn't held during the discovery, so that a slow IdP doesn't block the
+// other logins for longer than their own attempt: the concurrent first
+// logins discover the provider each, and the first one to finish keeps it.
+func (oidcs *OidcService) discover(ctx context.Context) (*oidc.Provider, error) {
+	oidcs.providerMu.Lock()
+	provider := oidcs.provider
+	oidcs.providerMu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oidcDiscoveryTimeout)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, oidcs.settings.IssuerUrl)
+	if err != nil {
+		log.Error().Err(err).Str("issuer", oidcs.settings.IssuerUrl).Msg("failed to discover OIDC provider")
+		return nil, common.ErrInternal
+	}
+
+	oidcs.providerMu.Lock()
+	defer oidcs.providerMu.Unlock()
+	if oidcs.provider == nil {
+		oidcs.provider = provider
+	}
+	return oidcs.provider, nil
+}
+
+// sealLogin encrypts and authenticates the login with AES-GCM, so that the
+// browser can neither read the nonce and the PKCE verifier nor forge a login.
+func (oidcs *OidcService) sealLogin(login oidcLogin) (string, error) {
+	aead, err := oidcs.loginAead()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(login)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal OIDC login")
+		return "", common.ErrInternal
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (oidcs *OidcService) openLogin(sealedLogin string) (*oidcLogin, error) {
+	aead, err := oidcs.loginAead()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(sealedLogin)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		log.Error().Msg("malformed OIDC login cookie")
+		return nil, common.ErrInternal
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		log.Error().Err(err).Msg("forged or foreign OIDC login cookie")
+		return nil, common.ErrInternal
+	}
+
+	var login oidcLogin
+	if err := json.Unmarshal(plaintext, &login); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal OIDC login")
+		return nil, common.ErrInternal
+	}
+	return &login, nil
+}
+
+func (oidcs *OidcService) loginAead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(oidcs.loginKey)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create OIDC login cipher")
+		return nil, common.ErrInternal
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create OIDC login AEAD")
+		return nil, common.ErrInternal
+	}
+	return aead, nil
+}
+
+// oidcLoginKey derives the AES-256 key of the logins in progress from the
+// dedicated login secret, or the client secret of a confidential client.
+func oidcLoginKey(settings configs.OidcSettings) []byte {
+	secret := settings.LoginSecret
+	if secret == "" {
+		secret = settings.ClientSecret
+	}
+	// the error is for the keys longer than 255 SHA-256 hashes only
+	loginKey, _ := hkdf.Key(sha256.New, []byte(secret), nil, "forq oidc login", 32)
+	return loginKey
+}
+
+func usernameFromClaims(claims map[string]any, subject string) string {
+	for _, claim := range []string{"preferred_username", "email"} {
+		if username, ok := claims[claim].(string); ok && username != "" {
+			return username
+		}
+	}
+	return subject
+}
+
+// groupsFromClaims accepts both a list and a single group, as IdPs differ.
+func groupsFromClaims(claims map[string]any, groupsClaim string) []string {
+	switch groups := claims[groupsClaim].(type) {
+	case string:
+		return []string{groups}
+	case []any:
+		var result []string
+		for _, group := range groups {
+			if s, ok := group.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
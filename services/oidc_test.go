@@ -0,0 +1,149 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/services"
+)
+
+const testOidcLoginSecret = "oidc-login-secret-that-is-32-chars"
+
+func newTestOidcService(t *testing.T) (*services.OidcService, *testutil.TestOidcProvider) {
+	t.Helper()
+
+	idp := testutil.NewTestOidcProvider(t)
+	return services.NewOidcService(testOidcSettings(idp)), idp
+}
+
+func testOidcSettings(idp *testutil.TestOidcProvider) configs.OidcSettings {
+	return configs.OidcSettings{
+		IssuerUrl:     idp.Url,
+		ClientId:      testutil.TestOidcClientId,
+		LoginSecret:   testOidcLoginSecret,
+		RedirectUrl:   "http://forq.test/oidc/callback",
+		Scopes:        []string{"openid", "profile"},
+		GroupsClaim:   "groups",
+		AllowedGroups: []string{"forq-users", "forq-admins"},
+		GroupRoles:    map[string]string{"forq-admins": common.AdminRole, "forq-ops": common.OperatorRole},
+		DefaultRole:   common.ViewerRole,
+	}
+}
+
+func TestOidcService_GroupRoles(t *testing.T) {
+	oidcService, idp := newTestOidcService(t)
+
+	testCases := []struct {
+		groups   []string
+		wantRole string // empty if not allowed
+	}{
+		{[]string{"forq-users"}, common.ViewerRole},
+		{[]string{"forq-users", "forq-ops"}, common.OperatorRole},
+		{[]string{"forq-ops", "forq-admins"}, common.AdminRole},
+		{[]string{"forq-ops"}, ""}, // not in the allowed groups
+		{nil, ""},
+	}
+	for _, tc := range testCases {
+		idp.SetUser("alice", tc.groups...)
+		sealedLogin, authUrl, err := oidcService.BeginLogin(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := idp.Authorize(t, authUrl)
+
+		user, err := oidcService.CompleteLogin(sealedLogin, state, code, t.Context())
+		if err != nil {
+			t.Fatalf("groups %v: %v", tc.groups, err)
+		}
+		switch {
+		case tc.wantRole == "" && user != nil:
+			t.Errorf("groups %v: logged in as %+v, want rejected", tc.groups, user)
+		case tc.wantRole != "" && (user == nil || *user != services.SessionUser{Name: "oidc:alice", Role: tc.wantRole}):
+			t.Errorf("groups %v: user = %+v, want oidc:alice as %s", tc.groups, user, tc.wantRole)
+		}
+	}
+}
+
+func TestOidcService_RejectsReusedState(t *testing.T) {
+	oidcService, idp := newTestOidcService(t)
+	idp.SetUser("alice", "forq-users")
+
+	sealedLogin, authUrl, err := oidcService.BeginLogin(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Authorize(t, authUrl)
+	if _, err := oidcService.CompleteLogin(sealedLogin, state, code, t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a replayed callback
+	if _, err := oidcService.CompleteLogin(sealedLogin, state, code, t.Context()); err == nil {
+		t.Fatal("state accepted twice")
+	}
+	if _, err := oidcService.CompleteLogin(sealedLogin, "forged-state", code, t.Context()); err == nil {
+		t.Fatal("unknown state accepted")
+	}
+}
+
+func TestOidcService_RejectsForgedLogin(t *testing.T) {
+	oidcService, idp := newTestOidcService(t)
+	idp.SetUser("alice", "forq-users")
+
+	sealedLogin, authUrl, err := oidcService.BeginLogin(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Authorize(t, authUrl)
+
+	// the login is sealed with the key derived from the login secret
+	otherSettings := testOidcSettings(idp)
+	otherSettings.LoginSecret = "other-login-secret-that-is-32-chars"
+	if _, err := services.NewOidcService(otherSettings).CompleteLogin(sealedLogin, state, code, t.Context()); err == nil {
+		t.Fatal("login sealed with another secret accepted")
+	}
+
+	tampered := []byte(sealedLogin)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := oidcService.CompleteLogin(string(tampered), state, code, t.Context()); err == nil {
+		t.Fatal("tampered login accepted")
+	}
+	if _, err := oidcService.CompleteLogin("not-a-login", state, code, t.Context()); err == nil {
+		t.Fatal("malformed login accepted")
+	}
+
+	// the untouched login still works
+	if _, err := oidcService.CompleteLogin(sealedLogin, state, code, t.Context()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOidcService_LoginSurvivesRestart(t *testing.T) {
+	oidcService, idp := newTestOidcService(t)
+	idp.SetUser("alice", "forq-users")
+
+	sealedLogin, authUrl, err := oidcService.BeginLogin(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Authorize(t, authUrl)
+
+	restarted := services.NewOidcService(testOidcSettings(idp))
+	user, err := restarted.CompleteLogin(sealedLogin, state, code, t.Context())
+	if err != nil || user == nil {
+		t.Fatalf("login begun before the restart: %+v, %v", user, err)
+	}
+}
+
+func TestOidcService_NoLimitOnLoginsInProgress(t *testing.T) {
+	oidcService, _ := newTestOidcService(t)
+
+	// the unauthenticated /oidc/login can be hit any number of times without blocking the SSO
+	for range 20_000 {
+		if _, _, err := oidcService.BeginLogin(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -2,18 +2,32 @@ package services
 
 import (
 	"context"
+	"math"
+	"strings"
 
 	"github.com/n0rdy/forq/common"
 	"github.com/n0rdy/forq/db"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxDeliveryRatePerSec = 10_000
+	maxDeliveryBurst      = 10_000
+	maxInFlightLimit      = 1_000_000
 )
 
 type QueuesService struct {
-	forqRepo *db.ForqRepo
+	forqRepo        *db.ForqRepo
+	limitingService *LimitingService
+	quotasService   *QuotasService
 }
 
-func NewQueuesService(forqRepo *db.ForqRepo) *QueuesService {
+func NewQueuesService(forqRepo *db.ForqRepo, limitingService *LimitingService, quotasService *QuotasService) *QueuesService {
 	return &QueuesService{
-		forqRepo: forqRepo,
+		forqRepo:        forqRepo,
+		limitingService: limitingService,
+		quotasService:   quotasService,
 	}
 }
 
@@ -38,6 +52,7 @@ func (qs *QueuesService) GetQueuesStats(ctx context.Context) (*common.DashboardP
 			Name:          q.Name,
 			TotalMessages: q.MessagesCount,
 			Type:          queueType,
+			Paused:        q.Paused,
 		})
 	}
 
@@ -68,5 +83,175 @@ func (qs *QueuesService) GetQueueStats(queueName string, ctx context.Context) (*
 		Name:          queueMeta.Name,
 		TotalMessages: queueMeta.MessagesCount,
 		Type:          queueType,
+		Paused:        queueMeta.Paused,
 	}, nil
 }
+
+func (qs *QueuesService) GetQueueSettings(queueName string, ctx context.Context) (*common.QueueSettings, error) {
+	dbSettings, err := qs.forqRepo.SelectQueueSettings(queueName, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &common.QueueSettings{
+		QueueName: queueName,
+		DlqPolicy: common.DefaultDlqPolicy,
+		IsDLQ:     strings.HasSuffix(queueName, common.DlqSuffix),
+	}
+	if !settings.IsDLQ {
+		// DLQs delete their failed messages by default, there is no DLQ of a DLQ
+		settings.DlqName = queueName + common.DlqSuffix
+	}
+	if dbSettings == nil {
+		return settings, nil
+	}
+
+	if dbSettings.PausedAt != nil {
+		settings.Paused = true
+		settings.PausedAt = *dbSettings.PausedAt
+	}
+
+	limits := deliveryLimitsFromDb(dbSettings)
+	settings.DeliveryRatePerSec = limits.RatePerSec
+	settings.DeliveryBurst = limits.Burst
+	settings.MaxInFlight = limits.MaxInFlight
+
+	quotas := quotaLimitsFromDb(dbSettings)
+	settings.MaxMessages = quotas.MaxMessages
+	settings.MaxBytes = quotas.MaxBytes
+
+	settings.DlqPolicy = dbSettings.DlqPolicy
+	switch dbSettings.DlqPolicy {
+	case common.NoDlqPolicy:
+		settings.DlqName = ""
+	case common.CustomDlqPolicy:
+		if dbSettings.DlqName != nil {
+			settings.DlqName = *dbSettings.DlqName
+		}
+	}
+	return settings, nil
+}
+
+// UpdateDlqPolicy sets where the failed and expired messages of the queue go.
+// A DLQ can have a policy too: "custom" chains its failed messages to another
+// DLQ instead of deleting them, while "default" and "none" both delete them.
+func (qs *QueuesService) UpdateDlqPolicy(queueName string, policyReq common.DlqPolicyRequest, ctx context.Context) error {
+	if !common.SupportedDlqPolicies[policyReq.DlqPolicy] {
+		log.Error().Str("queue", queueName).Str("dlq_policy", policyReq.DlqPolicy).Msg("unsupported DLQ policy")
+		return common.ErrBadRequestInvalidDlqPolicy
+	}
+
+	var dlqName *string
+	if policyReq.DlqPolicy == common.CustomDlqPolicy {
+		// the custom DLQ name must keep the reserved suffix: that's what keeps
+		// producers out of it and tells the rest of Forq that it is a DLQ
+		if !common.IsValidQueueName(policyReq.DlqName) ||
+			!strings.HasSuffix(policyReq.DlqName, common.DlqSuffix) ||
+			policyReq.DlqName == queueName {
+			log.Error().Str("queue", queueName).Str("dlq_name", policyReq.DlqName).Msg("invalid custom DLQ name")
+			return common.ErrBadRequestInvalidDlqName
+		}
+		dlqName = &policyReq.DlqName
+	}
+
+	return qs.forqRepo.UpsertQueueDlqPolicy(queueName, policyReq.DlqPolicy, dlqName, ctx)
+}
+
+// UpdateDeliveryLimits replaces the delivery limits of the queue. They apply
+// to the consumers right away, including the ones already long polling.
+func (qs *QueuesService) UpdateDeliveryLimits(queueName string, limitsReq common.DeliveryLimitsRequest, ctx context.Context) error {
+	// NaN passes both comparisons, and would stall the token bucket for good
+	rate := limitsReq.RatePerSecond
+	if math.IsNaN(rate) || math.IsInf(rate, 0) || rate < 0 || rate > maxDeliveryRatePerSec {
+		log.Error().Str("queue", queueName).Float64("rate_per_sec", limitsReq.RatePerSecond).Msg("invalid delivery rate")
+		return common.ErrBadRequestInvalidRate
+	}
+	// burst is meaningless without a rate, rejecting it rather than silently ignoring
+	if limitsReq.Burst < 0 || limitsReq.Burst > maxDeliveryBurst || (limitsReq.Burst > 0 && limitsReq.RatePerSecond == 0) {
+		log.Error().Str("queue", queueName).Int("burst", limitsReq.Burst).Msg("invalid delivery burst")
+		return common.ErrBadRequestInvalidBurst
+	}
+	if limitsReq.MaxInFlight < 0 || limitsReq.MaxInFlight > maxInFlightLimit {
+		log.Error().Str("queue", queueName).Int("max_in_flight", limitsReq.MaxInFlight).Msg("invalid max in-flight")
+		return common.ErrBadRequestInvalidMaxInFlight
+	}
+
+	var ratePerSec *float64
+	var burst, maxInFlight *int
+	if limitsReq.RatePerSecond > 0 {
+		ratePerSec = &limitsReq.RatePerSecond
+	}
+	if limitsReq.Burst > 0 {
+		burst = &limitsReq.Burst
+	}
+	if limitsReq.MaxInFlight > 0 {
+		maxInFlight = &limitsReq.MaxInFlight
+	}
+
+	err := qs.forqRepo.UpsertQueueDeliveryLimits(queueName, ratePerSec, burst, maxInFlight, ctx)
+	if err != nil {
+		return err
+	}
+
+	qs.limitingService.SetLimits(queueName, DeliveryLimits{
+		RatePerSec:  limitsReq.RatePerSecond,
+		Burst:       limitsReq.Burst,
+		MaxInFlight: limitsReq.MaxInFlight,
+	})
+	return nil
+}
+
+// UpdateQuotas replaces the quotas of the queue. Lowering a quota below the
+// current usage doesn't delete anything: producing is rejected until the
+// consumers bring the usage back under the quota.
+func (qs *QueuesService) UpdateQuotas(queueName string, quotasReq common.QuotasRequest, ctx context.Context) error {
+	if quotasReq.MaxMessages < 0 {
+		log.Error().Str("queue", queueName).Int64("max_messages", quotasReq.MaxMessages).Msg("invalid max messages quota")
+		return common.ErrBadRequestInvalidMaxMessages
+	}
+	if quotasReq.MaxBytes < 0 {
+		log.Error().Str("queue", queueName).Int64("max_bytes", quotasReq.MaxBytes).Msg("invalid max bytes quota")
+		return common.ErrBadRequestInvalidMaxBytes
+	}
+
+	var maxMessages, maxBytes *int64
+	if quotasReq.MaxMessages > 0 {
+		maxMessages = &quotasReq.MaxMessages
+	}
+	if quotasReq.MaxBytes > 0 {
+		maxBytes = &quotasReq.MaxBytes
+	}
+
+	err := qs.forqRepo.UpsertQueueQuotas(queueName, maxMessages, maxBytes, ctx)
+	if err != nil {
+		return err
+	}
+
+	qs.quotasService.SetLimits(queueName, QuotaLimits{
+		MaxMessages: quotasReq.MaxMessages,
+		MaxBytes:    quotasReq.MaxBytes,
+	})
+	return nil
+}
+
+// PauseQueue stops the deliveries of the queue: consumers get no messages,
+// while producing still works and the messages' TTLs are frozen.
+func (qs *QueuesService) PauseQueue(queueName string, ctx context.Context) error {
+	err := qs.forqRepo.PauseQueue(queueName, ctx)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("queue", queueName).Msg("queue paused")
+	return nil
+}
+
+func (qs *QueuesService) ResumeQueue(queueName string, ctx context.Context) error {
+	resumed, err := qs.forqRepo.ResumeQueue(queueName, ctx)
+	if err != nil {
+		return err
+	}
+	if resumed {
+		log.Info().Str("queue", queueName).Msg("queue resumed")
+	}
+	return nil
+}
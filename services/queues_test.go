@@ -0,0 +1,135 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/n0rdy/forq/common"
+)
+
+func TestUpdateDlqPolicy_Validation(t *testing.T) {
+	_, svc := newServices(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		queue   string
+		req     common.DlqPolicyRequest
+		wantErr error
+	}{
+		{
+			name:    "unknown policy",
+			queue:   "orders",
+			req:     common.DlqPolicyRequest{DlqPolicy: "sometimes"},
+			wantErr: common.ErrBadRequestInvalidDlqPolicy,
+		},
+		{
+			name:    "custom without a name",
+			queue:   "orders",
+			req:     common.DlqPolicyRequest{DlqPolicy: common.CustomDlqPolicy},
+			wantErr: common.ErrBadRequestInvalidDlqName,
+		},
+		{
+			// a known limitation: the suffix is what marks a queue as a DLQ
+			name:    "custom name without the DLQ suffix",
+			queue:   "orders",
+			req:     common.DlqPolicyRequest{DlqPolicy: common.CustomDlqPolicy, DlqName: "graveyard"},
+			wantErr: common.ErrBadRequestInvalidDlqName,
+		},
+		{
+			name:    "DLQ chained to itself",
+			queue:   "orders-dlq",
+			req:     common.DlqPolicyRequest{DlqPolicy: common.CustomDlqPolicy, DlqName: "orders-dlq"},
+			wantErr: common.ErrBadRequestInvalidDlqName,
+		},
+		{
+			name:  "no DLQ",
+			queue: "orders",
+			req:   common.DlqPolicyRequest{DlqPolicy: common.NoDlqPolicy},
+		},
+		{
+			name:  "shared DLQ",
+			queue: "orders",
+			req:   common.DlqPolicyRequest{DlqPolicy: common.CustomDlqPolicy, DlqName: "shared-dlq"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.UpdateDlqPolicy(tt.queue, tt.req, ctx)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	settings, err := svc.GetQueueSettings("orders", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.DlqPolicy != common.CustomDlqPolicy || settings.DlqName != "shared-dlq" {
+		t.Fatalf("settings after update: %+v", settings)
+	}
+}
+
+func TestUpdateDeliveryLimits_Validation(t *testing.T) {
+	_, svc := newServices(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		req     common.DeliveryLimitsRequest
+		wantErr error
+	}{
+		{
+			name:    "negative rate",
+			req:     common.DeliveryLimitsRequest{RatePerSecond: -1},
+			wantErr: common.ErrBadRequestInvalidRate,
+		},
+		{
+			name:    "NaN rate",
+			req:     common.DeliveryLimitsRequest{RatePerSecond: math.NaN()},
+			wantErr: common.ErrBadRequestInvalidRate,
+		},
+		{
+			name:    "infinite rate",
+			req:     common.DeliveryLimitsRequest{RatePerSecond: math.Inf(1)},
+			wantErr: common.ErrBadRequestInvalidRate,
+		},
+		{
+			name:    "burst without a rate",
+			req:     common.DeliveryLimitsRequest{Burst: 5},
+			wantErr: common.ErrBadRequestInvalidBurst,
+		},
+		{
+			name:    "negative max in-flight",
+			req:     common.DeliveryLimitsRequest{MaxInFlight: -1},
+			wantErr: common.ErrBadRequestInvalidMaxInFlight,
+		},
+		{
+			name: "fractional rate",
+			req:  common.DeliveryLimitsRequest{RatePerSecond: 0.5, MaxInFlight: 10},
+		},
+		{
+			name: "unlimited",
+			req:  common.DeliveryLimitsRequest{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.UpdateDeliveryLimits("orders", tt.req, ctx)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// QuotaLimits caps how much data a queue stores. Zero values mean unlimited.
+type QuotaLimits struct {
+	MaxMessages int64
+	MaxBytes    int64
+}
+
+type quotaUsage struct {
+	messages int64
+	bytes    int64
+}
+
+// quotaUsages is the usage per queue, plus the total of all the queues.
+type quotaUsages struct {
+	byQueue map[string]*quotaUsage
+	total   quotaUsage
+}
+
+// QuotasService enforces the per-queue and global quotas on producing.
+// Counting the usage on every produce would scan the whole queue, so it is
+// kept in memory instead: recounted from the DB every
+// common.QuotasUsageRefreshSec and bumped on every produce in between. The
+// messages consumed since the last recount are still counted as used, which
+// errs on the side of rejecting a producer for a few extra seconds rather than
+// letting it overshoot the quota.
+//
+// A reservation is pending from Reserve until the message is stored (Commit)
+// or not (Release). The recount can't tell whether the DB read saw the
+// messages pending when it started, so it counts them on top of the read,
+// along with the reservations made during the read: a message stored right
+// before the read is counted twice until the next recount, but none is missed.
+//
+// The usage is only tracked and recounted while there is a queue or global
+// quota to enforce, so instances without quotas don't pay for the periodic
+// GROUP BY over all the messages.
+type QuotasService struct {
+	metricsService metrics.Service
+	forqRepo       *db.ForqRepo
+	globalLimits   QuotaLimits
+	limits         map[string]QuotaLimits
+	usage          quotaUsages
+	pending        quotaUsages
+	// while the recount reads the DB: the reservations pending when it
+	// started, and the ones made or released since
+	pendingAtRead quotaUsages
+	sinceRead     quotaUsages
+	reading       bool
+	refreshing    bool
+	mu            sync.Mutex
+	done          chan struct{}
+}
+
+func NewQuotasService(metricsService metrics.Service, forqRepo *db.ForqRepo, appConfigs *configs.AppConfigs) (*QuotasService, error) {
+	qs := &QuotasService{
+		metricsService: metricsService,
+		forqRepo:       forqRepo,
+		globalLimits: QuotaLimits{
+			MaxMessages: appConfigs.GlobalQuotas.MaxMessages,
+			MaxBytes:    appConfigs.GlobalQuotas.MaxBytes,
+		},
+		limits:  make(map[string]QuotaLimits),
+		usage:   newQuotaUsages(),
+		pending: newQuotaUsages(),
+		done:    make(chan struct{}),
+	}
+
+	queuesSettings, err := forqRepo.SelectQueuesWithQuotas(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for _, settings := range queuesSettings {
+		qs.limits[settings.QueueName] = quotaLimitsFromDb(&settings)
+	}
+
+	if qs.hasLimitsLocked() {
+		if err := qs.RefreshUsage(); err != nil {
+			return nil, err
+		}
+		qs.refreshing = true
+		go qs.refreshPeriodically()
+	}
+
+	return qs, nil
+}
+
+// Reserve accounts for a new message of contentBytes in the queue, or returns
+// a too_many_requests error if it would exceed the queue or global quotas.
+// The reservation must be committed once the message is stored, or released
+// if it is not stored after all.
+func (qs *QuotasService) Reserve(queueName string, contentBytes int64) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if !qs.hasLimitsLocked() {
+		return nil
+	}
+
+	usage := qs.usage.of(queueName)
+	limits := qs.limits[queueName]
+
+	if exceeds(usage.messages+1, limits.MaxMessages) || exceeds(qs.usage.total.messages+1, qs.globalLimits.MaxMessages) {
+		qs.metricsService.IncQuotaRejectionsTotal(queueName, metrics.MessagesQuota)
+		return common.ErrTooManyRequestsQuotaMessages
+	}
+	if exceeds(usage.bytes+contentBytes, limits.MaxBytes) || exceeds(qs.usage.total.bytes+contentBytes, qs.globalLimits.MaxBytes) {
+		qs.metricsService.IncQuotaRejectionsTotal(queueName, metrics.BytesQuota)
+		return common.ErrTooManyRequestsQuotaBytes
+	}
+
+	reservation := quotaUsage{messages: 1, bytes: contentBytes}
+	qs.usage.add(queueName, reservation)
+	qs.pending.add(queueName, reservation)
+	if qs.reading {
+		qs.sinceRead.add(queueName, reservation)
+	}
+	return nil
+}
+
+// Commit ends the reservation of a message that is stored: it is counted by
+// the DB from now on.
+func (qs *QuotasService) Commit(queueName string, contentBytes int64) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if qs.pending.of(queueName).messages <= 0 {
+		// reserved while there were no quotas
+		return
+	}
+	qs.pending.add(queueName, quotaUsage{messages: -1, bytes: -contentBytes})
+}
+
+// Release cancels the reservation of a message that is not stored after all.
+func (qs *QuotasService) Release(queueName string, contentBytes int64) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if qs.pending.of(queueName).messages <= 0 {
+		// reserved while there were no quotas
+		return
+	}
+	release := quotaUsage{messages: -1, bytes: -contentBytes}
+	qs.pending.add(queueName, release)
+	qs.usage.add(queueName, release)
+	qs.usage.clamp(queueName)
+	if qs.reading {
+		qs.sinceRead.add(queueName, release)
+	}
+}
+
+func (qs *QuotasService) SetLimits(queueName string, limits QuotaLimits) {
+	qs.mu.Lock()
+	if limits.MaxMessages <= 0 && limits.MaxBytes <= 0 {
+		delete(qs.limits, queueName)
+	} else {
+		qs.limits[queueName] = limits
+	}
+	qs.mu.Unlock()
+
+	qs.startRefreshingIfNeeded()
+}
+
+// SetGlobalLimits replaces the global quotas, e.g. on the config reload.
+func (qs *QuotasService) SetGlobalLimits(limits QuotaLimits) {
+	qs.mu.Lock()
+	qs.globalLimits = limits
+	qs.mu.Unlock()
+
+	qs.startRefreshingIfNeeded()
+}
+
+// RefreshUsage recounts the usage from the DB. The service does it every
+// common.QuotasUsageRefreshSec on its own while there are quotas to enforce.
+func (qs *QuotasService) RefreshUsage() error {
+	ctx, cancel := context.WithTimeout(context.Background(), common.QuotasUsageRefreshSec*time.Second)
+	defer cancel()
+
+	qs.mu.Lock()
+	qs.pendingAtRead = qs.pending.clone()
+	qs.sinceRead = newQuotaUsages()
+	qs.reading = true
+	qs.mu.Unlock()
+
+	queuesUsage, err := qs.forqRepo.SelectQueuesUsage(ctx)
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.reading = false
+	if err != nil {
+		return err
+	}
+
+	usage := newQuotaUsages()
+	for _, qu := range queuesUsage {
+		usage.add(qu.QueueName, quotaUsage{messages: qu.MessagesCount, bytes: qu.ContentBytes})
+	}
+	usage.merge(qs.pendingAtRead)
+	usage.merge(qs.sinceRead)
+	for queueName := range usage.byQueue {
+		usage.clamp(queueName)
+	}
+	qs.usage = usage
+	return nil
+}
+
+// startRefreshingIfNeeded starts the recount once the first quota is set. The
+// usage isn't tracked without quotas, so it is recounted right away before
+// the new quota is enforced.
+func (qs *QuotasService) startRefreshingIfNeeded() {
+	qs.mu.Lock()
+	if qs.refreshing || !qs.hasLimitsLocked() {
+		qs.mu.Unlock()
+		return
+	}
+	qs.refreshing = true
+	qs.mu.Unlock()
+
+	if err := qs.RefreshUsage(); err != nil {
+		log.Error().Err(err).Msg("failed to refresh quotas usage, keeping the previous one")
+	}
+	go qs.refreshPeriodically()
+}
+
+// refreshPeriodically recounts the usage until the service is closed or there
+// are no quotas left to enforce.
+func (qs *QuotasService) refreshPeriodically() {
+	ticker := time.NewTicker(common.QuotasUsageRefreshSec * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			qs.mu.Lock()
+			if !qs.hasLimitsLocked() {
+				// nothing to enforce, so nothing to track either
+				qs.refreshing = false
+				qs.usage = newQuotaUsages()
+				qs.pending = newQuotaUsages()
+				qs.mu.Unlock()
+				return
+			}
+			qs.mu.Unlock()
+
+			if err := qs.RefreshUsage(); err != nil {
+				log.Error().Err(err).Msg("failed to refresh quotas usage, keeping the previous one")
+			}
+		case <-qs.done:
+			return
+		}
+	}
+}
+
+func (qs *QuotasService) hasLimitsLocked() bool {
+	return len(qs.limits) > 0 || qs.globalLimits.MaxMessages > 0 || qs.globalLimits.MaxBytes > 0
+}
+
+func (qs *QuotasService) Close() error {
+	close(qs.done)
+	return nil
+}
+
+func newQuotaUsages() quotaUsages {
+	return quotaUsages{byQueue: make(map[string]*quotaUsage)}
+}
+
+func (u *quotaUsages) of(queueName string) quotaUsage {
+	if usage, ok := u.byQueue[queueName]; ok {
+		return *usage
+	}
+	return quotaUsage{}
+}
+
+// add adds the delta, negative to subtract, to the queue and the total. The
+// queues that drop to zero are forgotten.
+func (u *quotaUsages) add(queueName string, delta quotaUsage) {
+	usage, ok := u.byQueue[queueName]
+	if !ok {
+		usage = &quotaUsage{}
+		u.byQueue[queueName] = usage
+	}
+	usage.messages += delta.messages
+	usage.bytes += delta.bytes
+	u.total.messages += delta.messages
+	u.total.bytes += delta.bytes
+
+	if usage.messages == 0 && usage.bytes == 0 {
+		delete(u.byQueue, queueName)
+	}
+}
+
+func (u *quotaUsages) merge(other quotaUsages) {
+	for queueName, usage := range other.byQueue {
+		u.add(queueName, *usage)
+	}
+}
+
+// clamp brings the usage of the queue, and the total with it, back to zero if
+// it went below.
+func (u *quotaUsages) clamp(queueName string) {
+	usage := u.of(queueName)
+	u.add(queueName, quotaUsage{messages: max(0, -usage.messages), bytes: max(0, -usage.bytes)})
+}
+
+func (u *quotaUsages) clone() quotaUsages {
+	clone := quotaUsages{byQueue: make(map[string]*quotaUsage, len(u.byQueue)), total: u.total}
+	for queueName, usage := range u.byQueue {
+		copied := *usage
+		clone.byQueue[queueName] = &copied
+	}
+	return clone
+}
+
+func exceeds(value int64, limit int64) bool {
+	return limit > 0 && value > limit
+}
+
+func quotaLimitsFromDb(settings *db.QueueSettings) QuotaLimits {
+	var limits QuotaLimits
+	if settings.MaxMessages != nil {
+		limits.MaxMessages = *settings.MaxMessages
+	}
+	if settings.MaxBytes != nil {
+		limits.MaxBytes = *settings.MaxBytes
+	}
+	return limits
+}
@@ -0,0 +1,104 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/metrics"
+	"github.com/n0rdy/forq/services"
+
+	"github.com/google/uuid"
+)
+
+func newQuotasService(t *testing.T) (*services.QuotasService, *db.ForqRepo) {
+	t.Helper()
+	repo, appConfigs, _ := testutil.NewTestRepo(t)
+	qs, err := services.NewQuotasService(metrics.NewMetricsService(configs.MetricsSettings{}), repo, appConfigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { qs.Close() })
+	return qs, repo
+}
+
+func insertMessage(t *testing.T, repo *db.ForqRepo, queueName string, content string) {
+	t.Helper()
+	id, err := uuid.NewV7()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nowMs := time.Now().UnixMilli()
+	err = repo.InsertMessage(&db.NewMessage{
+		Id:           id.String(),
+		QueueName:    queueName,
+		Content:      content,
+		ProcessAfter: nowMs,
+		ReceivedAt:   nowMs,
+		UpdatedAt:    nowMs,
+		ExpiresAfter: nowMs + 24*60*60*1000,
+	}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotasService_RefreshKeepsPendingReservations(t *testing.T) {
+	qs, repo := newQuotasService(t)
+	qs.SetLimits("orders", services.QuotaLimits{MaxMessages: 2})
+
+	if err := qs.Reserve("orders", 1); err != nil {
+		t.Fatal(err)
+	}
+	// the message isn't stored yet when the usage is recounted
+	if err := qs.RefreshUsage(); err != nil {
+		t.Fatal(err)
+	}
+	insertMessage(t, repo, "orders", "x")
+	qs.Commit("orders", 1)
+
+	if err := qs.Reserve("orders", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := qs.Reserve("orders", 1); !errors.Is(err, common.ErrTooManyRequestsQuotaMessages) {
+		t.Fatalf("reserve over the quota after the refresh: got %v, want ErrTooManyRequestsQuotaMessages", err)
+	}
+}
+
+func TestQuotasService_ReleaseFreesTheQuota(t *testing.T) {
+	qs, _ := newQuotasService(t)
+	qs.SetLimits("orders", services.QuotaLimits{MaxMessages: 1})
+
+	if err := qs.Reserve("orders", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := qs.RefreshUsage(); err != nil {
+		t.Fatal(err)
+	}
+	qs.Release("orders", 1)
+
+	if err := qs.Reserve("orders", 1); err != nil {
+		t.Fatalf("reserve after the release: %v", err)
+	}
+}
+
+func TestQuotasService_QuotaSetLaterCountsStoredMessages(t *testing.T) {
+	qs, repo := newQuotasService(t)
+
+	// no quotas yet, so nothing is tracked
+	if err := qs.Reserve("orders", 1); err != nil {
+		t.Fatal(err)
+	}
+	insertMessage(t, repo, "orders", "x")
+	qs.Commit("orders", 1)
+
+	qs.SetLimits("orders", services.QuotaLimits{MaxMessages: 1})
+	if err := qs.Reserve("orders", 1); !errors.Is(err, common.ErrTooManyRequestsQuotaMessages) {
+		t.Fatalf("reserve over the quota set later: got %v, want ErrTooManyRequestsQuotaMessages", err)
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/metrics"
+)
+
+const (
+	rateLimitingSweepMs     = 60 * 1000 // background cleanup interval
+	rateLimitingMaxBuckets  = 10000     // hard cap on tracked clients; one is evicted per new one on overflow
+	rateLimitingEvictSample = 8         // buckets looked at to evict one, preferably a full one
+)
+
+type rateBucket struct {
+	tokens     float64
+	capacity   float64
+	ratePerSec float64
+	lastMs     int64
+}
+
+// RateLimitDecision is the outcome of RateLimitingService.Allow. The limit
+// reported is the most restrictive of the ones that apply, for the
+// RateLimit-* headers, and is zero if the route is unlimited.
+type RateLimitDecision struct {
+	Allowed       bool
+	Limit         int // the burst
+	Remaining     int
+	ResetSec      int // until the bucket is full again
+	RetryAfterSec int // until the next request is allowed, if rejected
+}
+
+// RateLimitingService enforces the API request rate limits of the
+// authenticated clients, per API key and per client IP, with a token bucket
+// per route, limit and client. The failed auth attempts are left to the
+// ThrottlingService. The buckets are in-memory only, per instance: a process
+// restart refills them.
+type RateLimitingService struct {
+	metricsService metrics.Service
+	limits         map[string]configs.RouteRateLimits // by route
+	buckets        map[string]*rateBucket
+	mu             sync.Mutex
+	ticker         *time.Ticker
+	done           chan struct{}
+}
+
+func NewRateLimitingService(metricsService metrics.Service, settings configs.RateLimitsSettings) *RateLimitingService {
+	ticker := time.NewTicker(rateLimitingSweepMs * time.Millisecond)
+
+	rs := &RateLimitingService{
+		metricsService: metricsService,
+		limits: map[string]configs.RouteRateLimits{
+			common.ProducePermission: settings.Produce,
+			common.ConsumePermission: settings.Consume,
+			common.AdminPermission:   settings.Admin,
+		},
+		buckets: make(map[string]*rateBucket),
+		ticker:  ticker,
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				rs.mu.Lock()
+				rs.deleteFullBucketsLocked(now.UnixMilli())
+				rs.mu.Unlock()
+			case <-rs.done:
+				return
+			}
+		}
+	}()
+
+	return rs
+}
+
+// Allow takes a token from both the key's and the IP's bucket of the route,
+// or from neither if either is empty, so that a rejected request doesn't use
+// up the other budget. The route is the permission it requires, e.g.
+// common.ProducePermission. The key is the name of the API key, or empty if
+// unknown.
+func (rs *RateLimitingService) Allow(route string, key string, ip string) RateLimitDecision {
+	limits := rs.limits[route]
+	nowMs := time.Now().UnixMilli()
+
+	type check struct {
+		kind   string
+		bucket *rateBucket
+	}
+	var checks []check
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if key != "" && limits.PerKey.RatePerSec > 0 {
+		checks = append(checks, check{metrics.KeyRateLimit, rs.bucketLocked(route+"|key:"+key, limits.PerKey, nowMs)})
+	}
+	if limits.PerIp.RatePerSec > 0 {
+		checks = append(checks, check{metrics.IpRateLimit, rs.bucketLocked(route+"|ip:"+ip, limits.PerIp, nowMs)})
+	}
+
+	decision := RateLimitDecision{Allowed: true}
+	for _, c := range checks {
+		c.bucket.refill(nowMs)
+		if c.bucket.tokens < 1 {
+			decision.Allowed = false
+			decision.RetryAfterSec = max(decision.RetryAfterSec, secondsUntil(1-c.bucket.tokens, c.bucket.ratePerSec))
+			rs.metricsService.IncRateLimitedRequestsTotal(route, c.kind)
+		}
+	}
+	for _, c := range checks {
+		if decision.Allowed {
+			c.bucket.tokens--
+		}
+		remaining := int(math.Max(0, math.Floor(c.bucket.tokens)))
+		if decision.Limit == 0 || remaining < decision.Remaining {
+			decision.Limit = int(c.bucket.capacity)
+			decision.Remaining = remaining
+			decision.ResetSec = secondsUntil(c.bucket.capacity-c.bucket.tokens, c.bucket.ratePerSec)
+		}
+	}
+	return decision
+}
+
+// bucketLocked returns the bucket of the client, a full one if it's new.
+// Caller must hold rs.mu.
+func (rs *RateLimitingService) bucketLocked(bucketKey string, limit configs.RateLimit, nowMs int64) *rateBucket {
+	b, ok := rs.buckets[bucketKey]
+	if ok {
+		return b
+	}
+
+	if len(rs.buckets) >= rateLimitingMaxBuckets {
+		rs.evictOneLocked(nowMs)
+	}
+
+	capacity := float64(limit.Burst)
+	if capacity <= 0 {
+		capacity = math.Max(1, math.Ceil(limit.RatePerSec))
+	}
+	b = &rateBucket{
+		tokens:     capacity,
+		capacity:   capacity,
+		ratePerSec: limit.RatePerSec,
+		lastMs:     nowMs,
+	}
+	rs.buckets[bucketKey] = b
+	return b
+}
+
+// evictOneLocked makes room for a new bucket. Sweeping all the full buckets
+// here would scan the whole map for every new client while at the cap, so it
+// only looks at a few random ones instead, and evicts the first full one, or
+// the last one looked at. Evicting a bucket that isn't full only makes its
+// client's limit more lenient until its next request. Caller must hold rs.mu.
+func (rs *RateLimitingService) evictOneLocked(nowMs int64) {
+	var victim string
+	looked := 0
+	// the map iteration starts at a random bucket
+	for k, b := range rs.buckets {
+		victim = k
+		b.refill(nowMs)
+		looked++
+		if b.tokens >= b.capacity || looked >= rateLimitingEvictSample {
+			break
+		}
+	}
+	delete(rs.buckets, victim)
+}
+
+// deleteFullBucketsLocked removes the buckets refilled by now, which are no
+// different from the new ones. Caller must hold rs.mu.
+func (rs *RateLimitingService) deleteFullBucketsLocked(nowMs int64) {
+	for k, b := range rs.buckets {
+		b.refill(nowMs)
+		if b.tokens >= b.capacity {
+			delete(rs.buckets, k)
+		}
+	}
+}
+
+func (rs *RateLimitingService) Close() error {
+	rs.ticker.Stop()
+	close(rs.done)
+	return nil
+}
+
+func (b *rateBucket) refill(nowMs int64) {
+	elapsedMs := nowMs - b.lastMs
+	if elapsedMs <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.capacity, b.tokens+float64(elapsedMs)*b.ratePerSec/1000)
+	b.lastMs = nowMs
+}
+
+// secondsUntil returns how many whole seconds it takes to refill the tokens,
+// at least 1 if there are any to refill.
+func secondsUntil(tokens float64, ratePerSec float64) int {
+	if tokens <= 0 {
+		return 0
+	}
+	return max(1, int(math.Ceil(tokens/ratePerSec)))
+}
@@ -0,0 +1,64 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/metrics"
+	"github.com/n0rdy/forq/services"
+)
+
+func newTestRateLimitingService(t *testing.T, settings configs.RateLimitsSettings) *services.RateLimitingService {
+	t.Helper()
+
+	rs := services.NewRateLimitingService(metrics.NewMetricsService(configs.MetricsSettings{}), settings)
+	t.Cleanup(func() { rs.Close() })
+	return rs
+}
+
+func TestRateLimiting_PerKeyAndPerIp(t *testing.T) {
+	rs := newTestRateLimitingService(t, configs.RateLimitsSettings{
+		Produce: configs.RouteRateLimits{
+			PerKey: configs.RateLimit{RatePerSec: 0.01, Burst: 3},
+			PerIp:  configs.RateLimit{RatePerSec: 0.01, Burst: 2},
+		},
+	})
+
+	ip := "203.0.113.7"
+	for i := range 2 {
+		if d := rs.Allow(common.ProducePermission, "producer", ip); !d.Allowed || d.Limit != 2 || d.Remaining != 1-i {
+			t.Fatalf("request %d within the limits: %+v", i+1, d)
+		}
+	}
+
+	// the IP is out of tokens, whichever the key
+	d := rs.Allow(common.ProducePermission, "other", ip)
+	if d.Allowed || d.RetryAfterSec < 1 {
+		t.Fatalf("request over the IP limit: %+v", d)
+	}
+
+	// the key has one token left, not taken by the rejected request
+	if d := rs.Allow(common.ProducePermission, "producer", "203.0.113.8"); !d.Allowed || d.Limit != 3 || d.Remaining != 0 {
+		t.Fatalf("request of the key from another IP: %+v", d)
+	}
+	if d := rs.Allow(common.ProducePermission, "producer", "203.0.113.8"); d.Allowed {
+		t.Fatalf("request over the key limit: %+v", d)
+	}
+
+	// the other routes are unlimited
+	if d := rs.Allow(common.ConsumePermission, "producer", ip); !d.Allowed || d.Limit != 0 {
+		t.Fatalf("request of an unlimited route: %+v", d)
+	}
+}
+
+func TestRateLimiting_DefaultBurst(t *testing.T) {
+	rs := newTestRateLimitingService(t, configs.RateLimitsSettings{
+		Admin: configs.RouteRateLimits{PerIp: configs.RateLimit{RatePerSec: 2.5}},
+	})
+
+	d := rs.Allow(common.AdminPermission, "", "203.0.113.7")
+	if !d.Allowed || d.Limit != 3 {
+		t.Fatalf("burst of 2.5/s = %d, want 3", d.Limit)
+	}
+}
@@ -1,44 +1,82 @@
 package services
 
 import (
-	"sync"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/db"
+
+	"github.com/rs/zerolog/log"
 )
 
 const (
-	sessionExpiryDurationMs = 7 * 24 * 60 * 60 * 1000 // 7 days in milliseconds
+	sessionsCleanupInterval = 1 * time.Hour
+	// the last activity is written at most this often, rather than on every
+	// UI request, so the idle timeout can end a session up to this much late
+	lastActiveUpdateInterval = 1 * time.Minute
+	maxUserAgentLength       = 256
 )
 
+// SessionUser is the one logged in to the admin UI: an account, or the
+// superuser logged in with an auth secret or an admin API key, as an admin.
+type SessionUser struct {
+	Name string
+	Role string
+}
+
+// Has reports whether the user's role includes the given one.
+func (u SessionUser) Has(role string) bool {
+	return common.SupportedRoles[u.Role] >= common.SupportedRoles[role]
+}
+
+// CanOperate and IsAdmin are for the templates.
+func (u SessionUser) CanOperate() bool {
+	return u.Has(common.OperatorRole)
+}
+
+func (u SessionUser) IsAdmin() bool {
+	return u.Has(common.AdminRole)
+}
+
+// SessionClient is where a session was created from, to tell the sessions
+// apart on the sessions page.
+type SessionClient struct {
+	Ip        string
+	UserAgent string
+}
+
+// SessionsService keeps the admin UI sessions in SQLite, so that they survive
+// restarts. Only the hashes of the session IDs are stored, like the API keys,
+// so that a leaked DB backup can't be used to log in.
 type SessionsService struct {
-	activeSessions map[string]int64
-	mu             sync.RWMutex
-	ticker         *time.Ticker
-	done           chan struct{}
+	forqRepo        *db.ForqRepo
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	ticker          *time.Ticker
+	done            chan struct{}
 }
 
-func NewSessionsService() *SessionsService {
-	ticker := time.NewTicker(1 * time.Hour)
+func NewSessionsService(forqRepo *db.ForqRepo, idleTimeout time.Duration, absoluteTimeout time.Duration) *SessionsService {
+	ticker := time.NewTicker(sessionsCleanupInterval)
 
 	ss := &SessionsService{
-		activeSessions: make(map[string]int64),
-		mu:             sync.RWMutex{},
-		ticker:         ticker,
-		done:           make(chan struct{}),
+		forqRepo:        forqRepo,
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+		ticker:          ticker,
+		done:            make(chan struct{}),
 	}
 
 	go func() {
+		ss.deleteExpiredSessions(time.Now()) // the ones expired while Forq was down
 		for {
 			select {
 			case now := <-ticker.C:
-				ss.mu.Lock()
-				for sessionId, expiry := range ss.activeSessions {
-					if expiry < now.UnixMilli() {
-						delete(ss.activeSessions, sessionId)
-					}
-				}
-				ss.mu.Unlock()
+				ss.deleteExpiredSessions(now)
 			case <-ss.done:
 				return
 			}
@@ -48,33 +86,123 @@ func NewSessionsService() *SessionsService {
 	return ss
 }
 
-func (ss *SessionsService) CreateSession() (string, int64) {
-	sessionId := uuid.New().String()
-	expiresAt := time.Now().UnixMilli() + sessionExpiryDurationMs
+func (ss *SessionsService) CreateSession(user SessionUser, client SessionClient, ctx context.Context) (string, error) {
+	sessionId := rand.Text()
+	now := time.Now().UnixMilli()
 
-	ss.mu.Lock()
-	ss.activeSessions[sessionId] = expiresAt
-	ss.mu.Unlock()
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
 
-	return sessionId, expiresAt
+	session := db.Session{
+		IdHash:       hashSessionId(sessionId),
+		Username:     user.Name,
+		Role:         user.Role,
+		CreatedAt:    now,
+		LastActiveAt: now,
+		ClientIp:     client.Ip,
+		UserAgent:    userAgent,
+	}
+	if err := ss.forqRepo.InsertSession(&session, ctx); err != nil {
+		return "", err
+	}
+	return sessionId, nil
 }
 
-func (ss *SessionsService) IsSessionValid(sessionId string) bool {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
+// SessionUser returns nil if the session is unknown or expired.
+func (ss *SessionsService) SessionUser(sessionId string, ctx context.Context) (*SessionUser, error) {
+	session, err := ss.forqRepo.SelectSession(hashSessionId(sessionId), ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if session == nil || ss.isExpired(session, now) {
+		return nil, nil
+	}
 
-	if expiry, exists := ss.activeSessions[sessionId]; exists {
-		if time.Now().UnixMilli() < expiry {
-			return true
+	if now.UnixMilli()-session.LastActiveAt >= lastActiveUpdateInterval.Milliseconds() {
+		if err := ss.forqRepo.UpdateSessionLastActive(session.IdHash, now.UnixMilli(), ctx); err != nil {
+			return nil, err
 		}
 	}
-	return false
+	return &SessionUser{Name: session.Username, Role: session.Role}, nil
 }
 
-func (ss *SessionsService) InvalidateSession(sessionId string) {
-	ss.mu.Lock()
-	delete(ss.activeSessions, sessionId)
-	ss.mu.Unlock()
+// GetSessions marks the current session, so that it's not revoked by mistake.
+func (ss *SessionsService) GetSessions(currentSessionId string, ctx context.Context) ([]common.Session, error) {
+	sessions, err := ss.forqRepo.SelectAllSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currentIdHash := hashSessionId(currentSessionId)
+	now := time.Now()
+	result := make([]common.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if ss.isExpired(&session, now) {
+			continue // not cleaned up yet
+		}
+		result = append(result, common.Session{
+			ID:           session.IdHash,
+			Username:     session.Username,
+			Role:         session.Role,
+			ClientIp:     session.ClientIp,
+			UserAgent:    session.UserAgent,
+			CreatedAt:    time.UnixMilli(session.CreatedAt).Format("2006-01-02 15:04:05"),
+			LastActiveAt: time.UnixMilli(session.LastActiveAt).Format("2006-01-02 15:04:05"),
+			Current:      session.IdHash == currentIdHash,
+		})
+	}
+	return result, nil
+}
+
+func (ss *SessionsService) Timeouts() (time.Duration, time.Duration) {
+	return ss.idleTimeout, ss.absoluteTimeout
+}
+
+func (ss *SessionsService) InvalidateSession(sessionId string, ctx context.Context) error {
+	_, err := ss.forqRepo.DeleteSession(hashSessionId(sessionId), ctx)
+	return err
+}
+
+// RevokeSession ends the session listed on the sessions page, which shows
+// the hashes as the IDs. It returns the username of the session.
+func (ss *SessionsService) RevokeSession(idHash string, ctx context.Context) (string, error) {
+	username, err := ss.forqRepo.DeleteSession(idHash, ctx)
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", common.ErrNotFoundSession
+	}
+
+	log.Info().Str("username", username).Msg("session revoked")
+	return username, nil
+}
+
+// InvalidateUserSessions logs the user out everywhere, e.g. once their role
+// has changed or they are deleted.
+func (ss *SessionsService) InvalidateUserSessions(username string, ctx context.Context) error {
+	_, err := ss.forqRepo.DeleteUserSessions(username, ctx)
+	return err
+}
+
+func (ss *SessionsService) isExpired(session *db.Session, now time.Time) bool {
+	return now.UnixMilli()-session.LastActiveAt > ss.idleTimeout.Milliseconds() ||
+		now.UnixMilli()-session.CreatedAt > ss.absoluteTimeout.Milliseconds()
+}
+
+func (ss *SessionsService) deleteExpiredSessions(now time.Time) {
+	idleBefore := now.Add(-ss.idleTimeout).UnixMilli()
+	createdBefore := now.Add(-ss.absoluteTimeout).UnixMilli()
+	deleted, err := ss.forqRepo.DeleteExpiredSessions(idleBefore, createdBefore, context.Background())
+	if err != nil {
+		return // logged by the repo, retried on the next tick
+	}
+	if deleted > 0 {
+		log.Debug().Int64("count", deleted).Msg("expired sessions deleted")
+	}
 }
 
 func (ss *SessionsService) Close() error {
@@ -82,3 +210,8 @@ func (ss *SessionsService) Close() error {
 	close(ss.done)
 	return nil
 }
+
+func hashSessionId(sessionId string) string {
+	hash := sha256.Sum256([]byte(sessionId))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,153 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/services"
+)
+
+func TestSessionsService_SurvivesRestart(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	user := services.SessionUser{Name: "jane", Role: common.OperatorRole}
+
+	sessionsService := services.NewSessionsService(repo, time.Hour, 24*time.Hour)
+	sessionId, err := sessionsService.CreateSession(user, services.SessionClient{Ip: "10.0.0.1", UserAgent: "curl/8.5.0"}, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionsService.Close()
+
+	var stored int
+	if err := rawDB.QueryRow("SELECT COUNT(*) FROM sessions WHERE id_hash = ?", sessionId).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatal("the session ID is stored in plain text")
+	}
+
+	restarted := services.NewSessionsService(repo, time.Hour, 24*time.Hour)
+	t.Cleanup(func() { restarted.Close() })
+	got, err := restarted.SessionUser(sessionId, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || *got != user {
+		t.Fatalf("session user after restart = %+v, want %+v", got, user)
+	}
+
+	sessions, err := restarted.GetSessions(sessionId, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || !sessions[0].Current || sessions[0].ClientIp != "10.0.0.1" || sessions[0].UserAgent != "curl/8.5.0" {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+}
+
+func TestSessionsService_Timeouts(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	sessionsService := services.NewSessionsService(repo, time.Hour, 24*time.Hour)
+	t.Cleanup(func() { sessionsService.Close() })
+
+	newSession := func() string {
+		t.Helper()
+		sessionId, err := sessionsService.CreateSession(services.SessionUser{Name: "jane", Role: common.ViewerRole}, services.SessionClient{}, t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sessionId
+	}
+	// backdate moves the session's timestamps, as if it was created and
+	// last used that long ago
+	backdate := func(createdAgo time.Duration, lastActiveAgo time.Duration) {
+		t.Helper()
+		now := time.Now()
+		_, err := rawDB.Exec("UPDATE sessions SET created_at = ?, last_active_at = ?",
+			now.Add(-createdAgo).UnixMilli(), now.Add(-lastActiveAgo).UnixMilli())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name                      string
+		createdAgo, lastActiveAgo time.Duration
+		wantValid                 bool
+	}{
+		{"active", 2 * time.Hour, 5 * time.Minute, true},
+		{"idle", 2 * time.Hour, 2 * time.Hour, false},
+		{"past the absolute timeout while active", 25 * time.Hour, time.Minute, false},
+	}
+	for _, tc := range tests {
+		if _, err := rawDB.Exec("DELETE FROM sessions"); err != nil {
+			t.Fatal(err)
+		}
+		sessionId := newSession()
+		backdate(tc.createdAgo, tc.lastActiveAgo)
+
+		user, err := sessionsService.SessionUser(sessionId, t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (user != nil) != tc.wantValid {
+			t.Errorf("%s: session user = %+v, want valid %v", tc.name, user, tc.wantValid)
+		}
+	}
+
+	// the use extends the idle timeout
+	if _, err := rawDB.Exec("DELETE FROM sessions"); err != nil {
+		t.Fatal(err)
+	}
+	sessionId := newSession()
+	backdate(50*time.Minute, 50*time.Minute)
+	if user, _ := sessionsService.SessionUser(sessionId, t.Context()); user == nil {
+		t.Fatal("active session rejected")
+	}
+	var lastActiveAt int64
+	if err := rawDB.QueryRow("SELECT last_active_at FROM sessions").Scan(&lastActiveAt); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(time.UnixMilli(lastActiveAt)) > time.Minute {
+		t.Fatal("last activity not updated")
+	}
+}
+
+func TestSessionsService_Revoke(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	sessionsService := services.NewSessionsService(repo, time.Hour, 24*time.Hour)
+	t.Cleanup(func() { sessionsService.Close() })
+
+	janeSessionId, _ := sessionsService.CreateSession(services.SessionUser{Name: "jane", Role: common.AdminRole}, services.SessionClient{}, t.Context())
+	otherJaneSessionId, _ := sessionsService.CreateSession(services.SessionUser{Name: "jane", Role: common.AdminRole}, services.SessionClient{}, t.Context())
+	johnSessionId, _ := sessionsService.CreateSession(services.SessionUser{Name: "john", Role: common.ViewerRole}, services.SessionClient{}, t.Context())
+
+	sessions, err := sessionsService.GetSessions(janeSessionId, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range sessions {
+		if session.Username == "john" {
+			if username, err := sessionsService.RevokeSession(session.ID, t.Context()); err != nil || username != "john" {
+				t.Fatalf("revoke = %q, %v", username, err)
+			}
+		}
+	}
+	if user, _ := sessionsService.SessionUser(johnSessionId, t.Context()); user != nil {
+		t.Fatal("revoked session still valid")
+	}
+	if _, err := sessionsService.RevokeSession("unknown", t.Context()); err != common.ErrNotFoundSession {
+		t.Fatalf("revoke unknown = %v, want %v", err, common.ErrNotFoundSession)
+	}
+
+	if err := sessionsService.InvalidateUserSessions("jane", t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	for _, sessionId := range []string{janeSessionId, otherJaneSessionId} {
+		if user, _ := sessionsService.SessionUser(sessionId, t.Context()); user != nil {
+			t.Fatal("session still valid after invalidating the user's sessions")
+		}
+	}
+}
@@ -1,57 +1,69 @@
 package services
 
 import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/db"
+
+	"github.com/rs/zerolog/log"
 )
 
 const (
-	throttlingWindowMs   = 60 * 1000      // sliding window for counting failures
-	throttlingMaxFails   = 5              // failures within window that trigger a lockout
-	throttlingLockoutMs  = 60 * 1000      // lockout duration after threshold reached
 	throttlingSweepMs    = 5 * 60 * 1000  // background cleanup interval
-	throttlingStaleMs    = 10 * 60 * 1000 // entries idle this long are removed
-	throttlingMaxEntries = 10000          // hard cap on tracked IPs; oldest evicted on overflow
+	throttlingStaleMs    = 10 * 60 * 1000 // entries idle this long are removed, unless their strikes are still remembered
+	throttlingMaxEntries = 10000          // hard cap on tracked subjects; oldest evicted on overflow
 )
 
 type throttlingEntry struct {
 	failures    []int64
 	lockedUntil int64
+	strikes     int // the lockouts in a row, each one doubles the next one
 	lastSeenMs  int64
 }
 
-// ThrottlingService tracks failed-auth attempts per IP and locks out IPs
-// that exceed the threshold within a sliding window. In-memory only:
-// process restart wipes all state.
+// ThrottlingService tracks failed-auth attempts per subject - the client IP,
+// and the credential tried, if known - and locks out the subjects that exceed
+// the threshold within a sliding window. Each repeated lockout is twice as
+// long as the previous one, up to the max lockout, which is also how long the
+// strikes are remembered for after a lockout ends.
+//
+// The failures are counted in memory, so a restart wipes them. If persisted,
+// the lockouts are also kept in SQLite, which is then the source of truth for
+// whether a subject is locked: the lockouts survive restarts, and the ones of
+// an instance, as well as the manual unlocks, apply to all the instances
+// sharing the DB. In-memory lockouts remain the fallback if the DB fails.
 type ThrottlingService struct {
-	entries map[string]*throttlingEntry
-	mu      sync.Mutex
-	ticker  *time.Ticker
-	done    chan struct{}
+	forqRepo *db.ForqRepo
+	settings configs.ThrottlingSettings
+	entries  map[string]*throttlingEntry
+	mu       sync.Mutex
+	ticker   *time.Ticker
+	done     chan struct{}
 }
 
-func NewThrottlingService() *ThrottlingService {
+func NewThrottlingService(forqRepo *db.ForqRepo, settings configs.ThrottlingSettings) *ThrottlingService {
 	ticker := time.NewTicker(throttlingSweepMs * time.Millisecond)
 
 	ts := &ThrottlingService{
-		entries: make(map[string]*throttlingEntry),
-		ticker:  ticker,
-		done:    make(chan struct{}),
+		forqRepo: forqRepo,
+		settings: settings,
+		entries:  make(map[string]*throttlingEntry),
+		ticker:   ticker,
+		done:     make(chan struct{}),
 	}
 
 	go func() {
 		for {
 			select {
 			case now := <-ticker.C:
-				nowMs := now.UnixMilli()
-				cutoff := nowMs - throttlingStaleMs
-				ts.mu.Lock()
-				for ip, e := range ts.entries {
-					if e.lastSeenMs < cutoff && nowMs >= e.lockedUntil {
-						delete(ts.entries, ip)
-					}
-				}
-				ts.mu.Unlock()
+				ts.sweep(now.UnixMilli())
 			case <-ts.done:
 				return
 			}
@@ -61,30 +73,140 @@ func NewThrottlingService() *ThrottlingService {
 	return ts
 }
 
-func (ts *ThrottlingService) IsLocked(ip string) bool {
+// IsLocked reports whether the IP or the credential is locked out. The
+// credential is the one tried, as "<kind>:<name>", e.g. "user:jane", or empty
+// if unknown.
+func (ts *ThrottlingService) IsLocked(ip string, credential string, ctx context.Context) bool {
+	now := time.Now().UnixMilli()
+	for _, subject := range throttlingSubjects(ip, credential) {
+		if ts.isLocked(subject, now, ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCredentialLocked reports whether the credential alone is locked out,
+// regardless of the IP, see IsLocked. An empty credential is never locked.
+func (ts *ThrottlingService) IsCredentialLocked(credential string, ctx context.Context) bool {
+	if credential == "" {
+		return false
+	}
+	return ts.isLocked(credential, time.Now().UnixMilli(), ctx)
+}
+
+// RecordFailure counts the failed attempt against both the IP and the
+// credential, see IsLocked.
+func (ts *ThrottlingService) RecordFailure(ip string, credential string, ctx context.Context) {
+	now := time.Now().UnixMilli()
+	for _, subject := range throttlingSubjects(ip, credential) {
+		if strikes, exceeded := ts.recordFailure(subject, now); exceeded {
+			ts.lockOut(subject, strikes, now, ctx)
+		}
+	}
+}
+
+// GetLockouts returns the current lockouts, the longest first.
+func (ts *ThrottlingService) GetLockouts(ctx context.Context) ([]common.Lockout, error) {
+	now := time.Now().UnixMilli()
+
+	var lockouts []db.Lockout
+	if ts.settings.Persist {
+		var err error
+		lockouts, err = ts.forqRepo.SelectActiveLockouts(now, ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ts.mu.Lock()
+		for subject, e := range ts.entries {
+			if now < e.lockedUntil {
+				lockouts = append(lockouts, db.Lockout{Subject: subject, LockedUntil: e.lockedUntil, Strikes: e.strikes})
+			}
+		}
+		ts.mu.Unlock()
+		slices.SortFunc(lockouts, func(a, b db.Lockout) int {
+			return cmp.Compare(b.LockedUntil, a.LockedUntil)
+		})
+	}
+
+	result := make([]common.Lockout, 0, len(lockouts))
+	for _, lockout := range lockouts {
+		kind, name, _ := strings.Cut(lockout.Subject, ":")
+		result = append(result, common.Lockout{
+			Subject:     lockout.Subject,
+			Kind:        kind,
+			Name:        name,
+			Strikes:     lockout.Strikes,
+			LockedUntil: time.UnixMilli(lockout.LockedUntil).Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+// Unlock lifts the lockout of the subject listed on the lockouts page and
+// forgets its failures and strikes.
+func (ts *ThrottlingService) Unlock(subject string, ctx context.Context) error {
+	now := time.Now().UnixMilli()
+
+	ts.mu.Lock()
+	e, ok := ts.entries[subject]
+	found := ok && now < e.lockedUntil
+	delete(ts.entries, subject)
+	ts.mu.Unlock()
+
+	if ts.settings.Persist {
+		deleted, err := ts.forqRepo.DeleteLockout(subject, ctx)
+		if err != nil {
+			return err
+		}
+		found = found || deleted
+	}
+	if !found {
+		return common.ErrNotFoundLockout
+	}
+
+	log.Info().Str("subject", subject).Msg("auth lockout lifted")
+	return nil
+}
+
+func (ts *ThrottlingService) Settings() configs.ThrottlingSettings {
+	return ts.settings
+}
+
+func (ts *ThrottlingService) isLocked(subject string, now int64, ctx context.Context) bool {
+	if ts.settings.Persist {
+		lockout, err := ts.forqRepo.SelectLockout(subject, ctx)
+		if err == nil {
+			return lockout != nil && now < lockout.LockedUntil
+		}
+		// logged by the repo, falls back to the lockouts of this instance
+	}
+
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
-	e, ok := ts.entries[ip]
+	e, ok := ts.entries[subject]
 	if !ok {
 		return false
 	}
-	return time.Now().UnixMilli() < e.lockedUntil
+	return now < e.lockedUntil
 }
 
-func (ts *ThrottlingService) RecordFailure(ip string) {
-	now := time.Now().UnixMilli()
-	cutoff := now - throttlingWindowMs
+// recordFailure returns the strikes so far and true once the failures reach
+// the threshold.
+func (ts *ThrottlingService) recordFailure(subject string, now int64) (int, bool) {
+	cutoff := now - ts.settings.Window.Milliseconds()
 
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	e, ok := ts.entries[ip]
+	e, ok := ts.entries[subject]
 	if !ok {
 		if len(ts.entries) >= throttlingMaxEntries {
 			ts.evictOldestEntryLocked()
 		}
 		e = &throttlingEntry{}
-		ts.entries[ip] = e
+		ts.entries[subject] = e
 	}
 
 	pruned := e.failures[:0]
@@ -96,12 +218,76 @@ func (ts *ThrottlingService) RecordFailure(ip string) {
 	e.failures = append(pruned, now)
 	e.lastSeenMs = now
 
-	if len(e.failures) >= throttlingMaxFails {
-		e.lockedUntil = now + throttlingLockoutMs
-		// Clear failures so the next window starts with a fresh budget once the
-		// lockout expires; otherwise old timestamps inside the sliding window
-		// would re-trigger a lock on the very first post-lockout failure.
-		e.failures = e.failures[:0]
+	if len(e.failures) < ts.settings.MaxFails {
+		return 0, false
+	}
+	// Clear failures so the next window starts with a fresh budget once the
+	// lockout expires; otherwise old timestamps inside the sliding window
+	// would re-trigger a lock on the very first post-lockout failure.
+	e.failures = e.failures[:0]
+	if e.lockedUntil < now-ts.settings.MaxLockout.Milliseconds() {
+		e.strikes = 0 // forgotten, even if not swept yet
+	}
+	return e.strikes, true
+}
+
+func (ts *ThrottlingService) lockOut(subject string, strikes int, now int64, ctx context.Context) {
+	if ts.settings.Persist {
+		// the strikes of the other instances and of before a restart count,
+		// and the manual unlocks on the other instances forgive them
+		lockout, err := ts.forqRepo.SelectLockout(subject, ctx)
+		if err == nil {
+			strikes = 0
+			if lockout != nil && lockout.LockedUntil >= now-ts.settings.MaxLockout.Milliseconds() {
+				strikes = lockout.Strikes
+			}
+		}
+		// logged by the repo, falls back to the strikes of this instance
+	}
+	strikes++
+	lockedUntil := now + ts.lockoutMs(strikes)
+
+	ts.mu.Lock()
+	if e, ok := ts.entries[subject]; ok {
+		e.strikes = strikes
+		e.lockedUntil = lockedUntil
+	}
+	ts.mu.Unlock()
+
+	if ts.settings.Persist {
+		lockout := db.Lockout{Subject: subject, LockedUntil: lockedUntil, Strikes: strikes}
+		// logged by the repo, the in-memory lockout still applies on this instance
+		ts.forqRepo.UpsertLockout(&lockout, context.WithoutCancel(ctx))
+	}
+	log.Warn().Str("subject", subject).Int("strikes", strikes).Time("locked_until", time.UnixMilli(lockedUntil)).Msg("auth locked out after failed attempts")
+}
+
+// lockoutMs doubles the lockout on each strike after the first one, up to the
+// max lockout.
+func (ts *ThrottlingService) lockoutMs(strikes int) int64 {
+	lockoutMs := ts.settings.Lockout.Milliseconds()
+	maxLockoutMs := ts.settings.MaxLockout.Milliseconds()
+	for i := 1; i < strikes && lockoutMs < maxLockoutMs; i++ {
+		lockoutMs *= 2
+	}
+	return min(lockoutMs, maxLockoutMs)
+}
+
+func (ts *ThrottlingService) sweep(nowMs int64) {
+	cutoff := nowMs - throttlingStaleMs
+	strikesCutoff := nowMs - ts.settings.MaxLockout.Milliseconds()
+
+	ts.mu.Lock()
+	for subject, e := range ts.entries {
+		if e.lastSeenMs < cutoff && e.lockedUntil < strikesCutoff {
+			delete(ts.entries, subject)
+		}
+	}
+	ts.mu.Unlock()
+
+	if ts.settings.Persist {
+		// logged by the repo, retried on the next tick
+		ts.forqRepo.DeleteLockoutsEndedBefore(strikesCutoff, context.Background())
 	}
 }
 
@@ -114,27 +300,27 @@ func (ts *ThrottlingService) RecordFailure(ip string) {
 func (ts *ThrottlingService) evictOldestEntryLocked() {
 	nowMs := time.Now().UnixMilli()
 
-	var oldestUnlockedIP, oldestLockedIP string
+	var oldestUnlockedSubject, oldestLockedSubject string
 	var oldestUnlockedMs, oldestLockedMs int64
-	for ip, e := range ts.entries {
+	for subject, e := range ts.entries {
 		if nowMs < e.lockedUntil {
-			if oldestLockedIP == "" || e.lastSeenMs < oldestLockedMs {
+			if oldestLockedSubject == "" || e.lastSeenMs < oldestLockedMs {
 				oldestLockedMs = e.lastSeenMs
-				oldestLockedIP = ip
+				oldestLockedSubject = subject
 			}
 		} else {
-			if oldestUnlockedIP == "" || e.lastSeenMs < oldestUnlockedMs {
+			if oldestUnlockedSubject == "" || e.lastSeenMs < oldestUnlockedMs {
 				oldestUnlockedMs = e.lastSeenMs
-				oldestUnlockedIP = ip
+				oldestUnlockedSubject = subject
 			}
 		}
 	}
 
-	if oldestUnlockedIP != "" {
-		delete(ts.entries, oldestUnlockedIP)
+	if oldestUnlockedSubject != "" {
+		delete(ts.entries, oldestUnlockedSubject)
 		return
 	}
-	delete(ts.entries, oldestLockedIP)
+	delete(ts.entries, oldestLockedSubject)
 }
 
 func (ts *ThrottlingService) Close() error {
@@ -142,3 +328,12 @@ func (ts *ThrottlingService) Close() error {
 	close(ts.done)
 	return nil
 }
+
+// throttlingSubjects returns the subjects the failed attempts count against.
+// The IPs are prefixed, so that they can't clash with the credentials.
+func throttlingSubjects(ip string, credential string) []string {
+	if credential == "" {
+		return []string{"ip:" + ip}
+	}
+	return []string{"ip:" + ip, credential}
+}
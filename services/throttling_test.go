@@ -1,67 +1,177 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+	"github.com/n0rdy/forq/db"
+	"github.com/n0rdy/forq/internal/testutil"
 )
 
+// newTestThrottlingService has the default settings: 5 failures within 1m
+// lock out for 1m, up to 1h.
+func newTestThrottlingService(t *testing.T, forqRepo *db.ForqRepo, persist bool) *ThrottlingService {
+	t.Helper()
+
+	settings := configs.DefaultSettings().Throttling
+	settings.Persist = persist
+	ts := NewThrottlingService(forqRepo, settings)
+	t.Cleanup(func() { ts.Close() })
+	return ts
+}
+
+// lockOutNow trips the lockout of the IP.
+func lockOutNow(t *testing.T, ts *ThrottlingService, ip string) {
+	t.Helper()
+
+	for i := 0; i < ts.settings.MaxFails; i++ {
+		ts.RecordFailure(ip, "", t.Context())
+	}
+	if !ts.IsLocked(ip, "", t.Context()) {
+		t.Fatalf("%s not locked after %d failures", ip, ts.settings.MaxFails)
+	}
+}
+
+// expireLockout simulates the end of the lockout of this instance.
+func expireLockout(ts *ThrottlingService, subject string) {
+	ts.mu.Lock()
+	ts.entries[subject].lockedUntil = time.Now().UnixMilli() - 1
+	ts.mu.Unlock()
+}
+
 func TestThrottling_LockoutAfterThreshold(t *testing.T) {
-	ts := NewThrottlingService()
-	defer ts.Close()
+	ts := newTestThrottlingService(t, nil, false)
+	maxFails := ts.settings.MaxFails
 
 	ip := "203.0.113.7"
 
-	for i := 0; i < throttlingMaxFails-1; i++ {
-		ts.RecordFailure(ip)
-		if ts.IsLocked(ip) {
-			t.Fatalf("locked after %d failures, threshold is %d", i+1, throttlingMaxFails)
+	for i := 0; i < maxFails-1; i++ {
+		ts.RecordFailure(ip, "", t.Context())
+		if ts.IsLocked(ip, "", t.Context()) {
+			t.Fatalf("locked after %d failures, threshold is %d", i+1, maxFails)
 		}
 	}
 
-	ts.RecordFailure(ip)
-	if !ts.IsLocked(ip) {
-		t.Fatalf("not locked after %d failures", throttlingMaxFails)
+	ts.RecordFailure(ip, "", t.Context())
+	if !ts.IsLocked(ip, "", t.Context()) {
+		t.Fatalf("not locked after %d failures", maxFails)
 	}
 
 	// a different IP is unaffected
-	if ts.IsLocked("203.0.113.8") {
+	if ts.IsLocked("203.0.113.8", "", t.Context()) {
 		t.Fatal("unrelated IP is locked")
 	}
 }
 
 func TestThrottling_FreshBudgetAfterLockout(t *testing.T) {
-	ts := NewThrottlingService()
-	defer ts.Close()
+	ts := newTestThrottlingService(t, nil, false)
 
 	ip := "203.0.113.7"
-	for i := 0; i < throttlingMaxFails; i++ {
-		ts.RecordFailure(ip)
-	}
-	if !ts.IsLocked(ip) {
-		t.Fatal("expected lockout")
-	}
-
-	// simulate lockout expiry
-	ts.mu.Lock()
-	ts.entries[ip].lockedUntil = time.Now().UnixMilli() - 1
-	ts.mu.Unlock()
+	lockOutNow(t, ts, ip)
 
-	if ts.IsLocked(ip) {
+	expireLockout(ts, "ip:"+ip)
+	if ts.IsLocked(ip, "", t.Context()) {
 		t.Fatal("still locked after lockedUntil passed")
 	}
 
 	// the failures slice was cleared on lockout, so a single new failure
 	// must NOT re-lock (fresh budget semantics)
-	ts.RecordFailure(ip)
-	if ts.IsLocked(ip) {
+	ts.RecordFailure(ip, "", t.Context())
+	if ts.IsLocked(ip, "", t.Context()) {
 		t.Fatal("re-locked after a single post-lockout failure; budget was not reset")
 	}
 }
 
+func TestThrottling_PerCredential(t *testing.T) {
+	ts := newTestThrottlingService(t, nil, false)
+
+	// a distributed brute force: one attempt per IP
+	for i := 0; i < ts.settings.MaxFails; i++ {
+		ts.RecordFailure(fmt.Sprintf("10.0.0.%d", i), "user:jane", t.Context())
+	}
+
+	if !ts.IsLocked("10.0.1.1", "user:jane", t.Context()) {
+		t.Fatal("credential not locked from a new IP")
+	}
+	if ts.IsLocked("10.0.0.1", "user:john", t.Context()) {
+		t.Fatal("another credential locked from an IP with a single failure")
+	}
+}
+
+func TestThrottling_RepeatedLockoutsGrow(t *testing.T) {
+	ts := newTestThrottlingService(t, nil, false)
+
+	ip := "203.0.113.7"
+	var lockoutsMs []int64
+	for range 3 {
+		lockOutNow(t, ts, ip)
+		ts.mu.Lock()
+		lockoutsMs = append(lockoutsMs, ts.entries["ip:"+ip].lockedUntil-time.Now().UnixMilli())
+		ts.mu.Unlock()
+		expireLockout(ts, "ip:"+ip)
+	}
+
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		got := time.Duration(lockoutsMs[i]) * time.Millisecond
+		if got > want || got < want-time.Second {
+			t.Errorf("lockout %d lasts %s, want %s", i+1, got, want)
+		}
+	}
+
+	if got := ts.lockoutMs(20); got != time.Hour.Milliseconds() {
+		t.Errorf("lockout after 20 strikes = %dms, want the 1h max", got)
+	}
+}
+
+func TestThrottling_Persisted(t *testing.T) {
+	repo, _, _ := testutil.NewTestRepo(t)
+	// two instances sharing the DB
+	first := newTestThrottlingService(t, repo, true)
+	second := newTestThrottlingService(t, repo, true)
+
+	ip := "203.0.113.7"
+	lockOutNow(t, first, ip)
+	if !second.IsLocked(ip, "", t.Context()) {
+		t.Fatal("lockout of the other instance doesn't apply")
+	}
+
+	lockouts, err := second.GetLockouts(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Subject != "ip:"+ip || lockouts[0].Kind != "ip" || lockouts[0].Name != ip || lockouts[0].Strikes != 1 {
+		t.Fatalf("lockouts = %+v", lockouts)
+	}
+
+	if err := second.Unlock("ip:"+ip, t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if first.IsLocked(ip, "", t.Context()) {
+		t.Fatal("unlocked on one instance, still locked on the other")
+	}
+	if err := second.Unlock("ip:"+ip, t.Context()); !errors.Is(err, common.ErrNotFoundLockout) {
+		t.Fatalf("unlocking twice: %v, want not found", err)
+	}
+
+	// the unlock forgave the strikes, and the new ones survive a restart
+	lockOutNow(t, first, ip)
+	restarted := newTestThrottlingService(t, repo, true)
+	lockOutNow(t, restarted, ip)
+	lockouts, err = restarted.GetLockouts(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Strikes != 2 {
+		t.Fatalf("lockouts after a restart = %+v, want the 2nd strike", lockouts)
+	}
+}
+
 func TestThrottling_EvictionPrefersUnlockedEntries(t *testing.T) {
-	ts := NewThrottlingService()
-	defer ts.Close()
+	ts := newTestThrottlingService(t, nil, false)
 
 	nowMs := time.Now().UnixMilli()
 
@@ -89,8 +199,7 @@ func TestThrottling_EvictionPrefersUnlockedEntries(t *testing.T) {
 }
 
 func TestThrottling_EvictionFallsBackToLockedWhenAllLocked(t *testing.T) {
-	ts := NewThrottlingService()
-	defer ts.Close()
+	ts := newTestThrottlingService(t, nil, false)
 
 	nowMs := time.Now().UnixMilli()
 
@@ -111,11 +220,10 @@ func TestThrottling_EvictionFallsBackToLockedWhenAllLocked(t *testing.T) {
 }
 
 func TestThrottling_CapEnforced(t *testing.T) {
-	ts := NewThrottlingService()
-	defer ts.Close()
+	ts := newTestThrottlingService(t, nil, false)
 
 	for i := 0; i < throttlingMaxEntries+100; i++ {
-		ts.RecordFailure(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		ts.RecordFailure(fmt.Sprintf("10.0.%d.%d", i/256, i%256), "", t.Context())
 	}
 
 	ts.mu.Lock()
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const tlsFilesCheckMs = 10 * 1000 // how often the cert files are checked for changes
+
+// TlsService serves the TLS certificate of the API and UI listeners, and the
+// CA bundle the API client certificates are verified against. The files are
+// checked for changes periodically and reloaded, so a renewed certificate
+// (e.g. by certbot or cert-manager) is picked up without a restart. Reload can
+// also be triggered explicitly, on SIGHUP.
+type TlsService struct {
+	certFile           string
+	keyFile            string
+	clientCaFile       string
+	clientCertRequired bool
+
+	cert      *tls.Certificate
+	clientCas *x509.CertPool
+	fileStats map[string]fileStat // by path, to detect the changes
+	mu        sync.RWMutex
+
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewTlsService accepts an empty clientCaFile, then the client certificates
+// are not requested.
+func NewTlsService(certFile string, keyFile string, clientCaFile string, clientCertRequired bool) (*TlsService, error) {
+	ts := &TlsService{
+		certFile:           certFile,
+		keyFile:            keyFile,
+		clientCaFile:       clientCaFile,
+		clientCertRequired: clientCertRequired,
+		ticker:             time.NewTicker(tlsFilesCheckMs * time.Millisecond),
+		done:               make(chan struct{}),
+	}
+
+	err := ts.Reload()
+	if err != nil {
+		ts.ticker.Stop()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ts.ticker.C:
+				ts.ReloadIfChanged()
+			case <-ts.done:
+				return
+			}
+		}
+	}()
+
+	return ts, nil
+}
+
+// Reload re-reads the files. On error, the previous certificate stays in
+// effect, so a half-written renewal can't take the listeners down.
+func (ts *TlsService) Reload() error {
+	stats, err := ts.statFiles()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(ts.certFile, ts.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	var clientCas *x509.CertPool
+	if ts.clientCaFile != "" {
+		caPem, err := os.ReadFile(ts.clientCaFile)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS client CA file: %w", err)
+		}
+		clientCas = x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(caPem) {
+			return fmt.Errorf("no certificates found in TLS client CA file")
+		}
+	}
+
+	ts.mu.Lock()
+	ts.cert = &cert
+	ts.clientCas = clientCas
+	ts.fileStats = stats
+	ts.mu.Unlock()
+
+	log.Info().Bool("client_certs", clientCas != nil).Msg("TLS certificate loaded")
+	return nil
+}
+
+// ReloadIfChanged reloads the files if any of them has changed since the last
+// load. The errors are logged only: the check is repeated until the files are
+// consistent again, e.g. once both the new cert and key are in place.
+func (ts *TlsService) ReloadIfChanged() {
+	stats, err := ts.statFiles()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check TLS files for changes")
+		return
+	}
+
+	ts.mu.RLock()
+	changed := false
+	for path, stat := range stats {
+		if ts.fileStats[path] != stat {
+			changed = true
+		}
+	}
+	ts.mu.RUnlock()
+
+	if !changed {
+		return
+	}
+	log.Info().Msg("TLS files changed, reloading")
+	if err := ts.Reload(); err != nil {
+		log.Error().Err(err).Msg("failed to reload TLS files, keeping the previous ones")
+	}
+}
+
+// ServerConfig returns the TLS config of a listener. With verifyClientCerts,
+// the client certificates are requested and verified against the CA bundle:
+// that's for the API only, as the UI is used from browsers.
+func (ts *TlsService) ServerConfig(verifyClientCerts bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// resolved per connection, so that the reloads apply to the new connections right away
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			ts.mu.RLock()
+			defer ts.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*ts.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if verifyClientCerts && ts.clientCas != nil {
+				cfg.ClientCAs = ts.clientCas
+				if ts.clientCertRequired {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				} else {
+					cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+func (ts *TlsService) Close() {
+	ts.ticker.Stop()
+	close(ts.done)
+}
+
+func (ts *TlsService) statFiles() (map[string]fileStat, error) {
+	stats := make(map[string]fileStat)
+	for _, path := range []string{ts.certFile, ts.keyFile, ts.clientCaFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat TLS file: %w", err)
+		}
+		stats[path] = fileStat{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stats, nil
+}
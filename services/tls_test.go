@@ -0,0 +1,124 @@
+package services_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/services"
+)
+
+// handshake connects to the listener and returns the server certificate's
+// common name, or the handshake error.
+func handshake(t *testing.T, ln net.Listener, ca *testutil.TestCA, clientCert []tls.Certificate) (string, error) {
+	t.Helper()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, Certificates: clientCert})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// with TLS 1.3, the server rejects the client cert after the client side of
+	// the handshake is done, so it shows up on the first read; the server just
+	// closes the connection otherwise
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestTlsService_ReloadsChangedCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := testutil.NewTestCA(t)
+	certFile, keyFile := ca.WriteCert(t, dir, "forq")
+
+	svc, err := services.NewTlsService(certFile, keyFile, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svc.Close)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", svc.ServerConfig(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	if cn, err := handshake(t, ln, ca, nil); err != nil || cn != "forq" {
+		t.Fatalf("initial cert: %q %v", cn, err)
+	}
+
+	// a renewal replaces the files in place
+	renewedDir := t.TempDir()
+	renewedCert, renewedKey := ca.WriteCert(t, renewedDir, "forq-renewed")
+	if err := os.Rename(renewedCert, certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(renewedKey, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	svc.ReloadIfChanged()
+
+	if cn, err := handshake(t, ln, ca, nil); err != nil || cn != "forq-renewed" {
+		t.Fatalf("renewed cert: %q %v", cn, err)
+	}
+
+	// a broken file keeps the previous cert in effect
+	if err := os.WriteFile(certFile, []byte("not a cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Reload(); err == nil {
+		t.Fatal("reload of a broken cert succeeded")
+	}
+	if cn, err := handshake(t, ln, ca, nil); err != nil || cn != "forq-renewed" {
+		t.Fatalf("cert after a broken reload: %q %v", cn, err)
+	}
+}
+
+func TestTlsService_RequiresClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := testutil.NewTestCA(t)
+	certFile, keyFile := ca.WriteCert(t, dir, "forq")
+	caFile := ca.WriteCaFile(t, dir)
+
+	svc, err := services.NewTlsService(certFile, keyFile, caFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svc.Close)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", svc.ServerConfig(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	if _, err := handshake(t, ln, ca, nil); err == nil {
+		t.Fatal("handshake without a client cert succeeded")
+	}
+
+	clientCertFile, clientKeyFile := ca.WriteCert(t, dir, "orders-service")
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, ln, ca, []tls.Certificate{clientCert}); err != nil {
+		t.Fatalf("handshake with a client cert: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/db"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 12
+	maxPasswordLength = 72 // bcrypt ignores the bytes past 72
+)
+
+// usernameRegex keeps the usernames printable in logs and in the admin UI.
+var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9._@-]{1,64}$`)
+
+// dummyPasswordHash is compared against on the unknown usernames, so that the
+// login takes the same time whether the user exists or not.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("forq-dummy-password"), bcrypt.DefaultCost)
+
+// UsersService manages the admin UI accounts. Unlike the API keys, the
+// passwords are chosen by humans, hence the slow bcrypt hash, and the users are
+// not cached: they are only looked up on login.
+type UsersService struct {
+	forqRepo *db.ForqRepo
+}
+
+func NewUsersService(forqRepo *db.ForqRepo) *UsersService {
+	return &UsersService{
+		forqRepo: forqRepo,
+	}
+}
+
+// Authenticate returns nil if the username or password is wrong.
+func (us *UsersService) Authenticate(username string, password string, ctx context.Context) (*SessionUser, error) {
+	user, err := us.forqRepo.SelectUserByUsername(username, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return &SessionUser{Name: user.Username, Role: user.Role}, nil
+}
+
+func (us *UsersService) CreateUser(username string, password string, role string, ctx context.Context) error {
+	if !usernameRegex.MatchString(username) {
+		log.Error().Str("username", username).Msg("invalid username")
+		return common.ErrBadRequestInvalidUsername
+	}
+	if !isValidPassword(password) {
+		return common.ErrBadRequestInvalidPassword
+	}
+	if _, ok := common.SupportedRoles[role]; !ok {
+		return common.ErrBadRequestInvalidRole
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to hash password")
+		return common.ErrInternal
+	}
+
+	user := db.User{
+		Id:           uuid.NewString(),
+		Username:     username,
+		PasswordHash: string(passwordHash),
+		Role:         role,
+		CreatedAt:    time.Now().UnixMilli(),
+	}
+	err = us.forqRepo.InsertUser(&user, ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("username", username).Str("role", role).Msg("user created")
+	return nil
+}
+
+func (us *UsersService) GetUsers(ctx context.Context) ([]common.User, error) {
+	users, err := us.forqRepo.SelectAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]common.User, 0, len(users))
+	for _, user := range users {
+		result = append(result, common.User{
+			ID:        user.Id,
+			Username:  user.Username,
+			Role:      user.Role,
+			CreatedAt: time.UnixMilli(user.CreatedAt).Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+// UpdateRole returns the username, so that the caller can end the user's
+// sessions: they carry the role.
+func (us *UsersService) UpdateRole(id string, role string, ctx context.Context) (string, error) {
+	if _, ok := common.SupportedRoles[role]; !ok {
+		return "", common.ErrBadRequestInvalidRole
+	}
+
+	username, err := us.forqRepo.UpdateUserRole(id, role, ctx)
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", common.ErrNotFoundUser
+	}
+
+	log.Info().Str("username", username).Str("role", role).Msg("user role updated")
+	return username, nil
+}
+
+// ResetPassword returns the username, so that the caller can end the user's
+// sessions.
+func (us *UsersService) ResetPassword(id string, password string, ctx context.Context) (string, error) {
+	if !isValidPassword(password) {
+		return "", common.ErrBadRequestInvalidPassword
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to hash password")
+		return "", common.ErrInternal
+	}
+
+	username, err := us.forqRepo.UpdateUserPassword(id, string(passwordHash), ctx)
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", common.ErrNotFoundUser
+	}
+
+	log.Info().Str("username", username).Msg("user password reset")
+	return username, nil
+}
+
+// DeleteUser returns the username, so that the caller can end the user's
+// sessions.
+func (us *UsersService) DeleteUser(id string, ctx context.Context) (string, error) {
+	username, err := us.forqRepo.DeleteUser(id, ctx)
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", common.ErrNotFoundUser
+	}
+
+	log.Info().Str("username", username).Msg("user deleted")
+	return username, nil
+}
+
+func isValidPassword(password string) bool {
+	return len(password) >= minPasswordLength && len(password) <= maxPasswordLength
+}
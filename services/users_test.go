@@ -0,0 +1,74 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/internal/testutil"
+	"github.com/n0rdy/forq/services"
+)
+
+func TestUsersService(t *testing.T) {
+	repo, _, rawDB := testutil.NewTestRepo(t)
+	usersService := services.NewUsersService(repo)
+
+	rejected := []struct {
+		username, password, role string
+		want                     error
+	}{
+		{"jane doe", "correct-horse-battery", common.ViewerRole, common.ErrBadRequestInvalidUsername},
+		{"jane", "too-short", common.ViewerRole, common.ErrBadRequestInvalidPassword},
+		{"jane", "correct-horse-battery", "superuser", common.ErrBadRequestInvalidRole},
+	}
+	for _, tc := range rejected {
+		if err := usersService.CreateUser(tc.username, tc.password, tc.role, t.Context()); !errors.Is(err, tc.want) {
+			t.Errorf("CreateUser(%q, %q, %q) = %v, want %v", tc.username, tc.password, tc.role, err, tc.want)
+		}
+	}
+
+	if err := usersService.CreateUser("jane", "correct-horse-battery", common.OperatorRole, t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if err := usersService.CreateUser("jane", "another-password", common.ViewerRole, t.Context()); !errors.Is(err, common.ErrBadRequestUsernameTaken) {
+		t.Fatalf("duplicate username: %v", err)
+	}
+
+	var stored int
+	if err := rawDB.QueryRow("SELECT COUNT(*) FROM users WHERE password_hash LIKE '%correct-horse%'").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatal("the password must not be stored in plain text")
+	}
+
+	user, err := usersService.Authenticate("jane", "correct-horse-battery", t.Context())
+	if err != nil || user == nil || user.Role != common.OperatorRole {
+		t.Fatalf("Authenticate = %+v, %v", user, err)
+	}
+	if !user.CanOperate() || user.IsAdmin() {
+		t.Errorf("operator permissions: %+v", user)
+	}
+	for _, creds := range [][2]string{{"jane", "wrong-password"}, {"john", "correct-horse-battery"}} {
+		if user, err := usersService.Authenticate(creds[0], creds[1], t.Context()); user != nil || err != nil {
+			t.Errorf("Authenticate(%q, %q) = %+v, %v, want nil", creds[0], creds[1], user, err)
+		}
+	}
+
+	users, err := usersService.GetUsers(t.Context())
+	if err != nil || len(users) != 1 {
+		t.Fatalf("GetUsers = %+v, %v", users, err)
+	}
+	if _, err := usersService.ResetPassword(users[0].ID, "a-brand-new-password", t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := usersService.Authenticate("jane", "a-brand-new-password", t.Context()); user == nil {
+		t.Fatal("the new password is rejected")
+	}
+	if username, err := usersService.DeleteUser(users[0].ID, t.Context()); err != nil || username != "jane" {
+		t.Fatalf("DeleteUser = %q, %v", username, err)
+	}
+	if _, err := usersService.DeleteUser(users[0].ID, t.Context()); !errors.Is(err, common.ErrNotFoundUser) {
+		t.Fatalf("second DeleteUser: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package signing
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret-that-is-32-chars-long"
+
+func TestSignRequest(t *testing.T) {
+	body := `{"content":"x"}`
+	req, err := http.NewRequest("POST", "http://localhost:8080/api/v1/queues/orders/messages?x=1", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignRequest(req, testSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(restored) != body {
+		t.Fatalf("body = %q, want it restored", restored)
+	}
+
+	if req.Header.Get(KeyIdHeader) != KeyId(testSecret) || len(KeyId(testSecret)) != 8 {
+		t.Fatalf("key ID = %q", req.Header.Get(KeyIdHeader))
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := req.Header.Get(SignatureHeader)
+
+	if !Verify(signature, "POST", "/api/v1/queues/orders/messages?x=1", timestamp, []byte(body), testSecret) {
+		t.Fatal("signature doesn't verify")
+	}
+
+	tampered := map[string]func() bool{
+		"method": func() bool {
+			return Verify(signature, "PUT", "/api/v1/queues/orders/messages?x=1", timestamp, []byte(body), testSecret)
+		},
+		"path": func() bool {
+			return Verify(signature, "POST", "/api/v1/queues/emails/messages?x=1", timestamp, []byte(body), testSecret)
+		},
+		"query": func() bool {
+			return Verify(signature, "POST", "/api/v1/queues/orders/messages", timestamp, []byte(body), testSecret)
+		},
+		"timestamp": func() bool {
+			return Verify(signature, "POST", "/api/v1/queues/orders/messages?x=1", timestamp+1, []byte(body), testSecret)
+		},
+		"body": func() bool {
+			return Verify(signature, "POST", "/api/v1/queues/orders/messages?x=1", timestamp, []byte(`{"content":"y"}`), testSecret)
+		},
+		"secret": func() bool {
+			return Verify(signature, "POST", "/api/v1/queues/orders/messages?x=1", timestamp, []byte(body), "another-secret-that-is-32-chars-long")
+		},
+	}
+	for name, verify := range tampered {
+		if verify() {
+			t.Errorf("tampered %s still verifies", name)
+		}
+	}
+}
+
+func TestIsFresh(t *testing.T) {
+	now := time.Now()
+	tests := map[time.Duration]bool{
+		0:                           true,
+		-MaxClockSkew + time.Second: true,
+		MaxClockSkew - time.Second:  true,
+		-MaxClockSkew - time.Second: false,
+		MaxClockSkew + time.Second:  false,
+	}
+	for offset, want := range tests {
+		if got := IsFresh(now.Add(offset).Unix(), now); got != want {
+			t.Errorf("IsFresh(now%+v) = %v, want %v", offset, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Package systemd integrates Forq with systemd without any dependencies: the
+// socket activation, which lets systemd hold the listening sockets across
+// restarts, so the clients' connections queue up instead of being refused,
+// and the sd_notify protocol, which tells systemd when Forq is ready, when
+// it's stopping, and that it's still healthy (the watchdog).
+//
+// Both are no-ops when Forq isn't run by systemd.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AddrPrefix marks the socket activated addresses: "systemd:api" is the
+	// socket with FileDescriptorName=api in its .socket unit.
+	AddrPrefix = "systemd:"
+
+	listenFdsStart = 3 // SD_LISTEN_FDS_START, the first passed fd
+)
+
+// ActivatedListeners returns the sockets passed by systemd, by their
+// FileDescriptorName. A socket without a name is named "unknown" by systemd.
+// It returns an empty map if Forq isn't socket activated. The LISTEN_*
+// environment variables are unset, so they are not inherited by the child
+// processes.
+func ActivatedListeners() (map[string]net.Listener, error) {
+	pid := os.Getenv("LISTEN_PID")
+	fds := os.Getenv("LISTEN_FDS")
+	fdNames := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid == "" || fds == "" {
+		return map[string]net.Listener{}, nil
+	}
+	// the variables may have been inherited from a parent that was activated
+	if pid != strconv.Itoa(os.Getpid()) {
+		return map[string]net.Listener{}, nil
+	}
+
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %s", fds)
+	}
+	var names []string
+	if fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+	return listenersFromFds(listenFdsStart, count, names)
+}
+
+func listenersFromFds(firstFd int, count int, names []string) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener, count)
+	for i := 0; i < count; i++ {
+		fd := firstFd + i
+
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		if _, exists := listeners[name]; exists {
+			return nil, fmt.Errorf("more than one activated socket named %q, set FileDescriptorName in the .socket units", name)
+		}
+
+		file := os.NewFile(uintptr(fd), AddrPrefix+name)
+		listener, err := net.FileListener(file)
+		// FileListener dups the fd with close-on-exec, the original isn't needed anymore
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("activated socket %q is not a listening socket: %w", name, err)
+		}
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
@@ -0,0 +1,85 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// passedFd mimics systemd passing the listening socket: it returns a dup of
+// the listener's fd, which listenersFromFds takes over.
+func passedFd(t *testing.T) (int, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd, ln.Addr().String()
+}
+
+func TestListenersFromFds(t *testing.T) {
+	fd, addr := passedFd(t)
+
+	listeners, err := listenersFromFds(fd, 1, []string{"api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, ok := listeners["api"]
+	if !ok {
+		t.Fatalf("no listener named api: %v", listeners)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != addr {
+		t.Fatalf("listener addr = %s, want %s", ln.Addr(), addr)
+	}
+
+	// the listener accepts, i.e. it's the same socket
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListenersFromFds_UnnamedSockets(t *testing.T) {
+	fd, _ := passedFd(t)
+
+	listeners, err := listenersFromFds(fd, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, ok := listeners["unknown"]
+	if !ok {
+		t.Fatalf("no listener named unknown: %v", listeners)
+	}
+	ln.Close()
+}
+
+func TestActivatedListeners_NotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "2")
+
+	// another process' sockets are ignored
+	listeners, err := ActivatedListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Fatalf("listeners = %v, err = %v, want none", listeners, err)
+	}
+}
@@ -0,0 +1,117 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const watchdogHealthCheckTimeout = 5 * time.Second
+
+// Notifier sends the sd_notify messages to NOTIFY_SOCKET. Its methods are
+// no-ops if Forq isn't run by systemd, or by a unit without Type=notify.
+type Notifier struct {
+	socketAddr       *net.UnixAddr
+	watchdogInterval time.Duration
+	done             chan struct{}
+}
+
+func NewNotifier() *Notifier {
+	n := &Notifier{
+		done: make(chan struct{}),
+	}
+
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	os.Unsetenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return n
+	}
+	if socketPath[0] == '@' {
+		// abstract socket
+		socketPath = "\x00" + socketPath[1:]
+	}
+	n.socketAddr = &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+
+	// WATCHDOG_PID, if set, must be ours, same as LISTEN_PID
+	watchdogPid := os.Getenv("WATCHDOG_PID")
+	watchdogUsec := os.Getenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	os.Unsetenv("WATCHDOG_USEC")
+	if watchdogUsec != "" && (watchdogPid == "" || watchdogPid == strconv.Itoa(os.Getpid())) {
+		usec, err := strconv.ParseInt(watchdogUsec, 10, 64)
+		if err != nil || usec <= 0 {
+			log.Warn().Str("watchdog_usec", watchdogUsec).Msg("invalid WATCHDOG_USEC, systemd watchdog disabled")
+		} else {
+			n.watchdogInterval = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n
+}
+
+// Ready tells systemd that Forq is up: the DB is migrated and the servers are listening.
+func (n *Notifier) Ready() {
+	n.notify("READY=1")
+}
+
+// Stopping tells systemd that the graceful shutdown has begun.
+func (n *Notifier) Stopping() {
+	n.notify("STOPPING=1")
+}
+
+// StartWatchdog pings the systemd watchdog at half the WatchdogSec interval,
+// as long as isHealthy returns true. Once it doesn't, the pings stop, and
+// systemd restarts Forq when the interval runs out. A single failed check is
+// tolerated, as the next one comes before the interval runs out.
+func (n *Notifier) StartWatchdog(isHealthy func(ctx context.Context) bool) {
+	if n.socketAddr == nil || n.watchdogInterval == 0 {
+		return
+	}
+	log.Info().Dur("interval", n.watchdogInterval).Msg("systemd watchdog enabled")
+
+	ticker := time.NewTicker(n.watchdogInterval / 2)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), min(watchdogHealthCheckTimeout, n.watchdogInterval/2))
+				healthy := isHealthy(ctx)
+				cancel()
+				if healthy {
+					n.notify("WATCHDOG=1")
+				} else {
+					log.Warn().Msg("Forq is unhealthy, skipping the systemd watchdog ping")
+				}
+			case <-n.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the watchdog.
+func (n *Notifier) Close() {
+	close(n.done)
+}
+
+func (n *Notifier) notify(state string) {
+	if n.socketAddr == nil {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, n.socketAddr)
+	if err != nil {
+		log.Warn().Err(err).Str("state", state).Msg("failed to connect to the systemd notify socket")
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		log.Warn().Err(err).Str("state", state).Msg("failed to notify systemd")
+	}
+}
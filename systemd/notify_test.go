@@ -0,0 +1,106 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket listens where systemd's notify socket would be, and
+// returns the channel of the received messages.
+func fakeNotifySocket(t *testing.T) <-chan string {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	messages := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return messages
+}
+
+func expectMessage(t *testing.T, messages <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-messages:
+		if got != want {
+			t.Fatalf("message = %q, want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no %q message", want)
+	}
+}
+
+func TestNotifier_ReadyAndStopping(t *testing.T) {
+	messages := fakeNotifySocket(t)
+
+	n := NewNotifier()
+	defer n.Close()
+	if os.Getenv("NOTIFY_SOCKET") != "" {
+		t.Fatal("NOTIFY_SOCKET must be unset, so that the child processes don't inherit it")
+	}
+
+	n.Ready()
+	expectMessage(t, messages, "READY=1")
+	n.Stopping()
+	expectMessage(t, messages, "STOPPING=1")
+}
+
+func TestNotifier_WatchdogTiedToHealth(t *testing.T) {
+	messages := fakeNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000") // 100ms
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	n := NewNotifier()
+	defer n.Close()
+	n.StartWatchdog(func(ctx context.Context) bool { return healthy.Load() })
+
+	expectMessage(t, messages, "WATCHDOG=1")
+
+	healthy.Store(false)
+	// drain a ping that might have been sent before the switch
+	time.Sleep(100 * time.Millisecond)
+	for len(messages) > 0 {
+		<-messages
+	}
+	select {
+	case msg := <-messages:
+		t.Fatalf("got %q while unhealthy", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	healthy.Store(true)
+	expectMessage(t, messages, "WATCHDOG=1")
+}
+
+func TestNotifier_NoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	n := NewNotifier()
+	defer n.Close()
+	// no-ops, mustn't panic
+	n.Ready()
+	n.StartWatchdog(func(ctx context.Context) bool { return true })
+	n.Stopping()
+}
@@ -0,0 +1,195 @@
+// Package tracing implements the optional OpenTelemetry tracing: the spans of
+// the HTTP handlers, the MessagesService calls, the ForqRepo queries and the
+// background job ticks, exported to an OTLP/HTTP collector, and the W3C trace
+// context stored with each message, to link the consumer to the producer.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName      = "github.com/n0rdy/forq"
+	serviceName     = "forq"
+	traceparentKey  = "traceparent"
+	shutdownTimeout = 5 * time.Second // to export the pending spans on close
+)
+
+// propagator reads and writes the W3C trace context, regardless of whether
+// the spans are exported: Forq always passes the trace of the producer on to
+// the consumer.
+var propagator = propagation.TraceContext{}
+
+// Provider exports the spans over OTLP/HTTP. It's installed as the global
+// tracer provider, so the spans are started with Start anywhere in the code,
+// and are no-ops if the tracing is disabled.
+type Provider struct {
+	tracerProvider *sdktrace.TracerProvider
+}
+
+// NewProvider installs the tracer provider of the settings, unless the
+// endpoint is empty. The standard OTEL_EXPORTER_OTLP_* env vars, e.g. the
+// headers with the collector's API key, and OTEL_RESOURCE_ATTRIBUTES apply.
+func NewProvider(settings configs.TracingSettings) (*Provider, error) {
+	if settings.Endpoint == "" {
+		return &Provider{}, nil
+	}
+
+	endpointUrl, err := url.Parse(settings.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// the endpoint is the collector's base URL, as OTEL_EXPORTER_OTLP_ENDPOINT
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpointUrl.JoinPath("v1", "traces").String()))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(settings.SampleRatio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Warn().Err(err).Msg("tracing error, e.g. the collector is unreachable")
+	}))
+
+	log.Info().Str("endpoint", settings.Endpoint).Float64("sample_ratio", settings.SampleRatio).Msg("exporting traces")
+	return &Provider{tracerProvider: tracerProvider}, nil
+}
+
+// Close exports the pending spans.
+func (p *Provider) Close() error {
+	if p.tracerProvider == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return p.tracerProvider.Shutdown(ctx)
+}
+
+// Start starts a span, a child of the one in the context, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	// not cached: the global tracer provider may change, e.g. in tests
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, and ends the span. The errors of the client,
+// e.g. a bad request, don't fail the span, as Forq did its job.
+func End(span trace.Span, err error) {
+	if err != nil {
+		var forqErr common.ForqError
+		isForqErr := errors.As(err, &forqErr)
+		if isForqErr {
+			span.SetAttributes(attribute.String("forq.error.code", forqErr.Code))
+		}
+		if !isForqErr || forqErr.Code == common.ErrCodeInternal {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+// Traceparent returns the W3C traceparent of the span in the context, or
+// empty if there is none.
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(traceparentKey)
+}
+
+// LinkTo links the span to the one of the traceparent, e.g. the consume span
+// to the produce one, if the traceparent is valid.
+func LinkTo(span trace.Span, traceparent string) {
+	if traceparent == "" {
+		return
+	}
+	ctx := propagator.Extract(context.Background(), propagation.MapCarrier{traceparentKey: traceparent})
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		span.AddLink(trace.Link{SpanContext: spanContext})
+	}
+}
+
+// Middleware starts a server span for each request, a child of the caller's
+// W3C trace context, if any, and named after the route.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, req.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("url.path", req.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, req.WithContext(ctx))
+
+		// the route is only known once chi has routed the request
+		if routeCtx := chi.RouteContext(req.Context()); routeCtx != nil && routeCtx.RoutePattern() != "" {
+			span.SetName(req.Method + " " + routeCtx.RoutePattern())
+			span.SetAttributes(attribute.String("http.route", routeCtx.RoutePattern()))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// statusWriter records the status code of the response for the server span.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.status = code
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -9,6 +10,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/justinas/nosurf"
+	"github.com/rs/zerolog/log"
 )
 
 // securityHeaders middleware sets HTTP security headers on every UI response.
@@ -61,8 +63,12 @@ func validateQueueName(next http.Handler) http.Handler {
 	})
 }
 
-// sessionAuth middleware for UI routes
-func sessionAuth(sessionsService *services.SessionsService) func(http.Handler) http.Handler {
+type sessionUserCtxKey struct{}
+
+// sessionAuth middleware for UI routes, it puts the logged-in user into the
+// request context. The changes are logged with the user, so that it's known
+// who e.g. purged a DLQ.
+func sessionAuth(sessionsService *services.SessionsService, authSecrets *services.AuthSecretsService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			sessionCookie, err := req.Cookie("ForqSession")
@@ -71,16 +77,56 @@ func sessionAuth(sessionsService *services.SessionsService) func(http.Handler) h
 				return
 			}
 
-			sessionId := sessionCookie.Value
-			if !sessionsService.IsSessionValid(sessionId) {
+			user, err := sessionsService.SessionUser(sessionCookie.Value, req.Context())
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if user == nil {
+				http.Redirect(w, req, "/login", http.StatusFound)
+				return
+			}
+			// the secrets are removed from the file or expire without an event
+			// to end their sessions on, so they are checked on every request
+			if secretId, ok := strings.CutPrefix(user.Name, "auth_secret:"); ok && !authSecrets.IsActive(secretId) {
+				sessionsService.InvalidateSession(sessionCookie.Value, req.Context()) // on failure, the check fails again next time
 				http.Redirect(w, req, "/login", http.StatusFound)
 				return
 			}
+
+			if req.Method != http.MethodGet {
+				log.Info().
+					Str("user", user.Name).
+					Str("role", user.Role).
+					Str("method", req.Method).
+					Str("path", req.URL.Path).
+					Msg("UI action")
+			}
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), sessionUserCtxKey{}, *user)))
+		})
+	}
+}
+
+// requireRole middleware rejects the users whose role doesn't include the
+// given one. It goes after sessionAuth.
+func requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !sessionUser(req).Has(role) {
+				http.Error(w, "Forbidden: requires the "+role+" role", http.StatusForbidden)
+				return
+			}
 			next.ServeHTTP(w, req)
 		})
 	}
 }
 
+// sessionUser returns the zero user, which has no role, outside of sessionAuth.
+func sessionUser(req *http.Request) services.SessionUser {
+	user, _ := req.Context().Value(sessionUserCtxKey{}).(services.SessionUser)
+	return user
+}
+
 // csrfPrevention middleware to protect against CSRF attacks
 func csrfPrevention(csrfFailureHandler func(w http.ResponseWriter, r *http.Request), env string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
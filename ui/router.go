@@ -1,34 +1,51 @@
 package ui
 
 import (
-	"crypto/subtle"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/n0rdy/forq/common"
 	"github.com/n0rdy/forq/services"
+	"github.com/n0rdy/forq/signing"
+	"github.com/n0rdy/forq/tracing"
 	"github.com/n0rdy/forq/utils"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
 )
 
+// oidcStateCookieMaxAge is how long the user has to log in at the IdP.
+const oidcStateCookieMaxAge = 10 * time.Minute
+
 type Router struct {
 	messagesService   *services.MessagesService
 	sessionsService   *services.SessionsService
 	queuesService     *services.QueuesService
 	throttlingService *services.ThrottlingService
-	authSecret        string
+	apiKeysService    *services.ApiKeysService
+	usersService      *services.UsersService
+	oidcService       *services.OidcService // nil if OIDC is not configured
+	auditService      *services.AuditService
+	authSecrets       *services.AuthSecretsService
 	env               string
 	trustProxyHeaders bool
 }
 
-func NewRouter(messagesService *services.MessagesService, sessionsService *services.SessionsService, queuesService *services.QueuesService, throttlingService *services.ThrottlingService, authSecret string, env string, trustProxyHeaders bool) *Router {
+func NewRouter(messagesService *services.MessagesService, sessionsService *services.SessionsService, queuesService *services.QueuesService, throttlingService *services.ThrottlingService, apiKeysService *services.ApiKeysService, usersService *services.UsersService, oidcService *services.OidcService, auditService *services.AuditService, authSecrets *services.AuthSecretsService, env string, trustProxyHeaders bool) *Router {
 	return &Router{
 		messagesService:   messagesService,
 		sessionsService:   sessionsService,
 		queuesService:     queuesService,
 		throttlingService: throttlingService,
-		authSecret:        authSecret,
+		apiKeysService:    apiKeysService,
+		usersService:      usersService,
+		oidcService:       oidcService,
+		auditService:      auditService,
+		authSecrets:       authSecrets,
 		env:               env,
 		trustProxyHeaders: trustProxyHeaders,
 	}
@@ -37,6 +54,7 @@ func NewRouter(messagesService *services.MessagesService, sessionsService *servi
 func (ur *Router) NewRouter() *chi.Mux {
 	router := chi.NewRouter()
 
+	router.Use(tracing.Middleware)
 	router.Use(securityHeaders(ur.env))
 	router.Use(csrfPrevention(ur.csrfErrorHandler, ur.env))
 
@@ -53,33 +71,98 @@ func (ur *Router) NewRouter() *chi.Mux {
 	// unprotected login routes (failed attempts are throttled in processLogin):
 	router.Get("/login", ur.loginPage)
 	router.Post("/login", ur.processLogin)
+	if ur.oidcService != nil {
+		router.Get("/oidc/login", ur.beginOidcLogin)
+		router.Get("/oidc/callback", ur.completeOidcLogin)
+	}
 
 	// protected routes:
-	router.With(sessionAuth(ur.sessionsService)).
+	router.With(sessionAuth(ur.sessionsService, ur.authSecrets)).
 		Get("/", ur.dashboardPage)
 
-	router.With(sessionAuth(ur.sessionsService)).Post("/logout", ur.processLogout)
+	router.With(sessionAuth(ur.sessionsService, ur.authSecrets)).Post("/logout", ur.processLogout)
+
+	router.Route("/api-keys", func(r chi.Router) {
+		r.Use(sessionAuth(ur.sessionsService, ur.authSecrets))
+		r.Use(requireRole(common.AdminRole))
+
+		r.Get("/", ur.apiKeysPage)
+		r.Post("/", ur.createApiKey)
+		r.Delete("/{keyId}", ur.deleteApiKey)
+	})
+
+	router.Route("/users", func(r chi.Router) {
+		r.Use(sessionAuth(ur.sessionsService, ur.authSecrets))
+		r.Use(requireRole(common.AdminRole))
+
+		r.Get("/", ur.usersPage)
+		r.Post("/", ur.createUser)
+		r.Post("/{userId}/role", ur.updateUserRole)
+		r.Post("/{userId}/password", ur.resetUserPassword)
+		r.Delete("/{userId}", ur.deleteUser)
+	})
+
+	router.Route("/audit", func(r chi.Router) {
+		r.Use(sessionAuth(ur.sessionsService, ur.authSecrets))
+		r.Use(requireRole(common.AdminRole))
+
+		r.Get("/", ur.auditPage)
+	})
+
+	router.Route("/lockouts", func(r chi.Router) {
+		r.Use(sessionAuth(ur.sessionsService, ur.authSecrets))
+		r.Use(requireRole(common.AdminRole))
+
+		r.Get("/", ur.lockoutsPage)
+		r.Delete("/", ur.unlock) // the subject is a query param, as the IPv6 addresses contain colons
+	})
+
+	router.Route("/sessions", func(r chi.Router) {
+		r.Use(sessionAuth(ur.sessionsService, ur.authSecrets))
+		r.Use(requireRole(common.AdminRole))
+
+		r.Get("/", ur.sessionsPage)
+		r.Delete("/{sessionId}", ur.revokeSession)
+	})
 
 	router.Route("/queue/{queue}", func(r chi.Router) {
-		r.Use(sessionAuth(ur.sessionsService)) // session auth for all queue routes
+		r.Use(sessionAuth(ur.sessionsService, ur.authSecrets)) // session auth for all queue routes
 		r.Use(validateQueueName)
 
+		// any role can browse
 		r.Get("/", ur.queueDetailsPage)
 		r.Get("/messages", ur.queueMessages)
 		r.Get("/messages/{messageId}/details", ur.messageDetails)
-		r.Delete("/messages", ur.deleteAllMessages)
-		r.Post("/messages/requeue", ur.requeueAllMessages)
-		r.Delete("/messages/{messageId}", ur.deleteMessage)
-		r.Post("/messages/requeue/{messageId}", ur.requeueMessage)
+		r.Get("/settings/dlq-policy", ur.dlqPolicyForm)
+		r.Get("/settings/delivery-limits", ur.deliveryLimitsForm)
+		r.Get("/settings/quotas", ur.quotasForm)
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireRole(common.OperatorRole))
+
+			r.Delete("/messages", ur.deleteAllMessages)
+			r.Post("/messages/requeue", ur.requeueAllMessages)
+			r.Delete("/messages/{messageId}", ur.deleteMessage)
+			r.Post("/messages/requeue/{messageId}", ur.requeueMessage)
+			r.Post("/messages/redrive", ur.redriveMessages)
+			r.Post("/pause", ur.pauseQueue)
+			r.Post("/resume", ur.resumeQueue)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireRole(common.AdminRole))
+
+			r.Post("/settings/dlq-policy", ur.updateDlqPolicy)
+			r.Post("/settings/delivery-limits", ur.updateDeliveryLimits)
+			r.Post("/settings/quotas", ur.updateQuotas)
+		})
 	})
 
 	return router
 }
 
 func (ur *Router) loginPage(w http.ResponseWriter, req *http.Request) {
-	data := common.LoginPageData{
-		Title: "Login",
-	}
+	data := ur.loginPageData("")
 
 	RenderTemplate(w, req, "login.html", data)
 }
@@ -88,40 +171,154 @@ func (ur *Router) processLogin(w http.ResponseWriter, req *http.Request) {
 	err := req.ParseForm()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to parse login form")
-		data := common.LoginPageData{
-			Title: "Login",
-			Error: "Invalid form data",
-		}
+		data := ur.loginPageData("Invalid form data")
 		RenderTemplate(w, req, "login.html", data)
 		return
 	}
 
-	// the token is checked FIRST (constant-time) so that a valid token always
-	// works even while the IP is locked out - behind a proxy without
+	ip := utils.ClientIP(req, ur.trustProxyHeaders)
+	// the auth secrets and the API keys are random, so only the usernames
+	// are throttled as credentials
+	var credential string
+	if username := req.FormValue("username"); username != "" {
+		credential = "user:" + username
+	}
+
+	// a locked username is rejected before its password is checked, even a
+	// correct one - otherwise the guessing would go on behind the lockout,
+	// with the right guess logging in.
+	if ur.throttlingService.IsCredentialLocked(credential, req.Context()) {
+		data := ur.loginPageData("Too many failed login attempts. Try again later.")
+		RenderTemplateWithStatus(w, req, http.StatusTooManyRequests, "login.html", data)
+		return
+	}
+
+	// otherwise, the credentials are checked FIRST so that valid ones always
+	// work even while the IP is locked out - behind a proxy without
 	// FORQ_TRUST_PROXY_HEADERS all clients share the proxy's IP, and someone
 	// else's failed attempts must not lock the admin out.
-	token := req.FormValue("token")
-	if subtle.ConstantTimeCompare([]byte(token), []byte(ur.authSecret)) != 1 {
-		ip := utils.ClientIP(req, ur.trustProxyHeaders)
-		if ur.throttlingService.IsLocked(ip) {
-			data := common.LoginPageData{
-				Title: "Login",
-				Error: "Too many failed login attempts. Try again in a minute.",
-			}
+	user, err := ur.authenticate(req.FormValue("username"), req.FormValue("password"), req)
+	if err != nil {
+		data := ur.loginPageData("Login failed, try again later")
+		RenderTemplateWithStatus(w, req, http.StatusInternalServerError, "login.html", data)
+		return
+	}
+	if user == nil {
+		if ur.throttlingService.IsLocked(ip, credential, req.Context()) {
+			data := ur.loginPageData("Too many failed login attempts. Try again later.")
 			RenderTemplateWithStatus(w, req, http.StatusTooManyRequests, "login.html", data)
 			return
 		}
-		ur.throttlingService.RecordFailure(ip)
-		log.Error().Msg("Invalid login token")
-		data := common.LoginPageData{
-			Title: "Login",
-			Error: "Invalid authentication token",
-		}
+		ur.throttlingService.RecordFailure(ip, credential, req.Context())
+		log.Error().Str("username", req.FormValue("username")).Msg("Invalid login credentials")
+		// the attempts rejected by the lockout above are not recorded, so that
+		// a brute force can't flood the audit log
+		ur.audit(req, services.AuditEvent{
+			Actor:  loginActor(req.FormValue("username")),
+			Action: common.LoginAuditAction,
+			Err:    common.ErrUnauthorized,
+		})
+		data := ur.loginPageData("Invalid credentials")
+		RenderTemplateWithStatus(w, req, http.StatusUnauthorized, "login.html", data)
+		return
+	}
+
+	if err := ur.startSession(w, req, *user); err != nil {
+		data := ur.loginPageData("Login failed, try again later")
+		RenderTemplateWithStatus(w, req, http.StatusInternalServerError, "login.html", data)
+		return
+	}
+
+	// redirects to dashboard on successful login
+	w.Header().Set("HX-Redirect", "/")
+	w.WriteHeader(http.StatusOK)
+}
+
+// beginOidcLogin redirects to the IdP. The login in progress, the state
+// included, is bound to the browser with a cookie, so that a callback URL
+// can't be used to log someone else in.
+func (ur *Router) beginOidcLogin(w http.ResponseWriter, req *http.Request) {
+	sealedLogin, authUrl, err := ur.oidcService.BeginLogin(req.Context())
+	if err != nil {
+		data := ur.loginPageData("SSO is unavailable, try again later")
+		RenderTemplateWithStatus(w, req, http.StatusInternalServerError, "login.html", data)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "ForqOidcState",
+		Value:    sealedLogin,
+		Path:     "/oidc/callback",
+		MaxAge:   int(oidcStateCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   ur.env == common.ProEnv,
+		SameSite: http.SameSiteLaxMode, // sent on the IdP's top-level redirect back
+	})
+	http.Redirect(w, req, authUrl, http.StatusFound)
+}
+
+func (ur *Router) completeOidcLogin(w http.ResponseWriter, req *http.Request) {
+	stateCookie, _ := req.Cookie("ForqOidcState")
+	http.SetCookie(w, &http.Cookie{
+		Name:     "ForqOidcState",
+		Value:    "",
+		Path:     "/oidc/callback",
+		MaxAge:   -1, // delete the cookie
+		HttpOnly: true,
+		Secure:   ur.env == common.ProEnv,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if idpError := req.URL.Query().Get("error"); idpError != "" {
+		log.Error().Str("error", idpError).Str("description", req.URL.Query().Get("error_description")).Msg("OIDC login rejected by the IdP")
+		data := ur.loginPageData("SSO login failed")
 		RenderTemplateWithStatus(w, req, http.StatusUnauthorized, "login.html", data)
 		return
 	}
 
-	sessionId, _ := ur.sessionsService.CreateSession()
+	state := req.URL.Query().Get("state")
+	if stateCookie == nil || state == "" {
+		log.Error().Msg("OIDC callback without the browser's login cookie or the state")
+		data := ur.loginPageData("SSO login failed, try again")
+		RenderTemplateWithStatus(w, req, http.StatusBadRequest, "login.html", data)
+		return
+	}
+
+	user, err := ur.oidcService.CompleteLogin(stateCookie.Value, state, req.URL.Query().Get("code"), req.Context())
+	if err != nil {
+		data := ur.loginPageData("SSO login failed, try again")
+		RenderTemplateWithStatus(w, req, http.StatusUnauthorized, "login.html", data)
+		return
+	}
+	if user == nil {
+		data := ur.loginPageData("Your account is not allowed to access Forq")
+		RenderTemplateWithStatus(w, req, http.StatusForbidden, "login.html", data)
+		return
+	}
+
+	if err := ur.startSession(w, req, *user); err != nil {
+		data := ur.loginPageData("Login failed, try again later")
+		RenderTemplateWithStatus(w, req, http.StatusInternalServerError, "login.html", data)
+		return
+	}
+	http.Redirect(w, req, "/", http.StatusFound)
+}
+
+func (ur *Router) startSession(w http.ResponseWriter, req *http.Request, user services.SessionUser) error {
+	client := services.SessionClient{
+		Ip:        utils.ClientIP(req, ur.trustProxyHeaders),
+		UserAgent: req.UserAgent(),
+	}
+	sessionId, err := ur.sessionsService.CreateSession(user, client, req.Context())
+	if err != nil {
+		return err
+	}
+	log.Info().Str("user", user.Name).Str("role", user.Role).Msg("UI login")
+	ur.audit(req, services.AuditEvent{
+		Actor:   user.Name,
+		Action:  common.LoginAuditAction,
+		Details: "role: " + user.Role,
+	})
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "ForqSession",
@@ -131,17 +328,42 @@ func (ur *Router) processLogin(w http.ResponseWriter, req *http.Request) {
 		Secure:   ur.env == common.ProEnv,
 		SameSite: http.SameSiteLaxMode,
 	})
+	return nil
+}
 
-	// redirects to dashboard on successful login
-	w.Header().Set("HX-Redirect", "/")
-	w.WriteHeader(http.StatusOK)
+func (ur *Router) loginPageData(errorMessage string) common.LoginPageData {
+	return common.LoginPageData{
+		Title:      "Login",
+		Error:      errorMessage,
+		SsoEnabled: ur.oidcService != nil,
+	}
+}
+
+// authenticate returns nil if the credentials are wrong. Without a username,
+// the password is a token: the auth secrets and the API keys that are admins
+// of all queues are accepted as admins, as the UI has no per-queue access
+// control. Their session names have a ":", which the usernames can't have.
+func (ur *Router) authenticate(username string, password string, req *http.Request) (*services.SessionUser, error) {
+	if username != "" {
+		return ur.usersService.Authenticate(username, password, req.Context())
+	}
+
+	if ur.authSecrets.IsValid(password) {
+		return &services.SessionUser{Name: "auth_secret:" + signing.KeyId(password), Role: common.AdminRole}, nil
+	}
+	principal := ur.apiKeysService.Authenticate(password)
+	if principal != nil && principal.IsGlobalAdmin() {
+		return &services.SessionUser{Name: "api_key:" + principal.Name, Role: common.AdminRole}, nil
+	}
+	return nil, nil
 }
 
 func (ur *Router) processLogout(w http.ResponseWriter, req *http.Request) {
 	sessionCookie, _ := req.Cookie("ForqSession")
 	if sessionCookie != nil {
-		ur.sessionsService.InvalidateSession(sessionCookie.Value)
+		ur.sessionsService.InvalidateSession(sessionCookie.Value, req.Context()) // on failure, it expires anyway
 	}
+	ur.audit(req, services.AuditEvent{Action: common.LogoutAuditAction})
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "ForqSession",
@@ -232,7 +454,13 @@ func (ur *Router) messageDetails(w http.ResponseWriter, req *http.Request) {
 func (ur *Router) deleteAllMessages(w http.ResponseWriter, req *http.Request) {
 	queueName := chi.URLParam(req, "queue")
 
-	err := ur.messagesService.DeleteAllDlqMessages(queueName, req.Context())
+	deletedCount, err := ur.messagesService.DeleteAllDlqMessages(queueName, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action: common.DeleteAllDlqMessagesAuditAction,
+		Queue:  queueName,
+		Count:  &deletedCount,
+		Err:    err,
+	})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -246,7 +474,13 @@ func (ur *Router) deleteAllMessages(w http.ResponseWriter, req *http.Request) {
 func (ur *Router) requeueAllMessages(w http.ResponseWriter, req *http.Request) {
 	queueName := chi.URLParam(req, "queue")
 
-	err := ur.messagesService.RequeueAllDlqMessages(queueName, req.Context())
+	requeuedCount, err := ur.messagesService.RequeueAllDlqMessages(queueName, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action: common.RequeueAllDlqMessagesAuditAction,
+		Queue:  queueName,
+		Count:  &requeuedCount,
+		Err:    err,
+	})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -262,6 +496,12 @@ func (ur *Router) deleteMessage(w http.ResponseWriter, req *http.Request) {
 	queueName := chi.URLParam(req, "queue")
 
 	err := ur.messagesService.DeleteDlqMessage(messageId, queueName, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:    common.DeleteDlqMessageAuditAction,
+		Queue:     queueName,
+		MessageId: messageId,
+		Err:       err,
+	})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -274,13 +514,676 @@ func (ur *Router) requeueMessage(w http.ResponseWriter, req *http.Request) {
 	queueName := chi.URLParam(req, "queue")
 
 	err := ur.messagesService.RequeueDlqMessage(messageId, queueName, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:    common.RequeueDlqMessageAuditAction,
+		Queue:     queueName,
+		MessageId: messageId,
+		Err:       err,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ur *Router) redriveMessages(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse redrive form")
+		RenderTemplate(w, req, "redrive-result.html", common.RedriveResultData{Error: "Invalid form data"})
+		return
+	}
+
+	redriveReq := common.RedriveRequest{
+		TargetQueue:   req.FormValue("targetQueue"),
+		FailureReason: req.FormValue("failureReason"),
+	}
+	// the form fields are optional: empty means "no filter" / "no rate limit"
+	if v := req.FormValue("minAgeMinutes"); v != "" {
+		minAgeMinutes, err := strconv.Atoi(v)
+		if err != nil || minAgeMinutes < 0 {
+			RenderTemplate(w, req, "redrive-result.html", common.RedriveResultData{Error: "Min age must be a non-negative number of minutes"})
+			return
+		}
+		if minAgeMinutes > 0 {
+			redriveReq.ReceivedBefore = time.Now().Add(-time.Duration(minAgeMinutes) * time.Minute).UnixMilli()
+		}
+	}
+	if v := req.FormValue("ratePerSecond"); v != "" {
+		ratePerSecond, err := strconv.Atoi(v)
+		if err != nil {
+			RenderTemplate(w, req, "redrive-result.html", common.RedriveResultData{Error: "Rate must be a number of messages per second"})
+			return
+		}
+		redriveReq.RatePerSecond = ratePerSecond
+	}
+
+	redrivenCount, err := ur.messagesService.RedriveDlqMessages(queueName, redriveReq, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.RedriveDlqAuditAction,
+		Queue:   queueName,
+		Count:   &redrivenCount,
+		Details: services.RedriveAuditDetails(redriveReq),
+		Err:     err,
+	})
+	if err != nil {
+		var fe common.ForqError
+		if errors.As(err, &fe) && fe.Code != common.ErrCodeInternal {
+			RenderTemplate(w, req, "redrive-result.html", common.RedriveResultData{Error: fmt.Sprintf("Redrive rejected: %s", fe.Code)})
+			return
+		}
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	RenderTemplate(w, req, "redrive-result.html", common.RedriveResultData{
+		RedrivenCount: redrivenCount,
+		TargetQueue:   redriveReq.TargetQueue,
+	})
+}
+
+func (ur *Router) pauseQueue(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := ur.queuesService.PauseQueue(queueName, req.Context())
+	ur.audit(req, services.AuditEvent{Action: common.PauseQueueAuditAction, Queue: queueName, Err: err})
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("HX-Refresh", "true")
 	w.WriteHeader(http.StatusOK)
 }
 
+func (ur *Router) resumeQueue(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := ur.queuesService.ResumeQueue(queueName, req.Context())
+	ur.audit(req, services.AuditEvent{Action: common.ResumeQueueAuditAction, Queue: queueName, Err: err})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ur *Router) dlqPolicyForm(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	settings, err := ur.queuesService.GetQueueSettings(queueName, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	RenderTemplate(w, req, "dlq-policy-form.html", settings)
+}
+
+func (ur *Router) updateDlqPolicy(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse DLQ policy form")
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	policyReq := common.DlqPolicyRequest{
+		DlqPolicy: req.FormValue("dlqPolicy"),
+		DlqName:   req.FormValue("dlqName"),
+	}
+	updateErr := ur.queuesService.UpdateDlqPolicy(queueName, policyReq, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.UpdateDlqPolicyAuditAction,
+		Queue:   queueName,
+		Details: services.DlqPolicyAuditDetails(policyReq),
+		Err:     updateErr,
+	})
+
+	settings, err := ur.queuesService.GetQueueSettings(queueName, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if updateErr != nil {
+		var fe common.ForqError
+		if !errors.As(updateErr, &fe) || fe.Code == common.ErrCodeInternal {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		settings.Error = fmt.Sprintf("Policy rejected: %s", fe.Code)
+	}
+	RenderTemplate(w, req, "dlq-policy-form.html", settings)
+}
+
+func (ur *Router) deliveryLimitsForm(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	settings, err := ur.queuesService.GetQueueSettings(queueName, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	RenderTemplate(w, req, "delivery-limits-form.html", settings)
+}
+
+func (ur *Router) updateDeliveryLimits(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse delivery limits form")
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	var limitsReq common.DeliveryLimitsRequest
+	var updateErr error
+	// empty fields mean "no limit"
+	if v := req.FormValue("ratePerSecond"); v != "" {
+		limitsReq.RatePerSecond, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			updateErr = common.ErrBadRequestInvalidRate
+		}
+	}
+	if v := req.FormValue("burst"); v != "" && updateErr == nil {
+		limitsReq.Burst, err = strconv.Atoi(v)
+		if err != nil {
+			updateErr = common.ErrBadRequestInvalidBurst
+		}
+	}
+	if v := req.FormValue("maxInFlight"); v != "" && updateErr == nil {
+		limitsReq.MaxInFlight, err = strconv.Atoi(v)
+		if err != nil {
+			updateErr = common.ErrBadRequestInvalidMaxInFlight
+		}
+	}
+	if updateErr == nil {
+		updateErr = ur.queuesService.UpdateDeliveryLimits(queueName, limitsReq, req.Context())
+	}
+	ur.audit(req, services.AuditEvent{
+		Action:  common.UpdateDeliveryLimitsAuditAction,
+		Queue:   queueName,
+		Details: services.DeliveryLimitsAuditDetails(limitsReq),
+		Err:     updateErr,
+	})
+
+	settings, err := ur.queuesService.GetQueueSettings(queueName, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if updateErr != nil {
+		var fe common.ForqError
+		if !errors.As(updateErr, &fe) || fe.Code == common.ErrCodeInternal {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		settings.Error = fmt.Sprintf("Limits rejected: %s", fe.Code)
+	}
+	RenderTemplate(w, req, "delivery-limits-form.html", settings)
+}
+
+func (ur *Router) quotasForm(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	settings, err := ur.queuesService.GetQueueSettings(queueName, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	RenderTemplate(w, req, "quotas-form.html", settings)
+}
+
+func (ur *Router) updateQuotas(w http.ResponseWriter, req *http.Request) {
+	queueName := chi.URLParam(req, "queue")
+
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse quotas form")
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	var quotasReq common.QuotasRequest
+	var updateErr error
+	// empty fields mean "no limit"
+	if v := req.FormValue("maxMessages"); v != "" {
+		quotasReq.MaxMessages, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			updateErr = common.ErrBadRequestInvalidMaxMessages
+		}
+	}
+	if v := req.FormValue("maxBytes"); v != "" && updateErr == nil {
+		quotasReq.MaxBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			updateErr = common.ErrBadRequestInvalidMaxBytes
+		}
+	}
+	if updateErr == nil {
+		updateErr = ur.queuesService.UpdateQuotas(queueName, quotasReq, req.Context())
+	}
+	ur.audit(req, services.AuditEvent{
+		Action:  common.UpdateQuotasAuditAction,
+		Queue:   queueName,
+		Details: services.QuotasAuditDetails(quotasReq),
+		Err:     updateErr,
+	})
+
+	settings, err := ur.queuesService.GetQueueSettings(queueName, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if updateErr != nil {
+		var fe common.ForqError
+		if !errors.As(updateErr, &fe) || fe.Code == common.ErrCodeInternal {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		settings.Error = fmt.Sprintf("Quotas rejected: %s", fe.Code)
+	}
+	RenderTemplate(w, req, "quotas-form.html", settings)
+}
+
+func (ur *Router) apiKeysPage(w http.ResponseWriter, req *http.Request) {
+	apiKeys, err := ur.apiKeysService.GetApiKeys(req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := common.ApiKeysPageData{
+		Title:   "API Keys",
+		ApiKeys: make([]common.ApiKey, 0, len(apiKeys)),
+	}
+	for _, apiKey := range apiKeys {
+		data.ApiKeys = append(data.ApiKeys, common.ApiKey{
+			ID:        apiKey.Id,
+			Name:      apiKey.Name,
+			Scopes:    apiKey.Scopes,
+			CreatedAt: time.UnixMilli(apiKey.CreatedAt).Format("2006-01-02 15:04:05"),
+		})
+	}
+	RenderTemplate(w, req, "api-keys-base.html", data)
+}
+
+func (ur *Router) createApiKey(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse API key form")
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	newKeyReq := common.NewApiKeyRequest{
+		Name:   req.FormValue("name"),
+		Scopes: parseScopes(req.FormValue("scopes")),
+	}
+	newKey, err := ur.apiKeysService.CreateApiKey(newKeyReq, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.CreateApiKeyAuditAction,
+		Details: "name: " + newKeyReq.Name,
+		Err:     err,
+	})
+	if err != nil {
+		var fe common.ForqError
+		if !errors.As(err, &fe) || fe.Code == common.ErrCodeInternal {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		RenderTemplate(w, req, "api-key-created.html", common.ApiKeyCreatedData{
+			Error: fmt.Sprintf("API key rejected: %s", fe.Code),
+		})
+		return
+	}
+
+	RenderTemplate(w, req, "api-key-created.html", common.ApiKeyCreatedData{
+		Name: newKey.Name,
+		Key:  newKey.Key,
+	})
+}
+
+func (ur *Router) deleteApiKey(w http.ResponseWriter, req *http.Request) {
+	keyId := chi.URLParam(req, "keyId")
+	name, err := ur.apiKeysService.DeleteApiKey(keyId, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.DeleteApiKeyAuditAction,
+		Details: "id: " + keyId,
+		Err:     err,
+	})
+	if err != nil && !errors.Is(err, common.ErrNotFoundApiKey) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		// the sessions logged in with the key
+		if err := ur.sessionsService.InvalidateUserSessions("api_key:"+name, req.Context()); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ur *Router) usersPage(w http.ResponseWriter, req *http.Request) {
+	users, err := ur.usersService.GetUsers(req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := common.UsersPageData{
+		Title: "Users",
+		Users: users,
+	}
+	RenderTemplate(w, req, "users-base.html", data)
+}
+
+func (ur *Router) createUser(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse user form")
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	err = ur.usersService.CreateUser(req.FormValue("username"), req.FormValue("password"), req.FormValue("role"), req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.CreateUserAuditAction,
+		Details: "username: " + req.FormValue("username") + ", role: " + req.FormValue("role"),
+		Err:     err,
+	})
+	if err != nil {
+		ur.renderUserError(w, req, err)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ur *Router) updateUserRole(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse user role form")
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	username, err := ur.usersService.UpdateRole(chi.URLParam(req, "userId"), req.FormValue("role"), req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.UpdateUserRoleAuditAction,
+		Details: userDetails(username, chi.URLParam(req, "userId")) + ", role: " + req.FormValue("role"),
+		Err:     err,
+	})
+	if err != nil {
+		ur.renderUserError(w, req, err)
+		return
+	}
+	// the sessions carry the role
+	if err := ur.sessionsService.InvalidateUserSessions(username, req.Context()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ur *Router) resetUserPassword(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse user password form")
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	username, err := ur.usersService.ResetPassword(chi.URLParam(req, "userId"), req.FormValue("password"), req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.ResetUserPasswordAuditAction,
+		Details: userDetails(username, chi.URLParam(req, "userId")),
+		Err:     err,
+	})
+	if err != nil {
+		ur.renderUserError(w, req, err)
+		return
+	}
+	if err := ur.sessionsService.InvalidateUserSessions(username, req.Context()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	RenderTemplate(w, req, "user-result.html", common.UserResultData{
+		Message: fmt.Sprintf("The password of %s is reset, and their sessions are ended.", username),
+	})
+}
+
+func (ur *Router) deleteUser(w http.ResponseWriter, req *http.Request) {
+	username, err := ur.usersService.DeleteUser(chi.URLParam(req, "userId"), req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.DeleteUserAuditAction,
+		Details: userDetails(username, chi.URLParam(req, "userId")),
+		Err:     err,
+	})
+	if err != nil && !errors.Is(err, common.ErrNotFoundUser) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if username != "" {
+		if err := ur.sessionsService.InvalidateUserSessions(username, req.Context()); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ur *Router) sessionsPage(w http.ResponseWriter, req *http.Request) {
+	var currentSessionId string
+	if sessionCookie, err := req.Cookie("ForqSession"); err == nil {
+		currentSessionId = sessionCookie.Value
+	}
+	sessions, err := ur.sessionsService.GetSessions(currentSessionId, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	idleTimeout, absoluteTimeout := ur.sessionsService.Timeouts()
+	data := common.SessionsPageData{
+		Title:           "Sessions",
+		Sessions:        sessions,
+		IdleTimeout:     shortDuration(idleTimeout),
+		AbsoluteTimeout: shortDuration(absoluteTimeout),
+	}
+	RenderTemplate(w, req, "sessions-base.html", data)
+}
+
+func (ur *Router) lockoutsPage(w http.ResponseWriter, req *http.Request) {
+	lockouts, err := ur.throttlingService.GetLockouts(req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	settings := ur.throttlingService.Settings()
+	data := common.LockoutsPageData{
+		Title:      "Lockouts",
+		Lockouts:   lockouts,
+		MaxFails:   settings.MaxFails,
+		Window:     shortDuration(settings.Window),
+		Lockout:    shortDuration(settings.Lockout),
+		MaxLockout: shortDuration(settings.MaxLockout),
+		Persisted:  settings.Persist,
+	}
+	RenderTemplate(w, req, "lockouts-base.html", data)
+}
+
+func (ur *Router) auditPage(w http.ResponseWriter, req *http.Request) {
+	const entriesLimit = 50
+
+	query := req.URL.Query()
+	filter := common.AuditFilter{
+		Actor:   query.Get("actor"),
+		Action:  query.Get("action"),
+		Queue:   query.Get("queue"),
+		Outcome: query.Get("outcome"),
+	}
+	beforeId, _ := strconv.ParseInt(query.Get("before"), 10, 64) // the first page if invalid
+
+	// one more than shown, to know if there is a next page
+	entries, err := ur.auditService.GetEntries(filter, beforeId, entriesLimit+1, req.Context())
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := common.AuditPageData{
+		Title:   "Audit Log",
+		Entries: make([]common.AuditEntry, 0, len(entries)),
+		Filter:  filter,
+		Actions: common.AuditActions,
+	}
+	if len(entries) > entriesLimit {
+		entries = entries[:entriesLimit]
+		query.Set("before", strconv.FormatInt(entries[len(entries)-1].Id, 10))
+		data.NextPageUrl = "/audit?" + query.Encode()
+	}
+	for _, entry := range entries {
+		var count string
+		if entry.Count != nil {
+			count = strconv.FormatInt(*entry.Count, 10)
+		}
+		data.Entries = append(data.Entries, common.AuditEntry{
+			CreatedAt: time.UnixMilli(entry.CreatedAt).Format("2006-01-02 15:04:05"),
+			Actor:     entry.Actor,
+			SourceIp:  entry.SourceIp,
+			Action:    entry.Action,
+			Queue:     entry.Queue,
+			MessageId: entry.MessageId,
+			Count:     count,
+			Outcome:   entry.Outcome,
+			Details:   entry.Details,
+		})
+	}
+	RenderTemplate(w, req, "audit-base.html", data)
+}
+
+func (ur *Router) revokeSession(w http.ResponseWriter, req *http.Request) {
+	username, err := ur.sessionsService.RevokeSession(chi.URLParam(req, "sessionId"), req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.RevokeSessionAuditAction,
+		Details: sessionDetails(username),
+		Err:     err,
+	})
+	if err != nil && !errors.Is(err, common.ErrNotFoundSession) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ur *Router) unlock(w http.ResponseWriter, req *http.Request) {
+	subject := req.URL.Query().Get("subject")
+	err := ur.throttlingService.Unlock(subject, req.Context())
+	ur.audit(req, services.AuditEvent{
+		Action:  common.UnlockAuditAction,
+		Details: "subject: " + subject,
+		Err:     err,
+	})
+	if err != nil && !errors.Is(err, common.ErrNotFoundLockout) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+// audit records the action of the logged-in user, unless the event has
+// another actor, e.g. the one logging in.
+func (ur *Router) audit(req *http.Request, event services.AuditEvent) {
+	if event.Actor == "" {
+		event.Actor = sessionUser(req).Name
+	}
+	event.SourceIp = utils.ClientIP(req, ur.trustProxyHeaders)
+	ur.auditService.Record(event, req.Context())
+}
+
+// loginActor is who attempted the login: the auth secrets and the API keys are
+// entered without a username.
+func loginActor(username string) string {
+	if username == "" {
+		return "token"
+	}
+	return username
+}
+
+// userDetails falls back to the user ID if there is no such user.
+func userDetails(username string, userId string) string {
+	if username == "" {
+		return "id: " + userId
+	}
+	return "username: " + username
+}
+
+func sessionDetails(username string) string {
+	if username == "" {
+		return "" // no such session
+	}
+	return "username: " + username
+}
+
+// renderUserError renders the rejections into the result area of the users page.
+func (ur *Router) renderUserError(w http.ResponseWriter, req *http.Request, err error) {
+	var fe common.ForqError
+	if !errors.As(err, &fe) || fe.Code == common.ErrCodeInternal {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	RenderTemplate(w, req, "user-result.html", common.UserResultData{
+		Error: fmt.Sprintf("Rejected: %s", fe.Code),
+	})
+}
+
+// shortDuration drops the zero minutes and seconds, e.g. "24h" rather than
+// "24h0m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// parseScopes parses the "permission:queues" pairs of the API key form, e.g.
+// "produce:orders, consume:orders-*". A malformed pair is kept as is, so that
+// the service rejects the whole request with a proper error code.
+func parseScopes(scopesInput string) []common.ApiKeyScope {
+	var scopes []common.ApiKeyScope
+	for _, pair := range strings.Split(scopesInput, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		permission, queues, _ := strings.Cut(pair, ":")
+		scopes = append(scopes, common.ApiKeyScope{
+			Permission: strings.TrimSpace(permission),
+			Queues:     strings.TrimSpace(queues),
+		})
+	}
+	return scopes
+}
+
 func (ur *Router) csrfErrorHandler(w http.ResponseWriter, r *http.Request) {
 	log.Error().
 		Str("path", r.URL.Path).
@@ -9,8 +9,10 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/n0rdy/forq/common"
+	"github.com/n0rdy/forq/configs"
 	"github.com/n0rdy/forq/internal/testutil"
 	"github.com/n0rdy/forq/metrics"
 	"github.com/n0rdy/forq/services"
@@ -21,20 +23,53 @@ const testAuthSecret = "test-secret-that-is-32-chars-long"
 
 func newUITestServer(t *testing.T) *httptest.Server {
 	t.Helper()
+	srv, _, _ := newUITestServerWithServices(t)
+	return srv
+}
+
+// newUITestServerWithServices also returns the API keys and users services,
+// for the tests that log in with API keys or as users.
+func newUITestServerWithServices(t *testing.T) (*httptest.Server, *services.ApiKeysService, *services.UsersService) {
+	t.Helper()
+	return newUITestServerWithOidc(t, nil)
+}
+
+func newUITestServerWithOidc(t *testing.T, oidcService *services.OidcService) (*httptest.Server, *services.ApiKeysService, *services.UsersService) {
+	t.Helper()
 
 	repo, appConfigs, _ := testutil.NewTestRepo(t)
-	metricsService := metrics.NewMetricsService(false)
-	messagesService := services.NewMessagesService(metricsService, repo, appConfigs)
-	queuesService := services.NewQueuesService(repo)
-	sessionsService := services.NewSessionsService()
+	metricsService := metrics.NewMetricsService(configs.MetricsSettings{})
+	limitingService, err := services.NewLimitingService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotasService, err := services.NewQuotasService(metricsService, repo, appConfigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { quotasService.Close() })
+	messagesService := services.NewMessagesService(metricsService, repo, limitingService, quotasService, appConfigs)
+	queuesService := services.NewQueuesService(repo, limitingService, quotasService)
+	sessionsService := services.NewSessionsService(repo, 24*time.Hour, 7*24*time.Hour)
 	t.Cleanup(func() { sessionsService.Close() })
-	throttlingService := services.NewThrottlingService()
+	throttlingService := services.NewThrottlingService(repo, configs.DefaultSettings().Throttling)
 	t.Cleanup(func() { throttlingService.Close() })
+	apiKeysService, err := services.NewApiKeysService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecretsService, err := services.NewAuthSecretsService(metricsService, testAuthSecret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	router := ui.NewRouter(messagesService, sessionsService, queuesService, throttlingService, testAuthSecret, common.LocalEnv, false)
+	usersService := services.NewUsersService(repo)
+	auditService := services.NewAuditService(repo)
+
+	router := ui.NewRouter(messagesService, sessionsService, queuesService, throttlingService, apiKeysService, usersService, oidcService, auditService, authSecretsService, common.LocalEnv, false)
 	srv := httptest.NewServer(router.NewRouter())
 	t.Cleanup(srv.Close)
-	return srv
+	return srv, apiKeysService, usersService
 }
 
 // newClientWithJar returns an HTTP client that keeps cookies and does NOT
@@ -55,9 +90,14 @@ func newClientWithJar(t *testing.T) *http.Client {
 
 var csrfTokenRe = regexp.MustCompile(`X-CSRF-Token": "([^"]+)"`)
 
-// login performs the full CSRF-protected login flow and returns the client
-// holding the session cookie.
+// login performs the full CSRF-protected login flow with a token, i.e. an
+// auth secret or an API key, and returns the client holding the session cookie.
 func login(t *testing.T, srv *httptest.Server, token string) (*http.Client, *http.Response) {
+	t.Helper()
+	return loginAs(t, srv, "", token)
+}
+
+func loginAs(t *testing.T, srv *httptest.Server, username string, password string) (*http.Client, *http.Response) {
 	t.Helper()
 	client := newClientWithJar(t)
 
@@ -65,17 +105,29 @@ func login(t *testing.T, srv *httptest.Server, token string) (*http.Client, *htt
 	if err != nil {
 		t.Fatal(err)
 	}
-	body := readBody(t, resp)
+	csrfToken := csrfTokenFrom(t, readBody(t, resp))
+
+	form := url.Values{"username": {username}, "password": {password}}
+	loginResp := doForm(t, client, srv, http.MethodPost, "/login", form, csrfToken)
+	loginResp.Body.Close()
+	return client, loginResp
+}
+
+func csrfTokenFrom(t *testing.T, body string) string {
+	t.Helper()
 	match := csrfTokenRe.FindStringSubmatch(body)
 	if match == nil {
-		t.Fatalf("no CSRF token found in login page")
+		t.Fatalf("no CSRF token found in the page")
 	}
 	// the token sits in an HTML attribute, so html/template entity-escapes
 	// characters like '+' - browsers unescape automatically, tests must too
-	csrfToken := html.UnescapeString(match[1])
+	return html.UnescapeString(match[1])
+}
 
-	form := url.Values{"token": {token}}
-	req, err := http.NewRequest(http.MethodPost, srv.URL+"/login", strings.NewReader(form.Encode()))
+// doForm sends a CSRF-protected form request, the way HTMX does.
+func doForm(t *testing.T, client *http.Client, srv *httptest.Server, method string, path string, form url.Values, csrfToken string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(form.Encode()))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,12 +137,20 @@ func login(t *testing.T, srv *httptest.Server, token string) (*http.Client, *htt
 	// Sec-Fetch-Site/Origin/Referer; browsers always send Origin on POST
 	req.Header.Set("Origin", srv.URL)
 
-	loginResp, err := client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	loginResp.Body.Close()
-	return client, loginResp
+	return resp
+}
+
+func mustGet(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
 }
 
 func readBody(t *testing.T, resp *http.Response) string {
@@ -112,7 +172,7 @@ func TestUnauthenticatedRedirectsToLogin(t *testing.T) {
 	srv := newUITestServer(t)
 	client := newClientWithJar(t)
 
-	for _, path := range []string{"/", "/queue/orders"} {
+	for _, path := range []string{"/", "/queue/orders", "/api-keys", "/users"} {
 		resp, err := client.Get(srv.URL + path)
 		if err != nil {
 			t.Fatal(err)
@@ -188,11 +248,77 @@ func TestLoginFlow(t *testing.T) {
 	}
 }
 
+// only the API keys that are admins of all queues can log in, as the UI has
+// no per-queue access control
+func TestLoginWithApiKey(t *testing.T) {
+	srv, apiKeysService, _ := newUITestServerWithServices(t)
+
+	scopedKey, err := apiKeysService.CreateApiKey(common.NewApiKeyRequest{
+		Name:   "orders-admin",
+		Scopes: []common.ApiKeyScope{{Permission: common.AdminPermission, Queues: "orders"}},
+	}, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, resp := login(t, srv, scopedKey.Key)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("login with a queue admin key: %d, want 401", resp.StatusCode)
+	}
+
+	globalKey, err := apiKeysService.CreateApiKey(common.NewApiKeyRequest{
+		Name:   "global-admin",
+		Scopes: []common.ApiKeyScope{{Permission: common.AdminPermission, Queues: common.AllQueuesPattern}},
+	}, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, resp := login(t, srv, globalKey.Key)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login with a global admin key: %d", resp.StatusCode)
+	}
+
+	pageResp, err := client.Get(srv.URL + "/api-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, pageResp)
+	if pageResp.StatusCode != http.StatusOK || !strings.Contains(body, "orders-admin") || strings.Contains(body, globalKey.Key) {
+		t.Fatalf("API keys page: %d", pageResp.StatusCode)
+	}
+}
+
+func TestDeletingApiKeyEndsItsSessions(t *testing.T) {
+	srv, apiKeysService, _ := newUITestServerWithServices(t)
+	globalKey, err := apiKeysService.CreateApiKey(common.NewApiKeyRequest{
+		Name:   "global-admin",
+		Scopes: []common.ApiKeyScope{{Permission: common.AdminPermission, Queues: common.AllQueuesPattern}},
+	}, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySession, _ := login(t, srv, globalKey.Key)
+	admin, _ := login(t, srv, testAuthSecret)
+
+	page := readBody(t, mustGet(t, admin, srv.URL+"/api-keys"))
+	resp := doForm(t, admin, srv, http.MethodDelete, "/api-keys/"+globalKey.Id, url.Values{}, csrfTokenFrom(t, page))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+
+	if resp := mustGet(t, keySession, srv.URL+"/"); resp.StatusCode != http.StatusFound {
+		t.Fatalf("session of the deleted key: %d, want a redirect to the login", resp.StatusCode)
+	}
+	if resp := mustGet(t, admin, srv.URL+"/"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin session after deleting the key: %d", resp.StatusCode)
+	}
+}
+
 func TestLoginRequiresCSRFToken(t *testing.T) {
 	srv := newUITestServer(t)
 	client := newClientWithJar(t)
 
-	form := url.Values{"token": {testAuthSecret}}
+	form := url.Values{"password": {testAuthSecret}}
 	resp, err := client.PostForm(srv.URL+"/login", form)
 	if err != nil {
 		t.Fatal(err)
@@ -219,3 +345,260 @@ func TestQueueNameValidation(t *testing.T) {
 		t.Fatalf("invalid queue name: %d, want 404", resp.StatusCode)
 	}
 }
+
+func TestUserRoles(t *testing.T) {
+	srv, _, usersService := newUITestServerWithServices(t)
+	for username, role := range map[string]string{"vera": common.ViewerRole, "otto": common.OperatorRole, "ada": common.AdminRole} {
+		if err := usersService.CreateUser(username, "correct-horse-battery", role, t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, resp := loginAs(t, srv, "vera", "wrong-password"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong password: %d, want 401", resp.StatusCode)
+	}
+
+	// status returns the status of the request, made with the CSRF token of the dashboard
+	status := func(client *http.Client, method string, path string) int {
+		t.Helper()
+		dashResp, err := client.Get(srv.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := readBody(t, dashResp)
+		if dashResp.StatusCode != http.StatusOK {
+			return dashResp.StatusCode
+		}
+		resp := doForm(t, client, srv, method, path, url.Values{"role": {common.ViewerRole}}, csrfTokenFrom(t, body))
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	viewer, resp := loginAs(t, srv, "vera", "correct-horse-battery")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("viewer login: %d", resp.StatusCode)
+	}
+	operator, _ := loginAs(t, srv, "otto", "correct-horse-battery")
+	admin, _ := loginAs(t, srv, "ada", "correct-horse-battery")
+
+	tests := []struct {
+		method string
+		path   string
+		client *http.Client
+		want   int
+	}{
+		{http.MethodGet, "/queue/orders/settings/quotas", viewer, http.StatusOK},
+		{http.MethodPost, "/queue/orders/pause", viewer, http.StatusForbidden},
+		{http.MethodDelete, "/queue/orders-dlq/messages", viewer, http.StatusForbidden},
+		{http.MethodPost, "/queue/orders/pause", operator, http.StatusOK},
+		{http.MethodPost, "/queue/orders/settings/quotas", operator, http.StatusForbidden},
+		{http.MethodGet, "/users", operator, http.StatusForbidden},
+		{http.MethodGet, "/api-keys", operator, http.StatusForbidden},
+		{http.MethodPost, "/queue/orders/settings/quotas", admin, http.StatusOK},
+		{http.MethodGet, "/users", admin, http.StatusOK},
+	}
+	for _, tc := range tests {
+		if got := status(tc.client, tc.method, tc.path); got != tc.want {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.path, got, tc.want)
+		}
+	}
+
+	// the role change ends the sessions of the user
+	users, err := usersService.GetUsers(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ottoId string
+	for _, user := range users {
+		if user.Username == "otto" {
+			ottoId = user.ID
+		}
+	}
+	if got := status(admin, http.MethodPost, "/users/"+ottoId+"/role"); got != http.StatusOK {
+		t.Fatalf("role change: %d", got)
+	}
+	if got := status(operator, http.MethodPost, "/queue/orders/resume"); got != http.StatusFound {
+		t.Fatalf("request after the role change: %d, want a redirect to the login", got)
+	}
+	operator, _ = loginAs(t, srv, "otto", "correct-horse-battery")
+	if got := status(operator, http.MethodPost, "/queue/orders/resume"); got != http.StatusForbidden {
+		t.Fatalf("resume as a former operator: %d, want 403", got)
+	}
+}
+
+var revokeSessionRe = regexp.MustCompile(`hx-delete="/sessions/([0-9a-f]{64})"`)
+
+func TestSessionsPage(t *testing.T) {
+	srv, _, usersService := newUITestServerWithServices(t)
+	if err := usersService.CreateUser("vera", "correct-horse-battery", common.ViewerRole, t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	viewer, _ := loginAs(t, srv, "vera", "correct-horse-battery")
+	admin, _ := login(t, srv, testAuthSecret)
+
+	if resp := mustGet(t, viewer, srv.URL+"/sessions"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("sessions page as a viewer: %d, want 403", resp.StatusCode)
+	}
+
+	page := readBody(t, mustGet(t, admin, srv.URL+"/sessions"))
+	var veraSessionId string
+	for _, row := range strings.Split(page, "<tr>") {
+		if strings.Contains(row, "vera") {
+			if match := revokeSessionRe.FindStringSubmatch(row); match != nil {
+				veraSessionId = match[1]
+			}
+		}
+	}
+	if veraSessionId == "" || strings.Count(page, ">current<") != 1 {
+		t.Fatalf("sessions page doesn't list vera's and the current session:\n%s", page)
+	}
+
+	resp := doForm(t, admin, srv, http.MethodDelete, "/sessions/"+veraSessionId, url.Values{}, csrfTokenFrom(t, page))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("revoke: %d", resp.StatusCode)
+	}
+	if resp := mustGet(t, viewer, srv.URL+"/"); resp.StatusCode != http.StatusFound {
+		t.Fatalf("revoked session: %d, want a redirect to the login", resp.StatusCode)
+	}
+	if resp := mustGet(t, admin, srv.URL+"/"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin session after revoking another one: %d", resp.StatusCode)
+	}
+}
+
+func TestLockoutsPage(t *testing.T) {
+	srv, _, _ := newUITestServerWithServices(t)
+	for range configs.DefaultSettings().Throttling.MaxFails {
+		loginAs(t, srv, "mallory", "guess")
+	}
+	if _, resp := loginAs(t, srv, "eve", "guess"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("failed login from a locked IP: %d, want 429", resp.StatusCode)
+	}
+	admin, _ := login(t, srv, testAuthSecret)
+
+	page := readBody(t, mustGet(t, admin, srv.URL+"/lockouts"))
+	if !strings.Contains(page, ">127.0.0.1<") || !strings.Contains(page, ">mallory<") {
+		t.Fatalf("lockouts page doesn't list the IP and the username:\n%s", page)
+	}
+
+	resp := doForm(t, admin, srv, http.MethodDelete, "/lockouts?subject=ip:127.0.0.1", url.Values{}, csrfTokenFrom(t, page))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unlock: %d", resp.StatusCode)
+	}
+	if _, resp := loginAs(t, srv, "eve", "guess"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("failed login from an unlocked IP: %d, want 401", resp.StatusCode)
+	}
+	// the username is still locked out
+	if _, resp := loginAs(t, srv, "mallory", "guess"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("failed login of a locked username: %d, want 429", resp.StatusCode)
+	}
+
+	page = readBody(t, mustGet(t, admin, srv.URL+"/audit?action="+common.UnlockAuditAction))
+	if !strings.Contains(page, "subject: ip:127.0.0.1") {
+		t.Fatalf("unlock not audited:\n%s", page)
+	}
+}
+
+func TestLockedUsernameRejectsCorrectPassword(t *testing.T) {
+	srv, _, usersService := newUITestServerWithServices(t)
+	if err := usersService.CreateUser("vera", "correct-horse-battery", common.ViewerRole, t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	for range configs.DefaultSettings().Throttling.MaxFails {
+		loginAs(t, srv, "vera", "guess")
+	}
+
+	if _, resp := loginAs(t, srv, "vera", "correct-horse-battery"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("correct password of a locked username: %d, want 429", resp.StatusCode)
+	}
+	// the random auth secret still gets through the locked IP
+	if _, resp := login(t, srv, testAuthSecret); resp.StatusCode != http.StatusOK {
+		t.Fatalf("auth secret from a locked IP: %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestAuditPage(t *testing.T) {
+	srv, _, usersService := newUITestServerWithServices(t)
+	if err := usersService.CreateUser("vera", "correct-horse-battery", common.ViewerRole, t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	login(t, srv, "wrong-token")
+	viewer, _ := loginAs(t, srv, "vera", "correct-horse-battery")
+	admin, _ := login(t, srv, testAuthSecret)
+
+	if resp := mustGet(t, viewer, srv.URL+"/audit"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("audit page as a viewer: %d, want 403", resp.StatusCode)
+	}
+
+	page := readBody(t, mustGet(t, admin, srv.URL+"/audit"))
+	if strings.Count(page, "<td class=\"font-mono\">"+common.LoginAuditAction+"</td>") != 3 {
+		t.Fatalf("audit page doesn't list the 3 logins:\n%s", page)
+	}
+
+	page = readBody(t, mustGet(t, admin, srv.URL+"/audit?outcome=failure"))
+	if !strings.Contains(page, ">token<") || strings.Contains(page, ">vera<") {
+		t.Fatalf("failed logins page doesn't list the failed token login only:\n%s", page)
+	}
+}
+
+func TestOidcLogin(t *testing.T) {
+	idp := testutil.NewTestOidcProvider(t)
+	idp.SetUser("alice", "forq-ops")
+	oidcService := services.NewOidcService(configs.OidcSettings{
+		IssuerUrl:   idp.Url,
+		ClientId:    testutil.TestOidcClientId,
+		LoginSecret: "oidc-login-secret-that-is-32-chars",
+		RedirectUrl: "http://forq.test/oidc/callback",
+		Scopes:      []string{"openid"},
+		GroupsClaim: "groups",
+		GroupRoles:  map[string]string{"forq-ops": common.OperatorRole},
+	})
+	srv, _, _ := newUITestServerWithOidc(t, oidcService)
+
+	if body := readBody(t, mustGet(t, http.DefaultClient, srv.URL+"/login")); !strings.Contains(body, `href="/oidc/login"`) {
+		t.Fatal("no SSO link on the login page")
+	}
+
+	// oidcLogin goes through the IdP, as the browser would, and returns the
+	// callback response
+	oidcLogin := func(client *http.Client) *http.Response {
+		t.Helper()
+		resp := mustGet(t, client, srv.URL+"/oidc/login")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("/oidc/login: %d, want 302", resp.StatusCode)
+		}
+		code, state := idp.Authorize(t, resp.Header.Get("Location"))
+		callbackResp := mustGet(t, client, srv.URL+"/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode())
+		callbackResp.Body.Close()
+		return callbackResp
+	}
+
+	client := newClientWithJar(t)
+	resp := oidcLogin(client)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/" {
+		t.Fatalf("callback: %d to %q, want 302 to /", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	dashboard := readBody(t, mustGet(t, client, srv.URL+"/"))
+	if !strings.Contains(dashboard, "oidc:alice") {
+		t.Fatal("dashboard doesn't show the OIDC user")
+	}
+
+	// the state must come back to the browser that started the login
+	resp = mustGet(t, client, srv.URL+"/oidc/login")
+	resp.Body.Close()
+	code, state := idp.Authorize(t, resp.Header.Get("Location"))
+	otherBrowser := newClientWithJar(t)
+	resp = mustGet(t, otherBrowser, srv.URL+"/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode())
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("callback in another browser: %d, want 400", resp.StatusCode)
+	}
+
+	// no role for the user's groups
+	idp.SetUser("bob", "marketing")
+	if resp := oidcLogin(newClientWithJar(t)); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("user without a role: %d, want 403", resp.StatusCode)
+	}
+}
@@ -40,6 +40,7 @@ func RenderTemplateWithStatus(w http.ResponseWriter, req *http.Request, status i
 	templateData := map[string]interface{}{
 		"Data":      data,
 		"CSRFToken": nosurf.Token(req),
+		"User":      sessionUser(req), // to show only the actions the user's role allows
 	}
 
 	if dataMap, ok := data.(map[string]interface{}); ok {
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package utils
+
+import "syscall"
+
+// DiskFreeBytes returns the space available to Forq, i.e. not counting the
+// blocks reserved for root, on the filesystem of the path.
+func DiskFreeBytes(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
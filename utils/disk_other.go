@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package utils
+
+import "errors"
+
+// DiskFreeBytes isn't supported on this OS, the callers skip the free space
+// checks then.
+func DiskFreeBytes(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package utils
+
+import "testing"
+
+func TestDiskFreeBytes(t *testing.T) {
+	free, err := DiskFreeBytes(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free <= 0 {
+		t.Fatalf("free = %d, want some space on the temp dir's filesystem", free)
+	}
+
+	if _, err := DiskFreeBytes("/no/such/dir"); err == nil {
+		t.Fatal("no error for a missing dir")
+	}
+}
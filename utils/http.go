@@ -6,6 +6,12 @@ import (
 	"strings"
 )
 
+// UnixSocketClient is the "IP" of the clients connected over a Unix domain
+// socket, which have no remote address. They share one throttling bucket,
+// which is fine: the valid credentials pass even while it's locked out, so a
+// local process spamming bogus keys can't lock out the legit clients.
+const UnixSocketClient = "unix"
+
 // ClientIP returns the client IP for a request.
 //
 // When trustProxyHeaders is false (default), only RemoteAddr is used. This is
@@ -18,6 +24,9 @@ import (
 // clients - otherwise attackers can spoof their IP and bypass throttling.
 // Assumes a single proxy hop; multi-hop deployments should canonicalize the
 // header at the edge proxy before it reaches Forq.
+//
+// Over a Unix domain socket, it's UnixSocketClient, unless taken from
+// X-Forwarded-For, e.g. for a local reverse proxy.
 func ClientIP(req *http.Request, trustProxyHeaders bool) string {
 	if trustProxyHeaders {
 		if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
@@ -30,6 +39,10 @@ func ClientIP(req *http.Request, trustProxyHeaders bool) string {
 	}
 	host, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
+		if net.ParseIP(req.RemoteAddr) == nil {
+			// Go reports the Unix socket peers as "@"
+			return UnixSocketClient
+		}
 		return req.RemoteAddr
 	}
 	return host
@@ -57,6 +57,18 @@ func TestClientIP(t *testing.T) {
 			remoteAddr: "[2001:db8::1]:443",
 			want:       "2001:db8::1",
 		},
+		{
+			name:       "Unix socket peer",
+			remoteAddr: "@",
+			want:       UnixSocketClient,
+		},
+		{
+			name:       "XFF used over Unix socket when proxy trusted",
+			remoteAddr: "@",
+			xff:        "203.0.113.7",
+			trustProxy: true,
+			want:       "203.0.113.7",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// UnixAddrPrefix marks the Unix domain socket addresses, e.g. "unix:/run/forq/api.sock".
+const UnixAddrPrefix = "unix:"
+
+// Listen listens on the TCP address, or on the Unix domain socket if the
+// address has the UnixAddrPrefix. The socket file gets the socketMode
+// permissions, and is removed when the listener is closed.
+//
+// A socket file left behind by a crashed process is removed first. A socket
+// that still accepts connections is not: that's another process listening on
+// it, e.g. another Forq instance.
+func Listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	socketPath, isUnix := strings.CutPrefix(addr, UnixAddrPrefix)
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+	if socketPath == "" {
+		return nil, fmt.Errorf("empty Unix socket path in %q", addr)
+	}
+
+	err := removeStaleSocket(socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// the file is created with the umask permissions first, so keep the socket
+	// in a directory only Forq and its clients can access, if that matters
+	err = os.Chmod(socketPath, socketMode)
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set Unix socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+func removeStaleSocket(socketPath string) error {
+	info, err := os.Lstat(socketPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a Unix socket", socketPath)
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("Unix socket %s is in use by another process", socketPath)
+	}
+	return os.Remove(socketPath)
+}
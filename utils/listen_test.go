@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "forq.sock")
+
+	// a socket file left behind by a crashed process
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(UnixAddrPrefix+socketPath, 0660)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != 0660 {
+		t.Fatalf("socket mode = %v, want a socket with 0660", info.Mode())
+	}
+
+	// a live socket belongs to another process
+	if _, err := Listen(UnixAddrPrefix+socketPath, 0660); err == nil {
+		t.Fatal("listen over a live socket succeeded")
+	}
+
+	ln.Close()
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Fatalf("socket file not removed on close: %v", err)
+	}
+}
+
+func TestListen_RefusesNonSocketFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forq.db")
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Listen(UnixAddrPrefix+path, 0660); err == nil {
+		t.Fatal("listen over a regular file succeeded")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("regular file removed: %v", err)
+	}
+}
+
+func TestListen_Tcp(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().Network() != "tcp" {
+		t.Fatalf("network = %q, want tcp", ln.Addr().Network())
+	}
+}